@@ -0,0 +1,197 @@
+// gbsim GB28181 模拟设备，用于手工联调和平台压测
+//
+// 用法:
+//
+//	gbsim [-server 127.0.0.1:5060] [-server-id ...] [-transport udp|tcp] [-password ...]
+//	      [-id 34020000001320000001] [-count N] [-channels N] [-fps 25] ...
+//
+// 按 -id 起依次递增设备编码的后 7 位，启动 -count 个模拟设备向平台注册。每个设备
+// 定时发送心跳，应答目录/设备信息/录像查询，并在平台点播时推送 H.264 彩条测试图案
+// （PS over RTP）。Ctrl+C 退出时注销全部设备。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"gb28181-onvif-server/internal/gb28181/simulator"
+)
+
+func main() {
+	fs := flag.NewFlagSet("gbsim", flag.ExitOnError)
+	server := fs.String("server", "127.0.0.1:5060", "平台 SIP 地址")
+	serverID := fs.String("server-id", "34020000002000000001", "平台国标编码")
+	realm := fs.String("realm", "", "SIP 域（默认取平台编码前 10 位）")
+	transport := fs.String("transport", "udp", "SIP 传输方式: udp 或 tcp")
+	password := fs.String("password", "", "注册密码")
+	firstID := fs.String("id", "34020000001320000001", "第一个设备的国标编码，后续设备依次递增")
+	count := fs.Int("count", 1, "模拟设备数量")
+	channels := fs.Int("channels", 1, "每个设备的通道数（不超过 99，保证通道编码不重复）")
+	localIP := fs.String("local-ip", "", "Via/Contact/SDP 中宣告的本机地址（默认自动选择）")
+	expires := fs.Int("expires", 3600, "注册有效期（秒）")
+	keepalive := fs.Duration("keepalive", 60*time.Second, "心跳间隔")
+	fps := fs.Int("fps", 25, "测试图案帧率")
+	width := fs.Int("width", 176, "测试图案宽度（16 的倍数）")
+	height := fs.Int("height", 144, "测试图案高度（16 的倍数）")
+	ramp := fs.Duration("ramp", 20*time.Millisecond, "相邻设备的启动间隔，避免瞬时注册风暴")
+	statsInterval := fs.Duration("stats", 10*time.Second, "统计输出间隔，0 不输出")
+	verbose := fs.Bool("v", false, "输出每个设备的详细日志")
+	fs.Parse(os.Args[1:])
+
+	if *channels < 1 || *channels > 99 {
+		fatalf("-channels 应在 1~99 之间")
+	}
+	ids, err := deviceIDs(*firstID, *count)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	var logf func(string, ...interface{})
+	if *verbose {
+		logf = log.Printf
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		mu      sync.Mutex
+		devices []*simulator.Device
+		wg      sync.WaitGroup
+	)
+	for _, id := range ids {
+		dev, err := simulator.New(simulator.Config{
+			DeviceID:          id,
+			ServerID:          *serverID,
+			Realm:             *realm,
+			ServerAddr:        *server,
+			Transport:         *transport,
+			LocalIP:           *localIP,
+			Password:          *password,
+			Expires:           *expires,
+			KeepaliveInterval: *keepalive,
+			Channels:          *channels,
+			Width:             *width,
+			Height:            *height,
+			FPS:               *fps,
+			Logf:              logf,
+		})
+		if err != nil {
+			fatalf("%v", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			if err := dev.Start(startCtx); err != nil {
+				log.Printf("✗ 设备 %s 启动失败: %v", dev.DeviceID(), err)
+				return
+			}
+			mu.Lock()
+			devices = append(devices, dev)
+			mu.Unlock()
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(*ramp):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	wg.Wait()
+	log.Printf("✓ 已注册 %d/%d 个模拟设备 -> %s (%s)", len(devices), len(ids), *server, *transport)
+
+	if *statsInterval > 0 {
+		go reportStats(ctx, *statsInterval, func() []*simulator.Device {
+			mu.Lock()
+			defer mu.Unlock()
+			return devices
+		})
+	}
+
+	<-ctx.Done()
+	log.Printf("正在注销 %d 个模拟设备...", len(devices))
+	for _, dev := range devices {
+		wg.Add(1)
+		go func(dev *simulator.Device) {
+			defer wg.Done()
+			unregisterCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			dev.Unregister(unregisterCtx)
+			dev.Close()
+		}(dev)
+	}
+	wg.Wait()
+}
+
+// deviceIDs 从第一个设备编码起递增后 7 位序号
+func deviceIDs(first string, count int) ([]string, error) {
+	if len(first) != 20 {
+		return nil, fmt.Errorf("设备编码应为 20 位: %s", first)
+	}
+	serial, err := strconv.Atoi(first[13:])
+	if err != nil {
+		return nil, fmt.Errorf("设备编码后 7 位应为数字: %s", first)
+	}
+	if count < 1 || serial+count > 10000000 {
+		return nil, fmt.Errorf("设备数量无效: %d", count)
+	}
+
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s%07d", first[:13], serial+i)
+	}
+	return ids, nil
+}
+
+// reportStats 定时输出全部设备的汇总统计
+func reportStats(ctx context.Context, interval time.Duration, devices func() []*simulator.Device) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastBytes int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var total simulator.Stats
+		registered := 0
+		for _, dev := range devices() {
+			stats := dev.Stats()
+			if stats.Registered {
+				registered++
+			}
+			total.Keepalives += stats.Keepalives
+			total.Queries += stats.Queries
+			total.Invites += stats.Invites
+			total.ActiveStreams += stats.ActiveStreams
+			total.RTPPackets += stats.RTPPackets
+			total.RTPBytes += stats.RTPBytes
+		}
+		rate := float64(total.RTPBytes-lastBytes) * 8 / interval.Seconds() / 1000
+		lastBytes = total.RTPBytes
+		log.Printf("在线 %d | 心跳 %d | 查询 %d | 点播 %d | 推流中 %d | RTP %d 包 %.0f kbps",
+			registered, total.Keepalives, total.Queries, total.Invites, total.ActiveStreams, total.RTPPackets, rate)
+	}
+}
+
+// fatalf 输出错误并退出
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "错误: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,219 @@
+// secrets 凭据加密管理工具
+//
+// 用法:
+//
+//	secrets keygen                                  生成新的主密钥
+//	secrets encrypt [-config ...] [-push ...] [-admission ...]
+//	                                                将存量明文凭据加密
+//	secrets rotate  [-config ...] [-push ...] [-admission ...] [-new-key-file ...]
+//	                                                轮换主密钥并重新加密所有凭据
+//
+// 主密钥来源与服务端一致：环境变量 GB28181_SECRET_KEY，或 GB28181_SECRET_KEY_FILE
+// 指定的密钥文件（默认 configs/secret.key）。轮换前请停止服务。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gb28181-onvif-server/internal/auth"
+	"gb28181-onvif-server/internal/config"
+	"gb28181-onvif-server/internal/gb28181"
+	"gb28181-onvif-server/internal/push"
+	"gb28181-onvif-server/internal/secrets"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "keygen":
+		fmt.Println(secrets.EncodeKey(secrets.GenerateKey()))
+	case "encrypt":
+		err = runEncrypt(os.Args[2:])
+	case "rotate":
+		err = runRotate(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: secrets <keygen|encrypt|rotate> [参数]")
+	fmt.Fprintln(os.Stderr, "  keygen   生成新的主密钥（Base64）")
+	fmt.Fprintln(os.Stderr, "  encrypt  使用当前主密钥加密存量明文凭据")
+	fmt.Fprintln(os.Stderr, "  rotate   轮换主密钥并重新加密所有凭据")
+}
+
+// stores 需要加密的持久化存储
+type stores struct {
+	configPath string
+	config     *config.Config
+	push       *push.Manager
+	admission  *gb28181.AdmissionManager
+	users      *auth.AuthManager
+}
+
+// loadStores 使用当前全局主密钥加载并解密所有存储
+func loadStores(configPath, pushFile, admissionFile string) (*stores, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载配置 %s 失败: %w", configPath, err)
+	}
+
+	st := &stores{configPath: configPath, config: cfg}
+	if _, err := os.Stat(pushFile); err == nil {
+		st.push = push.NewManager(nil, pushFile, 0)
+	}
+	if admissionFile == "" {
+		admissionFile = gb28181.AdmissionFilePath(cfg.GB28181)
+	}
+	if _, err := os.Stat(admissionFile); err == nil {
+		st.admission = gb28181.NewAdmissionManager(admissionFile)
+	}
+	// 用户文件中的 TOTP 密钥
+	if cfg.Auth != nil && cfg.Auth.UsersFile != "" {
+		if _, err := os.Stat(cfg.Auth.UsersFile); err == nil {
+			st.users = auth.NewAuthManager(&auth.AuthConfig{
+				UsersFile:       cfg.Auth.UsersFile,
+				DefaultAdmin:    cfg.Auth.DefaultAdmin,
+				DefaultPassword: cfg.Auth.DefaultPassword,
+			})
+		}
+	}
+	return st, nil
+}
+
+// save 使用当前全局主密钥加密并写回所有存储
+func (st *stores) save() error {
+	if err := st.config.Save(st.configPath); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+	if st.push != nil {
+		if err := st.push.RewriteTargets(); err != nil {
+			return fmt.Errorf("保存推流目标失败: %w", err)
+		}
+	}
+	if st.admission != nil {
+		if err := st.admission.Rewrite(); err != nil {
+			return fmt.Errorf("保存设备准入策略失败: %w", err)
+		}
+	}
+	if st.users != nil {
+		if err := st.users.RewriteUsers(); err != nil {
+			return fmt.Errorf("保存用户文件失败: %w", err)
+		}
+	}
+	return nil
+}
+
+func storeFlags(fs *flag.FlagSet) (*string, *string, *string) {
+	configPath := fs.String("config", "configs/config.yaml", "配置文件路径")
+	pushFile := fs.String("push", "configs/push_targets.json", "推流目标文件路径")
+	admissionFile := fs.String("admission", "", "GB28181 设备准入策略文件路径（默认取配置 GB28181.AdmissionFile）")
+	return configPath, pushFile, admissionFile
+}
+
+// runEncrypt 将存量明文凭据加密
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	configPath, pushFile, admissionFile := storeFlags(fs)
+	fs.Parse(args)
+
+	keyring, err := secrets.Default()
+	if err != nil {
+		return err
+	}
+
+	st, err := loadStores(*configPath, *pushFile, *admissionFile)
+	if err != nil {
+		return err
+	}
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("凭据已使用主密钥 %s 加密\n", keyring.ID())
+	return nil
+}
+
+// runRotate 轮换主密钥
+// 新密钥先写入 <密钥文件>.new，所有存储重写成功后才替换正式密钥文件，旧密钥备份为 .bak-<时间>
+func runRotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	configPath, pushFile, admissionFile := storeFlags(fs)
+	newKeyFile := fs.String("new-key-file", "", "新主密钥文件（为空则自动生成）")
+	fs.Parse(args)
+
+	oldKeyring, err := secrets.Default()
+	if err != nil {
+		return fmt.Errorf("加载当前主密钥失败: %w", err)
+	}
+
+	st, err := loadStores(*configPath, *pushFile, *admissionFile)
+	if err != nil {
+		return err
+	}
+
+	var newKey []byte
+	if *newKeyFile != "" {
+		if newKey, err = secrets.ReadKeyFile(*newKeyFile); err != nil {
+			return fmt.Errorf("读取新主密钥失败: %w", err)
+		}
+	} else {
+		newKey = secrets.GenerateKey()
+	}
+	newKeyring, err := secrets.NewKeyring(newKey)
+	if err != nil {
+		return err
+	}
+	if newKeyring.ID() == oldKeyring.ID() {
+		return fmt.Errorf("新主密钥与当前主密钥相同")
+	}
+
+	fromEnv := os.Getenv(secrets.EnvKey) != ""
+	keyFile := secrets.KeyFilePath()
+	pendingFile := keyFile + ".new"
+	if !fromEnv {
+		if err := secrets.WriteKeyFile(pendingFile, newKey); err != nil {
+			return fmt.Errorf("写入新主密钥失败: %w", err)
+		}
+	}
+
+	secrets.SetDefault(newKeyring)
+	if err := st.save(); err != nil {
+		if !fromEnv {
+			return fmt.Errorf("%w（部分存储可能已使用新密钥 %s 加密，新密钥保存在 %s）", err, newKeyring.ID(), pendingFile)
+		}
+		return err
+	}
+
+	if fromEnv {
+		// 主密钥来自环境变量时无法替换，输出新密钥由运维更新
+		fmt.Printf("凭据已使用新主密钥 %s 重新加密，请将 %s 更新为:\n%s\n", newKeyring.ID(), secrets.EnvKey, secrets.EncodeKey(newKey))
+		return nil
+	}
+
+	backupFile := fmt.Sprintf("%s.bak-%s", keyFile, time.Now().Format("20060102150405"))
+	if err := os.Rename(keyFile, backupFile); err != nil {
+		return fmt.Errorf("备份旧主密钥失败: %w（新密钥保存在 %s）", err, pendingFile)
+	}
+	if err := os.Rename(pendingFile, keyFile); err != nil {
+		return fmt.Errorf("替换主密钥失败: %w（新密钥保存在 %s）", err, pendingFile)
+	}
+
+	fmt.Printf("主密钥已轮换: %s -> %s，旧密钥备份为 %s\n", oldKeyring.ID(), newKeyring.ID(), backupFile)
+	return nil
+}
@@ -0,0 +1,487 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/audit"
+	"gb28181-onvif-server/internal/auth"
+	"gb28181-onvif-server/internal/debug"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// auditBodyLimit 审计记录中请求/响应体的最大读取长度
+	auditBodyLimit = 64 * 1024
+	// auditRedacted 敏感字段替换值
+	auditRedacted = "******"
+)
+
+// auditSkipPaths 不记录审计的高频或只读性质的 POST 接口
+var auditSkipPaths = map[string]bool{
+	"/api/ai/detect":         true,
+	"/api/auth/refresh":      true,
+	"/api/auth/validate":     true,
+	"/api/preview/heartbeat": true,
+}
+
+// auditSensitiveKeys 需要在审计参数中脱敏的字段（小写，包含匹配）
+var auditSensitiveKeys = []string{"password", "secret", "token", "passphrase", "stream_key", "streamkey", "apikey", "api_key", "credential"}
+
+// initAudit 初始化审计日志
+func (s *Server) initAudit() {
+	if s.config.Audit == nil || !s.config.Audit.Enable {
+		return
+	}
+
+	logger, err := audit.NewLogger(&audit.Config{
+		Enable:     s.config.Audit.Enable,
+		Dir:        s.config.Audit.Dir,
+		MaxSizeMB:  s.config.Audit.MaxSizeMB,
+		MaxBackups: s.config.Audit.MaxBackups,
+	})
+	if err != nil {
+		debug.Error("api", "审计日志初始化失败: %v", err)
+		return
+	}
+
+	s.auditLogger = logger
+	debug.Info("api", "审计日志已启用，目录: %s", s.config.Audit.Dir)
+}
+
+// requireRole 包装需要指定角色的处理函数（认证未启用时直接放行）
+func (s *Server) requireRole(role auth.Role, handler http.HandlerFunc) http.Handler {
+	if s.authMiddleware == nil {
+		return handler
+	}
+	return s.authMiddleware.RequireRole(role)(handler)
+}
+
+// auditResponseWriter 在记录状态码的同时截取响应体，用于判断操作结果
+type auditResponseWriter struct {
+	*loggingResponseWriter
+	body bytes.Buffer
+}
+
+func (aw *auditResponseWriter) Write(b []byte) (int, error) {
+	if remain := auditBodyLimit - aw.body.Len(); remain > 0 {
+		if len(b) > remain {
+			aw.body.Write(b[:remain])
+		} else {
+			aw.body.Write(b)
+		}
+	}
+	return aw.loggingResponseWriter.Write(b)
+}
+
+// auditMiddleware 记录所有修改类 API 调用（POST/PUT/DELETE/PATCH）
+func (s *Server) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auditLogger == nil || !isAuditedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		// 读取请求体用于记录参数，然后恢复以供后续处理（文件上传不读取）
+		var bodyBytes []byte
+		if r.Body != nil && !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			bodyBytes, _ = io.ReadAll(io.LimitReader(r.Body, auditBodyLimit))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(bodyBytes), r.Body))
+		}
+
+		aw := &auditResponseWriter{
+			loggingResponseWriter: &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK},
+		}
+		next.ServeHTTP(aw, r)
+
+		entry := &audit.Entry{
+			Time:       start,
+			IP:         s.clientIP(r),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			StatusCode: aw.statusCode,
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+
+		template := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if t, err := route.GetPathTemplate(); err == nil {
+				template = t
+			}
+		}
+		entry.Action = auditAction(r.Method, template)
+		entry.Params = auditParams(r, bodyBytes)
+		entry.Target = auditTarget(template, mux.Vars(r), entry.Params)
+
+		if user := auth.GetUserFromContext(r.Context()); user != nil {
+			entry.User = user.Username
+		} else if name, ok := entry.Params["username"].(string); ok {
+			// 登录等未认证请求记录尝试使用的用户名
+			entry.User = name
+		}
+		if claims := auth.GetClaimsFromContext(r.Context()); claims != nil {
+			entry.Role = string(claims.Role)
+		}
+		if key := auth.GetAPIKeyFromContext(r.Context()); key != nil {
+			entry.APIKey = key.Prefix
+		}
+
+		entry.Result, entry.Error = auditResult(aw.statusCode, aw.body.Bytes())
+
+		if err := s.auditLogger.Record(entry); err != nil {
+			debug.Error("api", "写入审计日志失败: %v", err)
+		}
+	})
+}
+
+// isAuditedRequest 判断请求是否需要审计
+func isAuditedRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch:
+	default:
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return false
+	}
+	return !auditSkipPaths[r.URL.Path]
+}
+
+// auditAction 根据路由模板生成操作名称
+// 例如 "DELETE /api/gb28181/devices/{id}" -> "gb28181.devices.delete"，
+// "POST /api/push/targets/{id}/start" -> "push.targets.start"
+func auditAction(method, template string) string {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(template, "/api/"), "/"), "/")
+	parts := make([]string, 0, len(segments)+1)
+	endsWithVar := false
+	for _, seg := range segments {
+		if strings.HasPrefix(seg, "{") {
+			endsWithVar = true
+			continue
+		}
+		endsWithVar = false
+		parts = append(parts, seg)
+	}
+
+	// 资源集合或单个资源本身的操作，附加方法对应的动词
+	last := ""
+	if len(parts) > 0 {
+		last = parts[len(parts)-1]
+	}
+	if endsWithVar || strings.HasSuffix(last, "s") {
+		switch method {
+		case http.MethodPost:
+			if !endsWithVar {
+				parts = append(parts, "create")
+			}
+		case http.MethodPut, http.MethodPatch:
+			parts = append(parts, "update")
+		case http.MethodDelete:
+			parts = append(parts, "delete")
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// auditTarget 提取操作对象：优先使用路由变量，其次使用常见的 ID 参数
+func auditTarget(template string, vars map[string]string, params map[string]interface{}) string {
+	var values []string
+	for _, seg := range strings.Split(template, "/") {
+		if !strings.HasPrefix(seg, "{") {
+			continue
+		}
+		name := strings.Trim(seg, "{}")
+		if idx := strings.Index(name, ":"); idx >= 0 {
+			name = name[:idx]
+		}
+		if v := vars[name]; v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) > 0 {
+		return strings.Join(values, "/")
+	}
+
+	for _, key := range []string{"id", "username", "channel_id", "channelId", "device_id", "deviceId", "stream_id", "streamId"} {
+		if v, ok := params[key]; ok {
+			if str := fmt.Sprint(v); str != "" {
+				return str
+			}
+		}
+	}
+	return ""
+}
+
+// auditParams 合并查询参数和 JSON 请求体，并对敏感字段脱敏
+func auditParams(r *http.Request, body []byte) map[string]interface{} {
+	params := make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		if len(values) == 1 {
+			params[key] = values[0]
+		} else {
+			params[key] = values
+		}
+	}
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		var bodyMap map[string]interface{}
+		if err := json.Unmarshal(body, &bodyMap); err == nil {
+			for k, v := range bodyMap {
+				params[k] = v
+			}
+		}
+	}
+
+	redactParams(params)
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// redactParams 递归脱敏敏感字段
+func redactParams(params map[string]interface{}) {
+	for key, value := range params {
+		if isSensitiveKey(key) {
+			if str, ok := value.(string); !ok || str != "" {
+				params[key] = auditRedacted
+			}
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			redactParams(v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					redactParams(m)
+				}
+			}
+		}
+	}
+}
+
+// isSensitiveKey 判断字段名是否敏感
+func isSensitiveKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, s := range auditSensitiveKeys {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditResult 根据状态码和响应体判断操作结果
+func auditResult(statusCode int, body []byte) (string, string) {
+	var resp struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &resp)
+
+	if statusCode >= 400 || (resp.Success != nil && !*resp.Success) {
+		errMsg := resp.Error
+		if errMsg == "" {
+			errMsg = resp.Message
+		}
+		if errMsg == "" {
+			errMsg = http.StatusText(statusCode)
+		}
+		return audit.ResultFailure, errMsg
+	}
+	return audit.ResultSuccess, ""
+}
+
+// parseTrustedProxies 解析受信任的反向代理列表，单个 IP 视为 /32 或 /128
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				debug.Warn("api", "忽略无效的受信任代理地址: %s", entry)
+				continue
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			debug.Warn("api", "忽略无效的受信任代理网段: %s", entry)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// isTrustedProxy 检查地址是否属于受信任的反向代理
+func (s *Server) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 获取客户端 IP
+// 默认使用连接的对端地址；仅当对端为受信任代理时才采用转发头，
+// X-Forwarded-For 从右向左跳过受信任代理，取第一个不受信任的地址
+func (s *Server) clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !s.isTrustedProxy(remote) {
+		return remote
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if i == 0 || !s.isTrustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		return xri
+	}
+	return remote
+}
+
+// parseAuditFilter 从查询参数解析审计过滤条件
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	filter := audit.Filter{
+		User:   q.Get("user"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+		IP:     q.Get("ip"),
+		Result: q.Get("result"),
+	}
+
+	for name, dst := range map[string]*time.Time{"start": &filter.Start, "end": &filter.End} {
+		value := q.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := parseAuditTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*dst = t
+	}
+
+	return filter, nil
+}
+
+// parseAuditTime 支持 RFC3339、"2006-01-02 15:04:05"、日期和 Unix 秒
+func parseAuditTime(value string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time")
+}
+
+// handleQueryAuditLogs 查询审计日志
+func (s *Server) handleQueryAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if s.auditLogger == nil {
+		respondServiceUnavailable(w, "审计日志未启用")
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 1000 {
+		pageSize = 50
+	}
+	filter.Offset = (page - 1) * pageSize
+	filter.Limit = pageSize
+
+	entries, total, err := s.auditLogger.Query(filter)
+	if err != nil {
+		respondInternalError(w, "查询审计日志失败: "+err.Error())
+		return
+	}
+
+	respondSuccess(w, map[string]interface{}{
+		"entries":   entries,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// handleExportAuditLogs 以 CSV 导出审计日志
+func (s *Server) handleExportAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if s.auditLogger == nil {
+		respondServiceUnavailable(w, "审计日志未启用")
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	entries, _, err := s.auditLogger.Query(filter)
+	if err != nil {
+		respondInternalError(w, "查询审计日志失败: "+err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	// UTF-8 BOM，便于 Excel 正确识别中文
+	w.Write([]byte("\xEF\xBB\xBF"))
+	if err := audit.WriteCSV(w, entries); err != nil {
+		debug.Error("api", "导出审计日志失败: %v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"gb28181-onvif-server/internal/ai"
 	"gb28181-onvif-server/internal/config"
 	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/gb28181"
 )
 
 // handleStartAIRecording 启动AI录像
@@ -72,7 +73,7 @@ func (s *Server) handleStartAIRecording(w http.ResponseWriter, r *http.Request)
 
 		// 自动启动预览
 		debug.Info("ai", "通道 %s 未启动预览，自动启动预览...", req.ChannelID)
-		previewRes, err := s.startPreview(r, deviceID, req.ChannelID, "", app)
+		previewRes, err := s.startPreview(r, deviceID, req.ChannelID, gb28181.StreamQualityMain, "", app)
 		if err != nil {
 			respondInternalError(w, fmt.Sprintf("自动启动预览失败: %v", err))
 			return
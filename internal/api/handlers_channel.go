@@ -20,14 +20,17 @@ func (s *Server) handleListChannels(w http.ResponseWriter, r *http.Request) {
 	channelsWithAI := make([]map[string]interface{}, 0, len(channels))
 	for _, channel := range channels {
 		channelData := map[string]interface{}{
-			"channelId":    channel.ChannelID,
-			"channelName":  channel.ChannelName,
-			"deviceId":     channel.DeviceID,
-			"deviceType":   channel.DeviceType,
-			"status":       channel.Status,
-			"streamUrl":    channel.StreamURL,
-			"channel":      channel.Channel,
-			"profileToken": channel.ProfileToken,
+			"channelId":       channel.ChannelID,
+			"channelName":     channel.ChannelName,
+			"deviceId":        channel.DeviceID,
+			"deviceType":      channel.DeviceType,
+			"status":          channel.Status,
+			"streamUrl":       channel.StreamURL,
+			"channel":         channel.Channel,
+			"profileToken":    channel.ProfileToken,
+			"parentId":        channel.ParentID,
+			"businessGroupId": channel.BusinessGroupID,
+			"civilCode":       channel.CivilCode,
 		}
 
 		// 获取AI录像状态
@@ -56,14 +59,17 @@ func (s *Server) handleGetChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	channelData := map[string]interface{}{
-		"channelId":    channel.ChannelID,
-		"channelName":  channel.ChannelName,
-		"deviceId":     channel.DeviceID,
-		"deviceType":   channel.DeviceType,
-		"status":       channel.Status,
-		"streamUrl":    channel.StreamURL,
-		"channel":      channel.Channel,
-		"profileToken": channel.ProfileToken,
+		"channelId":       channel.ChannelID,
+		"channelName":     channel.ChannelName,
+		"deviceId":        channel.DeviceID,
+		"deviceType":      channel.DeviceType,
+		"status":          channel.Status,
+		"streamUrl":       channel.StreamURL,
+		"channel":         channel.Channel,
+		"profileToken":    channel.ProfileToken,
+		"parentId":        channel.ParentID,
+		"businessGroupId": channel.BusinessGroupID,
+		"civilCode":       channel.CivilCode,
 	}
 
 	// 获取AI录像状态
@@ -177,6 +183,9 @@ func (s *Server) handleDeleteChannel(w http.ResponseWriter, r *http.Request) {
 		respondInternalError(w, fmt.Sprintf("删除通道失败: %v", err))
 		return
 	}
+	if err := s.orgTree.RemoveChannel("", channelID); err != nil {
+		debug.Warn("api", "从分组移出已删除通道失败: %v", err)
+	}
 
 	respondSuccessMsg(w, "通道删除成功")
 }
@@ -364,3 +373,159 @@ func (s *Server) handleGetChannelRecordingStatus(w http.ResponseWriter, r *http.
 		"isRecording": isRecording,
 	})
 }
+
+// handleGetChannelTree 获取通道树
+// source=custom 仅返回自定义分组，source=device 仅返回设备目录层级，默认两者都返回
+// flat=true 时返回带 parentId 的节点列表（先序），可直接用于级联目录应答
+func (s *Server) handleGetChannelTree(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "custom" && source != "device" {
+		respondBadRequest(w, fmt.Sprintf("不支持的 source: %s", source))
+		return
+	}
+
+	var roots []*TreeNode
+	if source != "device" {
+		roots = append(roots, s.orgTree.buildCustomTree(s.channelTreeNode)...)
+	}
+	if source != "custom" {
+		roots = append(roots, s.buildDeviceTree()...)
+	}
+
+	if r.URL.Query().Get("flat") == "true" {
+		nodes := flattenTree(roots)
+		if nodes == nil {
+			nodes = []*TreeNode{}
+		}
+		respondRaw(w, http.StatusOK, map[string]interface{}{
+			"nodes": nodes,
+			"total": len(nodes),
+		})
+		return
+	}
+
+	if roots == nil {
+		roots = []*TreeNode{}
+	}
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"tree": roots,
+	})
+}
+
+// handleListChannelGroups 获取自定义分组列表
+func (s *Server) handleListChannelGroups(w http.ResponseWriter, r *http.Request) {
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"groups": s.orgTree.GetGroups(),
+	})
+}
+
+// handleCreateChannelGroup 创建自定义分组
+func (s *Server) handleCreateChannelGroup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		ParentID string `json:"parentId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+	if req.Type == "" {
+		req.Type = TreeNodeVirtualOrg
+		if req.ParentID == "" {
+			req.Type = TreeNodeBusinessGroup
+		}
+	}
+
+	group, err := s.orgTree.CreateGroup(req.ID, strings.TrimSpace(req.Name), req.Type, req.ParentID)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+	})
+}
+
+// handleUpdateChannelGroup 修改分组名称或移动分组
+func (s *Server) handleUpdateChannelGroup(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["groupId"]
+
+	var req struct {
+		Name     string  `json:"name"`
+		ParentID *string `json:"parentId"` // 省略时保持原父分组
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	group, err := s.orgTree.UpdateGroup(groupID, strings.TrimSpace(req.Name), req.ParentID)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+	})
+}
+
+// handleDeleteChannelGroup 删除分组
+func (s *Server) handleDeleteChannelGroup(w http.ResponseWriter, r *http.Request) {
+	if err := s.orgTree.DeleteGroup(mux.Vars(r)["groupId"]); err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	respondSuccessMsg(w, "分组删除成功")
+}
+
+// handleAssignGroupChannels 将通道拖入分组（GB28181 与 ONVIF 通道均可），通道会从原分组移出
+func (s *Server) handleAssignGroupChannels(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["groupId"]
+
+	var req struct {
+		ChannelIDs []string `json:"channelIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+	if len(req.ChannelIDs) == 0 {
+		respondBadRequest(w, "channelIds 不能为空")
+		return
+	}
+	for _, channelID := range req.ChannelIDs {
+		if s.channelTreeNode(channelID) == nil {
+			respondBadRequest(w, fmt.Sprintf("通道不存在: %s", channelID))
+			return
+		}
+	}
+
+	group, err := s.orgTree.AssignChannels(groupID, req.ChannelIDs)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+	})
+}
+
+// handleRemoveGroupChannel 将通道移出分组
+func (s *Server) handleRemoveGroupChannel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := s.orgTree.RemoveChannel(vars["groupId"], vars["channelId"]); err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	respondSuccessMsg(w, "通道已移出分组")
+}
@@ -1,9 +1,18 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/gb28181"
+
+	"github.com/gorilla/mux"
 )
 
 // handlePTZControl PTZ控制
@@ -55,3 +64,257 @@ func (s *Server) handlePTZReset(w http.ResponseWriter, r *http.Request) {
 		"message": "PTZ复位命令发送成功",
 	})
 }
+
+// handleGB28181GetPresets 从设备查询通道预置位列表（PresetQuery）
+func (s *Server) handleGB28181GetPresets(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if _, exists := s.gb28181Server.GetDeviceByID(vars["id"]); !exists {
+		respondNotFound(w, "设备不存在")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryWaitTimeout(r, "timeout", 10*time.Second))
+	defer cancel()
+	presets, err := s.gb28181Server.QueryPresetsSync(ctx, vars["id"], vars["channelId"])
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("查询预置位失败: %v", err))
+		return
+	}
+	if presets == nil {
+		presets = []gb28181.PresetItem{}
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"channelId": vars["channelId"],
+		"count":     len(presets),
+		"presets":   presets,
+		"complete":  err == nil,
+	})
+}
+
+// handleGB28181SetPreset 设置预置位（保存当前位置）
+func (s *Server) handleGB28181SetPreset(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req struct {
+		Preset int `json:"preset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	if err := s.gb28181Server.PresetControl(vars["id"], vars["channelId"], "set", req.Preset); err != nil {
+		respondBadRequest(w, fmt.Sprintf("设置预置位失败: %v", err))
+		return
+	}
+
+	respondSuccessMsg(w, "预置位设置命令已发送")
+}
+
+// handleGB28181GotoPreset 调用预置位
+func (s *Server) handleGB28181GotoPreset(w http.ResponseWriter, r *http.Request) {
+	s.presetAction(w, r, "goto", "调用预置位")
+}
+
+// handleGB28181DeletePreset 删除预置位
+func (s *Server) handleGB28181DeletePreset(w http.ResponseWriter, r *http.Request) {
+	s.presetAction(w, r, "delete", "删除预置位")
+}
+
+// presetAction 按路径中的预置位号执行预置位操作
+func (s *Server) presetAction(w http.ResponseWriter, r *http.Request, action, desc string) {
+	vars := mux.Vars(r)
+
+	preset, err := strconv.Atoi(vars["preset"])
+	if err != nil {
+		respondBadRequest(w, "无效的预置位号")
+		return
+	}
+
+	if err := s.gb28181Server.PresetControl(vars["id"], vars["channelId"], action, preset); err != nil {
+		respondBadRequest(w, fmt.Sprintf("%s失败: %v", desc, err))
+		return
+	}
+
+	respondSuccessMsg(w, desc+"命令已发送")
+}
+
+// handleGB28181Cruise 巡航控制
+// action: add（加入预置位 value）, delete（删除预置位 value，0 删除整条巡航）, speed, dwell（秒）, start, stop
+func (s *Server) handleGB28181Cruise(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req struct {
+		Action string `json:"action"`
+		Group  int    `json:"group"`
+		Value  int    `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	if err := s.gb28181Server.CruiseControl(vars["id"], vars["channelId"], req.Action, req.Group, req.Value); err != nil {
+		respondBadRequest(w, fmt.Sprintf("巡航控制失败: %v", err))
+		return
+	}
+
+	respondSuccessMsg(w, "巡航控制命令已发送")
+}
+
+// handleGB28181Scan 自动扫描控制
+// action: start, left（设置左边界）, right（设置右边界）, speed, stop
+func (s *Server) handleGB28181Scan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req struct {
+		Action string `json:"action"`
+		Group  int    `json:"group"`
+		Value  int    `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	if err := s.gb28181Server.ScanControl(vars["id"], vars["channelId"], req.Action, req.Group, req.Value); err != nil {
+		respondBadRequest(w, fmt.Sprintf("扫描控制失败: %v", err))
+		return
+	}
+
+	respondSuccessMsg(w, "扫描控制命令已发送")
+}
+
+// handleGB28181DeviceControl 设备控制：远程重启、录像、布撤防、报警复位、强制关键帧、拉框缩放
+// command: reboot, record_start, record_stop, guard_arm, guard_disarm, alarm_reset, iframe, dragzoom_in, dragzoom_out
+func (s *Server) handleGB28181DeviceControl(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Command   string            `json:"command"`
+		ChannelID string            `json:"channelId"`
+		DragZoom  *gb28181.DragZoom `json:"dragZoom"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	if _, exists := s.gb28181Server.GetDeviceByID(deviceID); !exists {
+		respondNotFound(w, "设备不存在")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryWaitTimeout(r, "timeout", 10*time.Second))
+	defer cancel()
+
+	var result string
+	var err error
+	switch req.Command {
+	case "reboot":
+		if err = s.gb28181Server.TeleBoot(ctx, deviceID); err == nil {
+			respondSuccessMsg(w, "远程重启命令已送达设备")
+			return
+		}
+	case "record_start", "record_stop":
+		result, err = s.gb28181Server.RecordControl(ctx, deviceID, req.ChannelID, req.Command == "record_start")
+	case "guard_arm", "guard_disarm":
+		result, err = s.gb28181Server.GuardControl(ctx, deviceID, req.ChannelID, req.Command == "guard_arm")
+	case "alarm_reset":
+		result, err = s.gb28181Server.ResetAlarm(ctx, deviceID, req.ChannelID)
+	case "iframe":
+		result, err = s.gb28181Server.ForceKeyFrame(ctx, deviceID, req.ChannelID)
+	case "dragzoom_in", "dragzoom_out":
+		if req.DragZoom == nil {
+			respondBadRequest(w, "拉框缩放需要 dragZoom 参数")
+			return
+		}
+		result, err = s.gb28181Server.DragZoomControl(ctx, deviceID, req.ChannelID, req.Command == "dragzoom_in", *req.DragZoom)
+	default:
+		respondBadRequest(w, fmt.Sprintf("不支持的控制命令: %s", req.Command))
+		return
+	}
+
+	if err != nil {
+		respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("设备控制失败: %v", err))
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": strings.EqualFold(result, "OK"),
+		"command": req.Command,
+		"result":  result,
+	})
+}
+
+// handleGB28181GetDeviceConfig 查询设备配置（ConfigDownload）
+// type 参数为逗号分隔的配置类型，默认 BasicParam；设备未在超时内返回全部类型时 complete 为 false
+func (s *Server) handleGB28181GetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	types, err := gb28181.ParseConfigTypes(r.URL.Query().Get("type"))
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	if _, exists := s.gb28181Server.GetDeviceByID(deviceID); !exists {
+		respondNotFound(w, "设备不存在")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryWaitTimeout(r, "timeout", 10*time.Second))
+	defer cancel()
+	config, err := s.gb28181Server.QueryConfigSync(ctx, deviceID, r.URL.Query().Get("channelId"), types)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("查询设备配置失败: %v", err))
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"deviceId": deviceID,
+		"types":    types,
+		"config":   config,
+		"complete": err == nil,
+	})
+}
+
+// handleGB28181SetDeviceConfig 下发设备配置（DeviceConfig）
+func (s *Server) handleGB28181SetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	var req struct {
+		ChannelID string `json:"channelId"`
+		gb28181.DeviceConfig
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+	if req.BasicParam == nil && req.SVACEncodeConfig == nil && req.SVACDecodeConfig == nil {
+		respondBadRequest(w, "至少需要 basicParam、svacEncodeConfig、svacDecodeConfig 之一")
+		return
+	}
+
+	if _, exists := s.gb28181Server.GetDeviceByID(deviceID); !exists {
+		respondNotFound(w, "设备不存在")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryWaitTimeout(r, "timeout", 10*time.Second))
+	defer cancel()
+	result, err := s.gb28181Server.SetDeviceConfig(ctx, deviceID, req.ChannelID, req.DeviceConfig)
+	if err != nil {
+		respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("下发设备配置失败: %v", err))
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": strings.EqualFold(result, "OK"),
+		"result":  result,
+	})
+}
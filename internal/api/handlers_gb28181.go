@@ -1,10 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/gb28181"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -59,9 +64,33 @@ func (s *Server) handleGetGB28181Channels(w http.ResponseWriter, r *http.Request
 }
 
 // handleRefreshGB28181Device 刷新设备信息和通道列表
+// 携带 wait 参数时等待设备应答，返回最新的设备信息和通道列表
 func (s *Server) handleRefreshGB28181Device(w http.ResponseWriter, r *http.Request) {
 	deviceID := mux.Vars(r)["id"]
 
+	if wait := queryWaitTimeout(r, "wait", 0); wait > 0 {
+		if _, exists := s.gb28181Server.GetDeviceByID(deviceID); !exists {
+			respondNotFound(w, "设备不存在")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		info, err := s.gb28181Server.QueryDeviceInfoSync(ctx, deviceID)
+		if err != nil {
+			respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("查询设备信息失败: %v", err))
+			return
+		}
+		channels, err := s.gb28181Server.QueryCatalogSync(ctx, deviceID)
+		respondRaw(w, http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"info":     info,
+			"channels": channels,
+			"complete": err == nil,
+		})
+		return
+	}
+
 	if err := s.gb28181Server.QueryDeviceInfo(deviceID); err != nil {
 		respondNotFound(w, err.Error())
 		return
@@ -75,6 +104,30 @@ func (s *Server) handleRefreshGB28181Device(w http.ResponseWriter, r *http.Reque
 	respondSuccessMsg(w, "已发送设备信息和目录查询请求")
 }
 
+// handleGB28181DeviceStatus 向设备查询运行状态（DeviceStatus）并返回最新结果
+func (s *Server) handleGB28181DeviceStatus(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	if _, exists := s.gb28181Server.GetDeviceByID(deviceID); !exists {
+		respondNotFound(w, "设备不存在")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryWaitTimeout(r, "timeout", 10*time.Second))
+	defer cancel()
+	status, err := s.gb28181Server.QueryDeviceStatusSync(ctx, deviceID)
+	if err != nil {
+		respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("查询设备状态失败: %v", err))
+		return
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"deviceId": deviceID,
+		"status":   status,
+	})
+}
+
 // handleGetGB28181Statistics 获取GB28181统计信息
 func (s *Server) handleGetGB28181Statistics(w http.ResponseWriter, r *http.Request) {
 	stats := s.gb28181Server.GetStatistics()
@@ -94,6 +147,8 @@ func (s *Server) handleGetGB28181ServerConfig(w http.ResponseWriter, r *http.Req
 		"heartbeat_interval": s.config.GB28181.HeartbeatInterval,
 		"register_expires":   s.config.GB28181.RegisterExpires,
 		"auth_enabled":       s.config.GB28181.Password != "",
+		"stream_mode":        s.config.GB28181.StreamMode,
+		"media_fallback":     s.config.GB28181.MediaFallbackTimeout,
 	}
 	respondRaw(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -110,6 +165,8 @@ func (s *Server) handleUpdateGB28181ServerConfig(w http.ResponseWriter, r *http.
 		ServerID        string `json:"server_id"`
 		Password        string `json:"password"`
 		RegisterExpires int    `json:"register_expires"`
+		StreamMode      string `json:"stream_mode"`
+		MediaFallback   *int   `json:"media_fallback"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -134,6 +191,17 @@ func (s *Server) handleUpdateGB28181ServerConfig(w http.ResponseWriter, r *http.
 	if req.RegisterExpires > 0 {
 		s.config.GB28181.RegisterExpires = req.RegisterExpires
 	}
+	if req.StreamMode != "" {
+		mode, err := gb28181.ParseStreamMode(req.StreamMode)
+		if err != nil {
+			respondBadRequest(w, err.Error())
+			return
+		}
+		s.config.GB28181.StreamMode = mode
+	}
+	if req.MediaFallback != nil {
+		s.config.GB28181.MediaFallbackTimeout = *req.MediaFallback
+	}
 
 	if err := s.config.Save(s.configPath); err != nil {
 		respondInternalError(w, fmt.Sprintf("保存配置失败: %v", err))
@@ -143,6 +211,31 @@ func (s *Server) handleUpdateGB28181ServerConfig(w http.ResponseWriter, r *http.
 	respondSuccessMsg(w, "配置已保存，需要重启服务器生效")
 }
 
+// handleSetGB28181StreamMode 设置设备的媒体传输模式（UDP/TCP-Passive/TCP-Active）
+func (s *Server) handleSetGB28181StreamMode(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	var req struct {
+		StreamMode string `json:"stream_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, "无效的请求数据")
+		return
+	}
+
+	if _, exists := s.gb28181Server.GetDeviceByID(deviceID); !exists {
+		respondNotFound(w, "设备不存在")
+		return
+	}
+
+	if err := s.gb28181Server.SetDeviceStreamMode(deviceID, req.StreamMode); err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	respondSuccessMsg(w, "媒体传输模式已更新，下次预览生效")
+}
+
 // handleGB28181Catalog 触发GB28181设备目录查询
 func (s *Server) handleGB28181Catalog(w http.ResponseWriter, r *http.Request) {
 	deviceID := mux.Vars(r)["id"]
@@ -162,6 +255,24 @@ func (s *Server) handleGB28181Catalog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 携带 wait 参数时等待设备返回全部目录分包
+	if wait := queryWaitTimeout(r, "wait", 0); wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		channels, err := s.gb28181Server.QueryCatalogSync(ctx, deviceID)
+		if err != nil && len(channels) == 0 {
+			respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("目录查询失败: %v", err))
+			return
+		}
+		respondRaw(w, http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"channels": channels,
+			"count":    len(channels),
+			"complete": err == nil,
+		})
+		return
+	}
+
 	if err := s.gb28181Server.SendCatalogQuery(deviceID); err != nil {
 		respondInternalError(w, fmt.Sprintf("发送目录查询失败: %v", err))
 		return
@@ -170,6 +281,25 @@ func (s *Server) handleGB28181Catalog(w http.ResponseWriter, r *http.Request) {
 	respondSuccessMsg(w, "目录查询已发送，请等待设备响应后刷新通道列表")
 }
 
+// queryWaitTimeout 解析同步查询的等待时间（秒），参数缺省时返回 def，"true" 使用 10 秒，最长 60 秒
+func queryWaitTimeout(r *http.Request, param string, def time.Duration) time.Duration {
+	value := r.URL.Query().Get(param)
+	switch value {
+	case "":
+		return def
+	case "true":
+		return 10 * time.Second
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return def
+	}
+	if seconds > 60 {
+		seconds = 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 // handleGB28181PTZ 处理GB28181 PTZ控制
 func (s *Server) handleGB28181PTZ(w http.ResponseWriter, r *http.Request) {
 	deviceID := mux.Vars(r)["id"]
@@ -213,6 +343,7 @@ func (s *Server) handleStartGB28181Preview(w http.ResponseWriter, r *http.Reques
 
 	var req struct {
 		ChannelID string `json:"channelId"`
+		Quality   string `json:"quality"` // main, sub, third
 	}
 	json.NewDecoder(r.Body).Decode(&req)
 	if req.ChannelID == "" {
@@ -249,7 +380,7 @@ func (s *Server) handleStartGB28181Preview(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	res, err := s.previewManager.StartChannelPreview(deviceID, req.ChannelID, app, zlmHost, httpPort, rtmpPort)
+	res, err := s.previewManager.StartChannelPreview(deviceID, req.ChannelID, req.Quality, app, zlmHost, httpPort, rtmpPort)
 	if err != nil {
 		respondInternalError(w, fmt.Sprintf("启动预览失败: %v", err))
 		return
@@ -287,20 +418,34 @@ func (s *Server) handleStopGB28181Preview(w http.ResponseWriter, r *http.Request
 
 	var req struct {
 		ChannelID string `json:"channelId"`
+		Quality   string `json:"quality"`
+		LeaseID   string `json:"lease_id"`
 	}
 	json.NewDecoder(r.Body).Decode(&req)
 	if req.ChannelID == "" {
 		req.ChannelID = deviceID
 	}
 
-	streamID := strings.ReplaceAll(req.ChannelID, "-", "")
+	quality, err := gb28181.ParseStreamQuality(req.Quality)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	key := previewSessionKey(deviceID, req.ChannelID, quality)
+	if s.respondIfStillWatched(w, r, key, req.LeaseID) {
+		return
+	}
+	defer s.previewSessions.Remove(key)
+
+	streamID := gb28181.QualityStreamID(strings.ReplaceAll(req.ChannelID, "-", ""), quality)
 
 	// 优先使用 PreviewManager 停止并清理
 	if s.previewManager != nil {
-		if err := s.previewManager.StopChannelPreview(deviceID, req.ChannelID); err != nil {
+		if err := s.previewManager.StopChannelPreview(deviceID, req.ChannelID, quality); err != nil {
 			debug.Warn("api", "停止预览失败: %v", err)
 			// 如果 manager 停止失败，继续尝试底层回退
-			if err := s.gb28181Server.ByeRequest(deviceID, req.ChannelID); err != nil {
+			if err := s.gb28181Server.ByeStreamRequest(deviceID, req.ChannelID, quality); err != nil {
 				debug.Warn("api", "发送BYE失败: %v", err)
 			}
 			if s.zlmServer != nil && s.zlmServer.GetAPIClient() != nil {
@@ -310,7 +455,7 @@ func (s *Server) handleStopGB28181Preview(w http.ResponseWriter, r *http.Request
 		}
 	} else {
 		// 兜底逻辑
-		if err := s.gb28181Server.ByeRequest(deviceID, req.ChannelID); err != nil {
+		if err := s.gb28181Server.ByeStreamRequest(deviceID, req.ChannelID, quality); err != nil {
 			debug.Warn("api", "发送BYE失败: %v", err)
 		}
 		if s.zlmServer != nil && s.zlmServer.GetAPIClient() != nil {
@@ -352,7 +497,7 @@ func (s *Server) handleStartGB28181ChannelPreview(w http.ResponseWriter, r *http
 
 	// 使用 preview.Manager 统一处理 channel 级别的 GB28181 预览
 	app := "rtp"
-	res, err := s.startPreview(r, deviceID, channelID, "", app)
+	res, err := s.startPreview(r, deviceID, channelID, r.URL.Query().Get("quality"), "", app)
 	if err != nil {
 		errMsg := fmt.Sprintf("启动预览失败: %v", err)
 		// 特别处理会话已存在的错误，提供更友好的提示
@@ -377,14 +522,26 @@ func (s *Server) handleStopGB28181ChannelPreview(w http.ResponseWriter, r *http.
 		return
 	}
 
-	streamID := strings.ReplaceAll(channelID, "-", "")
+	quality, err := gb28181.ParseStreamQuality(r.URL.Query().Get("quality"))
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	key := previewSessionKey(deviceID, channelID, quality)
+	if s.respondIfStillWatched(w, r, key, "") {
+		return
+	}
+	defer s.previewSessions.Remove(key)
+
+	streamID := gb28181.QualityStreamID(strings.ReplaceAll(channelID, "-", ""), quality)
 
 	// 优先使用 preview.Manager 停止通道预览
 	if s.previewManager != nil {
-		if err := s.previewManager.StopChannelPreview(deviceID, channelID); err != nil {
+		if err := s.previewManager.StopChannelPreview(deviceID, channelID, quality); err != nil {
 			debug.Warn("api", "停止预览失败: %v", err)
 			// 回退清理
-			if err := s.gb28181Server.ByeRequest(deviceID, channelID); err != nil {
+			if err := s.gb28181Server.ByeStreamRequest(deviceID, channelID, quality); err != nil {
 				debug.Warn("api", "发送BYE失败: %v", err)
 			}
 			if s.zlmServer != nil && s.zlmServer.GetAPIClient() != nil {
@@ -393,7 +550,7 @@ func (s *Server) handleStopGB28181ChannelPreview(w http.ResponseWriter, r *http.
 			}
 		}
 	} else {
-		if err := s.gb28181Server.ByeRequest(deviceID, channelID); err != nil {
+		if err := s.gb28181Server.ByeStreamRequest(deviceID, channelID, quality); err != nil {
 			debug.Warn("api", "发送BYE失败: %v", err)
 		}
 		if s.zlmServer != nil && s.zlmServer.GetAPIClient() != nil {
@@ -434,7 +591,7 @@ func (s *Server) handleTestGB28181ChannelPreview(w http.ResponseWriter, r *http.
 
 	testStreamURL := "rtmp://ns8.indexforce.com/home/mystream"
 
-	res, err := s.previewManager.StartRTSPProxy(channelID, testStreamURL, app, zlmHost, httpPort, rtmpPort, "", "")
+	res, err := s.previewManager.StartRTSPProxy(channelID, gb28181.StreamQualityMain, testStreamURL, app, zlmHost, httpPort, rtmpPort, "", "")
 	if err != nil {
 		debug.Error("api", "添加流代理失败: %v", err)
 		respondInternalError(w, fmt.Sprintf("添加流代理失败: %v", err))
@@ -497,30 +654,16 @@ func (s *Server) handleGB28181QueryRecordInfo(w http.ResponseWriter, r *http.Req
 	// 清空该通道的旧缓存，以获取最新数据
 	s.gb28181Server.ClearRecordCache(channelID)
 
-	// 发送录像查询请求到设备
-	if err := s.gb28181Server.QueryRecordInfo(channelID, startTime, endTime, recordType); err != nil {
+	// 发送录像查询并按 SN 等待设备分包返回，直到收齐 SumNum 条或超时（timeout 参数，默认 10 秒）
+	ctx, cancel := context.WithTimeout(r.Context(), queryWaitTimeout(r, "timeout", 10*time.Second))
+	defer cancel()
+	records, err := s.gb28181Server.QueryRecordInfoSync(ctx, channelID, startTime, endTime, recordType)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
 		respondInternalError(w, fmt.Sprintf("发送录像查询失败: %v", err))
 		return
 	}
-
-	// GB28181录像查询是异步的，等待设备响应（最多等待3秒）
-	// 设备会通过MESSAGE返回录像列表
-	var records []interface{}
-	maxWaitTime := 3000 // 毫秒
-	pollInterval := 100 // 毫秒
-	elapsed := 0
-
-	for elapsed < maxWaitTime {
-		recordList := s.gb28181Server.GetRecordList(channelID)
-		if len(recordList) > 0 {
-			// 有结果，立即返回
-			for _, r := range recordList {
-				records = append(records, r)
-			}
-			break
-		}
-		time.Sleep(time.Duration(pollInterval) * time.Millisecond)
-		elapsed += pollInterval
+	if records == nil {
+		records = []gb28181.DeviceRecordInfo{}
 	}
 
 	// 返回查询结果（即使为空）
@@ -529,6 +672,7 @@ func (s *Server) handleGB28181QueryRecordInfo(w http.ResponseWriter, r *http.Req
 		"channelId": channelID,
 		"count":     len(records),
 		"records":   records,
+		"complete":  err == nil,
 		"startTime": startTime,
 		"endTime":   endTime,
 		"type":      recordType,
@@ -812,3 +956,230 @@ func (s *Server) handleDiagnoseRTPPlayback(w http.ResponseWriter, r *http.Reques
 
 	respondRaw(w, http.StatusOK, diagnosis)
 }
+
+// geoJSONFeature GeoJSON 要素
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoJSONGeometry GeoJSON 几何对象，坐标为 [经度, 纬度]
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// geoJSONFeatureCollection GeoJSON 要素集合
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// positionFeature 将轨迹点转换为 GeoJSON Point 要素
+func positionFeature(position gb28181.Position) geoJSONFeature {
+	return geoJSONFeature{
+		Type: "Feature",
+		Geometry: geoJSONGeometry{
+			Type:        "Point",
+			Coordinates: []float64{position.Longitude, position.Latitude},
+		},
+		Properties: map[string]interface{}{
+			"deviceId":  position.DeviceID,
+			"channelId": position.ChannelID,
+			"time":      position.Time.Format(time.RFC3339),
+			"speed":     position.Speed,
+			"direction": position.Direction,
+			"altitude":  position.Altitude,
+		},
+	}
+}
+
+// parseTrackTime 解析轨迹查询时间，支持 GB28181 时间格式和 RFC3339，空值表示不限制
+func parseTrackTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无效的时间格式: %s", value)
+}
+
+// handleGB28181ChannelTrack 获取通道移动轨迹（GeoJSON）
+// 返回一条 LineString 轨迹线以及每个轨迹点的 Point（含时间、速度、方向）
+func (s *Server) handleGB28181ChannelTrack(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["id"]
+
+	start, err := parseTrackTime(r.URL.Query().Get("start"))
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	end, err := parseTrackTime(r.URL.Query().Get("end"))
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	track, err := s.gb28181Server.GetTrack(channelID, start, end)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	if len(track) >= 2 {
+		line := make([][]float64, 0, len(track))
+		for _, position := range track {
+			line = append(line, []float64{position.Longitude, position.Latitude})
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: line},
+			Properties: map[string]interface{}{
+				"deviceId":  track[0].DeviceID,
+				"channelId": channelID,
+				"startTime": track[0].Time.Format(time.RFC3339),
+				"endTime":   track[len(track)-1].Time.Format(time.RFC3339),
+				"count":     len(track),
+			},
+		})
+	}
+	for _, position := range track {
+		collection.Features = append(collection.Features, positionFeature(position))
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(collection)
+}
+
+// handleGB28181LatestPositions 获取每个设备的最新位置（GeoJSON），用于地图展示
+func (s *Server) handleGB28181LatestPositions(w http.ResponseWriter, r *http.Request) {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, position := range s.gb28181Server.GetLatestPositions() {
+		collection.Features = append(collection.Features, positionFeature(position))
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(collection)
+}
+
+// handleGetGB28181Admission 获取设备准入策略（黑白名单与配置了独立密码的设备）
+func (s *Server) handleGetGB28181Admission(w http.ResponseWriter, r *http.Request) {
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"policy":  s.gb28181Server.AdmissionPolicy(),
+	})
+}
+
+// handleAddGB28181AdmissionRule 添加准入规则
+func (s *Server) handleAddGB28181AdmissionRule(w http.ResponseWriter, r *http.Request) {
+	var req gb28181.AdmissionRule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	rule, err := s.gb28181Server.AddAdmissionRule(req)
+	if err != nil {
+		respondAdmissionError(w, err, http.StatusBadRequest)
+		return
+	}
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"rule":    rule,
+	})
+}
+
+// handleDeleteGB28181AdmissionRule 删除准入规则
+func (s *Server) handleDeleteGB28181AdmissionRule(w http.ResponseWriter, r *http.Request) {
+	if err := s.gb28181Server.Admission().DeleteRule(mux.Vars(r)["ruleId"]); err != nil {
+		respondAdmissionError(w, err, http.StatusNotFound)
+		return
+	}
+	respondSuccessMsg(w, "准入规则已删除")
+}
+
+// handleSetGB28181Credential 设置设备独立密码
+func (s *Server) handleSetGB28181Credential(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	if err := s.gb28181Server.Admission().SetCredential(mux.Vars(r)["id"], req.Password); err != nil {
+		respondAdmissionError(w, err, http.StatusBadRequest)
+		return
+	}
+	respondSuccessMsg(w, "设备密码已设置")
+}
+
+// handleDeleteGB28181Credential 删除设备独立密码
+func (s *Server) handleDeleteGB28181Credential(w http.ResponseWriter, r *http.Request) {
+	if err := s.gb28181Server.Admission().DeleteCredential(mux.Vars(r)["id"]); err != nil {
+		respondAdmissionError(w, err, http.StatusNotFound)
+		return
+	}
+	respondSuccessMsg(w, "设备密码已删除")
+}
+
+// respondAdmissionError 准入策略写入失败返回 500，其余错误使用 status
+func respondAdmissionError(w http.ResponseWriter, err error, status int) {
+	if errors.Is(err, gb28181.ErrAdmissionStore) {
+		respondInternalError(w, err.Error())
+		return
+	}
+	respondError(w, status, err.Error())
+}
+
+// handleGetGB28181PendingDevices 获取待审批设备
+func (s *Server) handleGetGB28181PendingDevices(w http.ResponseWriter, r *http.Request) {
+	pending := s.gb28181Server.Admission().Pending()
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"devices": pending,
+		"total":   len(pending),
+	})
+}
+
+// handleApproveGB28181Device 批准待审批设备，可同时设置独立密码
+// 设备下次 REGISTER 时接入
+func (s *Server) handleApproveGB28181Device(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	rule, err := s.gb28181Server.Admission().Approve(mux.Vars(r)["id"], req.Password)
+	if err != nil {
+		respondAdmissionError(w, err, http.StatusNotFound)
+		return
+	}
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"rule":    rule,
+	})
+}
+
+// handleRejectGB28181Device 拒绝待审批设备，设备ID加入黑名单
+func (s *Server) handleRejectGB28181Device(w http.ResponseWriter, r *http.Request) {
+	rule, err := s.gb28181Server.RejectDevice(mux.Vars(r)["id"])
+	if err != nil {
+		respondAdmissionError(w, err, http.StatusNotFound)
+		return
+	}
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"rule":    rule,
+	})
+}
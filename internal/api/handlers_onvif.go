@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"gb28181-onvif-server/internal/gb28181"
 	"gb28181-onvif-server/internal/onvif"
 
 	"github.com/gorilla/mux"
@@ -265,13 +266,15 @@ func (s *Server) handleStartONVIFPreview(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// 支持从 query 参数或 body 中获取 profileToken 和凭据
+	// 支持从 query 参数或 body 中获取 profileToken、码流和凭据
 	profileToken := r.URL.Query().Get("profileToken")
+	qualityParam := r.URL.Query().Get("quality")
 	var reqUsername, reqPassword string
 
 	// 尝试从 body 中获取参数
 	var reqBody struct {
 		ProfileToken string `json:"profileToken"`
+		Quality      string `json:"quality"` // main, sub, third
 		Username     string `json:"username"`
 		Password     string `json:"password"`
 	}
@@ -279,10 +282,19 @@ func (s *Server) handleStartONVIFPreview(w http.ResponseWriter, r *http.Request)
 		if profileToken == "" {
 			profileToken = reqBody.ProfileToken
 		}
+		if qualityParam == "" {
+			qualityParam = reqBody.Quality
+		}
 		reqUsername = reqBody.Username
 		reqPassword = reqBody.Password
 	}
 
+	quality, err := gb28181.ParseStreamQuality(qualityParam)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
 	// 使用请求中的凭据，或者回退到设备存储的凭据
 	username := reqUsername
 	password := reqPassword
@@ -296,19 +308,15 @@ func (s *Server) handleStartONVIFPreview(w http.ResponseWriter, r *http.Request)
 		username = "admin"
 	}
 
-	// 如果还是没有 profileToken，使用默认的第一个 profile
+	// 如果还是没有 profileToken，按码流对应的分辨率排名选择 profile
 	if profileToken == "" {
-		profiles, err := s.onvifManager.GetProfiles(deviceID)
-		if err == nil && len(profiles) > 0 {
-			if token, ok := profiles[0]["token"].(string); ok {
-				profileToken = token
-				log.Printf("[ONVIF] 使用默认 profile: %s", profileToken)
-			}
+		if token, err := s.onvifManager.GetProfileTokenByRank(deviceID, gb28181.StreamNumber(quality)); err == nil {
+			profileToken = token
+			log.Printf("[ONVIF] 按码流 %s 选择 profile: %s", quality, profileToken)
 		}
 	}
 
 	var rtspURL string
-	var err error
 
 	// 如果有 profileToken，尝试通过 ONVIF 获取流地址
 	if profileToken != "" {
@@ -331,7 +339,7 @@ func (s *Server) handleStartONVIFPreview(w http.ResponseWriter, r *http.Request)
 			password = "a123456789" // 默认密码
 		}
 		// 海康标准 RTSP URL 格式
-		rtspURL = fmt.Sprintf("rtsp://%s:%s@%s:554/Streaming/Channels/101", username, password, device.IP)
+		rtspURL = fmt.Sprintf("rtsp://%s:%s@%s:554/Streaming/Channels/10%d", username, password, device.IP, gb28181.StreamNumber(quality)+1)
 		log.Printf("[ONVIF] 使用默认 RTSP URL 模板: %s", rtspURL)
 	}
 
@@ -341,7 +349,7 @@ func (s *Server) handleStartONVIFPreview(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	res, err := s.startPreview(r, deviceID, "", rtspURL, "onvif")
+	res, err := s.startPreview(r, deviceID, "", quality, rtspURL, "onvif")
 	if err != nil {
 		// 记录详细的错误信息
 		log.Printf("[ONVIF] ⚠️ 启动预览失败: %s (RTSP URL: %s)", err.Error(), rtspURL)
@@ -380,11 +388,23 @@ func (s *Server) handleStopONVIFPreview(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	quality, err := gb28181.ParseStreamQuality(r.URL.Query().Get("quality"))
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
+	key := previewSessionKey(deviceID, "", quality)
+	if s.respondIfStillWatched(w, r, key, "") {
+		return
+	}
+
 	res, err := s.stopPreview(deviceID, "", "onvif")
 	if err != nil {
 		respondInternalError(w, fmt.Sprintf("停止预览失败: %s", err.Error()))
 		return
 	}
+	s.previewSessions.Remove(key)
 
 	// 清除设备预览URL
 	s.onvifManager.UpdateDevicePreview(deviceID, "", "")
@@ -407,7 +427,7 @@ func (s *Server) stopPreview(deviceID string, _ string, app string) (any, error)
 		// 注意：此处需要 channelID，但在 ONVIF 上下文中 channelID 为空
 		// 我们从设备 ID 推导出 channelID（假设设备 ID 包含 channelID 信息）
 		// 或者使用空 channelID 并让管理器自动处理
-		err = s.previewManager.StopChannelPreview(deviceID, "")
+		err = s.previewManager.StopChannelPreview(deviceID, "", gb28181.StreamQualityMain)
 	}
 
 	if err != nil {
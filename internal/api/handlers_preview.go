@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"gb28181-onvif-server/internal/auth"
 	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/gb28181"
+	"gb28181-onvif-server/internal/push"
 
 	"github.com/gorilla/mux"
 )
@@ -14,10 +18,16 @@ import (
 func (s *Server) handleGetPreviewSessions(w http.ResponseWriter, r *http.Request) {
 	sessions := s.previewSessions.GetAll()
 
+	viewers := 0
+	for _, session := range sessions {
+		viewers += session.ViewerCount
+	}
+
 	respondRaw(w, http.StatusOK, map[string]interface{}{
-		"success":  true,
-		"sessions": sessions,
-		"count":    len(sessions),
+		"success":       true,
+		"sessions":      sessions,
+		"count":         len(sessions),
+		"total_viewers": viewers,
 	})
 }
 
@@ -38,7 +48,7 @@ func (s *Server) handleGetPreviewSession(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// handleStopPreviewSession 停止预览会话
+// handleStopPreviewSession 强制停止预览会话（不论是否还有其他观看者）
 func (s *Server) handleStopPreviewSession(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
@@ -55,7 +65,7 @@ func (s *Server) handleStopPreviewSession(w http.ResponseWriter, r *http.Request
 		if session.DeviceType == "onvif" {
 			err = s.previewManager.StopRTSPProxy(session.DeviceID, session.App)
 		} else {
-			err = s.previewManager.StopChannelPreview(session.DeviceID, session.ChannelID)
+			err = s.previewManager.StopChannelPreview(session.DeviceID, session.ChannelID, session.Quality)
 		}
 		if err != nil {
 			debug.Error("preview", "停止预览失败: deviceID=%s, app=%s, error=%v",
@@ -84,6 +94,7 @@ func (s *Server) handleStartPreview(w http.ResponseWriter, r *http.Request) {
 		RtspURL      string `json:"rtsp_url,omitempty"`
 		App          string `json:"app,omitempty"` // 默认: gb28181=rtp, onvif=onvif
 		ProfileToken string `json:"profile_token,omitempty"`
+		Quality      string `json:"quality,omitempty"` // main(默认), sub, third
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -96,6 +107,12 @@ func (s *Server) handleStartPreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	quality, err := gb28181.ParseStreamQuality(req.Quality)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
 	// 确定app
 	if req.App == "" {
 		if req.DeviceType == "onvif" {
@@ -106,20 +123,24 @@ func (s *Server) handleStartPreview(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 检查是否已存在会话
-	key := fmt.Sprintf("%s:%s", req.DeviceID, req.ChannelID)
-	if session, exists := s.previewSessions.Get(key); exists {
-		// 会话已存在，直接返回
-		respondRaw(w, http.StatusOK, map[string]interface{}{
+	key := previewSessionKey(req.DeviceID, req.ChannelID, quality)
+	if _, exists := s.previewSessions.Get(key); exists {
+		// 会话已存在，为新的观看者分配租约后直接返回
+		resp := map[string]interface{}{
 			"success": true,
 			"message": "预览会话已存在",
-			"session": session,
-		})
+		}
+		if viewer, ok := s.previewSessions.Acquire(key, s.clientIP(r), requestUsername(r)); ok {
+			resp["lease_id"] = viewer.LeaseID
+			resp["lease_ttl"] = int(previewLeaseTTL.Seconds())
+		}
+		resp["session"], _ = s.previewSessions.Get(key)
+		respondRaw(w, http.StatusOK, resp)
 		return
 	}
 
 	// 根据设备类型处理
 	var rtspURL string
-	var err error
 
 	if req.DeviceType == "onvif" {
 		// ONVIF设备，需要先获取RTSP URL
@@ -127,7 +148,7 @@ func (s *Server) handleStartPreview(w http.ResponseWriter, r *http.Request) {
 			rtspURL = req.RtspURL
 		} else if s.onvifManager != nil {
 			// 从ONVIF管理器获取流URL
-			rtspURL, err = s.onvifManager.GetStreamURI(req.DeviceID, req.ProfileToken)
+			rtspURL, err = s.onvifStreamURL(req.DeviceID, req.ProfileToken, quality)
 			if err != nil {
 				respondInternalError(w, fmt.Sprintf("获取ONVIF流地址失败: %v", err))
 				return
@@ -148,7 +169,7 @@ func (s *Server) handleStartPreview(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 启动预览
-	result, err := s.startPreview(r, req.DeviceID, req.ChannelID, rtspURL, req.App)
+	result, err := s.startPreview(r, req.DeviceID, req.ChannelID, quality, rtspURL, req.App)
 	if err != nil {
 		respondInternalError(w, fmt.Sprintf("启动预览失败: %v", err))
 		return
@@ -171,6 +192,8 @@ func (s *Server) handleStopPreview(w http.ResponseWriter, r *http.Request) {
 		DeviceID  string `json:"device_id"`
 		ChannelID string `json:"channel_id,omitempty"`
 		App       string `json:"app,omitempty"`
+		Quality   string `json:"quality,omitempty"`
+		LeaseID   string `json:"lease_id,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -183,8 +206,17 @@ func (s *Server) handleStopPreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	quality, err := gb28181.ParseStreamQuality(req.Quality)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
 	// 查找会话
-	key := fmt.Sprintf("%s:%s", req.DeviceID, req.ChannelID)
+	key := previewSessionKey(req.DeviceID, req.ChannelID, quality)
+	if s.respondIfStillWatched(w, r, key, req.LeaseID) {
+		return
+	}
 	session, exists := s.previewSessions.Get(key)
 
 	var app string
@@ -202,7 +234,7 @@ func (s *Server) handleStopPreview(w http.ResponseWriter, r *http.Request) {
 		if exists && session.DeviceType == "onvif" {
 			err = s.previewManager.StopRTSPProxy(req.DeviceID, app)
 		} else {
-			err = s.previewManager.StopChannelPreview(req.DeviceID, req.ChannelID)
+			err = s.previewManager.StopChannelPreview(req.DeviceID, req.ChannelID, quality)
 		}
 		if err != nil {
 			debug.Warn("preview", "停止预览失败: deviceID=%s, app=%s, error=%v",
@@ -222,3 +254,214 @@ func (s *Server) handleStopPreview(w http.ResponseWriter, r *http.Request) {
 		"message": "预览已停止",
 	})
 }
+
+// onvifStreamURL 获取 ONVIF 设备的流地址，未指定 profile 时按码流对应的分辨率选择
+func (s *Server) onvifStreamURL(deviceID, profileToken, quality string) (string, error) {
+	if profileToken == "" {
+		if token, err := s.onvifManager.GetProfileTokenByRank(deviceID, gb28181.StreamNumber(quality)); err == nil {
+			profileToken = token
+		} else {
+			debug.Warn("preview", "按码流选择 profile 失败，使用默认 profile: deviceID=%s, quality=%s, error=%v", deviceID, quality, err)
+		}
+	}
+	return s.onvifManager.GetStreamURI(deviceID, profileToken)
+}
+
+// handlePreviewHeartbeat 续期观看租约
+func (s *Server) handlePreviewHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LeaseID  string   `json:"lease_id"`
+		LeaseIDs []string `json:"lease_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	leaseIDs := req.LeaseIDs
+	if req.LeaseID != "" {
+		leaseIDs = append(leaseIDs, req.LeaseID)
+	}
+	if len(leaseIDs) == 0 {
+		respondBadRequest(w, "缺少必要参数: lease_id")
+		return
+	}
+
+	// 失效的租约（会话已停止或租约已过期）由客户端重新发起预览
+	expires := make(map[string]time.Time)
+	missing := []string{}
+	for _, id := range leaseIDs {
+		if expiresAt, ok := s.previewSessions.Heartbeat(id); ok {
+			expires[id] = expiresAt
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success":   len(missing) == 0,
+		"expires":   expires,
+		"missing":   missing,
+		"lease_ttl": int(previewLeaseTTL.Seconds()),
+	})
+}
+
+// handleReleasePreview 释放观看租约，最后一个观看者离开时停止预览
+func (s *Server) handleReleasePreview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LeaseID string `json:"lease_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBadRequest(w, fmt.Sprintf("无效的请求参数: %v", err))
+		return
+	}
+
+	if req.LeaseID == "" {
+		respondBadRequest(w, "缺少必要参数: lease_id")
+		return
+	}
+
+	key, ok := s.previewSessions.SessionKeyForLease(req.LeaseID)
+	if !ok {
+		respondNotFound(w, "观看租约不存在或已过期")
+		return
+	}
+	if s.respondIfStillWatched(w, r, key, req.LeaseID) {
+		return
+	}
+
+	if session, exists := s.previewSessions.Get(key); exists {
+		s.stopPreviewSession(session)
+	}
+
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "预览已停止",
+	})
+}
+
+// respondIfStillWatched 释放当前请求的观看租约，若会话仍有其他观看者则直接响应并返回 true
+// leaseID 为空时读取 lease_id 查询参数，仍为空则按客户端 IP 和用户释放
+func (s *Server) respondIfStillWatched(w http.ResponseWriter, r *http.Request, key, leaseID string) bool {
+	if leaseID == "" {
+		leaseID = r.URL.Query().Get("lease_id")
+	}
+
+	remaining, exists := s.previewSessions.Release(key, leaseID, s.clientIP(r), requestUsername(r))
+	if !exists || remaining == 0 {
+		return false
+	}
+
+	debug.Info("preview", "观看者已离开，会话仍有 %d 个观看者: key=%s", remaining, key)
+	respondRaw(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"message":      "仍有其他观看者，预览保持运行",
+		"viewer_count": remaining,
+	})
+	return true
+}
+
+// stopPreviewSession 停止预览流并移除会话记录
+func (s *Server) stopPreviewSession(session *PreviewSession) {
+	if s.previewManager != nil {
+		var err error
+		if session.DeviceType == "onvif" {
+			err = s.previewManager.StopRTSPProxy(session.DeviceID, session.App)
+		} else {
+			err = s.previewManager.StopChannelPreview(session.DeviceID, session.ChannelID, session.Quality)
+		}
+		if err != nil {
+			debug.Warn("preview", "停止预览失败: deviceID=%s, app=%s, error=%v",
+				session.DeviceID, session.App, err)
+		}
+	}
+
+	s.previewSessions.Remove(session.StreamKey)
+	debug.Info("preview", "预览会话已停止: key=%s", session.StreamKey)
+}
+
+// previewInUse 预览流是否仍被持久录像或推流使用（此时回收只移除会话记录，不断开流）
+func (s *Server) previewInUse(session *PreviewSession) bool {
+	id := session.ChannelID
+	if id == "" {
+		id = session.DeviceID
+	}
+
+	if s.recordingManager != nil && s.recordingManager.IsPersistentRecording(id) {
+		return true
+	}
+	if s.pushManager != nil {
+		for _, target := range s.pushManager.GetTargetsByChannel(id) {
+			if target.Status == push.StatusPushing || target.Status == push.StatusReconnecting {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startPreviewReaper 启动空闲预览回收器：租约全部过期且 ZLM 无播放连接的预览会被停止
+func (s *Server) startPreviewReaper() {
+	s.previewReaperStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(previewReapInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.reapIdlePreviews()
+			case <-s.previewReaperStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopPreviewReaper 停止空闲预览回收器
+func (s *Server) stopPreviewReaper() {
+	if s.previewReaperStop != nil {
+		close(s.previewReaperStop)
+		s.previewReaperStop = nil
+	}
+}
+
+// reapIdlePreviews 回收无人观看的预览
+func (s *Server) reapIdlePreviews() {
+	// 获取 ZLM 播放连接数，失败时仅按租约判断
+	var readers map[string]int
+	if s.zlmServer != nil && s.zlmServer.GetAPIClient() != nil {
+		if streams, err := s.zlmServer.GetAPIClient().GetMediaList(); err == nil {
+			readers = make(map[string]int, len(streams))
+			for _, stream := range streams {
+				// 旧版 ZLM 不返回 totalReaderCount，退回当前协议的观众数
+				count := stream.TotalReaderCount
+				if count < stream.ReaderCount {
+					count = stream.ReaderCount
+				}
+				readers[stream.App+"/"+stream.Stream] += count
+			}
+		}
+	}
+
+	for _, session := range s.previewSessions.Idle(time.Now(), readers) {
+		if s.previewInUse(session) {
+			s.previewSessions.Remove(session.StreamKey)
+			debug.Info("preview", "预览无人观看，流仍被录像或推流使用，仅移除会话: key=%s", session.StreamKey)
+			continue
+		}
+		debug.Info("preview", "预览无人观看，自动停止: key=%s", session.StreamKey)
+		s.stopPreviewSession(session)
+	}
+}
+
+// requestUsername 获取当前请求的登录用户名
+func requestUsername(r *http.Request) string {
+	if user := auth.GetUserFromContext(r.Context()); user != nil {
+		return user.Username
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gb28181-onvif-server/internal/push"
+
+	"github.com/gorilla/mux"
+)
+
+// handleGetPushGroups 获取推流组列表
+func (s *Server) handleGetPushGroups(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	groups := s.pushManager.GetGroups()
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"groups":  groups,
+		"total":   len(groups),
+	})
+}
+
+// handleGetPushGroup 获取单个推流组
+func (s *Server) handleGetPushGroup(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	group, err := s.pushManager.GetGroup(mux.Vars(r)["id"])
+	if err != nil {
+		s.jsonError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+	})
+}
+
+// handleAddPushGroup 添加推流组
+func (s *Server) handleAddPushGroup(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	var req struct {
+		Name                string          `json:"name"`
+		ChannelID           string          `json:"channel_id"`
+		ChannelName         string          `json:"channel_name"`
+		SourceURL           string          `json:"source_url"`
+		TargetIDs           []string        `json:"target_ids"`
+		Schedules           []push.Schedule `json:"schedules"`
+		StartOnSourceOnline bool            `json:"start_on_source_online"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	group := &push.PushGroup{
+		Name:                req.Name,
+		ChannelID:           req.ChannelID,
+		ChannelName:         req.ChannelName,
+		SourceURL:           req.SourceURL,
+		TargetIDs:           req.TargetIDs,
+		Schedules:           req.Schedules,
+		StartOnSourceOnline: req.StartOnSourceOnline,
+	}
+	if err := s.pushManager.AddGroup(group); err != nil {
+		s.jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+		"message": "Push group added successfully",
+	})
+}
+
+// handleUpdatePushGroup 更新推流组
+func (s *Server) handleUpdatePushGroup(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := s.pushManager.UpdateGroup(mux.Vars(r)["id"], updates); err != nil {
+		s.jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Push group updated successfully",
+	})
+}
+
+// handleDeletePushGroup 删除推流组（成员推流目标保留）
+func (s *Server) handleDeletePushGroup(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	if err := s.pushManager.DeleteGroup(mux.Vars(r)["id"]); err != nil {
+		s.jsonError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Push group deleted successfully",
+	})
+}
+
+// handleStartPushGroup 启动推流组的所有成员
+func (s *Server) handleStartPushGroup(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	failures, err := s.pushManager.StartGroup(id)
+	if err != nil {
+		s.jsonResponse(w, http.StatusInternalServerError, map[string]interface{}{
+			"success":  false,
+			"error":    err.Error(),
+			"failures": failures,
+		})
+		return
+	}
+
+	group, _ := s.pushManager.GetGroup(id)
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"group":    group,
+		"failures": failures,
+		"message":  "Push group started",
+	})
+}
+
+// handleStopPushGroup 停止推流组的所有成员
+func (s *Server) handleStopPushGroup(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := s.pushManager.StopGroup(id); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	group, _ := s.pushManager.GetGroup(id)
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+		"message": "Push group stopped",
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"gb28181-onvif-server/internal/gb28181"
+
 	"github.com/gorilla/mux"
 )
 
@@ -25,6 +27,7 @@ func (s *Server) handleStartStream(w http.ResponseWriter, r *http.Request) {
 		Channel      string `json:"channel,omitempty"`
 		ChannelID    string `json:"channelId,omitempty"` // 兼容字段
 		ProfileToken string `json:"profileToken,omitempty"`
+		Quality      string `json:"quality,omitempty"` // main(默认), sub, third
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -32,6 +35,12 @@ func (s *Server) handleStartStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	quality, err := gb28181.ParseStreamQuality(req.Quality)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
 	// 兼容处理
 	if req.Channel == "" && req.ChannelID != "" {
 		req.Channel = req.ChannelID
@@ -49,7 +58,7 @@ func (s *Server) handleStartStream(w http.ResponseWriter, r *http.Request) {
 
 		// 使用统一的预览启动函数
 		app := "rtp"
-		previewResult, previewErr := s.startPreview(r, req.DeviceID, req.Channel, "", app)
+		previewResult, previewErr := s.startPreview(r, req.DeviceID, req.Channel, quality, "", app)
 		if previewErr != nil {
 			respondInternalError(w, fmt.Sprintf("GB28181流启动失败: %v", previewErr))
 			return
@@ -65,7 +74,7 @@ func (s *Server) handleStartStream(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 获取RTSP URL
-		rtspURL, err := s.onvifManager.GetStreamURI(req.DeviceID, req.ProfileToken)
+		rtspURL, err := s.onvifStreamURL(req.DeviceID, req.ProfileToken, quality)
 		if err != nil {
 			respondInternalError(w, fmt.Sprintf("获取ONVIF流地址失败: %v", err))
 			return
@@ -73,7 +82,7 @@ func (s *Server) handleStartStream(w http.ResponseWriter, r *http.Request) {
 
 		// 使用统一的预览启动函数
 		app := "onvif"
-		previewResult, err := s.startPreview(r, req.DeviceID, req.Channel, rtspURL, app)
+		previewResult, err := s.startPreview(r, req.DeviceID, req.Channel, quality, rtspURL, app)
 		if err != nil {
 			respondInternalError(w, fmt.Sprintf("ONVIF流启动失败: %v", err))
 			return
@@ -102,6 +111,8 @@ func (s *Server) handleStopStream(w http.ResponseWriter, r *http.Request) {
 		ChannelID  string `json:"channelId,omitempty"`
 		DeviceType string `json:"deviceType,omitempty"`
 		App        string `json:"app,omitempty"`
+		Quality    string `json:"quality,omitempty"`
+		LeaseID    string `json:"lease_id,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -114,8 +125,17 @@ func (s *Server) handleStopStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	quality, err := gb28181.ParseStreamQuality(req.Quality)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+
 	// 查找并停止预览会话
-	key := fmt.Sprintf("%s:%s", req.DeviceID, req.ChannelID)
+	key := previewSessionKey(req.DeviceID, req.ChannelID, quality)
+	if s.respondIfStillWatched(w, r, key, req.LeaseID) {
+		return
+	}
 	session, exists := s.previewSessions.Get(key)
 
 	var app string
@@ -138,7 +158,7 @@ func (s *Server) handleStopStream(w http.ResponseWriter, r *http.Request) {
 		if exists && session.DeviceType == "onvif" {
 			stopErr = s.previewManager.StopRTSPProxy(req.DeviceID, app)
 		} else {
-			stopErr = s.previewManager.StopChannelPreview(req.DeviceID, req.ChannelID)
+			stopErr = s.previewManager.StopChannelPreview(req.DeviceID, req.ChannelID, quality)
 		}
 		if stopErr != nil {
 			respondInternalError(w, fmt.Sprintf("停止流失败: %v", stopErr))
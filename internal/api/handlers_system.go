@@ -239,7 +239,7 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 		if s.config.ZLM.API != nil {
 			zlmConfig["API"] = map[string]interface{}{
 				"Debug":       s.config.ZLM.API.Debug,
-				"Secret":      s.config.ZLM.API.Secret,
+				"Secret":      "", // 不返回 API 密钥
 				"SnapRoot":    s.config.ZLM.API.SnapRoot,
 				"DefaultSnap": s.config.ZLM.API.DefaultSnap,
 			}
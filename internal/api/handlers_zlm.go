@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"gb28181-onvif-server/internal/gb28181"
 	"gb28181-onvif-server/internal/preview"
 
 	"github.com/gorilla/mux"
@@ -209,7 +210,7 @@ func (s *Server) handleZLMAddStream(w http.ResponseWriter, r *http.Request) {
 	var res *preview.PreviewResult
 	var err error
 	if s.previewManager != nil {
-		res, err = s.previewManager.StartRTSPProxy(req.StreamID, req.URL, req.App, zlmHost, httpPort, rtmpPort, "", "")
+		res, err = s.previewManager.StartRTSPProxy(req.StreamID, gb28181.StreamQualityMain, req.URL, req.App, zlmHost, httpPort, rtmpPort, "", "")
 	} else {
 		// 添加流代理时启用TCP模式以支持长连接
 		_, err = s.zlmServer.GetAPIClient().AddStreamProxy(req.URL, req.App, req.StreamID)
@@ -262,7 +263,7 @@ func (s *Server) handleZLMRemoveStream(w http.ResponseWriter, r *http.Request) {
 		err = s.previewManager.StopRTSPProxy(streamID, app)
 		if err != nil {
 			// GB28181通道流用 StopChannelPreview
-			err = s.previewManager.StopChannelPreview(streamID, streamID)
+			err = s.previewManager.StopChannelPreview(streamID, streamID, gb28181.StreamQualityMain)
 		}
 	}
 
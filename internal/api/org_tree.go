@@ -0,0 +1,545 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/gb28181"
+)
+
+// 树节点类型：分组类型与 gb28181 目录节点类型保持一致，便于级联目录直接使用
+const (
+	TreeNodeBusinessGroup = gb28181.CatalogNodeBusinessGroup
+	TreeNodeVirtualOrg    = gb28181.CatalogNodeVirtualOrg
+	TreeNodeCivilCode     = gb28181.CatalogNodeCivilCode
+	TreeNodeDevice        = "device"
+	TreeNodeChannel       = "channel"
+)
+
+// ChannelGroup 自定义分组（业务分组或虚拟组织），可包含 GB28181 和 ONVIF 通道
+// 业务分组只能作为根节点，虚拟组织必须挂在业务分组或其他虚拟组织下（GB/T 28181 附录 O）
+type ChannelGroup struct {
+	ID         string    `json:"id"` // 20 位国标编码，级联目录中作为 DeviceID
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	ParentID   string    `json:"parentId,omitempty"`
+	ChannelIDs []string  `json:"channelIds"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// TreeNode 通道树节点
+type TreeNode struct {
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"` // custom: 自定义分组; device: 设备目录
+	ParentID        string      `json:"parentId,omitempty"`
+	BusinessGroupID string      `json:"businessGroupId,omitempty"`
+	CivilCode       string      `json:"civilCode,omitempty"`
+	DeviceID        string      `json:"deviceId,omitempty"`
+	DeviceType      string      `json:"deviceType,omitempty"` // gb28181 / onvif
+	Status          string      `json:"status,omitempty"`
+	Children        []*TreeNode `json:"children,omitempty"`
+}
+
+// OrgTreeManager 自定义组织树管理器
+type OrgTreeManager struct {
+	groups   map[string]*ChannelGroup
+	file     string
+	domainID string // 分组编码前 10 位（中心编码 + 行业编码）
+	mutex    sync.RWMutex
+}
+
+// NewOrgTreeManager 创建组织树管理器，domainID 取平台 ServerID 的前 10 位
+func NewOrgTreeManager(file, serverID string) *OrgTreeManager {
+	domainID := "3402000000"
+	if len(serverID) >= 10 {
+		domainID = serverID[:10]
+	}
+	m := &OrgTreeManager{
+		groups:   make(map[string]*ChannelGroup),
+		file:     file,
+		domainID: domainID,
+	}
+	m.load()
+	return m
+}
+
+// load 加载分组
+func (m *OrgTreeManager) load() {
+	if m.file == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			debug.Warn("api", "加载通道分组失败: %v", err)
+		}
+		return
+	}
+
+	var groups []*ChannelGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		debug.Warn("api", "解析通道分组失败: %v", err)
+		return
+	}
+	for _, g := range groups {
+		m.groups[g.ID] = g
+	}
+	debug.Info("api", "已加载 %d 个通道分组", len(groups))
+}
+
+// save 保存分组（调用方持有锁）
+func (m *OrgTreeManager) save() error {
+	if m.file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m.sortedGroups(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化通道分组失败: %v", err)
+	}
+	if err := os.WriteFile(m.file, data, 0644); err != nil {
+		return fmt.Errorf("保存通道分组失败: %v", err)
+	}
+	return nil
+}
+
+// sortedGroups 按编码排序的分组列表（调用方持有锁）
+func (m *OrgTreeManager) sortedGroups() []*ChannelGroup {
+	groups := make([]*ChannelGroup, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
+}
+
+// nextGroupID 生成分组编码：域编码(10) + 类型编码(215/216) + 序号(7)（调用方持有锁）
+func (m *OrgTreeManager) nextGroupID(groupType string) string {
+	typeCode := "216"
+	if groupType == TreeNodeBusinessGroup {
+		typeCode = "215"
+	}
+	prefix := m.domainID + typeCode
+
+	serial := 0
+	for id := range m.groups {
+		if len(id) == 20 && id[:13] == prefix {
+			if n, err := strconv.Atoi(id[13:]); err == nil && n > serial {
+				serial = n
+			}
+		}
+	}
+	return fmt.Sprintf("%s%07d", prefix, serial+1)
+}
+
+// validateParent 校验分组的父节点（调用方持有锁）
+func (m *OrgTreeManager) validateParent(id, groupType, parentID string) error {
+	if groupType == TreeNodeBusinessGroup {
+		if parentID != "" {
+			return fmt.Errorf("业务分组只能作为根节点")
+		}
+		return nil
+	}
+
+	if parentID == "" {
+		return fmt.Errorf("虚拟组织必须属于业务分组或其他虚拟组织")
+	}
+	// 父节点必须存在，且不能是自身或自身的下级
+	for current := parentID; current != ""; {
+		if current == id {
+			return fmt.Errorf("不能将分组移动到自身或其下级")
+		}
+		parent, ok := m.groups[current]
+		if !ok {
+			return fmt.Errorf("父分组不存在: %s", current)
+		}
+		current = parent.ParentID
+	}
+	return nil
+}
+
+// businessGroupOf 分组所属的业务分组（调用方持有锁）
+func (m *OrgTreeManager) businessGroupOf(id string) string {
+	for current := id; current != ""; {
+		group, ok := m.groups[current]
+		if !ok {
+			return ""
+		}
+		if group.Type == TreeNodeBusinessGroup {
+			return group.ID
+		}
+		current = group.ParentID
+	}
+	return ""
+}
+
+// CreateGroup 创建分组，id 为空时自动生成国标编码
+func (m *OrgTreeManager) CreateGroup(id, name, groupType, parentID string) (*ChannelGroup, error) {
+	if name == "" {
+		return nil, fmt.Errorf("分组名称不能为空")
+	}
+	if groupType != TreeNodeBusinessGroup && groupType != TreeNodeVirtualOrg {
+		return nil, fmt.Errorf("不支持的分组类型: %s", groupType)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if id == "" {
+		id = m.nextGroupID(groupType)
+	} else if gb28181.CatalogNodeType(id) != groupType {
+		return nil, fmt.Errorf("分组编码 %s 与类型 %s 不符（第 11~13 位应为 215 业务分组或 216 虚拟组织）", id, groupType)
+	}
+	if _, exists := m.groups[id]; exists {
+		return nil, fmt.Errorf("分组已存在: %s", id)
+	}
+	if err := m.validateParent(id, groupType, parentID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	group := &ChannelGroup{
+		ID:         id,
+		Name:       name,
+		Type:       groupType,
+		ParentID:   parentID,
+		ChannelIDs: []string{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	m.groups[id] = group
+	if err := m.save(); err != nil {
+		delete(m.groups, id)
+		return nil, err
+	}
+	return group, nil
+}
+
+// UpdateGroup 修改分组名称或移动到新的父分组，parentID 为 nil 时保持原父分组
+func (m *OrgTreeManager) UpdateGroup(id, name string, parentID *string) (*ChannelGroup, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	group, ok := m.groups[id]
+	if !ok {
+		return nil, fmt.Errorf("分组不存在: %s", id)
+	}
+	newParent := group.ParentID
+	if parentID != nil {
+		newParent = *parentID
+	}
+	if err := m.validateParent(id, group.Type, newParent); err != nil {
+		return nil, err
+	}
+
+	previous := *group
+	if name != "" {
+		group.Name = name
+	}
+	group.ParentID = newParent
+	group.UpdatedAt = time.Now()
+	if err := m.save(); err != nil {
+		*group = previous
+		return nil, err
+	}
+	return group, nil
+}
+
+// DeleteGroup 删除分组，存在下级分组时拒绝删除，分组内的通道随之移出
+func (m *OrgTreeManager) DeleteGroup(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.groups[id]; !ok {
+		return fmt.Errorf("分组不存在: %s", id)
+	}
+	for _, g := range m.groups {
+		if g.ParentID == id {
+			return fmt.Errorf("分组下存在子分组 %s，请先删除或移动", g.ID)
+		}
+	}
+
+	delete(m.groups, id)
+	return m.save()
+}
+
+// AssignChannels 将通道移入分组，一个通道只属于一个自定义分组，已在其他分组中的通道会被移出
+func (m *OrgTreeManager) AssignChannels(id string, channelIDs []string) (*ChannelGroup, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	group, ok := m.groups[id]
+	if !ok {
+		return nil, fmt.Errorf("分组不存在: %s", id)
+	}
+
+	moving := make(map[string]bool, len(channelIDs))
+	for _, channelID := range channelIDs {
+		moving[channelID] = true
+	}
+	for _, g := range m.groups {
+		if g.ID != id {
+			g.ChannelIDs = removeChannelIDs(g.ChannelIDs, moving)
+		}
+	}
+
+	existing := make(map[string]bool, len(group.ChannelIDs))
+	for _, channelID := range group.ChannelIDs {
+		existing[channelID] = true
+	}
+	for _, channelID := range channelIDs {
+		if !existing[channelID] {
+			existing[channelID] = true
+			group.ChannelIDs = append(group.ChannelIDs, channelID)
+		}
+	}
+	group.UpdatedAt = time.Now()
+	return group, m.save()
+}
+
+// RemoveChannel 将通道移出分组，groupID 为空时从所有分组移出
+func (m *OrgTreeManager) RemoveChannel(groupID, channelID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if groupID != "" {
+		if _, ok := m.groups[groupID]; !ok {
+			return fmt.Errorf("分组不存在: %s", groupID)
+		}
+	}
+
+	removing := map[string]bool{channelID: true}
+	for _, g := range m.groups {
+		if groupID == "" || g.ID == groupID {
+			g.ChannelIDs = removeChannelIDs(g.ChannelIDs, removing)
+		}
+	}
+	return m.save()
+}
+
+// GetGroups 获取所有分组（副本）
+func (m *OrgTreeManager) GetGroups() []ChannelGroup {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	groups := make([]ChannelGroup, 0, len(m.groups))
+	for _, g := range m.sortedGroups() {
+		copied := *g
+		copied.ChannelIDs = append([]string{}, g.ChannelIDs...)
+		groups = append(groups, copied)
+	}
+	return groups
+}
+
+// removeChannelIDs 从列表中移除指定通道
+func removeChannelIDs(channelIDs []string, remove map[string]bool) []string {
+	kept := channelIDs[:0]
+	for _, channelID := range channelIDs {
+		if !remove[channelID] {
+			kept = append(kept, channelID)
+		}
+	}
+	return kept
+}
+
+// buildCustomTree 构建自定义分组树，channelNode 返回通道叶子节点，未知通道返回 nil
+func (m *OrgTreeManager) buildCustomTree(channelNode func(channelID string) *TreeNode) []*TreeNode {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	nodes := make(map[string]*TreeNode, len(m.groups))
+	groups := m.sortedGroups()
+	for _, g := range groups {
+		node := &TreeNode{
+			ID:       g.ID,
+			Name:     g.Name,
+			Type:     g.Type,
+			Source:   "custom",
+			ParentID: g.ParentID,
+		}
+		if g.Type == TreeNodeVirtualOrg {
+			node.BusinessGroupID = m.businessGroupOf(g.ID)
+		}
+		for _, channelID := range g.ChannelIDs {
+			if child := channelNode(channelID); child != nil {
+				child.Source = "custom"
+				child.ParentID = g.ID
+				child.BusinessGroupID = m.businessGroupOf(g.ID)
+				node.Children = append(node.Children, child)
+			}
+		}
+		nodes[g.ID] = node
+	}
+
+	var roots []*TreeNode
+	for _, g := range groups {
+		node := nodes[g.ID]
+		if parent, ok := nodes[g.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// flattenTree 将树展开为带 parentId 的列表（先序），用于级联目录应答
+func flattenTree(roots []*TreeNode) []*TreeNode {
+	var flat []*TreeNode
+	var walk func(nodes []*TreeNode)
+	walk = func(nodes []*TreeNode) {
+		for _, node := range nodes {
+			copied := *node
+			copied.Children = nil
+			flat = append(flat, &copied)
+			walk(node.Children)
+		}
+	}
+	walk(roots)
+	return flat
+}
+
+// channelTreeNode 查找通道并生成叶子节点，GB28181 通道优先使用设备目录中的信息
+func (s *Server) channelTreeNode(channelID string) *TreeNode {
+	if s.gb28181Server != nil {
+		if ch, ok := s.gb28181Server.GetChannelByID(channelID); ok {
+			return &TreeNode{
+				ID:         ch.ChannelID,
+				Name:       ch.Name,
+				Type:       TreeNodeChannel,
+				CivilCode:  ch.CivilCode,
+				DeviceID:   ch.DeviceID,
+				DeviceType: "gb28181",
+				Status:     ch.Status,
+			}
+		}
+	}
+	if ch, ok := s.channelManager.GetChannel(channelID); ok {
+		return &TreeNode{
+			ID:         ch.ChannelID,
+			Name:       ch.ChannelName,
+			Type:       TreeNodeChannel,
+			DeviceID:   ch.DeviceID,
+			DeviceType: ch.DeviceType,
+			Status:     ch.Status,
+		}
+	}
+	return nil
+}
+
+// buildDeviceTree 按设备目录构建树：GB28181 设备保留目录中的行政区划/业务分组/虚拟组织层级，
+// ONVIF 通道按设备归组
+func (s *Server) buildDeviceTree() []*TreeNode {
+	var roots []*TreeNode
+
+	if s.gb28181Server != nil {
+		devices := s.gb28181Server.GetDevices()
+		sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceID < devices[j].DeviceID })
+		for _, device := range devices {
+			deviceNode := &TreeNode{
+				ID:         device.DeviceID,
+				Name:       device.Name,
+				Type:       TreeNodeDevice,
+				Source:     "device",
+				DeviceID:   device.DeviceID,
+				DeviceType: "gb28181",
+				Status:     device.Status,
+			}
+
+			// 目录节点按 ParentID 挂接，父节点不在本设备目录中时挂在设备下
+			nodes := make(map[string]*TreeNode)
+			catalogNodes := s.gb28181Server.GetCatalogNodes(device.DeviceID)
+			for _, cn := range catalogNodes {
+				nodes[cn.ID] = &TreeNode{
+					ID:              cn.ID,
+					Name:            cn.Name,
+					Type:            cn.Type,
+					Source:          "device",
+					ParentID:        cn.ParentID,
+					BusinessGroupID: cn.BusinessGroupID,
+					CivilCode:       cn.CivilCode,
+					DeviceID:        device.DeviceID,
+					DeviceType:      "gb28181",
+				}
+			}
+			for _, cn := range catalogNodes {
+				node := nodes[cn.ID]
+				if parent, ok := nodes[cn.ParentID]; ok && cn.ParentID != cn.ID {
+					parent.Children = append(parent.Children, node)
+				} else {
+					node.ParentID = device.DeviceID
+					deviceNode.Children = append(deviceNode.Children, node)
+				}
+			}
+
+			for _, ch := range s.gb28181Server.GetChannels(device.DeviceID) {
+				node := &TreeNode{
+					ID:              ch.ChannelID,
+					Name:            ch.Name,
+					Type:            TreeNodeChannel,
+					Source:          "device",
+					BusinessGroupID: ch.BusinessGroupID,
+					CivilCode:       ch.CivilCode,
+					DeviceID:        device.DeviceID,
+					DeviceType:      "gb28181",
+					Status:          ch.Status,
+				}
+				// 优先挂在 ParentID 指向的节点，其次是所属业务分组，否则挂在设备下
+				parent := deviceNode
+				if p, ok := nodes[ch.ParentID]; ok {
+					parent = p
+				} else if p, ok := nodes[ch.BusinessGroupID]; ok {
+					parent = p
+				}
+				node.ParentID = parent.ID
+				parent.Children = append(parent.Children, node)
+			}
+			roots = append(roots, deviceNode)
+		}
+	}
+
+	onvifDevices := make(map[string]*TreeNode)
+	for _, ch := range s.channelManager.GetChannels() {
+		if ch.DeviceType != "onvif" {
+			continue
+		}
+		deviceNode, ok := onvifDevices[ch.DeviceID]
+		if !ok {
+			deviceNode = &TreeNode{
+				ID:         ch.DeviceID,
+				Name:       ch.DeviceID,
+				Type:       TreeNodeDevice,
+				Source:     "device",
+				DeviceID:   ch.DeviceID,
+				DeviceType: "onvif",
+			}
+			onvifDevices[ch.DeviceID] = deviceNode
+			roots = append(roots, deviceNode)
+		}
+		deviceNode.Children = append(deviceNode.Children, &TreeNode{
+			ID:         ch.ChannelID,
+			Name:       ch.ChannelName,
+			Type:       TreeNodeChannel,
+			Source:     "device",
+			ParentID:   ch.DeviceID,
+			DeviceID:   ch.DeviceID,
+			DeviceType: "onvif",
+			Status:     ch.Status,
+		})
+	}
+	for _, deviceNode := range onvifDevices {
+		sort.Slice(deviceNode.Children, func(i, j int) bool { return deviceNode.Children[i].ID < deviceNode.Children[j].ID })
+	}
+
+	return roots
+}
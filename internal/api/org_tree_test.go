@@ -0,0 +1,146 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestOrgTree(t *testing.T) *OrgTreeManager {
+	t.Helper()
+	return NewOrgTreeManager(filepath.Join(t.TempDir(), "groups.json"), "34020000002000000001")
+}
+
+func TestOrgTreeGroupRules(t *testing.T) {
+	m := newTestOrgTree(t)
+
+	bg, err := m.CreateGroup("", "业务分组", TreeNodeBusinessGroup, "")
+	if err != nil {
+		t.Fatalf("创建业务分组失败: %v", err)
+	}
+	if bg.ID != "34020000002150000001" {
+		t.Errorf("业务分组编码 = %s", bg.ID)
+	}
+	org, err := m.CreateGroup("", "虚拟组织", TreeNodeVirtualOrg, bg.ID)
+	if err != nil {
+		t.Fatalf("创建虚拟组织失败: %v", err)
+	}
+	child, err := m.CreateGroup("", "下级组织", TreeNodeVirtualOrg, org.ID)
+	if err != nil {
+		t.Fatalf("创建下级组织失败: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		id        string
+		groupType string
+		parentID  string
+	}{
+		{"BusinessGroupWithParent", "", TreeNodeBusinessGroup, bg.ID},
+		{"VirtualOrgWithoutParent", "", TreeNodeVirtualOrg, ""},
+		{"MissingParent", "", TreeNodeVirtualOrg, "34020000002169999999"},
+		{"IDTypeMismatch", "34020000002150000099", TreeNodeVirtualOrg, bg.ID},
+		{"UnknownType", "", "civil", ""},
+		{"Duplicate", org.ID, TreeNodeVirtualOrg, bg.ID},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := m.CreateGroup(tc.id, "x", tc.groupType, tc.parentID); err == nil {
+				t.Fatal("应拒绝创建")
+			}
+		})
+	}
+
+	// 移动到自身或下级形成环
+	for _, parent := range []string{org.ID, child.ID} {
+		if _, err := m.UpdateGroup(org.ID, "", &parent); err == nil {
+			t.Errorf("移动到 %s 应被拒绝", parent)
+		}
+	}
+
+	// 存在子分组时拒绝删除
+	if err := m.DeleteGroup(org.ID); err == nil {
+		t.Error("存在子分组时应拒绝删除")
+	}
+	if err := m.DeleteGroup(child.ID); err != nil {
+		t.Fatalf("删除叶子分组失败: %v", err)
+	}
+	if err := m.DeleteGroup(org.ID); err != nil {
+		t.Fatalf("删除分组失败: %v", err)
+	}
+}
+
+func TestOrgTreeUpdateKeepsParent(t *testing.T) {
+	m := newTestOrgTree(t)
+	bg1, _ := m.CreateGroup("", "业务分组1", TreeNodeBusinessGroup, "")
+	bg2, _ := m.CreateGroup("", "业务分组2", TreeNodeBusinessGroup, "")
+	org, _ := m.CreateGroup("", "虚拟组织", TreeNodeVirtualOrg, bg1.ID)
+
+	// 仅改名时不传 parentId，父分组保持不变
+	group, err := m.UpdateGroup(org.ID, "新名称", nil)
+	if err != nil {
+		t.Fatalf("改名失败: %v", err)
+	}
+	if group.Name != "新名称" || group.ParentID != bg1.ID {
+		t.Fatalf("改名后 = %+v", group)
+	}
+
+	group, err = m.UpdateGroup(org.ID, "", &bg2.ID)
+	if err != nil {
+		t.Fatalf("移动失败: %v", err)
+	}
+	if group.Name != "新名称" || group.ParentID != bg2.ID {
+		t.Fatalf("移动后 = %+v", group)
+	}
+
+	empty := ""
+	if _, err := m.UpdateGroup(org.ID, "", &empty); err == nil {
+		t.Error("虚拟组织不能移动为根节点")
+	}
+}
+
+func TestOrgTreeAssignChannels(t *testing.T) {
+	m := newTestOrgTree(t)
+	bg, _ := m.CreateGroup("", "业务分组", TreeNodeBusinessGroup, "")
+	org1, _ := m.CreateGroup("", "组织1", TreeNodeVirtualOrg, bg.ID)
+	org2, _ := m.CreateGroup("", "组织2", TreeNodeVirtualOrg, bg.ID)
+
+	if _, err := m.AssignChannels(org1.ID, []string{"ch1", "ch2"}); err != nil {
+		t.Fatalf("分配通道失败: %v", err)
+	}
+	// 通道只属于一个分组，移入 org2 时从 org1 移出
+	if _, err := m.AssignChannels(org2.ID, []string{"ch2", "ch2", "ch3"}); err != nil {
+		t.Fatalf("移动通道失败: %v", err)
+	}
+
+	want := map[string][]string{
+		bg.ID:   {},
+		org1.ID: {"ch1"},
+		org2.ID: {"ch2", "ch3"},
+	}
+	for _, g := range m.GetGroups() {
+		if got := g.ChannelIDs; len(got) != len(want[g.ID]) {
+			t.Errorf("%s 通道 = %v, want %v", g.ID, got, want[g.ID])
+		} else {
+			for i := range got {
+				if got[i] != want[g.ID][i] {
+					t.Errorf("%s 通道 = %v, want %v", g.ID, got, want[g.ID])
+					break
+				}
+			}
+		}
+	}
+
+	if err := m.RemoveChannel("", "ch2"); err != nil {
+		t.Fatalf("移出通道失败: %v", err)
+	}
+	// 重新加载后分组与通道保持一致
+	reloaded := NewOrgTreeManager(m.file, "34020000002000000001")
+	for _, g := range reloaded.GetGroups() {
+		if g.ID == org2.ID && (len(g.ChannelIDs) != 1 || g.ChannelIDs[0] != "ch3") {
+			t.Errorf("重新加载后 %s 通道 = %v", g.ID, g.ChannelIDs)
+		}
+	}
+	if _, err := m.AssignChannels("34020000002169999999", []string{"ch1"}); err == nil {
+		t.Error("分组不存在时应返回错误")
+	}
+}
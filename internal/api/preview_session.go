@@ -1,14 +1,39 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"sync"
 	"time"
+
+	"gb28181-onvif-server/internal/gb28181"
+)
+
+const (
+	// previewLeaseTTL 观看租约有效期，客户端需在到期前发送心跳
+	previewLeaseTTL = 60 * time.Second
+	// previewIdleGrace 会话无租约后保留的时间，给播放器重连和刷新页面留出余地
+	previewIdleGrace = 30 * time.Second
+	// previewReapInterval 空闲预览回收检查间隔
+	previewReapInterval = 15 * time.Second
 )
 
+// PreviewViewer 预览观看者（一个租约对应一个播放窗口）
+type PreviewViewer struct {
+	LeaseID   string    `json:"lease_id"`
+	ClientIP  string    `json:"client_ip"`
+	Username  string    `json:"username,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 // PreviewSession 预览会话信息
 type PreviewSession struct {
 	DeviceID   string `json:"device_id"`
 	ChannelID  string `json:"channel_id,omitempty"`
+	Quality    string `json:"quality"` // 码流: main, sub, third
 	StreamKey  string `json:"stream_key"`
 	App        string `json:"app"`
 	Stream     string `json:"stream"`
@@ -20,25 +45,62 @@ type PreviewSession struct {
 	RtspURL    string `json:"rtsp_url"`
 	CreateTime int64  `json:"create_time"`
 	DeviceType string `json:"device_type"` // "gb28181" or "onvif"
+
+	Viewers     []*PreviewViewer `json:"viewers"`
+	ViewerCount int              `json:"viewer_count"` // 持有有效租约的观看者数
+	ReaderCount int              `json:"reader_count"` // ZLM 统计的播放连接数（最近一次回收检查时）
+
+	idleSince time.Time // 最后一个租约结束的时间
 }
 
 // PreviewSessionManager 预览会话管理器
 type PreviewSessionManager struct {
 	sessions map[string]*PreviewSession
+	leases   map[string]string // lease ID -> stream key
 	mu       sync.RWMutex
 }
 
+// previewSessionKey 预览会话键：设备ID:通道ID，非主码流追加 :码流
+func previewSessionKey(deviceID, channelID, quality string) string {
+	key := fmt.Sprintf("%s:%s", deviceID, channelID)
+	if quality != "" && quality != gb28181.StreamQualityMain {
+		key += ":" + quality
+	}
+	return key
+}
+
 // NewPreviewSessionManager 创建预览会话管理器
 func NewPreviewSessionManager() *PreviewSessionManager {
 	return &PreviewSessionManager{
 		sessions: make(map[string]*PreviewSession),
+		leases:   make(map[string]string),
+	}
+}
+
+// snapshot 复制会话供外部读取（调用方持有锁）
+func (s *PreviewSession) snapshot() *PreviewSession {
+	copied := *s
+	copied.Viewers = make([]*PreviewViewer, 0, len(s.Viewers))
+	for _, v := range s.Viewers {
+		viewer := *v
+		copied.Viewers = append(copied.Viewers, &viewer)
 	}
+	copied.ViewerCount = len(s.Viewers)
+	return &copied
 }
 
-// Add 添加预览会话
+// Add 添加预览会话（已存在时保留观看者，更新流信息）
 func (m *PreviewSessionManager) Add(session *PreviewSession) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+
+	if existing, ok := m.sessions[session.StreamKey]; ok {
+		session.Viewers = existing.Viewers
+		session.idleSince = existing.idleSince
+	} else {
+		session.Viewers = nil
+		session.idleSince = time.Now()
+	}
 	m.sessions[session.StreamKey] = session
 }
 
@@ -47,13 +109,22 @@ func (m *PreviewSessionManager) Get(key string) (*PreviewSession, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	session, exists := m.sessions[key]
-	return session, exists
+	if !exists {
+		return nil, false
+	}
+	return session.snapshot(), true
 }
 
-// Remove 移除预览会话
+// Remove 移除预览会话及其所有租约
 func (m *PreviewSessionManager) Remove(key string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[key]; ok {
+		for _, v := range session.Viewers {
+			delete(m.leases, v.LeaseID)
+		}
+	}
 	delete(m.sessions, key)
 }
 
@@ -64,7 +135,7 @@ func (m *PreviewSessionManager) GetAll() []*PreviewSession {
 
 	sessions := make([]*PreviewSession, 0, len(m.sessions))
 	for _, session := range m.sessions {
-		sessions = append(sessions, session)
+		sessions = append(sessions, session.snapshot())
 	}
 	return sessions
 }
@@ -77,12 +148,133 @@ func (m *PreviewSessionManager) GetByDevice(deviceID string) []*PreviewSession {
 	sessions := make([]*PreviewSession, 0)
 	for _, session := range m.sessions {
 		if session.DeviceID == deviceID {
-			sessions = append(sessions, session)
+			sessions = append(sessions, session.snapshot())
 		}
 	}
 	return sessions
 }
 
+// Acquire 为会话添加观看租约，返回租约
+func (m *PreviewSessionManager) Acquire(key, clientIP, username string) (*PreviewViewer, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[key]
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	viewer := &PreviewViewer{
+		LeaseID:   generateLeaseID(),
+		ClientIP:  clientIP,
+		Username:  username,
+		StartTime: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(previewLeaseTTL),
+	}
+	session.Viewers = append(session.Viewers, viewer)
+	session.idleSince = time.Time{}
+	m.leases[viewer.LeaseID] = key
+
+	copied := *viewer
+	return &copied, true
+}
+
+// Heartbeat 续期观看租约，返回新的到期时间
+func (m *PreviewSessionManager) Heartbeat(leaseID string) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[m.leases[leaseID]]
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, v := range session.Viewers {
+		if v.LeaseID == leaseID {
+			v.LastSeen = time.Now()
+			v.ExpiresAt = v.LastSeen.Add(previewLeaseTTL)
+			return v.ExpiresAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Release 释放观看租约，返回会话剩余的观看者数
+// leaseID 为空时（未使用租约的旧客户端）释放同一客户端最早的租约
+func (m *PreviewSessionManager) Release(key, leaseID, clientIP, username string) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key == "" {
+		key = m.leases[leaseID]
+	}
+	session, ok := m.sessions[key]
+	if !ok {
+		return 0, false
+	}
+
+	for i, v := range session.Viewers {
+		match := v.LeaseID == leaseID
+		if leaseID == "" {
+			match = v.ClientIP == clientIP && v.Username == username
+		}
+		if match {
+			session.Viewers = append(session.Viewers[:i:i], session.Viewers[i+1:]...)
+			delete(m.leases, v.LeaseID)
+			break
+		}
+	}
+	if len(session.Viewers) == 0 && session.idleSince.IsZero() {
+		session.idleSince = time.Now()
+	}
+	return len(session.Viewers), true
+}
+
+// SessionKeyForLease 查询租约所属的会话
+func (m *PreviewSessionManager) SessionKeyForLease(leaseID string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.leases[leaseID]
+	return key, ok
+}
+
+// Idle 清理过期租约，返回可以回收的会话：无有效租约超过宽限期且 ZLM 中没有播放连接
+// readers 为 nil 表示无法获取 ZLM 播放统计，仅按租约判断
+func (m *PreviewSessionManager) Idle(now time.Time, readers map[string]int) []*PreviewSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var idle []*PreviewSession
+	for _, session := range m.sessions {
+		alive := session.Viewers[:0]
+		for _, v := range session.Viewers {
+			if now.Before(v.ExpiresAt) {
+				alive = append(alive, v)
+			} else {
+				delete(m.leases, v.LeaseID)
+			}
+		}
+		session.Viewers = alive
+
+		if readers != nil {
+			session.ReaderCount = readers[session.App+"/"+session.Stream]
+		}
+		if len(session.Viewers) > 0 {
+			continue
+		}
+		if session.idleSince.IsZero() {
+			session.idleSince = now
+		}
+		// 未接入租约的旧客户端仍在播放时不回收
+		if session.ReaderCount > 0 || now.Sub(session.idleSince) < previewIdleGrace {
+			continue
+		}
+		idle = append(idle, session.snapshot())
+	}
+	return idle
+}
+
 // Clean 清理过期的预览会话（超过指定时间未使用）
 func (m *PreviewSessionManager) Clean(maxAge time.Duration) []string {
 	m.mu.Lock()
@@ -93,6 +285,9 @@ func (m *PreviewSessionManager) Clean(maxAge time.Duration) []string {
 
 	for key, session := range m.sessions {
 		if now-session.CreateTime > int64(maxAge.Seconds()) {
+			for _, v := range session.Viewers {
+				delete(m.leases, v.LeaseID)
+			}
 			delete(m.sessions, key)
 			removed = append(removed, key)
 		}
@@ -107,3 +302,10 @@ func (m *PreviewSessionManager) Count() int {
 	defer m.mu.RUnlock()
 	return len(m.sessions)
 }
+
+// generateLeaseID 生成观看租约 ID
+func generateLeaseID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"gb28181-onvif-server/internal/ai"
+	"gb28181-onvif-server/internal/audit"
 	"gb28181-onvif-server/internal/auth"
 	"gb28181-onvif-server/internal/config"
 	"gb28181-onvif-server/internal/debug"
@@ -53,9 +54,13 @@ type Server struct {
 	previewSessions    *PreviewSessionManager         // 预览会话管理器
 	startTime          time.Time
 	recordingWatchStop chan struct{}
+	previewReaperStop  chan struct{}
 	gb28181Running     bool                       // GB28181 服务运行状态
 	onvifRunning       bool                       // ONVIF 服务运行状态
 	staticServer       *frontend.StaticFileServer // 静态文件服务器
+	auditLogger        *audit.Logger              // 操作审计日志
+	orgTree            *OrgTreeManager            // 自定义组织树
+	trustedProxies     []*net.IPNet               // 受信任的反向代理网段
 }
 
 // NewServer 创建一个新的API服务器实例。
@@ -89,10 +94,46 @@ func NewServer(cfg *config.Config, gbServer *gb28181.Server, onvifMgr *onvif.Man
 		onvifRunning:     true, // 默认启动时为运行状态
 		staticServer:     staticServer,
 	}
+	if cfg != nil && cfg.API != nil {
+		s.trustedProxies = parseTrustedProxies(cfg.API.TrustedProxies)
+	}
+	serverID := ""
+	if cfg != nil && cfg.GB28181 != nil {
+		serverID = cfg.GB28181.ServerID
+	}
+	s.orgTree = NewOrgTreeManager("configs/channel_groups.json", serverID)
 	if zlmSrv != nil {
 		s.previewManager = preview.NewManager(gbServer, zlmSrv)
 		// 初始化推流管理器
 		s.pushManager = push.NewManager(zlmSrv.GetAPIClient(), "configs/push_targets.json", cfg.ZLM.HTTP.Port)
+		if cfg.Push != nil {
+			s.pushManager.SetRetryPolicy(push.RetryPolicy{
+				MaxRetries:     cfg.Push.MaxRetries,
+				InitialBackoff: time.Duration(cfg.Push.InitialBackoff) * time.Second,
+				MaxBackoff:     time.Duration(cfg.Push.MaxBackoff) * time.Second,
+				CheckInterval:  time.Duration(cfg.Push.CheckInterval) * time.Second,
+			})
+			profiles := make([]*push.TranscodeProfile, 0, len(cfg.Push.Profiles))
+			for _, p := range cfg.Push.Profiles {
+				profiles = append(profiles, &push.TranscodeProfile{
+					Name:            p.Name,
+					Description:     p.Description,
+					VideoCodec:      p.VideoCodec,
+					Width:           p.Width,
+					Height:          p.Height,
+					VideoBitrate:    p.VideoBitrate,
+					FPS:             p.FPS,
+					GOP:             p.GOP,
+					AudioCodec:      p.AudioCodec,
+					AudioBitrate:    p.AudioBitrate,
+					AudioSampleRate: p.AudioSampleRate,
+					AudioChannels:   p.AudioChannels,
+				})
+			}
+			s.pushManager.SetProfiles(profiles)
+			s.pushManager.SetFontFile(cfg.Push.FontFile)
+			s.pushManager.SetVAAPIDevice(cfg.Push.VAAPIDevice)
+		}
 
 		// 初始化 ffmpeg 推流管理器
 		zlmRTMPHost := "127.0.0.1"
@@ -110,6 +151,9 @@ func NewServer(cfg *config.Config, gbServer *gb28181.Server, onvifMgr *onvif.Man
 	// 初始化认证模块
 	s.initAuth()
 
+	// 初始化审计日志
+	s.initAudit()
+
 	return s
 }
 
@@ -127,15 +171,94 @@ func (s *Server) initAuth() {
 		UsersFile:       s.config.Auth.UsersFile,
 		DefaultAdmin:    s.config.Auth.DefaultAdmin,
 		DefaultPassword: s.config.Auth.DefaultPassword,
+
+		DisableLocalLogin: s.config.Auth.DisableLocalLogin,
+	}
+	for _, role := range s.config.Auth.TwoFactorRequired {
+		authConfig.TwoFactorRequiredRoles = append(authConfig.TwoFactorRequiredRoles, auth.Role(role))
 	}
 
 	s.authManager = auth.NewAuthManager(authConfig)
 	s.authMiddleware = auth.NewMiddleware(s.authManager)
 	s.authHandler = auth.NewAuthHandler(s.authManager)
 
+	s.registerIdentityProviders()
+
 	debug.Info("api", "认证模块初始化完成，启用状态: %v", s.config.Auth.Enable)
 }
 
+// registerIdentityProviders 注册配置中的 LDAP / OIDC 外部身份源
+func (s *Server) registerIdentityProviders() {
+	for _, c := range s.config.Auth.LDAP {
+		if c == nil || !c.Enable {
+			continue
+		}
+		provider, err := auth.NewLDAPAuthenticator(auth.LDAPConfig{
+			Name:               c.Name,
+			DisplayName:        c.DisplayName,
+			URL:                c.URL,
+			StartTLS:           c.StartTLS,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+			BindDN:             c.BindDN,
+			BindPassword:       c.BindPassword,
+			BaseDN:             c.BaseDN,
+			UserFilter:         c.UserFilter,
+			UsernameAttribute:  c.UsernameAttribute,
+			DisplayNameAttr:    c.DisplayNameAttribute,
+			EmailAttribute:     c.EmailAttribute,
+			GroupAttribute:     c.GroupAttribute,
+			GroupBaseDN:        c.GroupBaseDN,
+			GroupFilter:        c.GroupFilter,
+			GroupRoles:         toRoleMap(c.GroupRoles),
+			DefaultRole:        auth.Role(c.DefaultRole),
+			Timeout:            time.Duration(c.TimeoutSec) * time.Second,
+		})
+		if err != nil {
+			debug.Error("api", "LDAP 身份源 %s 配置无效: %v", c.Name, err)
+			continue
+		}
+		s.authManager.RegisterProvider(provider)
+		debug.Info("api", "已注册 LDAP 身份源: %s (%s)", provider.Name(), c.URL)
+	}
+
+	for _, c := range s.config.Auth.OIDC {
+		if c == nil || !c.Enable {
+			continue
+		}
+		provider, err := auth.NewOIDCAuthenticator(auth.OIDCConfig{
+			Name:          c.Name,
+			DisplayName:   c.DisplayName,
+			Issuer:        c.Issuer,
+			ClientID:      c.ClientID,
+			ClientSecret:  c.ClientSecret,
+			RedirectURL:   c.RedirectURL,
+			Scopes:        c.Scopes,
+			UsernameClaim: c.UsernameClaim,
+			GroupsClaim:   c.GroupsClaim,
+			GroupRoles:    toRoleMap(c.GroupRoles),
+			DefaultRole:   auth.Role(c.DefaultRole),
+		})
+		if err != nil {
+			debug.Error("api", "OIDC 身份源 %s 配置无效: %v", c.Name, err)
+			continue
+		}
+		s.authManager.RegisterProvider(provider)
+		debug.Info("api", "已注册 OIDC 身份源: %s (%s)", provider.Name(), c.Issuer)
+	}
+}
+
+// toRoleMap 将配置中的组角色映射转换为认证模块的角色类型
+func toRoleMap(m map[string]string) map[string]auth.Role {
+	if len(m) == 0 {
+		return nil
+	}
+	roles := make(map[string]auth.Role, len(m))
+	for group, role := range m {
+		roles[group] = auth.Role(role)
+	}
+	return roles
+}
+
 // GetZLMAPIClient 提供给其他模块获取 ZLM API 客户端
 func (s *Server) GetZLMAPIClient() *zlm.ZLMAPIClient {
 	if s.zlmServer == nil {
@@ -173,7 +296,7 @@ func (s *Server) SetupAutoStreamProxy() {
 
 	s.onvifManager.SetStreamProxyCallback(func(deviceID, rtspURL, username, password string) error {
 		debug.Info("api", "自动添加流代理: deviceID=%s, rtspURL=%s", deviceID, rtspURL)
-		_, err := s.previewManager.StartRTSPProxy(deviceID, rtspURL, "onvif", "127.0.0.1", httpPort, rtmpPort, username, password)
+		_, err := s.previewManager.StartRTSPProxy(deviceID, gb28181.StreamQualityMain, rtspURL, "onvif", "127.0.0.1", httpPort, rtmpPort, username, password)
 		return err
 	})
 	debug.Info("api", "✅ 自动流代理已设置")
@@ -384,7 +507,7 @@ func (s *Server) autoStartAIDetection() {
 
 			// GB28181 流
 			if s.previewManager != nil {
-				previewRes, err = s.previewManager.StartChannelPreview(ch.DeviceID, ch.ID, "rtp", zlmHost, httpPort, rtmpPort)
+				previewRes, err = s.previewManager.StartChannelPreview(ch.DeviceID, ch.ID, gb28181.StreamQualityMain, "rtp", zlmHost, httpPort, rtmpPort)
 			} else {
 				err = fmt.Errorf("preview manager 未初始化")
 			}
@@ -420,11 +543,18 @@ func (s *Server) SyncGB28181Channel(channel *gb28181.Channel) error {
 		DeviceType:  "gb28181",
 		Status:      channel.Status,
 		StreamURL:   "",
+
+		ParentID:        channel.ParentID,
+		BusinessGroupID: channel.BusinessGroupID,
+		CivilCode:       channel.CivilCode,
 	}
 
 	if existingChannel, exists := s.channelManager.GetChannel(channel.ChannelID); exists {
 		existingChannel.ChannelName = channel.Name
 		existingChannel.Status = channel.Status
+		existingChannel.ParentID = channel.ParentID
+		existingChannel.BusinessGroupID = channel.BusinessGroupID
+		existingChannel.CivilCode = channel.CivilCode
 		return s.channelManager.UpdateChannel(existingChannel)
 	} else {
 		return s.channelManager.AddChannel(apiChannel)
@@ -443,6 +573,9 @@ func (s *Server) Start() error {
 		r.Use(s.authMiddleware.Handler)
 	}
 
+	// 审计中间件需在认证之后，以便获取当前用户
+	r.Use(s.auditMiddleware)
+
 	s.setupRoutes(r)
 
 	s.server = &http.Server{
@@ -465,6 +598,11 @@ func (s *Server) Start() error {
 	debug.Info("api", "API服务器启动成功，监听地址: %s:%d", s.config.API.Host, s.config.API.Port)
 
 	s.startRecordingWatchdog()
+	s.startPreviewReaper()
+
+	if s.pushManager != nil {
+		s.pushManager.StartSupervisor()
+	}
 
 	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		debug.Error("api", "启动API服务器失败: %v", err)
@@ -477,6 +615,11 @@ func (s *Server) Start() error {
 // Stop 停止API服务器
 func (s *Server) Stop() error {
 	s.stopRecordingWatchdog()
+	s.stopPreviewReaper()
+
+	if s.pushManager != nil {
+		s.pushManager.StopSupervisor()
+	}
 
 	// 停止所有 ffmpeg 推流会话
 	if s.ffmpegStreamMgr != nil {
@@ -484,6 +627,10 @@ func (s *Server) Stop() error {
 		s.ffmpegStreamMgr.StopAll()
 	}
 
+	if s.auditLogger != nil {
+		s.auditLogger.Close()
+	}
+
 	if s.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -514,7 +661,7 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 
 		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, X-API-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
@@ -614,7 +761,24 @@ func (s *Server) setupRoutes(r *mux.Router) {
 		r.HandleFunc("/api/auth/users/delete", s.authHandler.HandleDeleteUser).Methods("DELETE", "OPTIONS")
 		r.HandleFunc("/api/auth/password", s.authHandler.HandleChangePassword).Methods("PUT", "OPTIONS")
 		r.HandleFunc("/api/auth/validate", s.authHandler.HandleValidateToken).Methods("GET", "OPTIONS")
-	}
+		r.HandleFunc("/api/auth/providers", s.authHandler.HandleListProviders).Methods("GET", "OPTIONS")
+		r.HandleFunc("/api/auth/2fa/verify", s.authHandler.HandleVerifyTwoFactor).Methods("POST", "OPTIONS")
+		r.HandleFunc("/api/auth/2fa/enroll", s.authHandler.HandleEnrollTwoFactor).Methods("POST", "OPTIONS")
+		r.HandleFunc("/api/auth/2fa/enable", s.authHandler.HandleEnableTwoFactor).Methods("POST", "OPTIONS")
+		r.HandleFunc("/api/auth/2fa/recovery-codes", s.authHandler.HandleRegenerateRecoveryCodes).Methods("POST", "OPTIONS")
+		r.HandleFunc("/api/auth/2fa/disable", s.authHandler.HandleDisableTwoFactor).Methods("POST", "OPTIONS")
+		r.HandleFunc("/api/auth/oidc/login", s.authHandler.HandleOIDCLogin).Methods("GET")
+		r.HandleFunc("/api/auth/oidc/callback", s.authHandler.HandleOIDCCallback).Methods("GET")
+		r.HandleFunc("/api/auth/apikeys", s.authHandler.HandleListAPIKeys).Methods("GET", "OPTIONS")
+		r.HandleFunc("/api/auth/apikeys", s.authHandler.HandleCreateAPIKey).Methods("POST", "OPTIONS")
+		r.HandleFunc("/api/auth/apikeys/update", s.authHandler.HandleUpdateAPIKey).Methods("PUT", "OPTIONS")
+		r.HandleFunc("/api/auth/apikeys/delete", s.authHandler.HandleDeleteAPIKey).Methods("DELETE", "OPTIONS")
+	}
+
+	// 审计日志API（仅管理员）
+	auditGroup := r.PathPrefix("/api/audit").Subrouter()
+	auditGroup.Handle("/logs", s.requireRole(auth.RoleAdmin, s.handleQueryAuditLogs)).Methods("GET")
+	auditGroup.Handle("/logs/export", s.requireRole(auth.RoleAdmin, s.handleExportAuditLogs)).Methods("GET")
 
 	// 服务控制API
 	r.HandleFunc("/api/services/status", s.handleGetServiceStatus).Methods("GET")
@@ -633,11 +797,32 @@ func (s *Server) setupRoutes(r *mux.Router) {
 	gb28181Group.HandleFunc("/devices/{id}/channels", s.handleGetGB28181Channels).Methods("GET")
 	gb28181Group.HandleFunc("/devices/{id}/catalog", s.handleGB28181Catalog).Methods("POST")
 	gb28181Group.HandleFunc("/devices/{id}/refresh", s.handleRefreshGB28181Device).Methods("POST")
+	gb28181Group.HandleFunc("/devices/{id}/status", s.handleGB28181DeviceStatus).Methods("GET")
+	gb28181Group.HandleFunc("/devices/{id}/stream-mode", s.handleSetGB28181StreamMode).Methods("PUT")
 	gb28181Group.HandleFunc("/devices/{id}/preview/start", s.handleStartGB28181Preview).Methods("POST")
 	gb28181Group.HandleFunc("/devices/{id}/preview/stop", s.handleStopGB28181Preview).Methods("POST")
 	gb28181Group.HandleFunc("/devices/{id}/channels/{channelId}/preview/start", s.handleStartGB28181ChannelPreview).Methods("POST")
 	gb28181Group.HandleFunc("/devices/{id}/channels/{channelId}/preview/stop", s.handleStopGB28181ChannelPreview).Methods("POST")
 	gb28181Group.HandleFunc("/devices/{id}/ptz", s.handleGB28181PTZ).Methods("POST")
+	gb28181Group.HandleFunc("/devices/{id}/channels/{channelId}/presets", s.handleGB28181GetPresets).Methods("GET")
+	gb28181Group.Handle("/devices/{id}/channels/{channelId}/presets", s.requireRole(auth.RoleOperator, s.handleGB28181SetPreset)).Methods("POST")
+	gb28181Group.Handle("/devices/{id}/channels/{channelId}/presets/{preset}/goto", s.requireRole(auth.RoleOperator, s.handleGB28181GotoPreset)).Methods("POST")
+	gb28181Group.Handle("/devices/{id}/channels/{channelId}/presets/{preset}", s.requireRole(auth.RoleOperator, s.handleGB28181DeletePreset)).Methods("DELETE")
+	gb28181Group.Handle("/devices/{id}/channels/{channelId}/cruise", s.requireRole(auth.RoleOperator, s.handleGB28181Cruise)).Methods("POST")
+	gb28181Group.Handle("/devices/{id}/channels/{channelId}/scan", s.requireRole(auth.RoleOperator, s.handleGB28181Scan)).Methods("POST")
+	gb28181Group.Handle("/devices/{id}/control", s.requireRole(auth.RoleOperator, s.handleGB28181DeviceControl)).Methods("POST")
+	gb28181Group.HandleFunc("/devices/{id}/config", s.handleGB28181GetDeviceConfig).Methods("GET")
+	gb28181Group.Handle("/devices/{id}/config", s.requireRole(auth.RoleOperator, s.handleGB28181SetDeviceConfig)).Methods("PUT")
+	gb28181Group.HandleFunc("/channels/{id}/track", s.handleGB28181ChannelTrack).Methods("GET")
+	gb28181Group.HandleFunc("/positions", s.handleGB28181LatestPositions).Methods("GET")
+	gb28181Group.Handle("/admission", s.requireRole(auth.RoleAdmin, s.handleGetGB28181Admission)).Methods("GET")
+	gb28181Group.Handle("/admission/rules", s.requireRole(auth.RoleAdmin, s.handleAddGB28181AdmissionRule)).Methods("POST")
+	gb28181Group.Handle("/admission/rules/{ruleId}", s.requireRole(auth.RoleAdmin, s.handleDeleteGB28181AdmissionRule)).Methods("DELETE")
+	gb28181Group.Handle("/admission/credentials/{id}", s.requireRole(auth.RoleAdmin, s.handleSetGB28181Credential)).Methods("PUT")
+	gb28181Group.Handle("/admission/credentials/{id}", s.requireRole(auth.RoleAdmin, s.handleDeleteGB28181Credential)).Methods("DELETE")
+	gb28181Group.Handle("/admission/pending", s.requireRole(auth.RoleAdmin, s.handleGetGB28181PendingDevices)).Methods("GET")
+	gb28181Group.Handle("/admission/pending/{id}/approve", s.requireRole(auth.RoleAdmin, s.handleApproveGB28181Device)).Methods("POST")
+	gb28181Group.Handle("/admission/pending/{id}/reject", s.requireRole(auth.RoleAdmin, s.handleRejectGB28181Device)).Methods("POST")
 	gb28181Group.HandleFunc("/discover", s.handleDiscoverGB28181Devices).Methods("POST")
 	gb28181Group.HandleFunc("/statistics", s.handleGetGB28181Statistics).Methods("GET")
 	gb28181Group.HandleFunc("/server-config", s.handleGetGB28181ServerConfig).Methods("GET")
@@ -689,11 +874,20 @@ func (s *Server) setupRoutes(r *mux.Router) {
 	previewGroup.HandleFunc("/sessions/{key}", s.handleStopPreviewSession).Methods("DELETE")
 	previewGroup.HandleFunc("/start", s.handleStartPreview).Methods("POST")
 	previewGroup.HandleFunc("/stop", s.handleStopPreview).Methods("POST")
+	previewGroup.HandleFunc("/heartbeat", s.handlePreviewHeartbeat).Methods("POST")
+	previewGroup.HandleFunc("/release", s.handleReleasePreview).Methods("POST")
 	streamGroup.HandleFunc("/list", s.handleListStreams).Methods("GET")
 
 	// 通道管理API
 	channelGroup := r.PathPrefix("/api/channel").Subrouter()
 	channelGroup.HandleFunc("/list", s.handleListChannels).Methods("GET")
+	channelGroup.HandleFunc("/tree", s.handleGetChannelTree).Methods("GET")
+	channelGroup.HandleFunc("/groups", s.handleListChannelGroups).Methods("GET")
+	channelGroup.Handle("/groups", s.requireRole(auth.RoleAdmin, s.handleCreateChannelGroup)).Methods("POST")
+	channelGroup.Handle("/groups/{groupId}", s.requireRole(auth.RoleAdmin, s.handleUpdateChannelGroup)).Methods("PUT")
+	channelGroup.Handle("/groups/{groupId}", s.requireRole(auth.RoleAdmin, s.handleDeleteChannelGroup)).Methods("DELETE")
+	channelGroup.Handle("/groups/{groupId}/channels", s.requireRole(auth.RoleAdmin, s.handleAssignGroupChannels)).Methods("POST")
+	channelGroup.Handle("/groups/{groupId}/channels/{channelId}", s.requireRole(auth.RoleAdmin, s.handleRemoveGroupChannel)).Methods("DELETE")
 	channelGroup.HandleFunc("/import", s.handleImportChannels).Methods("POST")
 	channelGroup.HandleFunc("/add", s.handleAddChannel).Methods("POST")
 	channelGroup.HandleFunc("/{id}", s.handleDeleteChannel).Methods("DELETE")
@@ -767,6 +961,7 @@ func (s *Server) setupRoutes(r *mux.Router) {
 	// 推流管理API
 	pushGroup := r.PathPrefix("/api/push").Subrouter()
 	pushGroup.HandleFunc("/platforms", s.handleGetPushPlatforms).Methods("GET")
+	pushGroup.HandleFunc("/profiles", s.handleGetPushProfiles).Methods("GET")
 	pushGroup.HandleFunc("/targets", s.handleGetPushTargets).Methods("GET")
 	pushGroup.HandleFunc("/targets", s.handleAddPushTarget).Methods("POST")
 	pushGroup.HandleFunc("/targets/{id}", s.handleGetPushTarget).Methods("GET")
@@ -774,7 +969,16 @@ func (s *Server) setupRoutes(r *mux.Router) {
 	pushGroup.HandleFunc("/targets/{id}", s.handleDeletePushTarget).Methods("DELETE")
 	pushGroup.HandleFunc("/targets/{id}/start", s.handleStartPush).Methods("POST")
 	pushGroup.HandleFunc("/targets/{id}/stop", s.handleStopPush).Methods("POST")
+	pushGroup.HandleFunc("/targets/{id}/history", s.handleGetPushHistory).Methods("GET")
+	pushGroup.HandleFunc("/targets/{id}/command", s.handleGetPushCommand).Methods("GET")
 	pushGroup.HandleFunc("/channel/{channelId}", s.handleGetChannelPushTargets).Methods("GET")
+	pushGroup.HandleFunc("/groups", s.handleGetPushGroups).Methods("GET")
+	pushGroup.HandleFunc("/groups", s.handleAddPushGroup).Methods("POST")
+	pushGroup.HandleFunc("/groups/{id}", s.handleGetPushGroup).Methods("GET")
+	pushGroup.HandleFunc("/groups/{id}", s.handleUpdatePushGroup).Methods("PUT")
+	pushGroup.HandleFunc("/groups/{id}", s.handleDeletePushGroup).Methods("DELETE")
+	pushGroup.HandleFunc("/groups/{id}/start", s.handleStartPushGroup).Methods("POST")
+	pushGroup.HandleFunc("/groups/{id}/stop", s.handleStopPushGroup).Methods("POST")
 
 	// ZLM流代理 - 解决跨域问题
 	r.PathPrefix("/zlm/").HandlerFunc(s.handleZLMProxy)
@@ -957,16 +1161,20 @@ func (s *Server) handleZLMProxy(w http.ResponseWriter, r *http.Request) {
 
 // startPreview 是一个统一的预览启动函数
 // 对于 GB28181，rtspURL 为空；对于 ONVIF，rtspURL 不为空
-func (s *Server) startPreview(r *http.Request, deviceID, channelID, rtspURL, app string) (*preview.PreviewResult, error) {
+func (s *Server) startPreview(r *http.Request, deviceID, channelID, quality, rtspURL, app string) (*preview.PreviewResult, error) {
 	if s.previewManager == nil {
 		return nil, fmt.Errorf("preview manager 未初始化")
 	}
 
+	quality, err := gb28181.ParseStreamQuality(quality)
+	if err != nil {
+		return nil, err
+	}
+
 	zlmHost := s.getZLMHost(r)
 	httpPort, rtmpPort, _ := s.getZLMPorts()
 
 	var res *preview.PreviewResult
-	var err error
 
 	if rtspURL != "" {
 		// ONVIF 或其他 RTSP 流
@@ -980,13 +1188,13 @@ func (s *Server) startPreview(r *http.Request, deviceID, channelID, rtspURL, app
 			}
 		}
 		debug.Info("preview", "添加RTSP流代理: deviceID=%s, app=%s, rtspURL=%s", deviceID, app, rtspURL)
-		res, err = s.previewManager.StartRTSPProxy(deviceID, rtspURL, app, zlmHost, httpPort, rtmpPort, rtspUser, rtspPassword)
+		res, err = s.previewManager.StartRTSPProxy(deviceID, quality, rtspURL, app, zlmHost, httpPort, rtmpPort, rtspUser, rtspPassword)
 		if err == nil {
 			debug.Info("preview", "RTSP流代理添加成功: streamID=%s, flvURL=%s", res.StreamID, res.FlvURL)
 		}
 	} else {
 		// GB28181 流
-		res, err = s.previewManager.StartChannelPreview(deviceID, channelID, app, zlmHost, httpPort, rtmpPort)
+		res, err = s.previewManager.StartChannelPreview(deviceID, channelID, quality, app, zlmHost, httpPort, rtmpPort)
 	}
 
 	if err != nil {
@@ -1002,7 +1210,8 @@ func (s *Server) startPreview(r *http.Request, deviceID, channelID, rtspURL, app
 	session := &PreviewSession{
 		DeviceID:   deviceID,
 		ChannelID:  channelID,
-		StreamKey:  fmt.Sprintf("%s:%s", deviceID, channelID),
+		Quality:    quality,
+		StreamKey:  previewSessionKey(deviceID, channelID, quality),
 		App:        app,
 		Stream:     res.StreamID,
 		SourceURL:  rtspURL,
@@ -1015,6 +1224,10 @@ func (s *Server) startPreview(r *http.Request, deviceID, channelID, rtspURL, app
 		DeviceType: deviceType,
 	}
 	s.previewSessions.Add(session)
+	if viewer, ok := s.previewSessions.Acquire(session.StreamKey, s.clientIP(r), requestUsername(r)); ok {
+		res.LeaseID = viewer.LeaseID
+		res.LeaseTTL = int(previewLeaseTTL.Seconds())
+	}
 
 	debug.Info("preview", "预览会话已创建: key=%s, app=%s, stream=%s", session.StreamKey, app, res.StreamID)
 
@@ -1026,6 +1239,10 @@ func (s *Server) startPreview(r *http.Request, deviceID, channelID, rtspURL, app
 
 	// RtmpURL 已经在 preview.Manager 中生成
 
+	if deviceType == "gb28181" && s.gb28181Server != nil {
+		s.gb28181Server.SetChannelStreamURL(channelID, quality, res.FlvURL)
+	}
+
 	return res, nil
 }
 
@@ -1089,13 +1306,17 @@ func (s *Server) handleAddPushTarget(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name        string `json:"name"`
-		Platform    string `json:"platform"`
-		PushURL     string `json:"push_url"`
-		StreamKey   string `json:"stream_key"`
-		ChannelID   string `json:"channel_id"`
-		ChannelName string `json:"channel_name"`
-		SourceURL   string `json:"source_url"`
+		Name        string            `json:"name"`
+		Platform    string            `json:"platform"`
+		Protocol    string            `json:"protocol"`
+		PushURL     string            `json:"push_url"`
+		StreamKey   string            `json:"stream_key"`
+		ChannelID   string            `json:"channel_id"`
+		ChannelName string            `json:"channel_name"`
+		SourceURL   string            `json:"source_url"`
+		Options     *push.PushOptions `json:"options"`
+		Profile     string            `json:"profile"`
+		Overlays    []push.Overlay    `json:"overlays"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1116,15 +1337,19 @@ func (s *Server) handleAddPushTarget(w http.ResponseWriter, r *http.Request) {
 	target := &push.PushTarget{
 		Name:        req.Name,
 		Platform:    req.Platform,
+		Protocol:    req.Protocol,
 		PushURL:     req.PushURL,
 		StreamKey:   req.StreamKey,
 		ChannelID:   req.ChannelID,
 		ChannelName: req.ChannelName,
 		SourceURL:   req.SourceURL,
+		Options:     req.Options,
+		Profile:     req.Profile,
+		Overlays:    req.Overlays,
 	}
 
 	if err := s.pushManager.AddTarget(target); err != nil {
-		s.jsonError(w, http.StatusInternalServerError, err.Error())
+		s.jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -1229,6 +1454,63 @@ func (s *Server) handleStopPush(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleGetPushHistory 获取推流目标的启停/错误事件历史
+func (s *Server) handleGetPushHistory(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	history, err := s.pushManager.GetHistory(id)
+	if err != nil {
+		s.jsonError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"history": history,
+	})
+}
+
+// handleGetPushProfiles 获取推流转码模板列表
+func (s *Server) handleGetPushProfiles(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"profiles": s.pushManager.GetProfiles(),
+	})
+}
+
+// handleGetPushCommand 预览推流目标生成的 FFmpeg 命令模板
+func (s *Server) handleGetPushCommand(w http.ResponseWriter, r *http.Request) {
+	if s.pushManager == nil {
+		s.jsonError(w, http.StatusServiceUnavailable, "Push manager not initialized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	command, err := s.pushManager.GetCommandTemplate(id)
+	if err != nil {
+		s.jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"command": command,
+	})
+}
+
 // handleGetChannelPushTargets 获取通道的推流任务
 func (s *Server) handleGetChannelPushTargets(w http.ResponseWriter, r *http.Request) {
 	if s.pushManager == nil {
@@ -13,6 +13,10 @@ type Channel struct {
 	StreamURL    string `json:"streamUrl"`
 	Channel      string `json:"channel,omitempty"`      // GB28181通道号
 	ProfileToken string `json:"profileToken,omitempty"` // ONVIF Profile Token
+	// GB28181 设备目录中的层级信息
+	ParentID        string `json:"parentId,omitempty"`
+	BusinessGroupID string `json:"businessGroupId,omitempty"`
+	CivilCode       string `json:"civilCode,omitempty"`
 }
 
 // Recording 录像信息
@@ -0,0 +1,351 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计结果
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+const (
+	currentFileName = "audit.log"
+	rotatedPrefix   = "audit-"
+	rotatedSuffix   = ".log"
+)
+
+// Config 审计日志配置
+type Config struct {
+	Enable     bool   `yaml:"Enable" json:"enable"`
+	Dir        string `yaml:"Dir" json:"dir"`                // 审计日志目录
+	MaxSizeMB  int    `yaml:"MaxSizeMB" json:"max_size_mb"`  // 单个文件最大大小(MB)，超过后轮转
+	MaxBackups int    `yaml:"MaxBackups" json:"max_backups"` // 保留的历史文件数量（0=不限制）
+}
+
+// DefaultConfig 默认审计配置
+func DefaultConfig() *Config {
+	return &Config{
+		Enable:     true,
+		Dir:        "logs/audit",
+		MaxSizeMB:  20,
+		MaxBackups: 30,
+	}
+}
+
+// Entry 审计记录
+type Entry struct {
+	ID         string                 `json:"id"`
+	Time       time.Time              `json:"time"`
+	User       string                 `json:"user"`
+	Role       string                 `json:"role,omitempty"`
+	APIKey     string                 `json:"api_key,omitempty"` // 通过 API Key 调用时记录 Key 前缀
+	IP         string                 `json:"ip"`
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Action     string                 `json:"action"`
+	Target     string                 `json:"target,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	Result     string                 `json:"result"`
+	StatusCode int                    `json:"status_code"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// Filter 查询条件
+type Filter struct {
+	User   string
+	Action string // 前缀匹配，如 "gb28181.devices"
+	Target string // 包含匹配
+	IP     string
+	Result string
+	Start  time.Time
+	End    time.Time
+	Offset int
+	Limit  int
+}
+
+// match 检查记录是否满足条件
+func (f *Filter) match(e *Entry) bool {
+	if f.User != "" && e.User != f.User {
+		return false
+	}
+	if f.Action != "" && !strings.HasPrefix(e.Action, f.Action) {
+		return false
+	}
+	if f.Target != "" && !strings.Contains(e.Target, f.Target) {
+		return false
+	}
+	if f.IP != "" && e.IP != f.IP {
+		return false
+	}
+	if f.Result != "" && e.Result != f.Result {
+		return false
+	}
+	if !f.Start.IsZero() && e.Time.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && e.Time.After(f.End) {
+		return false
+	}
+	return true
+}
+
+// Logger 审计日志记录器
+// 记录以 JSON Lines 追加写入当前文件，文件超过大小上限后按时间戳重命名轮转，
+// 已写入的记录不会被修改
+type Logger struct {
+	config *Config
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	seq    uint64
+}
+
+// NewLogger 创建审计日志记录器
+func NewLogger(config *Config) (*Logger, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.Dir == "" {
+		config.Dir = DefaultConfig().Dir
+	}
+	if config.MaxSizeMB <= 0 {
+		config.MaxSizeMB = DefaultConfig().MaxSizeMB
+	}
+
+	if err := os.MkdirAll(config.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("创建审计日志目录失败: %w", err)
+	}
+
+	l := &Logger{config: config}
+	if err := l.openCurrent(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openCurrent 以追加模式打开当前审计文件
+func (l *Logger) openCurrent() error {
+	path := filepath.Join(l.config.Dir, currentFileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开审计日志文件失败: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Close 关闭审计日志
+func (l *Logger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file != nil {
+		err := l.file.Close()
+		l.file = nil
+		return err
+	}
+	return nil
+}
+
+// Record 写入一条审计记录
+func (l *Logger) Record(entry *Entry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("审计日志已关闭")
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	l.seq++
+	if entry.ID == "" {
+		entry.ID = fmt.Sprintf("%d-%d", entry.Time.UnixNano(), l.seq)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	var rotateErr error
+	if l.size+int64(len(data)) > int64(l.config.MaxSizeMB)*1024*1024 && l.size > 0 {
+		// 轮转失败但原文件已重新打开时仍写入本条记录
+		if rotateErr = l.rotate(); l.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate 轮转当前文件（调用者需持有锁）
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	current := filepath.Join(l.config.Dir, currentFileName)
+	rotated := filepath.Join(l.config.Dir, rotatedPrefix+time.Now().Format("20060102-150405.000")+rotatedSuffix)
+	if err := os.Rename(current, rotated); err != nil {
+		// 轮转失败时继续追加写入原文件，避免后续记录全部丢失
+		if reopenErr := l.openCurrent(); reopenErr != nil {
+			return fmt.Errorf("轮转审计日志失败: %v; %w", err, reopenErr)
+		}
+		return fmt.Errorf("轮转审计日志失败: %w", err)
+	}
+
+	l.pruneBackups()
+	return l.openCurrent()
+}
+
+// pruneBackups 删除超出保留数量的历史文件
+func (l *Logger) pruneBackups() {
+	if l.config.MaxBackups <= 0 {
+		return
+	}
+	backups := l.rotatedFiles()
+	for len(backups) > l.config.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// rotatedFiles 返回按时间升序排列的历史文件
+func (l *Logger) rotatedFiles() []string {
+	matches, _ := filepath.Glob(filepath.Join(l.config.Dir, rotatedPrefix+"*"+rotatedSuffix))
+	sort.Strings(matches)
+	return matches
+}
+
+// Query 按条件查询审计记录，结果按时间倒序排列，返回分页结果和总数
+func (l *Logger) Query(filter Filter) ([]*Entry, int, error) {
+	l.mutex.Lock()
+	files := append(l.rotatedFiles(), filepath.Join(l.config.Dir, currentFileName))
+	l.mutex.Unlock()
+
+	var entries []*Entry
+	for _, path := range files {
+		if err := readEntries(path, &filter, &entries); err != nil && !os.IsNotExist(err) {
+			return nil, 0, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+
+	total := len(entries)
+	if filter.Offset > 0 {
+		if filter.Offset >= len(entries) {
+			return []*Entry{}, total, nil
+		}
+		entries = entries[filter.Offset:]
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, total, nil
+}
+
+// readEntries 读取文件中满足条件的记录
+func readEntries(path string, filter *Filter, out *[]*Entry) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue // 跳过损坏的行
+		}
+		if filter.match(&e) {
+			*out = append(*out, &e)
+		}
+	}
+	return scanner.Err()
+}
+
+// csvSafe 防止 CSV 注入：以公式字符开头的单元格加 ' 前缀，避免在表格软件中被当作公式执行
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// WriteCSV 将审计记录导出为 CSV
+func WriteCSV(w io.Writer, entries []*Entry) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "user", "role", "api_key", "ip", "method", "path", "action", "target", "params", "result", "status_code", "error", "duration_ms"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		params := ""
+		if len(e.Params) > 0 {
+			if data, err := json.Marshal(e.Params); err == nil {
+				params = string(data)
+			}
+		}
+		record := []string{
+			e.Time.Format(time.RFC3339),
+			csvSafe(e.User),
+			csvSafe(e.Role),
+			csvSafe(e.APIKey),
+			csvSafe(e.IP),
+			csvSafe(e.Method),
+			csvSafe(e.Path),
+			csvSafe(e.Action),
+			csvSafe(e.Target),
+			csvSafe(params),
+			csvSafe(e.Result),
+			strconv.Itoa(e.StatusCode),
+			csvSafe(e.Error),
+			strconv.FormatInt(e.DurationMS, 10),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
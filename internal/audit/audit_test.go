@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateFailureReopensFile(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLogger(&Config{Enable: true, Dir: dir, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+
+	// 当前文件被外部删除，下一条记录触发的轮转会因重命名失败而出错
+	if err := os.Remove(filepath.Join(dir, currentFileName)); err != nil {
+		t.Fatal(err)
+	}
+	l.size = 1024 * 1024
+
+	if err := l.Record(&Entry{User: "admin", Action: "rotate-fail"}); err == nil {
+		t.Fatal("轮转失败应返回错误")
+	}
+	if l.file == nil {
+		t.Fatal("轮转失败后审计文件未重新打开")
+	}
+	if err := l.Record(&Entry{User: "admin", Action: "after"}); err != nil {
+		t.Fatalf("轮转失败后的写入: %v", err)
+	}
+
+	entries, total, err := l.Query(Filter{User: "admin"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if total != 2 || entries[0].Action != "after" {
+		t.Fatalf("记录 = %d 条, want 2", total)
+	}
+}
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []*Entry{{
+		User:   "=HYPERLINK(\"http://evil\")",
+		Target: "+cmd",
+		Params: map[string]interface{}{"name": "x"},
+		Error:  "@SUM(A1)",
+		Path:   "-1+1",
+		Action: "update",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := records[1]
+	want := map[int]string{
+		1:  "'=HYPERLINK(\"http://evil\")",
+		6:  "'-1+1",
+		7:  "update",
+		8:  "'+cmd",
+		9:  `{"name":"x"}`,
+		12: "'@SUM(A1)",
+	}
+	for col, value := range want {
+		if row[col] != value {
+			t.Errorf("%s = %q, want %q", records[0][col], row[col], value)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// API Key 相关错误
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyExpired  = errors.New("api key expired")
+	ErrAPIKeyDisabled = errors.New("api key disabled")
+	ErrAPIKeyScope    = errors.New("api key scope does not allow this resource")
+)
+
+const (
+	// APIKeyHeader 机器客户端传递 API Key 的请求头
+	APIKeyHeader = "X-API-Key"
+	// apiKeyPrefix 生成的 API Key 前缀，便于在日志和配置中识别
+	apiKeyPrefix = "gbk_"
+	// apiKeysFileName API Key 存储文件名（与 users.json 同目录）
+	apiKeysFileName = "apikeys.json"
+	// apiKeyTouchInterval 最后使用时间落盘的最小间隔，避免每个请求都写文件
+	apiKeyTouchInterval = time.Minute
+)
+
+// APIKey 机器客户端使用的长期凭证
+// Scopes 为空表示不限资源；否则为 API 资源名列表，如 "gb28181"、"onvif"、"push"，
+// 对应 /api/<scope>/ 路径前缀
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Role      Role      `json:"role"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	Prefix    string    `json:"prefix"`
+	KeyHash   string    `json:"key_hash,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+// APIKeyCreateRequest 创建 API Key 请求
+type APIKeyCreateRequest struct {
+	Name      string   `json:"name"`
+	Role      string   `json:"role"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"` // 有效期（天），0 表示永不过期
+}
+
+// IsExpired 检查 API Key 是否已过期
+func (k *APIKey) IsExpired() bool {
+	return !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt)
+}
+
+// AllowsPath 检查 API Key 的资源范围是否允许访问指定路径
+func (k *APIKey) AllowsPath(path string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range k.Scopes {
+		scope = strings.Trim(scope, "/")
+		if scope == "*" {
+			return true
+		}
+		prefix := "/api/" + scope
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitized 返回不含哈希的副本，用于 API 响应
+func (k *APIKey) sanitized() *APIKey {
+	keyCopy := *k
+	keyCopy.KeyHash = ""
+	keyCopy.Scopes = append([]string(nil), k.Scopes...)
+	return &keyCopy
+}
+
+// hashAPIKey 计算 API Key 的哈希（Key 本身为高熵随机串，使用 SHA-256 即可）
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIKey 生成原始 API Key，返回完整 Key 和用于展示的前缀
+func generateAPIKey() (string, string) {
+	idBytes := make([]byte, 4)
+	rand.Read(idBytes)
+	secret := make([]byte, 24)
+	rand.Read(secret)
+
+	prefix := apiKeyPrefix + hex.EncodeToString(idBytes)
+	return prefix + "_" + hex.EncodeToString(secret), prefix
+}
+
+// apiKeysFile 返回 API Key 存储文件路径
+func (am *AuthManager) apiKeysFile() string {
+	return filepath.Join(filepath.Dir(am.config.UsersFile), apiKeysFileName)
+}
+
+// loadAPIKeys 从文件加载 API Key
+func (am *AuthManager) loadAPIKeys() error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	data, err := os.ReadFile(am.apiKeysFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		am.apiKeys[key.ID] = key
+	}
+
+	return nil
+}
+
+// saveAPIKeys 保存 API Key 到文件（调用者需持有锁）
+func (am *AuthManager) saveAPIKeys() error {
+	keys := make([]*APIKey, 0, len(am.apiKeys))
+	for _, key := range am.apiKeys {
+		keys = append(keys, key)
+	}
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(am.apiKeysFile(), data, 0600)
+}
+
+// CreateAPIKey 为指定用户创建 API Key，返回 Key 信息和仅展示一次的原始 Key
+// caller 为请求者使用的 API Key（通过会话认证时为 nil），新 Key 的角色与作用域不能超过它
+func (am *AuthManager) CreateAPIKey(owner string, req APIKeyCreateRequest, caller *APIKey) (*APIKey, string, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	user, exists := am.users[owner]
+	if !exists {
+		return nil, "", ErrUserNotFound
+	}
+
+	role := Role(req.Role)
+	if role == "" {
+		role = user.Role
+		if caller != nil && !HasPermission(caller.Role, role) {
+			role = caller.Role
+		}
+	}
+	if !isValidRole(role) {
+		return nil, "", errors.New("invalid role")
+	}
+	// API Key 的角色不能高于所属用户
+	if !HasPermission(user.Role, role) {
+		return nil, "", ErrForbidden
+	}
+
+	scopes := normalizeScopes(req.Scopes)
+	if caller != nil {
+		// 未指定作用域时继承当前 Key，避免创建不限资源的 Key
+		if len(scopes) == 0 {
+			scopes = append([]string(nil), caller.Scopes...)
+		}
+		if !HasPermission(caller.Role, role) || !scopesWithin(scopes, caller.Scopes) {
+			return nil, "", ErrForbidden
+		}
+	}
+
+	rawKey, prefix := generateAPIKey()
+	now := time.Now()
+	key := &APIKey{
+		ID:        generateUserID(),
+		Name:      req.Name,
+		Owner:     owner,
+		Role:      role,
+		Scopes:    scopes,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(rawKey),
+		Enabled:   true,
+		CreatedAt: now,
+	}
+	if req.ExpiresIn > 0 {
+		key.ExpiresAt = now.Add(time.Duration(req.ExpiresIn) * 24 * time.Hour)
+	}
+
+	am.apiKeys[key.ID] = key
+	if err := am.saveAPIKeys(); err != nil {
+		delete(am.apiKeys, key.ID)
+		return nil, "", err
+	}
+
+	return key.sanitized(), rawKey, nil
+}
+
+// GetAPIKey 获取 API Key 信息
+func (am *AuthManager) GetAPIKey(id string) (*APIKey, error) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	key, exists := am.apiKeys[id]
+	if !exists {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key.sanitized(), nil
+}
+
+// ListAPIKeys 列出 API Key，owner 为空时返回全部
+func (am *AuthManager) ListAPIKeys(owner string) []*APIKey {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	keys := make([]*APIKey, 0, len(am.apiKeys))
+	for _, key := range am.apiKeys {
+		if owner != "" && key.Owner != owner {
+			continue
+		}
+		keys = append(keys, key.sanitized())
+	}
+	return keys
+}
+
+// UpdateAPIKey 更新 API Key 的名称、角色、资源范围、有效期和启用状态
+// callerRole 为请求者的有效角色；caller 为请求者使用的 API Key（通过会话认证时为 nil），
+// 更新后的角色与作用域不能超过它
+func (am *AuthManager) UpdateAPIKey(id string, updates map[string]interface{}, callerRole Role, caller *APIKey) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	key, exists := am.apiKeys[id]
+	if !exists {
+		return ErrAPIKeyNotFound
+	}
+
+	// 先校验全部字段，避免部分更新
+	role := key.Role
+	if roleStr, ok := updates["role"].(string); ok && roleStr != "" {
+		role = Role(roleStr)
+		if !isValidRole(role) {
+			return errors.New("invalid role")
+		}
+		if !HasPermission(callerRole, role) {
+			return ErrForbidden
+		}
+		if owner, exists := am.users[key.Owner]; exists && !HasPermission(owner.Role, role) {
+			return ErrForbidden
+		}
+		if caller != nil && !HasPermission(caller.Role, role) {
+			return ErrForbidden
+		}
+	}
+
+	scopes := key.Scopes
+	if rawScopes, ok := updates["scopes"].([]interface{}); ok {
+		list := make([]string, 0, len(rawScopes))
+		for _, s := range rawScopes {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+		scopes = normalizeScopes(list)
+		if caller != nil && !scopesWithin(scopes, caller.Scopes) {
+			return ErrForbidden
+		}
+	}
+
+	if name, ok := updates["name"].(string); ok {
+		key.Name = name
+	}
+	key.Role = role
+	key.Scopes = scopes
+
+	if days, ok := updates["expires_in"].(float64); ok {
+		if days > 0 {
+			key.ExpiresAt = time.Now().Add(time.Duration(days * float64(24*time.Hour)))
+		} else {
+			key.ExpiresAt = time.Time{}
+		}
+	}
+
+	if enabled, ok := updates["enabled"].(bool); ok {
+		key.Enabled = enabled
+	}
+
+	return am.saveAPIKeys()
+}
+
+// DeleteAPIKey 删除 API Key
+func (am *AuthManager) DeleteAPIKey(id string) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if _, exists := am.apiKeys[id]; !exists {
+		return ErrAPIKeyNotFound
+	}
+
+	delete(am.apiKeys, id)
+	return am.saveAPIKeys()
+}
+
+// ValidateAPIKey 验证原始 API Key，返回 Key 信息和所属用户
+func (am *AuthManager) ValidateAPIKey(rawKey string) (*APIKey, *User, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+	hash := hashAPIKey(rawKey)
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	var key *APIKey
+	for _, k := range am.apiKeys {
+		if k.KeyHash == hash {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if !key.Enabled {
+		return nil, nil, ErrAPIKeyDisabled
+	}
+	if key.IsExpired() {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	user, exists := am.users[key.Owner]
+	if !exists {
+		return nil, nil, ErrUserNotFound
+	}
+
+	now := time.Now()
+	if now.Sub(key.LastUsed) >= apiKeyTouchInterval {
+		key.LastUsed = now
+		am.saveAPIKeys()
+	} else {
+		key.LastUsed = now
+	}
+
+	return key.sanitized(), user, nil
+}
+
+// deleteUserAPIKeys 删除用户的所有 API Key（调用者需持有锁）
+func (am *AuthManager) deleteUserAPIKeys(username string) {
+	removed := false
+	for id, key := range am.apiKeys {
+		if key.Owner == username {
+			delete(am.apiKeys, id)
+			removed = true
+		}
+	}
+	if removed {
+		am.saveAPIKeys()
+	}
+}
+
+// ExtractAPIKeyFromRequest 从请求中提取 API Key
+// 支持 X-API-Key 头和 "Authorization: ApiKey <key>"，不接受 cookie 和查询参数
+func ExtractAPIKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return strings.TrimSpace(key)
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		parts := strings.SplitN(authHeader, " ", 2)
+		if len(parts) == 2 && strings.ToLower(parts[0]) == "apikey" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+
+	return ""
+}
+
+// isValidRole 检查角色是否合法
+func isValidRole(role Role) bool {
+	switch role {
+	case RoleAdmin, RoleOperator, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// normalizeScopes 规范化资源范围列表（去除空白、斜杠和重复项）
+func normalizeScopes(scopes []string) []string {
+	seen := make(map[string]bool, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		s = strings.Trim(strings.TrimSpace(s), "/")
+		s = strings.TrimPrefix(s, "api/")
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		result = append(result, s)
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// scopesWithin 检查 scopes 是否不超出 allowed 的范围（空列表表示不限资源）
+func scopesWithin(scopes, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	if len(scopes) == 0 {
+		return false
+	}
+	permitted := make(map[string]bool, len(allowed))
+	for _, s := range allowed {
+		if s == "*" {
+			return true
+		}
+		permitted[s] = true
+	}
+	for _, s := range scopes {
+		if !permitted[s] {
+			return false
+		}
+	}
+	return true
+}
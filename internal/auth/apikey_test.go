@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// viewerCaller 请求者使用的 API Key：viewer，仅限 gb28181 与 onvif
+var viewerCaller = &APIKey{Role: RoleViewer, Scopes: []string{"gb28181", "onvif"}}
+
+func TestUpdateAPIKeyEscalation(t *testing.T) {
+	cases := []struct {
+		name    string
+		updates map[string]interface{}
+		role    Role    // 请求者有效角色
+		caller  *APIKey // 请求者使用的 API Key，nil 表示会话认证
+		wantErr error
+	}{
+		{name: "AdminRaisesRole", updates: map[string]interface{}{"role": "admin"}, role: RoleAdmin},
+		{name: "RoleAboveCaller", updates: map[string]interface{}{"role": "admin"}, role: RoleOperator, wantErr: ErrForbidden},
+		{name: "APIKeyRaisesRole", updates: map[string]interface{}{"role": "operator"}, role: RoleAdmin, caller: viewerCaller, wantErr: ErrForbidden},
+		{name: "AdminKeyRaisesRole", updates: map[string]interface{}{"role": "operator"}, role: RoleAdmin, caller: &APIKey{Role: RoleAdmin}},
+		{name: "APIKeyLowersRole", updates: map[string]interface{}{"role": "viewer"}, role: RoleViewer, caller: viewerCaller},
+		{name: "APIKeyNarrowsScopes", updates: map[string]interface{}{"scopes": []interface{}{"gb28181"}}, role: RoleAdmin, caller: viewerCaller},
+		{name: "APIKeyAddsScope", updates: map[string]interface{}{"scopes": []interface{}{"gb28181", "auth"}}, role: RoleAdmin, caller: viewerCaller, wantErr: ErrForbidden},
+		{name: "APIKeyClearsScopes", updates: map[string]interface{}{"scopes": []interface{}{}}, role: RoleAdmin, caller: viewerCaller, wantErr: ErrForbidden},
+		{name: "NarrowKeyWidensOther", updates: map[string]interface{}{"scopes": []interface{}{"gb28181", "onvif"}}, role: RoleAdmin, caller: &APIKey{Role: RoleAdmin, Scopes: []string{"gb28181"}}, wantErr: ErrForbidden},
+		{name: "UserClearsScopes", updates: map[string]interface{}{"scopes": []interface{}{}}, role: RoleAdmin},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			am := newTestAuthManager(t, false)
+			key, _, err := am.CreateAPIKey("admin", APIKeyCreateRequest{Name: "ci", Role: "viewer", Scopes: []string{"gb28181", "onvif"}}, nil)
+			if err != nil {
+				t.Fatalf("CreateAPIKey: %v", err)
+			}
+
+			err = am.UpdateAPIKey(key.ID, tc.updates, tc.role, tc.caller)
+			if err != tc.wantErr {
+				t.Fatalf("UpdateAPIKey err = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				got, _ := am.GetAPIKey(key.ID)
+				if got.Role != RoleViewer || len(got.Scopes) != 2 {
+					t.Errorf("拒绝后 Key 被修改: role=%s scopes=%v", got.Role, got.Scopes)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyBoundedByCaller(t *testing.T) {
+	cases := []struct {
+		name       string
+		req        APIKeyCreateRequest
+		caller     *APIKey
+		wantRole   Role
+		wantScopes []string
+		wantErr    error
+	}{
+		{name: "Session", req: APIKeyCreateRequest{Name: "k"}, wantRole: RoleAdmin},
+		{name: "InheritsCaller", req: APIKeyCreateRequest{Name: "k"}, caller: viewerCaller, wantRole: RoleViewer, wantScopes: []string{"gb28181", "onvif"}},
+		{name: "NarrowerScopes", req: APIKeyCreateRequest{Name: "k", Scopes: []string{"onvif"}}, caller: viewerCaller, wantRole: RoleViewer, wantScopes: []string{"onvif"}},
+		{name: "HigherRole", req: APIKeyCreateRequest{Name: "k", Role: "operator"}, caller: viewerCaller, wantErr: ErrForbidden},
+		{name: "WiderScopes", req: APIKeyCreateRequest{Name: "k", Scopes: []string{"auth"}}, caller: viewerCaller, wantErr: ErrForbidden},
+		{name: "UnscopedCaller", req: APIKeyCreateRequest{Name: "k", Role: "operator"}, caller: &APIKey{Role: RoleAdmin}, wantRole: RoleOperator},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			am := newTestAuthManager(t, false)
+			key, _, err := am.CreateAPIKey("admin", tc.req, tc.caller)
+			if err != tc.wantErr {
+				t.Fatalf("CreateAPIKey err = %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				if keys := am.ListAPIKeys(""); len(keys) != 0 {
+					t.Errorf("拒绝后仍创建了 Key: %+v", keys)
+				}
+				return
+			}
+			if key.Role != tc.wantRole || strings.Join(key.Scopes, ",") != strings.Join(tc.wantScopes, ",") {
+				t.Errorf("Key role=%s scopes=%v, want %s %v", key.Role, key.Scopes, tc.wantRole, tc.wantScopes)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRejectsAPIKey(t *testing.T) {
+	am := newTestAuthManager(t, false)
+	_, rawKey, err := am.CreateAPIKey("admin", APIKeyCreateRequest{Name: "viewer", Role: "viewer"}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	handler := NewMiddleware(am).Handler(http.HandlerFunc(NewAuthHandler(am).HandleRefreshToken))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+	req.Header.Set(APIKeyHeader, rawKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), `"token"`) || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("API Key 换取了会话令牌: %s", rec.Body.String())
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"sync"
 	"time"
 
+	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/secrets"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -46,6 +49,15 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	LastLogin time.Time `json:"last_login,omitempty"`
+	// 外部身份源用户（JIT 开通），本地用户为空
+	Source      string `json:"source,omitempty"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	// TOTP 双因素认证
+	TOTPEnabled   bool     `json:"totp_enabled"`
+	TOTPSecret    string   `json:"-"`
+	TOTPLastStep  int64    `json:"-"` // 最近一次使用的时间步，防止验证码重放
+	RecoveryCodes []string `json:"-"` // 恢复码哈希
 }
 
 // userPersist 用于持久化的用户结构（包含密码）
@@ -58,6 +70,15 @@ type userPersist struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	LastLogin time.Time `json:"last_login,omitempty"`
+
+	Source      string `json:"source,omitempty"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+
+	TOTPEnabled   bool     `json:"totp_enabled,omitempty"`
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 // Claims JWT声明
@@ -65,6 +86,8 @@ type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     Role   `json:"role"`
+	// Purpose 令牌用途，为空表示完整登录令牌；"2fa" 表示仅可用于完成双因素认证的预认证令牌
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -80,6 +103,10 @@ type LoginResponse struct {
 	Token   string `json:"token,omitempty"`
 	User    *User  `json:"user,omitempty"`
 	Error   string `json:"error,omitempty"`
+	// 需要第二因素时不返回 Token，而是返回短期预认证令牌
+	TwoFactorRequired  bool   `json:"two_factor_required,omitempty"`
+	EnrollmentRequired bool   `json:"enrollment_required,omitempty"` // 角色要求 2FA 但尚未注册
+	PreAuthToken       string `json:"pre_auth_token,omitempty"`
 }
 
 // AuthConfig 认证配置
@@ -90,6 +117,10 @@ type AuthConfig struct {
 	UsersFile       string        `yaml:"UsersFile" json:"users_file"`
 	DefaultAdmin    string        `yaml:"DefaultAdmin" json:"default_admin"`
 	DefaultPassword string        `yaml:"DefaultPassword" json:"-"`
+	// DisableLocalLogin 禁用本地密码登录（默认管理员作为应急账户除外）
+	DisableLocalLogin bool `yaml:"DisableLocalLogin" json:"disable_local_login"`
+	// TwoFactorRequiredRoles 必须启用 TOTP 双因素认证的角色
+	TwoFactorRequiredRoles []Role `yaml:"TwoFactorRequiredRoles" json:"two_factor_required_roles"`
 }
 
 // DefaultAuthConfig 默认认证配置
@@ -108,8 +139,12 @@ func DefaultAuthConfig() *AuthConfig {
 type AuthManager struct {
 	config    *AuthConfig
 	users     map[string]*User
+	apiKeys   map[string]*APIKey
+	providers []Authenticator
 	mutex     sync.RWMutex
-	jwtSecret []byte
+	// 预认证令牌 ID -> 失败次数
+	preAuthFailures map[string]int
+	jwtSecret       []byte
 }
 
 // NewAuthManager 创建认证管理器
@@ -125,11 +160,15 @@ func NewAuthManager(config *AuthConfig) *AuthManager {
 	am := &AuthManager{
 		config:    config,
 		users:     make(map[string]*User),
+		apiKeys:   make(map[string]*APIKey),
 		jwtSecret: []byte(config.JWTSecret),
+
+		preAuthFailures: make(map[string]int),
 	}
 
 	// 加载用户数据
 	am.loadUsers()
+	am.loadAPIKeys()
 
 	// 确保有默认管理员账户
 	am.ensureDefaultAdmin()
@@ -162,7 +201,18 @@ func (am *AuthManager) loadUsers() error {
 		return err
 	}
 
+	plaintext := 0
 	for _, up := range users {
+		if up.TOTPSecret != "" && !secrets.IsEncrypted(up.TOTPSecret) {
+			plaintext++
+		}
+		totpSecret, err := secrets.Decrypt(up.TOTPSecret)
+		if err != nil {
+			// 解密失败时保留密文：双因素校验失败而不是被跳过，更换正确主密钥后可恢复
+			debug.Error("auth", "Failed to decrypt TOTP secret of user %s: %v", up.Username, err)
+			totpSecret = up.TOTPSecret
+		}
+
 		user := &User{
 			ID:        up.ID,
 			Username:  up.Username,
@@ -172,18 +222,46 @@ func (am *AuthManager) loadUsers() error {
 			CreatedAt: up.CreatedAt,
 			UpdatedAt: up.UpdatedAt,
 			LastLogin: up.LastLogin,
+
+			Source:      up.Source,
+			Email:       up.Email,
+			DisplayName: up.DisplayName,
+
+			TOTPEnabled:   up.TOTPEnabled,
+			TOTPSecret:    totpSecret,
+			TOTPLastStep:  up.TOTPLastStep,
+			RecoveryCodes: up.RecoveryCodes,
 		}
 		am.users[user.Username] = user
 	}
 
+	// 旧版明文 TOTP 密钥立即加密回写
+	if plaintext > 0 {
+		debug.Info("auth", "Encrypting %d plaintext TOTP secrets", plaintext)
+		if err := am.saveUsers(); err != nil {
+			debug.Warn("auth", "Failed to save users: %v", err)
+		}
+	}
+
 	return nil
 }
 
-// saveUsers 保存用户到文件
+// RewriteUsers 使用当前主密钥重写用户文件（密钥轮换时使用）
+func (am *AuthManager) RewriteUsers() error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	return am.saveUsers()
+}
+
+// saveUsers 保存用户到文件，TOTP 密钥加密后落盘，内存中保留明文
 func (am *AuthManager) saveUsers() error {
 	// 注意：调用此函数时，调用者应该已经持有锁或者在安全的上下文中
 	users := make([]*userPersist, 0, len(am.users))
 	for _, user := range am.users {
+		totpSecret, err := secrets.Encrypt(user.TOTPSecret)
+		if err != nil {
+			return fmt.Errorf("encrypt totp secret: %w", err)
+		}
 		up := &userPersist{
 			ID:        user.ID,
 			Username:  user.Username,
@@ -193,6 +271,15 @@ func (am *AuthManager) saveUsers() error {
 			CreatedAt: user.CreatedAt,
 			UpdatedAt: user.UpdatedAt,
 			LastLogin: user.LastLogin,
+
+			Source:      user.Source,
+			Email:       user.Email,
+			DisplayName: user.DisplayName,
+
+			TOTPEnabled:   user.TOTPEnabled,
+			TOTPSecret:    totpSecret,
+			TOTPLastStep:  user.TOTPLastStep,
+			RecoveryCodes: user.RecoveryCodes,
 		}
 		users = append(users, up)
 	}
@@ -239,13 +326,18 @@ func generateUserID() string {
 }
 
 // Authenticate 验证用户名密码
+// 本地用户使用 bcrypt 校验；外部身份源用户和未知用户交给已注册的密码类身份源（如 LDAP）
 func (am *AuthManager) Authenticate(username, password string) (*User, error) {
 	am.mutex.RLock()
 	user, exists := am.users[username]
 	am.mutex.RUnlock()
 
-	if !exists {
-		return nil, ErrInvalidCredentials
+	if !exists || user.Source != UserSourceLocal {
+		return am.authenticateExternal(username, password)
+	}
+
+	if am.config.DisableLocalLogin && !am.isBreakGlassUser(username) {
+		return nil, ErrLocalLoginDisabled
 	}
 
 	if !user.Enabled {
@@ -282,8 +374,20 @@ func (am *AuthManager) GenerateToken(user *User) (string, error) {
 	return token.SignedString(am.jwtSecret)
 }
 
-// ValidateToken 验证JWT令牌
+// ValidateToken 验证JWT令牌（预认证令牌不能作为登录令牌使用）
 func (am *AuthManager) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := am.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// parseToken 解析并校验JWT签名和有效期
+func (am *AuthManager) parseToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -416,6 +520,7 @@ func (am *AuthManager) DeleteUser(username string) error {
 
 	delete(am.users, username)
 	am.saveUsers()
+	am.deleteUserAPIKeys(username)
 
 	return nil
 }
@@ -1,21 +1,42 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"gb28181-onvif-server/internal/debug"
 )
 
+// oidcStateCookie OIDC 登录状态 cookie 名称
+const oidcStateCookie = "oidc_state"
+
+// oidcPendingLogin 等待 IdP 回调的登录请求
+type oidcPendingLogin struct {
+	provider string
+	nonce    string
+	redirect string
+	expires  time.Time
+}
+
 // AuthHandler 认证处理器
 type AuthHandler struct {
 	authManager *AuthManager
+
+	oidcMutex   sync.Mutex
+	oidcPending map[string]*oidcPendingLogin
 }
 
 // NewAuthHandler 创建认证处理器
 func NewAuthHandler(am *AuthManager) *AuthHandler {
-	return &AuthHandler{authManager: am}
+	return &AuthHandler{
+		authManager: am,
+		oidcPending: make(map[string]*oidcPendingLogin),
+	}
 }
 
 // HandleLogin 处理登录请求
@@ -38,6 +59,26 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 需要第二因素时只签发预认证令牌，不设置登录 cookie
+	if h.authManager.RequiresTwoFactor(user) {
+		preAuthToken, err := h.authManager.GeneratePreAuthToken(user)
+		if err != nil {
+			debug.Error("auth", "Failed to generate pre-auth token: %v", err)
+			h.jsonError(w, http.StatusInternalServerError, "failed to generate token")
+			return
+		}
+
+		debug.Info("auth", "User %s passed password check, awaiting second factor", req.Username)
+
+		h.jsonResponse(w, http.StatusOK, LoginResponse{
+			Success:            true,
+			TwoFactorRequired:  true,
+			EnrollmentRequired: !user.TOTPEnabled,
+			PreAuthToken:       preAuthToken,
+		})
+		return
+	}
+
 	token, err := h.authManager.GenerateToken(user)
 	if err != nil {
 		debug.Error("auth", "Failed to generate token: %v", err)
@@ -296,6 +337,11 @@ func (h *AuthHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request)
 		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
 		return
 	}
+	// API Key 不能换取会话令牌，否则会绕过 Key 的角色、作用域与有效期限制
+	if GetAPIKeyFromContext(r.Context()) != nil {
+		h.jsonError(w, http.StatusForbidden, "api key cannot be exchanged for a session token")
+		return
+	}
 
 	token, err := h.authManager.GenerateToken(user)
 	if err != nil {
@@ -345,6 +391,633 @@ func (h *AuthHandler) HandleValidateToken(w http.ResponseWriter, r *http.Request
 	})
 }
 
+// HandleListProviders 列出可用的登录方式（公开接口，供登录页使用）
+func (h *AuthHandler) HandleListProviders(w http.ResponseWriter, r *http.Request) {
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"providers":   h.authManager.ListProviders(),
+		"local_login": !h.authManager.config.DisableLocalLogin,
+	})
+}
+
+// HandleOIDCLogin 跳转到 OIDC 身份源登录
+func (h *AuthHandler) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("provider")
+	provider, err := h.authManager.GetProvider(name)
+	if err != nil {
+		h.jsonError(w, http.StatusNotFound, "identity provider not found")
+		return
+	}
+	redirectProvider, ok := provider.(RedirectAuthenticator)
+	if !ok {
+		h.jsonError(w, http.StatusBadRequest, "identity provider does not support redirect login")
+		return
+	}
+
+	state := generateRandomSecret()
+	pending := &oidcPendingLogin{
+		provider: name,
+		nonce:    generateRandomSecret(),
+		redirect: safeRedirectPath(r.URL.Query().Get("redirect")),
+		expires:  time.Now().Add(10 * time.Minute),
+	}
+
+	authURL, err := redirectProvider.AuthCodeURL(r.Context(), state, pending.nonce)
+	if err != nil {
+		debug.Error("auth", "OIDC provider %s unavailable: %v", name, err)
+		h.jsonError(w, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+
+	h.oidcMutex.Lock()
+	now := time.Now()
+	for k, p := range h.oidcPending {
+		if now.After(p.expires) {
+			delete(h.oidcPending, k)
+		}
+	}
+	h.oidcPending[state] = pending
+	h.oidcMutex.Unlock()
+
+	// 将 state 绑定到发起登录的浏览器，防止登录 CSRF
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		HttpOnly: true,
+		MaxAge:   600,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback 处理 OIDC 授权码回调，完成 JIT 开通并签发 JWT
+func (h *AuthHandler) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		debug.Warn("auth", "OIDC login rejected by provider: %s %s", errCode, query.Get("error_description"))
+		http.Redirect(w, r, "/login?error="+errCode, http.StatusFound)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		h.jsonError(w, http.StatusBadRequest, "invalid oidc state")
+		return
+	}
+
+	h.oidcMutex.Lock()
+	pending, exists := h.oidcPending[state]
+	delete(h.oidcPending, state)
+	h.oidcMutex.Unlock()
+
+	if !exists || time.Now().After(pending.expires) {
+		h.jsonError(w, http.StatusBadRequest, "oidc login expired")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/auth/oidc", HttpOnly: true, MaxAge: -1})
+
+	provider, err := h.authManager.GetProvider(pending.provider)
+	if err != nil {
+		h.jsonError(w, http.StatusNotFound, "identity provider not found")
+		return
+	}
+	redirectProvider, ok := provider.(RedirectAuthenticator)
+	if !ok {
+		h.jsonError(w, http.StatusBadRequest, "identity provider does not support redirect login")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	identity, err := redirectProvider.Exchange(ctx, query.Get("code"), pending.nonce)
+	if err != nil {
+		debug.Warn("auth", "OIDC login failed via %s: %v", pending.provider, err)
+		h.jsonError(w, http.StatusUnauthorized, "oidc authentication failed")
+		return
+	}
+
+	user, err := h.authManager.ProvisionExternalUser(provider, identity)
+	if err != nil {
+		debug.Warn("auth", "OIDC user %s rejected: %v", identity.Username, err)
+		h.jsonError(w, http.StatusForbidden, "user is not allowed to log in")
+		return
+	}
+
+	// 与密码登录一致：需要第二因素时只签发预认证令牌，跳回登录页完成验证或注册
+	// 令牌放在 URL 片段中，不会随请求发送到服务器或写入访问日志
+	if h.authManager.RequiresTwoFactor(user) {
+		preAuthToken, err := h.authManager.GeneratePreAuthToken(user)
+		if err != nil {
+			debug.Error("auth", "Failed to generate pre-auth token: %v", err)
+			h.jsonError(w, http.StatusInternalServerError, "failed to generate token")
+			return
+		}
+
+		debug.Info("auth", "User %s passed %s login, awaiting second factor", user.Username, pending.provider)
+
+		params := url.Values{}
+		params.Set("two_factor_required", "true")
+		if !user.TOTPEnabled {
+			params.Set("enrollment_required", "true")
+		}
+		params.Set("redirect", pending.redirect)
+		fragment := url.Values{"pre_auth_token": {preAuthToken}}
+		http.Redirect(w, r, "/login?"+params.Encode()+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	token, err := h.authManager.GenerateToken(user)
+	if err != nil {
+		debug.Error("auth", "Failed to generate token: %v", err)
+		h.jsonError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "auth_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   int(h.authManager.config.TokenExpiry.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	debug.Info("auth", "User %s logged in via %s (role=%s)", user.Username, pending.provider, user.Role)
+
+	http.Redirect(w, r, pending.redirect, http.StatusFound)
+}
+
+// twoFactorRequest 双因素认证请求
+type twoFactorRequest struct {
+	PreAuthToken string `json:"pre_auth_token"`
+	Code         string `json:"code"`
+}
+
+// resolveTwoFactorUser 解析双因素认证接口的调用者
+// 登录流程中使用预认证令牌，已登录用户使用正式令牌；返回是否为预认证令牌
+func (h *AuthHandler) resolveTwoFactorUser(r *http.Request, preAuthToken string) (*Claims, *User, bool, error) {
+	var claims *Claims
+	var err error
+	preAuth := preAuthToken != ""
+	if preAuth {
+		claims, err = h.authManager.ValidatePreAuthToken(preAuthToken)
+	} else {
+		token := ExtractTokenFromRequest(r)
+		if token == "" {
+			return nil, nil, false, ErrInvalidToken
+		}
+		claims, err = h.authManager.ValidateToken(token)
+	}
+	if err != nil {
+		return nil, nil, preAuth, err
+	}
+
+	user, err := h.authManager.GetUser(claims.Username)
+	if err != nil {
+		return nil, nil, preAuth, err
+	}
+	if !user.Enabled {
+		return nil, nil, preAuth, ErrForbidden
+	}
+	return claims, user, preAuth, nil
+}
+
+// completeLogin 签发正式令牌并设置 cookie
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, user *User) (string, bool) {
+	token, err := h.authManager.GenerateToken(user)
+	if err != nil {
+		debug.Error("auth", "Failed to generate token: %v", err)
+		h.jsonError(w, http.StatusInternalServerError, "failed to generate token")
+		return "", false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "auth_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   int(h.authManager.config.TokenExpiry.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, true
+}
+
+// HandleVerifyTwoFactor 登录第二步：校验 TOTP 码或恢复码
+func (h *AuthHandler) HandleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.PreAuthToken == "" || req.Code == "" {
+		h.jsonError(w, http.StatusBadRequest, "pre_auth_token and code are required")
+		return
+	}
+
+	claims, user, _, err := h.resolveTwoFactorUser(r, req.PreAuthToken)
+	if err != nil {
+		if err == ErrTooManyAttempts {
+			h.jsonError(w, http.StatusTooManyRequests, "too many failed attempts, please log in again")
+			return
+		}
+		h.jsonError(w, http.StatusUnauthorized, "invalid or expired pre-auth token")
+		return
+	}
+	if !user.TOTPEnabled {
+		h.jsonError(w, http.StatusBadRequest, "two-factor enrollment required")
+		return
+	}
+
+	if err := h.authManager.VerifySecondFactor(user.Username, req.Code); err != nil {
+		h.authManager.recordPreAuthFailure(claims.ID)
+		debug.Warn("auth", "Two-factor verification failed for user %s", user.Username)
+		h.jsonError(w, http.StatusUnauthorized, "invalid two-factor code")
+		return
+	}
+
+	token, ok := h.completeLogin(w, user)
+	if !ok {
+		return
+	}
+
+	debug.Info("auth", "User %s logged in successfully (2FA)", user.Username)
+
+	userCopy := *user
+	userCopy.Password = ""
+
+	h.jsonResponse(w, http.StatusOK, LoginResponse{
+		Success: true,
+		Token:   token,
+		User:    &userCopy,
+	})
+}
+
+// HandleEnrollTwoFactor 开始 TOTP 注册，返回密钥和二维码 URI
+func (h *AuthHandler) HandleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.jsonError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	_, user, _, err := h.resolveTwoFactorUser(r, req.PreAuthToken)
+	if err != nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+	enrollment, err := h.authManager.BeginTOTPEnrollment(user.Username)
+	if err != nil {
+		if err == ErrTOTPAlreadyEnrolled {
+			h.jsonError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"enrollment": enrollment,
+	})
+}
+
+// HandleEnableTwoFactor 使用验证码确认 TOTP 注册，返回一次性恢复码
+// 通过预认证令牌调用时同时完成登录
+func (h *AuthHandler) HandleEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Code == "" {
+		h.jsonError(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	claims, user, preAuth, err := h.resolveTwoFactorUser(r, req.PreAuthToken)
+	if err != nil {
+		if err == ErrTooManyAttempts {
+			h.jsonError(w, http.StatusTooManyRequests, "too many failed attempts, please log in again")
+			return
+		}
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+
+	codes, err := h.authManager.ConfirmTOTPEnrollment(user.Username, req.Code)
+	if err != nil {
+		switch err {
+		case ErrInvalidTOTPCode:
+			if preAuth {
+				h.authManager.recordPreAuthFailure(claims.ID)
+			}
+			h.jsonError(w, http.StatusUnauthorized, err.Error())
+		case ErrTOTPAlreadyEnrolled:
+			h.jsonError(w, http.StatusConflict, err.Error())
+		default:
+			h.jsonError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	debug.Info("auth", "User %s enabled two-factor authentication", user.Username)
+
+	resp := map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+	}
+	if preAuth {
+		token, ok := h.completeLogin(w, user)
+		if !ok {
+			return
+		}
+		userCopy := *user
+		userCopy.Password = ""
+		resp["token"] = token
+		resp["user"] = &userCopy
+	}
+
+	h.jsonResponse(w, http.StatusOK, resp)
+}
+
+// HandleRegenerateRecoveryCodes 重新生成恢复码（需要当前验证码）
+func (h *AuthHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromContext(r.Context())
+	if user == nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.authManager.VerifySecondFactor(user.Username, req.Code); err != nil {
+		h.jsonError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	codes, err := h.authManager.RegenerateRecoveryCodes(user.Username)
+	if err != nil {
+		h.jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// HandleDisableTwoFactor 关闭双因素认证
+// 用户关闭自己的 2FA 需要验证码；管理员可通过 ?username= 重置其他用户（如丢失设备）
+func (h *AuthHandler) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaimsFromContext(r.Context())
+	if claims == nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username != "" && username != claims.Username {
+		if claims.Role != RoleAdmin {
+			h.jsonError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		if err := h.authManager.DisableTOTP(username); err != nil {
+			h.jsonError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		debug.Info("auth", "Admin %s reset two-factor authentication for user %s", claims.Username, username)
+
+		h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "two-factor authentication reset",
+		})
+		return
+	}
+
+	user := GetUserFromContext(r.Context())
+	if user == nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+	for _, role := range h.authManager.config.TwoFactorRequiredRoles {
+		if role == user.Role {
+			h.jsonError(w, http.StatusForbidden, "two-factor authentication is required for this role")
+			return
+		}
+	}
+
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := h.authManager.VerifySecondFactor(user.Username, req.Code); err != nil {
+		h.jsonError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := h.authManager.DisableTOTP(user.Username); err != nil {
+		h.jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	debug.Info("auth", "User %s disabled two-factor authentication", user.Username)
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "two-factor authentication disabled",
+	})
+}
+
+// safeRedirectPath 只允许站内相对路径，防止开放重定向
+func safeRedirectPath(path string) string {
+	if path == "" || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") {
+		return "/"
+	}
+	return path
+}
+
+// HandleListAPIKeys 列出 API Key（管理员可查看全部，其他用户只能查看自己的）
+func (h *AuthHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaimsFromContext(r.Context())
+	if claims == nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+
+	owner := claims.Username
+	if claims.Role == RoleAdmin {
+		owner = r.URL.Query().Get("owner")
+	}
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"apikeys": h.authManager.ListAPIKeys(owner),
+	})
+}
+
+// HandleCreateAPIKey 创建 API Key，原始 Key 仅在创建时返回一次
+func (h *AuthHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaimsFromContext(r.Context())
+	if claims == nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+
+	var req struct {
+		APIKeyCreateRequest
+		Owner string `json:"owner"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Name == "" {
+		h.jsonError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	owner := claims.Username
+	if req.Owner != "" && req.Owner != owner {
+		if claims.Role != RoleAdmin {
+			h.jsonError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		owner = req.Owner
+	}
+
+	// 通过 API Key 认证时，新 Key 的角色不能超过当前 Key
+	if req.Role == "" && owner == claims.Username {
+		req.Role = string(claims.Role)
+	}
+	if req.Role != "" && owner == claims.Username && !HasPermission(claims.Role, Role(req.Role)) {
+		h.jsonError(w, http.StatusForbidden, "insufficient permissions")
+		return
+	}
+
+	key, rawKey, err := h.authManager.CreateAPIKey(owner, req.APIKeyCreateRequest, GetAPIKeyFromContext(r.Context()))
+	if err != nil {
+		switch err {
+		case ErrUserNotFound:
+			h.jsonError(w, http.StatusNotFound, "user not found")
+		case ErrForbidden:
+			h.jsonError(w, http.StatusForbidden, "api key role exceeds owner role")
+		default:
+			h.jsonError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	debug.Info("auth", "API key %s (%s) created for %s by %s", key.Prefix, key.Name, owner, claims.Username)
+
+	h.jsonResponse(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"apikey":  key,
+		"key":     rawKey,
+	})
+}
+
+// HandleUpdateAPIKey 更新 API Key（管理员或所属用户）
+func (h *AuthHandler) HandleUpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaimsFromContext(r.Context())
+	key, ok := h.authorizeAPIKeyAccess(w, r, claims)
+	if !ok {
+		return
+	}
+
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// 角色上限为当前请求者的角色；通过 API Key 认证时不能超过当前 Key 的角色和资源范围
+	if err := h.authManager.UpdateAPIKey(key.ID, updates, claims.Role, GetAPIKeyFromContext(r.Context())); err != nil {
+		switch err {
+		case ErrAPIKeyNotFound:
+			h.jsonError(w, http.StatusNotFound, "api key not found")
+		case ErrForbidden:
+			h.jsonError(w, http.StatusForbidden, "insufficient permissions")
+		default:
+			h.jsonError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	debug.Info("auth", "API key %s updated by %s", key.Prefix, claims.Username)
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "api key updated successfully",
+	})
+}
+
+// HandleDeleteAPIKey 删除 API Key（管理员或所属用户）
+func (h *AuthHandler) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaimsFromContext(r.Context())
+	key, ok := h.authorizeAPIKeyAccess(w, r, claims)
+	if !ok {
+		return
+	}
+
+	if err := h.authManager.DeleteAPIKey(key.ID); err != nil {
+		if err == ErrAPIKeyNotFound {
+			h.jsonError(w, http.StatusNotFound, "api key not found")
+		} else {
+			h.jsonError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	debug.Info("auth", "API key %s deleted by %s", key.Prefix, claims.Username)
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "api key deleted successfully",
+	})
+}
+
+// authorizeAPIKeyAccess 根据 id 查询参数查找 API Key，并检查当前用户是否可管理
+func (h *AuthHandler) authorizeAPIKeyAccess(w http.ResponseWriter, r *http.Request, claims *Claims) (*APIKey, bool) {
+	if claims == nil {
+		h.jsonError(w, http.StatusUnauthorized, "not authenticated")
+		return nil, false
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.jsonError(w, http.StatusBadRequest, "id is required")
+		return nil, false
+	}
+
+	key, err := h.authManager.GetAPIKey(id)
+	if err != nil {
+		h.jsonError(w, http.StatusNotFound, "api key not found")
+		return nil, false
+	}
+
+	if claims.Role != RoleAdmin && key.Owner != claims.Username {
+		h.jsonError(w, http.StatusForbidden, "admin access required")
+		return nil, false
+	}
+
+	return key, true
+}
+
 // jsonResponse 发送JSON响应
 func (h *AuthHandler) jsonResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
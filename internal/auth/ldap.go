@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig LDAP 身份源配置
+type LDAPConfig struct {
+	Name               string          `yaml:"Name" json:"name"`
+	DisplayName        string          `yaml:"DisplayName" json:"display_name"`
+	URL                string          `yaml:"URL" json:"url"` // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool            `yaml:"StartTLS" json:"start_tls"`
+	InsecureSkipVerify bool            `yaml:"InsecureSkipVerify" json:"insecure_skip_verify"`
+	BindDN             string          `yaml:"BindDN" json:"bind_dn"` // 查询用服务账户，为空时匿名查询
+	BindPassword       string          `yaml:"BindPassword" json:"-"`
+	BaseDN             string          `yaml:"BaseDN" json:"base_dn"`
+	UserFilter         string          `yaml:"UserFilter" json:"user_filter"` // 如 (uid=%s)，%s 为转义后的用户名
+	UsernameAttribute  string          `yaml:"UsernameAttribute" json:"username_attribute"`
+	DisplayNameAttr    string          `yaml:"DisplayNameAttribute" json:"display_name_attribute"`
+	EmailAttribute     string          `yaml:"EmailAttribute" json:"email_attribute"`
+	GroupAttribute     string          `yaml:"GroupAttribute" json:"group_attribute"` // 用户条目上的组属性，如 memberOf
+	GroupBaseDN        string          `yaml:"GroupBaseDN" json:"group_base_dn"`      // 不支持 memberOf 时按组搜索
+	GroupFilter        string          `yaml:"GroupFilter" json:"group_filter"`       // 如 (member=%s)，%s 为用户 DN
+	GroupRoles         map[string]Role `yaml:"GroupRoles" json:"group_roles"`
+	DefaultRole        Role            `yaml:"DefaultRole" json:"default_role"`
+	Timeout            time.Duration   `yaml:"Timeout" json:"timeout"`
+}
+
+// LDAPAuthenticator LDAP 绑定认证
+// 先使用服务账户查找用户 DN，再以用户 DN 和密码绑定验证，最后读取用户组
+type LDAPAuthenticator struct {
+	config  LDAPConfig
+	mapping RoleMapping
+}
+
+// NewLDAPAuthenticator 创建 LDAP 身份源
+func NewLDAPAuthenticator(config LDAPConfig) (*LDAPAuthenticator, error) {
+	if config.URL == "" {
+		return nil, errors.New("ldap url is required")
+	}
+	if config.BaseDN == "" {
+		return nil, errors.New("ldap base dn is required")
+	}
+	if config.Name == "" {
+		config.Name = ProviderTypeLDAP
+	}
+	if config.DisplayName == "" {
+		config.DisplayName = "LDAP"
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+	if config.UsernameAttribute == "" {
+		config.UsernameAttribute = "uid"
+	}
+	if config.DisplayNameAttr == "" {
+		config.DisplayNameAttr = "cn"
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.GroupAttribute == "" && config.GroupBaseDN == "" {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.GroupBaseDN != "" && config.GroupFilter == "" {
+		config.GroupFilter = "(member=%s)"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &LDAPAuthenticator{
+		config: config,
+		mapping: RoleMapping{
+			GroupRoles:  config.GroupRoles,
+			DefaultRole: config.DefaultRole,
+		},
+	}, nil
+}
+
+// Name 身份源名称
+func (l *LDAPAuthenticator) Name() string { return l.config.Name }
+
+// Type 身份源类型
+func (l *LDAPAuthenticator) Type() string { return ProviderTypeLDAP }
+
+// DisplayName 登录页展示名称
+func (l *LDAPAuthenticator) DisplayName() string { return l.config.DisplayName }
+
+// MapRole 用户组映射为角色
+func (l *LDAPAuthenticator) MapRole(groups []string) (Role, error) {
+	return l.mapping.MapRole(groups)
+}
+
+// dial 建立 LDAP 连接
+func (l *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: l.config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(l.config.URL,
+		ldap.DialWithTLSConfig(tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.config.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("连接 LDAP 服务器失败: %w", err)
+	}
+	conn.SetTimeout(l.config.Timeout)
+
+	if l.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS 失败: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// AuthenticatePassword 使用用户名密码进行 LDAP 绑定认证
+func (l *LDAPAuthenticator) AuthenticatePassword(ctx context.Context, username, password string) (*ExternalIdentity, error) {
+	// 空密码在多数 LDAP 服务器上会被当作匿名绑定而“成功”，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if l.config.BindDN != "" {
+		if err := conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP 服务账户绑定失败: %w", err)
+		}
+	}
+
+	attrs := []string{"dn", l.config.UsernameAttribute, l.config.DisplayNameAttr, l.config.EmailAttribute}
+	if l.config.GroupAttribute != "" {
+		attrs = append(attrs, l.config.GroupAttribute)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.config.UserFilter, ldap.EscapeFilter(username)),
+		attrs, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("LDAP 查询用户失败: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP 用户绑定失败: %w", err)
+	}
+
+	identity := &ExternalIdentity{
+		Provider:    l.config.Name,
+		Subject:     entry.DN,
+		Username:    entry.GetAttributeValue(l.config.UsernameAttribute),
+		DisplayName: entry.GetAttributeValue(l.config.DisplayNameAttr),
+		Email:       entry.GetAttributeValue(l.config.EmailAttribute),
+	}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+	if l.config.GroupAttribute != "" {
+		identity.Groups = append(identity.Groups, entry.GetAttributeValues(l.config.GroupAttribute)...)
+	}
+
+	if l.config.GroupBaseDN != "" {
+		// 以服务账户身份查询组，用户本身可能没有读取组的权限
+		if l.config.BindDN != "" {
+			if err := conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+				return nil, fmt.Errorf("LDAP 服务账户绑定失败: %w", err)
+			}
+		}
+		groups, err := conn.Search(ldap.NewSearchRequest(
+			l.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(l.config.GroupFilter, ldap.EscapeFilter(entry.DN)),
+			[]string{"dn"}, nil,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("LDAP 查询用户组失败: %w", err)
+		}
+		for _, g := range groups.Entries {
+			identity.Groups = append(identity.Groups, g.DN)
+		}
+	}
+
+	return identity, nil
+}
@@ -17,6 +17,8 @@ const (
 	UserContextKey ContextKey = "user"
 	// ClaimsContextKey 声明信息上下文键
 	ClaimsContextKey ContextKey = "claims"
+	// APIKeyContextKey API Key 上下文键（仅通过 API Key 认证时存在）
+	APIKeyContextKey ContextKey = "apikey"
 )
 
 // Middleware 认证中间件
@@ -45,6 +47,13 @@ func NewMiddleware(am *AuthManager) *Middleware {
 			"/",
 			"/login",
 			"/api/auth/login",
+			"/api/auth/providers",
+			"/api/auth/oidc/login",
+			"/api/auth/oidc/callback",
+			// 双因素认证流程在处理器内自行校验预认证令牌
+			"/api/auth/2fa/verify",
+			"/api/auth/2fa/enroll",
+			"/api/auth/2fa/enable",
 		},
 	}
 }
@@ -99,6 +108,12 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 			return
 		}
 
+		// 机器客户端通过 API Key 认证
+		if rawKey := ExtractAPIKeyFromRequest(r); rawKey != "" {
+			m.handleAPIKey(w, r, next, rawKey)
+			return
+		}
+
 		// 提取令牌
 		token := ExtractTokenFromRequest(r)
 		if token == "" {
@@ -134,6 +149,44 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 	})
 }
 
+// handleAPIKey 使用 API Key 认证请求
+func (m *Middleware) handleAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, rawKey string) {
+	key, user, err := m.authManager.ValidateAPIKey(rawKey)
+	if err != nil {
+		debug.Warn("auth", "API key validation failed: %v", err)
+		m.unauthorized(w, r, "invalid or expired api key")
+		return
+	}
+
+	if !user.Enabled {
+		m.forbidden(w, r, "user account is disabled")
+		return
+	}
+
+	if !key.AllowsPath(r.URL.Path) {
+		m.forbidden(w, r, "api key scope does not allow this resource")
+		return
+	}
+
+	// 有效角色取 Key 角色和所属用户当前角色中较低者
+	role := key.Role
+	if !HasPermission(user.Role, role) {
+		role = user.Role
+	}
+
+	claims := &Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     role,
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, user)
+	ctx = context.WithValue(ctx, ClaimsContextKey, claims)
+	ctx = context.WithValue(ctx, APIKeyContextKey, key)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 // RequireRole 角色要求中间件
 func (m *Middleware) RequireRole(requiredRole Role) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -204,3 +257,11 @@ func GetClaimsFromContext(ctx context.Context) *Claims {
 	}
 	return nil
 }
+
+// GetAPIKeyFromContext 从上下文获取 API Key 信息
+func GetAPIKeyFromContext(ctx context.Context) *APIKey {
+	if key, ok := ctx.Value(APIKeyContextKey).(*APIKey); ok {
+		return key
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig OpenID Connect 身份源配置
+type OIDCConfig struct {
+	Name          string          `yaml:"Name" json:"name"`
+	DisplayName   string          `yaml:"DisplayName" json:"display_name"`
+	Issuer        string          `yaml:"Issuer" json:"issuer"`
+	ClientID      string          `yaml:"ClientID" json:"client_id"`
+	ClientSecret  string          `yaml:"ClientSecret" json:"-"`
+	RedirectURL   string          `yaml:"RedirectURL" json:"redirect_url"` // 如 https://nvr.example.com/api/auth/oidc/callback
+	Scopes        []string        `yaml:"Scopes" json:"scopes"`
+	UsernameClaim string          `yaml:"UsernameClaim" json:"username_claim"`
+	GroupsClaim   string          `yaml:"GroupsClaim" json:"groups_claim"`
+	GroupRoles    map[string]Role `yaml:"GroupRoles" json:"group_roles"`
+	DefaultRole   Role            `yaml:"DefaultRole" json:"default_role"`
+}
+
+// oidcDiscovery OpenID Provider 元数据
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey JWKS 中的公钥
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCAuthenticator OpenID Connect 授权码模式认证
+type OIDCAuthenticator struct {
+	config     OIDCConfig
+	mapping    RoleMapping
+	httpClient *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+	keysAt    time.Time
+}
+
+// NewOIDCAuthenticator 创建 OIDC 身份源
+func NewOIDCAuthenticator(config OIDCConfig) (*OIDCAuthenticator, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc issuer, client id and redirect url are required")
+	}
+	if config.Name == "" {
+		config.Name = ProviderTypeOIDC
+	}
+	if config.DisplayName == "" {
+		config.DisplayName = "SSO"
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email", "groups"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+
+	return &OIDCAuthenticator{
+		config: config,
+		mapping: RoleMapping{
+			GroupRoles:  config.GroupRoles,
+			DefaultRole: config.DefaultRole,
+		},
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name 身份源名称
+func (o *OIDCAuthenticator) Name() string { return o.config.Name }
+
+// Type 身份源类型
+func (o *OIDCAuthenticator) Type() string { return ProviderTypeOIDC }
+
+// DisplayName 登录页展示名称
+func (o *OIDCAuthenticator) DisplayName() string { return o.config.DisplayName }
+
+// MapRole 用户组映射为角色
+func (o *OIDCAuthenticator) MapRole(groups []string) (Role, error) {
+	return o.mapping.MapRole(groups)
+}
+
+// getJSON 获取并解析 JSON
+func (o *OIDCAuthenticator) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回状态码 %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover 获取（并缓存）Provider 元数据
+func (o *OIDCAuthenticator) discover(ctx context.Context) (*oidcDiscovery, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := o.getJSON(ctx, o.config.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("获取 OIDC 元数据失败: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != o.config.Issuer {
+		return nil, fmt.Errorf("OIDC issuer 不匹配: %s", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC 元数据不完整")
+	}
+
+	o.discovery = &d
+	return o.discovery, nil
+}
+
+// AuthCodeURL 生成授权跳转地址
+func (o *OIDCAuthenticator) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", o.config.ClientID)
+	params.Set("redirect_uri", o.config.RedirectURL)
+	params.Set("scope", strings.Join(o.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange 使用授权码换取令牌并验证 ID Token
+func (o *OIDCAuthenticator) Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.config.RedirectURL)
+	form.Set("client_id", o.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC 令牌请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("解析 OIDC 令牌响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokenResp.Error != "" {
+		return nil, fmt.Errorf("OIDC 令牌请求被拒绝: %s %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("OIDC 令牌响应缺少 id_token")
+	}
+
+	claims, err := o.verifyIDToken(ctx, tokenResp.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.identityFromClaims(claims)
+}
+
+// verifyIDToken 验证 ID Token 签名、issuer、audience、有效期和 nonce
+func (o *OIDCAuthenticator) verifyIDToken(ctx context.Context, rawToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(o.config.Issuer),
+		jwt.WithAudience(o.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC ID Token 验证失败: %w", err)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("OIDC ID Token nonce 不匹配")
+	}
+	return claims, nil
+}
+
+// identityFromClaims 从 ID Token 声明中提取用户身份
+func (o *OIDCAuthenticator) identityFromClaims(claims jwt.MapClaims) (*ExternalIdentity, error) {
+	subject, _ := claims["sub"].(string)
+	username, _ := claims[o.config.UsernameClaim].(string)
+	if username == "" {
+		username, _ = claims["email"].(string)
+	}
+	if username == "" {
+		username = subject
+	}
+	if username == "" {
+		return nil, errors.New("OIDC ID Token 缺少用户名")
+	}
+
+	identity := &ExternalIdentity{
+		Provider: o.config.Name,
+		Subject:  subject,
+		Username: username,
+	}
+	identity.DisplayName, _ = claims["name"].(string)
+	identity.Email, _ = claims["email"].(string)
+
+	switch groups := claims[o.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if str, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, str)
+			}
+		}
+	case string:
+		identity.Groups = append(identity.Groups, groups)
+	}
+
+	return identity, nil
+}
+
+// publicKey 根据 kid 获取签名公钥，未命中时刷新 JWKS
+func (o *OIDCAuthenticator) publicKey(ctx context.Context, kid string) (interface{}, error) {
+	o.mutex.Lock()
+	key, ok := o.lookupKey(kid)
+	stale := time.Since(o.keysAt) > time.Minute
+	o.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale && o.keys != nil {
+		return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+	}
+
+	if err := o.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+}
+
+// lookupKey 查找公钥（调用者需持有锁）；kid 为空且只有一个密钥时直接使用
+func (o *OIDCAuthenticator) lookupKey(kid string) (interface{}, bool) {
+	if key, ok := o.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// refreshKeys 重新获取 JWKS
+func (o *OIDCAuthenticator) refreshKeys(ctx context.Context) error {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("获取 OIDC JWKS 失败: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	o.mutex.Lock()
+	o.keys = keys
+	o.keysAt = time.Now()
+	o.mutex.Unlock()
+	return nil
+}
+
+// publicKey 将 JWK 转换为公钥
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
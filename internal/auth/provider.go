@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// 外部身份源相关错误
+var (
+	ErrProviderNotFound   = errors.New("identity provider not found")
+	ErrNoRoleMapping      = errors.New("no role mapping for user groups")
+	ErrLocalLoginDisabled = errors.New("local password login is disabled")
+)
+
+// 用户来源
+const (
+	// UserSourceLocal 本地用户（users.json 中的 bcrypt 密码）
+	UserSourceLocal = ""
+)
+
+// 身份源类型
+const (
+	ProviderTypeLDAP = "ldap"
+	ProviderTypeOIDC = "oidc"
+)
+
+// ExternalIdentity 外部身份源认证通过后返回的用户身份
+type ExternalIdentity struct {
+	Provider    string   `json:"provider"`
+	Subject     string   `json:"subject"`
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+}
+
+// Authenticator 外部身份源
+type Authenticator interface {
+	// Name 身份源名称（唯一，同时作为 JIT 用户的来源标识）
+	Name() string
+	// Type 身份源类型: ldap, oidc
+	Type() string
+	// DisplayName 登录页展示名称
+	DisplayName() string
+	// MapRole 将身份源的用户组映射为本地角色
+	MapRole(groups []string) (Role, error)
+}
+
+// PasswordAuthenticator 使用用户名密码认证的身份源（如 LDAP）
+type PasswordAuthenticator interface {
+	Authenticator
+	AuthenticatePassword(ctx context.Context, username, password string) (*ExternalIdentity, error)
+}
+
+// RedirectAuthenticator 使用浏览器重定向认证的身份源（如 OIDC 授权码模式）
+type RedirectAuthenticator interface {
+	Authenticator
+	AuthCodeURL(ctx context.Context, state, nonce string) (string, error)
+	Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error)
+}
+
+// ProviderInfo 身份源信息（用于登录页展示）
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	DisplayName string `json:"display_name"`
+	Redirect    bool   `json:"redirect"`
+}
+
+// RoleMapping 用户组到角色的映射
+type RoleMapping struct {
+	// GroupRoles 用户组 -> 角色，组名可为完整 DN 或 CN，大小写不敏感
+	GroupRoles map[string]Role
+	// DefaultRole 未匹配任何用户组时的角色，为空表示拒绝登录
+	DefaultRole Role
+}
+
+// MapRole 取匹配用户组中权限最高的角色
+func (m RoleMapping) MapRole(groups []string) (Role, error) {
+	var best Role
+	for _, group := range groups {
+		for _, name := range groupAliases(group) {
+			for key, role := range m.GroupRoles {
+				if !strings.EqualFold(key, name) {
+					continue
+				}
+				if best == "" || HasPermission(role, best) {
+					best = role
+				}
+			}
+		}
+	}
+
+	if best == "" {
+		best = m.DefaultRole
+	}
+	if best == "" {
+		return "", ErrNoRoleMapping
+	}
+	if !isValidRole(best) {
+		return "", errors.New("invalid mapped role: " + string(best))
+	}
+	return best, nil
+}
+
+// groupAliases 返回组名本身及 DN 形式组名的 CN
+func groupAliases(group string) []string {
+	aliases := []string{group}
+	first := strings.SplitN(group, ",", 2)[0]
+	if kv := strings.SplitN(first, "=", 2); len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "cn") {
+		aliases = append(aliases, strings.TrimSpace(kv[1]))
+	}
+	return aliases
+}
+
+// RegisterProvider 注册外部身份源
+func (am *AuthManager) RegisterProvider(p Authenticator) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	for i, existing := range am.providers {
+		if existing.Name() == p.Name() {
+			am.providers[i] = p
+			return
+		}
+	}
+	am.providers = append(am.providers, p)
+}
+
+// GetProvider 根据名称获取身份源
+func (am *AuthManager) GetProvider(name string) (Authenticator, error) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	for _, p := range am.providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, ErrProviderNotFound
+}
+
+// ListProviders 列出已注册的身份源
+func (am *AuthManager) ListProviders() []ProviderInfo {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	infos := make([]ProviderInfo, 0, len(am.providers))
+	for _, p := range am.providers {
+		_, redirect := p.(RedirectAuthenticator)
+		infos = append(infos, ProviderInfo{
+			Name:        p.Name(),
+			Type:        p.Type(),
+			DisplayName: p.DisplayName(),
+			Redirect:    redirect,
+		})
+	}
+	return infos
+}
+
+// passwordProviders 返回支持用户名密码认证的身份源
+func (am *AuthManager) passwordProviders() []PasswordAuthenticator {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	var result []PasswordAuthenticator
+	for _, p := range am.providers {
+		if pa, ok := p.(PasswordAuthenticator); ok {
+			result = append(result, pa)
+		}
+	}
+	return result
+}
+
+// isBreakGlassUser 本地默认管理员作为应急账户，始终允许本地密码登录
+func (am *AuthManager) isBreakGlassUser(username string) bool {
+	return username == am.config.DefaultAdmin
+}
+
+// authenticateExternal 依次尝试各密码类身份源
+func (am *AuthManager) authenticateExternal(username, password string) (*User, error) {
+	providers := am.passwordProviders()
+	if len(providers) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	lastErr := ErrInvalidCredentials
+	for _, p := range providers {
+		identity, err := p.AuthenticatePassword(ctx, username, password)
+		if err != nil {
+			if err != ErrInvalidCredentials {
+				lastErr = err
+			}
+			continue
+		}
+		return am.ProvisionExternalUser(p, identity)
+	}
+	return nil, lastErr
+}
+
+// ProvisionExternalUser 按需创建或更新外部身份源用户（JIT 开通）
+// 每次登录都会根据用户组重新计算角色，外部用户没有本地密码
+func (am *AuthManager) ProvisionExternalUser(p Authenticator, identity *ExternalIdentity) (*User, error) {
+	if identity == nil || identity.Username == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	role, err := p.MapRole(identity.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	now := time.Now()
+	user, exists := am.users[identity.Username]
+	if exists {
+		// 不允许外部身份覆盖本地账户（包括应急管理员）
+		if user.Source != p.Name() {
+			return nil, ErrUserExists
+		}
+		if !user.Enabled {
+			return nil, ErrForbidden
+		}
+		user.Role = role
+		user.Email = identity.Email
+		user.DisplayName = identity.DisplayName
+		user.UpdatedAt = now
+	} else {
+		user = &User{
+			ID:          generateUserID(),
+			Username:    identity.Username,
+			Role:        role,
+			Source:      p.Name(),
+			Email:       identity.Email,
+			DisplayName: identity.DisplayName,
+			Enabled:     true,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		am.users[user.Username] = user
+	}
+
+	user.LastLogin = now
+	am.saveUsers()
+
+	return user, nil
+}
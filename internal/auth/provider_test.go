@@ -0,0 +1,515 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestAuthManager 创建使用临时目录的认证管理器
+func newTestAuthManager(t *testing.T, disableLocal bool) *AuthManager {
+	t.Helper()
+	return NewAuthManager(&AuthConfig{
+		Enable:            true,
+		JWTSecret:         "test-secret",
+		TokenExpiry:       time.Hour,
+		UsersFile:         filepath.Join(t.TempDir(), "users.json"),
+		DefaultAdmin:      "admin",
+		DefaultPassword:   "admin123",
+		DisableLocalLogin: disableLocal,
+	})
+}
+
+// ---- 进程内 LDAP 服务器 ----
+
+type testLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPServer 仅实现 Bind / Search / Unbind 的最小 LDAP 服务器
+type testLDAPServer struct {
+	listener net.Listener
+	entries  []testLDAPEntry
+}
+
+func startTestLDAPServer(t *testing.T, entries []testLDAPEntry) *testLDAPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &testLDAPServer{listener: ln, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *testLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		msgID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			for _, e := range s.entries {
+				if e.dn == dn && e.password == password && password != "" {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			s.write(conn, msgID, ldapResultPacket(ldap.ApplicationBindResponse, code))
+
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			for _, e := range s.entries {
+				if !matchTestFilter(filter, e) {
+					continue
+				}
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				for name, values := range e.attrs {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, v := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					}
+					attr.AppendChild(set)
+					attrs.AppendChild(attr)
+				}
+				entry.AppendChild(attrs)
+				s.write(conn, msgID, entry)
+			}
+			s.write(conn, msgID, ldapResultPacket(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) write(conn net.Conn, msgID int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+	envelope.AppendChild(op)
+	conn.Write(envelope.Bytes())
+}
+
+func ldapResultPacket(tag ber.Tag, code int64) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return p
+}
+
+// matchTestFilter 仅支持 (attr=value) 形式的等值过滤
+func matchTestFilter(filter string, e testLDAPEntry) bool {
+	kv := strings.SplitN(strings.Trim(filter, "()"), "=", 2)
+	if len(kv) != 2 {
+		return false
+	}
+	for _, v := range e.attrs[kv[0]] {
+		if v == kv[1] {
+			return true
+		}
+	}
+	return false
+}
+
+var testDirectory = []testLDAPEntry{
+	{dn: "cn=svc,dc=corp", password: "svcpass"},
+	{
+		dn:       "uid=alice,ou=people,dc=corp",
+		password: "alicepass",
+		attrs: map[string][]string{
+			"uid":      {"alice"},
+			"cn":       {"Alice"},
+			"mail":     {"alice@corp"},
+			"memberOf": {"cn=nvr-viewers,ou=groups,dc=corp", "cn=nvr-operators,ou=groups,dc=corp"},
+		},
+	},
+	{
+		dn:       "uid=bob,ou=people,dc=corp",
+		password: "bobpass",
+		attrs: map[string][]string{
+			"uid":      {"bob"},
+			"memberOf": {"cn=finance,ou=groups,dc=corp"},
+		},
+	},
+	{
+		dn:       "uid=admin,ou=people,dc=corp",
+		password: "ldapadmin",
+		attrs: map[string][]string{
+			"uid":      {"admin"},
+			"memberOf": {"cn=nvr-admins,ou=groups,dc=corp"},
+		},
+	},
+}
+
+func newTestLDAPAuthenticator(t *testing.T, srv *testLDAPServer) *LDAPAuthenticator {
+	t.Helper()
+	p, err := NewLDAPAuthenticator(LDAPConfig{
+		Name:         "corp-ldap",
+		URL:          srv.url(),
+		BindDN:       "cn=svc,dc=corp",
+		BindPassword: "svcpass",
+		BaseDN:       "dc=corp",
+		GroupRoles: map[string]Role{
+			"nvr-admins":                         RoleAdmin,
+			"cn=nvr-operators,ou=groups,dc=corp": RoleOperator,
+			"NVR-Viewers":                        RoleViewer,
+		},
+		Timeout: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewLDAPAuthenticator: %v", err)
+	}
+	return p
+}
+
+func TestRoleMapping_MapRole(t *testing.T) {
+	m := RoleMapping{GroupRoles: map[string]Role{"ops": RoleOperator, "cn=view,dc=corp": RoleViewer}}
+
+	tests := []struct {
+		name    string
+		groups  []string
+		want    Role
+		wantErr bool
+	}{
+		{"最高权限优先", []string{"cn=view,dc=corp", "ops"}, RoleOperator, false},
+		{"DN 组名按 CN 匹配", []string{"cn=ops,ou=groups,dc=corp"}, RoleOperator, false},
+		{"无匹配且无默认角色", []string{"finance"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.MapRole(tt.groups)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("MapRole(%v) = %q, %v; want %q, err=%v", tt.groups, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticator_JITProvisioning(t *testing.T) {
+	srv := startTestLDAPServer(t, testDirectory)
+	am := newTestAuthManager(t, true)
+	am.RegisterProvider(newTestLDAPAuthenticator(t, srv))
+
+	user, err := am.Authenticate("alice", "alicepass")
+	if err != nil {
+		t.Fatalf("LDAP 登录失败: %v", err)
+	}
+	if user.Role != RoleOperator || user.Source != "corp-ldap" || user.Email != "alice@corp" {
+		t.Fatalf("JIT 用户不正确: %+v", user)
+	}
+	if user.Password != "" {
+		t.Fatalf("外部用户不应保存本地密码")
+	}
+
+	if _, err := am.Authenticate("alice", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("错误密码应被拒绝, got %v", err)
+	}
+	if _, err := am.Authenticate("alice", ""); err != ErrInvalidCredentials {
+		t.Fatalf("空密码应被拒绝, got %v", err)
+	}
+	if _, err := am.Authenticate("bob", "bobpass"); err != ErrNoRoleMapping {
+		t.Fatalf("无角色映射的用户应被拒绝, got %v", err)
+	}
+}
+
+func TestAuthenticate_BreakGlassAdmin(t *testing.T) {
+	srv := startTestLDAPServer(t, testDirectory)
+	am := newTestAuthManager(t, true)
+	am.RegisterProvider(newTestLDAPAuthenticator(t, srv))
+
+	if _, err := am.CreateUser("local", "localpass", RoleViewer); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := am.Authenticate("local", "localpass"); err != ErrLocalLoginDisabled {
+		t.Fatalf("禁用本地登录后普通本地用户应被拒绝, got %v", err)
+	}
+
+	// 应急管理员使用本地密码，LDAP 中同名账户不能覆盖
+	user, err := am.Authenticate("admin", "admin123")
+	if err != nil || user.Source != UserSourceLocal {
+		t.Fatalf("应急管理员登录失败: %v", err)
+	}
+	if _, err := am.Authenticate("admin", "ldapadmin"); err == nil {
+		t.Fatalf("应急管理员不应通过 LDAP 密码登录")
+	}
+}
+
+// ---- 本地 OIDC 替身 IdP ----
+
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	codes  map[string]string // code -> nonce
+	claims jwt.MapClaims
+}
+
+func startTestIdP(t *testing.T, claims jwt.MapClaims) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	idp := &testIdP{key: key, codes: make(map[string]string), claims: claims}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := "code-" + r.URL.Query().Get("state")
+		idp.mutex.Lock()
+		idp.codes[code] = r.URL.Query().Get("nonce")
+		idp.mutex.Unlock()
+		http.Redirect(w, r, r.URL.Query().Get("redirect_uri")+"?code="+code+"&state="+r.URL.Query().Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "nvr" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		idp.mutex.Lock()
+		nonce, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   "nvr",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": nonce,
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func TestOIDCAuthenticator_LoginFlow(t *testing.T) {
+	idp := startTestIdP(t, jwt.MapClaims{
+		"sub":                "u-123",
+		"preferred_username": "carol",
+		"email":              "carol@corp",
+		"groups":             []string{"nvr-admins"},
+	})
+
+	provider, err := NewOIDCAuthenticator(OIDCConfig{
+		Name:         "corp-sso",
+		Issuer:       idp.server.URL,
+		ClientID:     "nvr",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://nvr.local/api/auth/oidc/callback",
+		GroupRoles:   map[string]Role{"nvr-admins": RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCAuthenticator: %v", err)
+	}
+
+	am := newTestAuthManager(t, true)
+	am.RegisterProvider(provider)
+	h := NewAuthHandler(am)
+
+	// 1. 发起登录，跳转到 IdP
+	rec := httptest.NewRecorder()
+	h.HandleOIDCLogin(rec, httptest.NewRequest("GET", "/api/auth/oidc/login?provider=corp-sso&redirect=/devices", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	stateCookie := rec.Result().Cookies()[0]
+
+	// 2. IdP 授权后回调
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	// 3. 回调缺少 state cookie 时拒绝
+	rec = httptest.NewRecorder()
+	h.HandleOIDCCallback(rec, httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callback.RawQuery, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("缺少 state cookie 应被拒绝, status=%d", rec.Code)
+	}
+
+	// 4. 正常回调，完成 JIT 开通并签发令牌
+	req := httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callback.RawQuery, nil)
+	req.AddCookie(stateCookie)
+	rec = httptest.NewRecorder()
+	h.HandleOIDCCallback(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/devices" {
+		t.Fatalf("callback status=%d location=%s body=%s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+
+	var token string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "auth_token" {
+			token = c.Value
+		}
+	}
+	claims, err := am.ValidateToken(token)
+	if err != nil || claims.Username != "carol" || claims.Role != RoleAdmin {
+		t.Fatalf("签发的令牌不正确: %+v, %v", claims, err)
+	}
+
+	user, _ := am.GetUser("carol")
+	if user.Source != "corp-sso" || user.Email != "carol@corp" {
+		t.Fatalf("JIT 用户不正确: %+v", user)
+	}
+}
+
+func TestOIDCAuthenticator_RejectsBadNonce(t *testing.T) {
+	idp := startTestIdP(t, jwt.MapClaims{"sub": "u-1", "preferred_username": "dave"})
+	provider, _ := NewOIDCAuthenticator(OIDCConfig{
+		Issuer:       idp.server.URL,
+		ClientID:     "nvr",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://nvr.local/api/auth/oidc/callback",
+		DefaultRole:  RoleViewer,
+	})
+
+	idp.codes["c1"] = "nonce-a"
+	if _, err := provider.Exchange(t.Context(), "c1", "nonce-b"); err == nil {
+		t.Fatalf("nonce 不匹配时应拒绝")
+	}
+}
+
+func TestOIDCCallbackRequiresTwoFactor(t *testing.T) {
+	idp := startTestIdP(t, jwt.MapClaims{
+		"sub":                "u-456",
+		"preferred_username": "erin",
+		"groups":             []string{"nvr-admins"},
+	})
+	provider, err := NewOIDCAuthenticator(OIDCConfig{
+		Name:         "corp-sso",
+		Issuer:       idp.server.URL,
+		ClientID:     "nvr",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://nvr.local/api/auth/oidc/callback",
+		GroupRoles:   map[string]Role{"nvr-admins": RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCAuthenticator: %v", err)
+	}
+
+	am := newTestAuthManager(t, true)
+	am.config.TwoFactorRequiredRoles = []Role{RoleAdmin}
+	am.RegisterProvider(provider)
+	h := NewAuthHandler(am)
+
+	rec := httptest.NewRecorder()
+	h.HandleOIDCLogin(rec, httptest.NewRequest("GET", "/api/auth/oidc/login?provider=corp-sso&redirect=/devices", nil))
+	stateCookie := rec.Result().Cookies()[0]
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	req := httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callback.RawQuery, nil)
+	req.AddCookie(stateCookie)
+	rec = httptest.NewRecorder()
+	h.HandleOIDCCallback(rec, req)
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "auth_token" && c.Value != "" {
+			t.Fatal("需要第二因素时不应签发正式令牌")
+		}
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || err != nil || location.Path != "/login" {
+		t.Fatalf("callback status=%d location=%s", rec.Code, rec.Header().Get("Location"))
+	}
+	query := location.Query()
+	if query.Get("two_factor_required") != "true" || query.Get("enrollment_required") != "true" || query.Get("redirect") != "/devices" {
+		t.Errorf("跳转参数 = %v", query)
+	}
+
+	fragment, _ := url.ParseQuery(location.Fragment)
+	preAuthToken := fragment.Get("pre_auth_token")
+	claims, err := am.ValidatePreAuthToken(preAuthToken)
+	if err != nil || claims.Username != "erin" {
+		t.Fatalf("预认证令牌无效: %+v, %v", claims, err)
+	}
+	if _, err := am.ValidateToken(preAuthToken); err == nil {
+		t.Error("预认证令牌不能作为正式令牌使用")
+	}
+}
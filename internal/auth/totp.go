@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 双因素认证相关错误
+var (
+	ErrInvalidTOTPCode     = errors.New("invalid two-factor code")
+	ErrTOTPNotEnrolled     = errors.New("two-factor authentication not enrolled")
+	ErrTOTPAlreadyEnrolled = errors.New("two-factor authentication already enabled")
+	ErrTooManyAttempts     = errors.New("too many failed two-factor attempts")
+)
+
+const (
+	// totpPeriod TOTP 时间步长
+	totpPeriod = 30
+	// totpDigits TOTP 位数
+	totpDigits = 6
+	// totpSkew 允许前后偏差的时间步数
+	totpSkew = 1
+	// recoveryCodeCount 恢复码数量
+	recoveryCodeCount = 10
+	// preAuthTokenExpiry 预认证令牌有效期
+	preAuthTokenExpiry = 5 * time.Minute
+	// preAuthMaxAttempts 单个预认证令牌允许的最大失败次数
+	preAuthMaxAttempts = 5
+	// tokenPurposePreAuth 预认证令牌用途标识
+	tokenPurposePreAuth = "2fa"
+	// totpIssuer 认证器 App 中显示的发行方
+	totpIssuer = "GB28181-ONVIF-Server"
+)
+
+// TOTPEnrollment TOTP 注册信息
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI，前端据此生成二维码
+}
+
+// generateTOTPSecret 生成 160 位 Base32 密钥
+func generateTOTPSecret() string {
+	secret := make([]byte, 20)
+	rand.Read(secret)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// totpCode 计算指定时间步的 TOTP 码（RFC 6238，HMAC-SHA1）
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// validateTOTP 校验 TOTP 码，返回匹配的时间步；lastStep 之前（含）的时间步视为重放
+func validateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for delta := int64(-totpSkew); delta <= totpSkew; delta++ {
+		step := current + delta
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpProvisioningURI 生成 otpauth:// 注册 URI
+func totpProvisioningURI(account, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// generateRecoveryCodes 生成恢复码，返回明文和哈希
+func generateRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		raw := hex.EncodeToString(b)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode 计算恢复码哈希（忽略大小写和分隔符）
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequiresTwoFactor 检查用户登录是否需要第二因素（已启用或角色强制要求）
+func (am *AuthManager) RequiresTwoFactor(user *User) bool {
+	if user.TOTPEnabled {
+		return true
+	}
+	for _, role := range am.config.TwoFactorRequiredRoles {
+		if role == user.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// GeneratePreAuthToken 生成仅可用于完成双因素认证的短期令牌
+func (am *AuthManager) GeneratePreAuthToken(user *User) (string, error) {
+	claims := &Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Purpose:  tokenPurposePreAuth,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        generateUserID(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(preAuthTokenExpiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "gb28181-onvif-server",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(am.jwtSecret)
+}
+
+// ValidatePreAuthToken 验证预认证令牌
+func (am *AuthManager) ValidatePreAuthToken(tokenString string) (*Claims, error) {
+	claims, err := am.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != tokenPurposePreAuth {
+		return nil, ErrInvalidToken
+	}
+
+	am.mutex.RLock()
+	attempts := am.preAuthFailures[claims.ID]
+	am.mutex.RUnlock()
+	if attempts >= preAuthMaxAttempts {
+		return nil, ErrTooManyAttempts
+	}
+	return claims, nil
+}
+
+// recordPreAuthFailure 记录预认证令牌的失败次数
+func (am *AuthManager) recordPreAuthFailure(tokenID string) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.preAuthFailures[tokenID]++
+	// 令牌过期后失败记录无意义，简单限制表大小
+	if len(am.preAuthFailures) > 10000 {
+		am.preAuthFailures = make(map[string]int)
+	}
+}
+
+// BeginTOTPEnrollment 开始 TOTP 注册，生成新密钥（确认前不生效）
+func (am *AuthManager) BeginTOTPEnrollment(username string) (*TOTPEnrollment, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+
+	user.TOTPSecret = generateTOTPSecret()
+	user.UpdatedAt = time.Now()
+	am.saveUsers()
+
+	return &TOTPEnrollment{
+		Secret:          user.TOTPSecret,
+		ProvisioningURI: totpProvisioningURI(user.Username, user.TOTPSecret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment 使用认证器生成的验证码确认注册，返回恢复码
+func (am *AuthManager) ConfirmTOTPEnrollment(username, code string) ([]string, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok := validateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = time.Now()
+	am.saveUsers()
+
+	return codes, nil
+}
+
+// VerifySecondFactor 校验 TOTP 码或恢复码（恢复码使用后作废）
+func (am *AuthManager) VerifySecondFactor(username, code string) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	if step, ok := validateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		user.TOTPLastStep = step
+		am.saveUsers()
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			user.UpdatedAt = time.Now()
+			am.saveUsers()
+			return nil
+		}
+	}
+
+	return ErrInvalidTOTPCode
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（旧恢复码全部作废）
+func (am *AuthManager) RegenerateRecoveryCodes(username string) ([]string, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = time.Now()
+	am.saveUsers()
+
+	return codes, nil
+}
+
+// DisableTOTP 关闭用户的双因素认证
+func (am *AuthManager) DisableTOTP(username string) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	user.UpdatedAt = time.Now()
+	am.saveUsers()
+
+	return nil
+}
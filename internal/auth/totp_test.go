@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"encoding/base32"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gb28181-onvif-server/internal/secrets"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量（8 位结果取末 6 位）
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tc := range cases {
+		step := tc.unix / totpPeriod
+		got, err := totpCode(secret, step)
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", tc.unix, err)
+		}
+		if want := tc.want[len(tc.want)-totpDigits:]; got != want {
+			t.Errorf("T=%d: code = %s, want %s", tc.unix, got, want)
+		}
+
+		if _, ok := validateTOTP(secret, got, time.Unix(tc.unix, 0), 0); !ok {
+			t.Errorf("T=%d: validateTOTP 拒绝了正确的验证码", tc.unix)
+		}
+		if _, ok := validateTOTP(secret, got, time.Unix(tc.unix, 0), step); ok {
+			t.Errorf("T=%d: 已使用的时间步应视为重放", tc.unix)
+		}
+	}
+}
+
+func TestTOTPSecretEncryptedAtRest(t *testing.T) {
+	keyring, err := secrets.NewKeyring(secrets.GenerateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetDefault(keyring)
+
+	am := newTestAuthManager(t, false)
+	enrollment, err := am.BeginTOTPEnrollment("admin")
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment: %v", err)
+	}
+
+	data, err := os.ReadFile(am.config.UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), enrollment.Secret) {
+		t.Fatal("users.json 中出现明文 TOTP 密钥")
+	}
+
+	reloaded := NewAuthManager(am.config)
+	user, err := reloaded.GetUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPSecret != enrollment.Secret {
+		t.Fatalf("重新加载后 TOTP 密钥不一致")
+	}
+}
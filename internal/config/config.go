@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/secrets"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -54,6 +57,34 @@ type GB28181Config struct {
 	Password          string `yaml:"Password"`
 	HeartbeatInterval int    `yaml:"HeartbeatInterval"`
 	RegisterExpires   int    `yaml:"RegisterExpires"`
+	// StreamMode 新注册设备的默认媒体传输模式: UDP, TCP-Passive, TCP-Active
+	StreamMode string `yaml:"StreamMode"`
+	// MediaFallbackTimeout UDP 取流无数据多少秒后自动改用 TCP 被动模式，0 使用默认 5 秒，负数禁用回退
+	MediaFallbackTimeout int `yaml:"MediaFallbackTimeout"`
+	// NATKeepaliveInterval 向 UDP 设备发送 OPTIONS 保持 NAT 映射的间隔（秒），0 使用默认 20 秒，负数禁用
+	NATKeepaliveInterval int `yaml:"NATKeepaliveInterval"`
+	// DeviceStatusInterval 向在线设备查询 DeviceStatus 的间隔（秒），0 使用默认 300 秒，负数禁用
+	DeviceStatusInterval int `yaml:"DeviceStatusInterval"`
+	// ClockSkewThreshold 设备时钟偏差告警阈值（秒），0 使用默认 10 秒，负数禁用告警
+	ClockSkewThreshold int `yaml:"ClockSkewThreshold"`
+	// TLSPort SIP over TLS 监听端口，0 不启用
+	TLSPort int `yaml:"TLSPort"`
+	// TLSCertFile/TLSKeyFile 服务端证书与私钥（PEM）
+	TLSCertFile string `yaml:"TLSCertFile"`
+	TLSKeyFile  string `yaml:"TLSKeyFile"`
+	// TLSClientCAFile 校验设备客户端证书的 CA（PEM）
+	TLSClientCAFile string `yaml:"TLSClientCAFile"`
+	// TLSClientAuth 客户端证书校验: none, optional, require；为空时配置了 TLSClientCAFile 即为 require
+	TLSClientAuth string `yaml:"TLSClientAuth"`
+	// AdmissionMode 设备准入模式: approval（未知设备进入待审批队列）, open（未命中黑名单即接入）；
+	// 未配置时为 open，保证升级前已接入的设备不被拒绝，配置模板默认 approval
+	AdmissionMode string `yaml:"AdmissionMode"`
+	// AdmissionFile 设备准入策略存储文件，为空使用 configs/gb28181_admission.json
+	AdmissionFile string `yaml:"AdmissionFile"`
+	// PositionRetention 移动位置轨迹在内存中的保留时长（小时），0 使用默认 24 小时；轨迹不落盘，重启后清空
+	PositionRetention int `yaml:"PositionRetention"`
+	// MaxTrackPoints 单个通道最多保留的轨迹点数，0 使用默认 10000
+	MaxTrackPoints int `yaml:"MaxTrackPoints"`
 }
 
 // ONVIFConfig ONVIF配置结构体
@@ -71,6 +102,9 @@ type APIConfig struct {
 	Port             int      `yaml:"Port"`
 	CorsAllowOrigins []string `yaml:"CorsAllowOrigins"`
 	StaticDir        string   `yaml:"StaticDir"` // 静态文件目录，默认为 www
+	// TrustedProxies 受信任的反向代理地址（IP 或 CIDR），仅来自这些地址的请求才采用
+	// X-Forwarded-For/X-Real-IP 作为客户端 IP，为空时始终使用连接的对端地址
+	TrustedProxies []string `yaml:"TrustedProxies"`
 }
 
 // DebugConfig 调试配置结构体
@@ -331,6 +365,97 @@ type AuthConfig struct {
 	UsersFile       string `yaml:"UsersFile"`       // 用户数据文件
 	DefaultAdmin    string `yaml:"DefaultAdmin"`    // 默认管理员账户
 	DefaultPassword string `yaml:"DefaultPassword"` // 默认管理员密码
+	// 禁用本地密码登录，仅保留默认管理员作为应急账户
+	DisableLocalLogin bool              `yaml:"DisableLocalLogin"`
+	LDAP              []*AuthLDAPConfig `yaml:"LDAP"` // LDAP 身份源
+	OIDC              []*AuthOIDCConfig `yaml:"OIDC"` // OpenID Connect 身份源
+	// 必须启用 TOTP 双因素认证的角色（admin/operator/viewer）
+	TwoFactorRequired []string `yaml:"TwoFactorRequired"`
+}
+
+// AuthLDAPConfig LDAP 身份源配置
+type AuthLDAPConfig struct {
+	Enable               bool              `yaml:"Enable"`
+	Name                 string            `yaml:"Name"`                 // 身份源名称（唯一）
+	DisplayName          string            `yaml:"DisplayName"`          // 登录页展示名称
+	URL                  string            `yaml:"URL"`                  // ldap://host:389 或 ldaps://host:636
+	StartTLS             bool              `yaml:"StartTLS"`             // 是否使用 StartTLS
+	InsecureSkipVerify   bool              `yaml:"InsecureSkipVerify"`   // 跳过证书校验（仅测试环境）
+	BindDN               string            `yaml:"BindDN"`               // 查询用服务账户
+	BindPassword         string            `yaml:"BindPassword"`         // 服务账户密码
+	BaseDN               string            `yaml:"BaseDN"`               // 用户搜索根
+	UserFilter           string            `yaml:"UserFilter"`           // 用户过滤器，如 (uid=%s)
+	UsernameAttribute    string            `yaml:"UsernameAttribute"`    // 用户名属性
+	DisplayNameAttribute string            `yaml:"DisplayNameAttribute"` // 显示名属性
+	EmailAttribute       string            `yaml:"EmailAttribute"`       // 邮箱属性
+	GroupAttribute       string            `yaml:"GroupAttribute"`       // 用户条目上的组属性，如 memberOf
+	GroupBaseDN          string            `yaml:"GroupBaseDN"`          // 组搜索根（不支持 memberOf 时使用）
+	GroupFilter          string            `yaml:"GroupFilter"`          // 组过滤器，如 (member=%s)
+	GroupRoles           map[string]string `yaml:"GroupRoles"`           // 组 -> 角色(admin/operator/viewer)
+	DefaultRole          string            `yaml:"DefaultRole"`          // 未匹配组时的角色，为空则拒绝登录
+	TimeoutSec           int               `yaml:"TimeoutSec"`           // 连接超时(秒)
+}
+
+// AuthOIDCConfig OpenID Connect 身份源配置
+type AuthOIDCConfig struct {
+	Enable        bool              `yaml:"Enable"`
+	Name          string            `yaml:"Name"`          // 身份源名称（唯一）
+	DisplayName   string            `yaml:"DisplayName"`   // 登录页展示名称
+	Issuer        string            `yaml:"Issuer"`        // Issuer 地址
+	ClientID      string            `yaml:"ClientID"`      // 客户端 ID
+	ClientSecret  string            `yaml:"ClientSecret"`  // 客户端密钥
+	RedirectURL   string            `yaml:"RedirectURL"`   // 回调地址: http(s)://<host>/api/auth/oidc/callback
+	Scopes        []string          `yaml:"Scopes"`        // 请求的 scope
+	UsernameClaim string            `yaml:"UsernameClaim"` // 用户名声明，默认 preferred_username
+	GroupsClaim   string            `yaml:"GroupsClaim"`   // 用户组声明，默认 groups
+	GroupRoles    map[string]string `yaml:"GroupRoles"`    // 组 -> 角色(admin/operator/viewer)
+	DefaultRole   string            `yaml:"DefaultRole"`   // 未匹配组时的角色，为空则拒绝登录
+}
+
+// AuditConfig 审计日志配置
+type AuditConfig struct {
+	Enable     bool   `yaml:"Enable"`     // 是否记录操作审计
+	Dir        string `yaml:"Dir"`        // 审计日志目录
+	MaxSizeMB  int    `yaml:"MaxSizeMB"`  // 单个文件最大大小(MB)，超过后轮转
+	MaxBackups int    `yaml:"MaxBackups"` // 保留的历史文件数量（0=不限制）
+}
+
+// PushConfig 直播推流配置
+type PushConfig struct {
+	MaxRetries     int `yaml:"MaxRetries"`     // 推流中断后连续重试上限（0=不限制）
+	InitialBackoff int `yaml:"InitialBackoff"` // 首次重试等待(秒)，之后指数增长
+	MaxBackoff     int `yaml:"MaxBackoff"`     // 最大重试等待(秒)
+	CheckInterval  int `yaml:"CheckInterval"`  // 推流状态检查间隔(秒)
+
+	Profiles []PushProfileConfig `yaml:"Profiles"` // 自定义转码模板（与内置模板同名时覆盖内置模板）
+	FontFile string              `yaml:"FontFile"` // 文字叠加默认字体文件（中文通道名需使用中文字体）
+	// VAAPIDevice VAAPI 硬件编码使用的 DRM 渲染节点，为空时使用 /dev/dri/renderD128
+	VAAPIDevice string `yaml:"VAAPIDevice"`
+}
+
+// PushProfileConfig 推流转码模板配置
+type PushProfileConfig struct {
+	Name            string `yaml:"Name"`
+	Description     string `yaml:"Description"`
+	VideoCodec      string `yaml:"VideoCodec"`      // copy/h264/h265
+	Width           int    `yaml:"Width"`           // 输出宽度，0 表示保持源分辨率
+	Height          int    `yaml:"Height"`          // 输出高度，0 表示按宽度等比缩放
+	VideoBitrate    int    `yaml:"VideoBitrate"`    // 视频码率(kbps)
+	FPS             int    `yaml:"FPS"`             // 输出帧率，0 表示保持源帧率
+	GOP             int    `yaml:"GOP"`             // 关键帧间隔(帧)，0 表示 2 秒
+	AudioCodec      string `yaml:"AudioCodec"`      // copy/aac/opus/none
+	AudioBitrate    int    `yaml:"AudioBitrate"`    // 音频码率(kbps)
+	AudioSampleRate int    `yaml:"AudioSampleRate"` // 音频重采样率(Hz)
+	AudioChannels   int    `yaml:"AudioChannels"`   // 音频声道数
+}
+
+// FFmpegPushCmdSlots 为推流转码预留的 FFmpeg 命令模板数量
+// ZLM 的 setServerConfig 只能修改已存在的配置项，转码推流的命令模板在运行时写入这些槽位
+const FFmpegPushCmdSlots = 16
+
+// FFmpegPushCmdKey 推流转码命令模板槽位在 ZLM 中的配置项名称
+func FFmpegPushCmdKey(slot int) string {
+	return fmt.Sprintf("ffmpeg.cmd_push_%d", slot)
 }
 
 type Config struct {
@@ -341,6 +466,8 @@ type Config struct {
 	ZLM     *ZLMConfig     `yaml:"ZLM"`
 	AI      *AIConfig      `yaml:"AI"`
 	Auth    *AuthConfig    `yaml:"Auth"`
+	Audit   *AuditConfig   `yaml:"Audit"`
+	Push    *PushConfig    `yaml:"Push"`
 }
 
 // Load 从文件加载配置
@@ -367,6 +494,7 @@ func Load(filePath string) (*Config, error) {
 			Password:          "",
 			HeartbeatInterval: 30,
 			RegisterExpires:   3600,
+			StreamMode:        "UDP",
 		}
 	}
 
@@ -420,10 +548,109 @@ func Load(filePath string) (*Config, error) {
 		}
 	}
 
+	if config.Audit == nil {
+		config.Audit = &AuditConfig{
+			Enable:     true,
+			Dir:        "logs/audit",
+			MaxSizeMB:  20,
+			MaxBackups: 30,
+		}
+	}
+
+	if config.Push == nil {
+		config.Push = &PushConfig{
+			MaxRetries:     10,
+			InitialBackoff: 2,
+			MaxBackoff:     300,
+			CheckInterval:  10,
+		}
+	}
+
+	if err := config.decryptSecrets(); err != nil {
+		return nil, err
+	}
+
 	log.Printf("配置加载成功: %s", filePath)
 	return &config, nil
 }
 
+// decryptSecrets 解密配置中的敏感字段（兼容旧版明文）
+// 这些字段均为凭据，旧版明文同样登记到日志屏蔽列表
+func (c *Config) decryptSecrets() error {
+	return c.transformSecrets(func(value string) (string, error) {
+		plaintext, err := secrets.Decrypt(value)
+		if err == nil {
+			debug.RegisterSecret(plaintext)
+		}
+		return plaintext, err
+	})
+}
+
+// transformSecrets 对配置中的敏感字段逐一应用 fn
+func (c *Config) transformSecrets(fn func(string) (string, error)) error {
+	var err error
+	apply := func(name string, value *string) {
+		if err != nil {
+			return
+		}
+		var out string
+		if out, err = fn(*value); err != nil {
+			err = fmt.Errorf("处理配置项 %s 失败: %w", name, err)
+			return
+		}
+		*value = out
+	}
+
+	if c.GB28181 != nil {
+		apply("GB28181.Password", &c.GB28181.Password)
+	}
+	if c.Auth != nil {
+		for _, l := range c.Auth.LDAP {
+			if l != nil {
+				apply("Auth.LDAP.BindPassword", &l.BindPassword)
+			}
+		}
+		for _, o := range c.Auth.OIDC {
+			if o != nil {
+				apply("Auth.OIDC.ClientSecret", &o.ClientSecret)
+			}
+		}
+	}
+	return err
+}
+
+// withEncryptedSecrets 返回敏感字段已加密的副本（不修改内存中的明文配置）
+func (c *Config) withEncryptedSecrets() (*Config, error) {
+	out := *c
+	if c.GB28181 != nil {
+		gb := *c.GB28181
+		out.GB28181 = &gb
+	}
+	if c.Auth != nil {
+		authCfg := *c.Auth
+		authCfg.LDAP = make([]*AuthLDAPConfig, len(c.Auth.LDAP))
+		for i, l := range c.Auth.LDAP {
+			if l != nil {
+				copied := *l
+				authCfg.LDAP[i] = &copied
+			}
+		}
+		authCfg.OIDC = make([]*AuthOIDCConfig, len(c.Auth.OIDC))
+		for i, o := range c.Auth.OIDC {
+			if o != nil {
+				copied := *o
+				authCfg.OIDC[i] = &copied
+			}
+		}
+		out.Auth = &authCfg
+	}
+
+	if err := out.transformSecrets(secrets.Encrypt); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
 // DefaultZLMConfig 返回默认的 ZLM 配置
 func DefaultZLMConfig() *ZLMConfig {
 	return &ZLMConfig{
@@ -667,6 +894,13 @@ func (z *ZLMConfig) GenerateConfigINI() string {
 	sb.WriteString(fmt.Sprintf("bin=%s\n", z.FFmpeg.Bin))
 	sb.WriteString("cmd=%s -re -i %s -c:a aac -strict -2 -ar 44100 -ab 48k -c:v libx264 -f flv %s\n")
 	sb.WriteString("snap=%s -i %s -y -f mjpeg -frames:v 1 -an %s\n")
+	// 推流协议专用模板（push 模块通过 ffmpeg_cmd_key 选择）
+	sb.WriteString("cmd_srt=%s -re -i %s -c:v copy -c:a aac -ar 44100 -ab 64k -f mpegts %s\n")
+	sb.WriteString("cmd_whip=%s -re -i %s -c:v libx264 -profile:v baseline -bf 0 -tune zerolatency -c:a libopus -ar 48000 -ac 2 -f whip %s\n")
+	// 转码推流模板槽位，由 push 模块按推流目标的转码模板和叠加层动态写入
+	for i := 0; i < FFmpegPushCmdSlots; i++ {
+		sb.WriteString(fmt.Sprintf("cmd_push_%d=%%s -re -i %%s -c:a aac -strict -2 -ar 44100 -ab 48k -c:v libx264 -f flv %%s\n", i))
+	}
 	sb.WriteString(fmt.Sprintf("log=%s\n", z.FFmpeg.Log))
 	sb.WriteString(fmt.Sprintf("restart_sec=%d\n\n", z.FFmpeg.RestartSec))
 
@@ -931,8 +1165,14 @@ func (z *ZLMConfig) GetSecret() string {
 
 // Save 将配置保存到文件
 func (c *Config) Save(filePath string) error {
+	// 敏感字段加密后落盘
+	encrypted, err := c.withEncryptedSecrets()
+	if err != nil {
+		return err
+	}
+
 	// 将配置转换为YAML格式
-	data, err := yaml.Marshal(c)
+	data, err := yaml.Marshal(encrypted)
 	if err != nil {
 		return err
 	}
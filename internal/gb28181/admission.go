@@ -0,0 +1,661 @@
+package gb28181
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/secrets"
+)
+
+// 设备准入模式
+const (
+	AdmissionModeApproval = "approval" // 未知设备进入待审批队列（默认）
+	AdmissionModeOpen     = "open"     // 未命中黑名单的设备直接接入
+)
+
+// 准入规则动作与判定结果
+const (
+	AdmissionAllow   = "allow"
+	AdmissionDeny    = "deny"
+	AdmissionPending = "pending" // 仅作为判定结果，表示需人工审批
+)
+
+// DefaultAdmissionFile 准入策略默认存储文件
+const DefaultAdmissionFile = "configs/gb28181_admission.json"
+
+// ErrAdmissionStore 准入策略持久化失败，此时内存中的策略保持修改前的状态
+var ErrAdmissionStore = errors.New("保存准入策略失败")
+
+// 待审批队列限制：队列在认证之前按 From 中的设备ID登记，需防止伪造设备ID挤掉真实设备
+const (
+	maxPendingDevices = 1000             // 队列上限，满时淘汰最久未出现的设备
+	maxPendingPerIP   = 64               // 单个来源 IP 最多登记的设备数
+	minPendingAge     = 10 * time.Minute // 最近出现过的设备不被淘汰
+)
+
+// AdmissionRule 准入规则，按设备ID（精确或前缀）和/或来源 IP 网段匹配，配置多项时需同时满足
+type AdmissionRule struct {
+	ID             string `json:"id"`
+	Action         string `json:"action"`             // allow / deny
+	DeviceID       string `json:"deviceId,omitempty"` // 精确匹配设备ID，审批生成的规则使用
+	DeviceIDPrefix string `json:"deviceIdPrefix,omitempty"`
+	CIDR           string `json:"cidr,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+	CreatedAt      int64  `json:"createdAt"`
+
+	network *net.IPNet
+}
+
+// PendingDevice 待审批设备
+type PendingDevice struct {
+	DeviceID  string `json:"deviceId"`
+	IP        string `json:"ip"`
+	Port      int    `json:"port"`
+	Transport string `json:"transport"`
+	Method    string `json:"method"` // 触发接入的请求: REGISTER / MESSAGE
+	FirstSeen int64  `json:"firstSeen"`
+	LastSeen  int64  `json:"lastSeen"`
+	Attempts  int    `json:"attempts"`
+}
+
+// AdmissionPolicy 准入策略快照（不含密码）
+type AdmissionPolicy struct {
+	Mode        string           `json:"mode"`
+	Rules       []*AdmissionRule `json:"rules"`
+	Credentials []string         `json:"credentials"` // 配置了独立密码的设备ID
+}
+
+// admissionFile 准入策略文件格式，设备密码加密落盘
+// 规则按原始 JSON 读写，无法解析的规则可原样写回
+type admissionFile struct {
+	Rules       []json.RawMessage `json:"rules"`
+	Credentials map[string]string `json:"credentials"`
+}
+
+// AdmissionManager 设备准入管理：黑白名单、设备独立密码与待审批队列
+type AdmissionManager struct {
+	file        string
+	mu          sync.RWMutex
+	rules       []*AdmissionRule
+	credentials map[string]string // 设备ID -> 明文密码（仅内存）
+	// 加载时无法处理的条目原样保留并写回，避免主密钥错误或规则格式变化导致策略丢失
+	lockedCredentials map[string]string        // 设备ID -> 无法解密的密文，该设备拒绝接入
+	invalidRules      []json.RawMessage        // 无法解析的规则，不参与判定
+	loadErr           error                    // 策略文件读取或解析失败时拒绝写入，以免覆盖原文件
+	pending           map[string]*list.Element // 设备ID -> pendingLRU 中的 *PendingDevice
+	pendingLRU        *list.List               // 按 LastSeen 从旧到新排列
+	pendingIPs        map[string]int           // 来源 IP -> 待审批设备数
+}
+
+// NewAdmissionManager 创建准入管理器并加载策略文件，file 为空时不持久化
+func NewAdmissionManager(file string) *AdmissionManager {
+	m := &AdmissionManager{
+		file:        file,
+		credentials: make(map[string]string),
+
+		lockedCredentials: make(map[string]string),
+		pending:           make(map[string]*list.Element),
+		pendingLRU:        list.New(),
+		pendingIPs:        make(map[string]int),
+	}
+	m.load()
+	return m
+}
+
+// load 加载策略文件，旧版明文密码立即加密回写
+func (m *AdmissionManager) load() {
+	if m.file == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.loadErr = err
+			debug.Error("gb28181", "加载准入策略失败，修复前不会写入策略文件: %v", err)
+		}
+		return
+	}
+
+	var stored admissionFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		m.loadErr = err
+		debug.Error("gb28181", "解析准入策略失败，修复前不会写入策略文件: %v", err)
+		return
+	}
+
+	for _, raw := range stored.Rules {
+		rule := &AdmissionRule{}
+		err := json.Unmarshal(raw, rule)
+		if err == nil {
+			err = rule.compile()
+		}
+		if err != nil {
+			debug.Warn("gb28181", "忽略无效准入规则 %s: %v", rule.ID, err)
+			m.invalidRules = append(m.invalidRules, raw)
+			continue
+		}
+		m.rules = append(m.rules, rule)
+	}
+
+	plaintext := 0
+	for deviceID, password := range stored.Credentials {
+		if password != "" && !secrets.IsEncrypted(password) {
+			plaintext++
+		}
+		decrypted, err := secrets.Decrypt(password)
+		if err != nil {
+			debug.Error("gb28181", "解密设备 %s 的密码失败，该设备将被拒绝接入: %v", deviceID, err)
+			m.lockedCredentials[deviceID] = password
+			continue
+		}
+		m.credentials[deviceID] = decrypted
+	}
+
+	debug.Info("gb28181", "已加载准入策略: %d 条规则, %d 个设备密码", len(m.rules), len(m.credentials))
+	if plaintext > 0 {
+		m.mu.Lock()
+		m.save(m.rules, m.credentials)
+		m.mu.Unlock()
+	}
+}
+
+// save 保存给定的规则与密码，调用方在成功后再替换内存中的策略（调用方持有锁）
+func (m *AdmissionManager) save(rules []*AdmissionRule, credentials map[string]string) error {
+	if m.loadErr != nil {
+		return fmt.Errorf("%w: 策略文件 %s 加载失败，请修复后重启: %v", ErrAdmissionStore, m.file, m.loadErr)
+	}
+	if err := m.write(rules, credentials); err != nil {
+		debug.Warn("gb28181", "保存准入策略失败: %v", err)
+		return fmt.Errorf("%w: %v", ErrAdmissionStore, err)
+	}
+	return nil
+}
+
+// write 将策略写入文件，设备密码加密后落盘（调用方持有锁）
+func (m *AdmissionManager) write(rules []*AdmissionRule, credentials map[string]string) error {
+	if m.file == "" {
+		return nil
+	}
+
+	stored := admissionFile{
+		Rules:       make([]json.RawMessage, 0, len(rules)+len(m.invalidRules)),
+		Credentials: make(map[string]string, len(credentials)+len(m.lockedCredentials)),
+	}
+	for _, rule := range rules {
+		raw, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		stored.Rules = append(stored.Rules, raw)
+	}
+	stored.Rules = append(stored.Rules, m.invalidRules...)
+	for deviceID, encrypted := range m.lockedCredentials {
+		stored.Credentials[deviceID] = encrypted
+	}
+	for deviceID, password := range credentials {
+		encrypted, err := secrets.Encrypt(password)
+		if err != nil {
+			return fmt.Errorf("加密设备 %s 的密码失败: %w", deviceID, err)
+		}
+		stored.Credentials[deviceID] = encrypted
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.file, data, 0600)
+}
+
+// Rewrite 使用当前主密钥重写策略文件（密钥轮换时使用）
+func (m *AdmissionManager) Rewrite() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save(m.rules, m.credentials)
+}
+
+// compile 校验规则并解析网段
+func (r *AdmissionRule) compile() error {
+	if r.Action != AdmissionAllow && r.Action != AdmissionDeny {
+		return fmt.Errorf("无效的规则动作: %s", r.Action)
+	}
+	if r.DeviceID == "" && r.DeviceIDPrefix == "" && r.CIDR == "" {
+		return fmt.Errorf("规则需指定设备ID、设备ID前缀或 IP 网段")
+	}
+	r.network = nil
+	if r.CIDR != "" {
+		cidr := r.CIDR
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("无效的 IP 网段: %s", r.CIDR)
+		}
+		r.network = network
+	}
+	return nil
+}
+
+// matches 规则是否匹配设备
+func (r *AdmissionRule) matches(deviceID string, ip net.IP) bool {
+	if r.DeviceID != "" && deviceID != r.DeviceID {
+		return false
+	}
+	if r.DeviceIDPrefix != "" && !strings.HasPrefix(deviceID, r.DeviceIDPrefix) {
+		return false
+	}
+	if r.network != nil && (ip == nil || !r.network.Contains(ip)) {
+		return false
+	}
+	return true
+}
+
+// Evaluate 判定设备准入：命中黑名单拒绝；命中白名单或配置了独立密码允许；
+// 其余设备在 open 模式下允许，approval 模式下进入待审批
+func (m *AdmissionManager) Evaluate(deviceID, ip, mode string) string {
+	addr := net.ParseIP(ip)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rule := range m.rules {
+		if rule.Action == AdmissionDeny && rule.matches(deviceID, addr) {
+			return AdmissionDeny
+		}
+	}
+	if _, ok := m.lockedCredentials[deviceID]; ok {
+		// 密码无法解密时不能回退到全局密码，需重新设置该设备密码
+		return AdmissionDeny
+	}
+	if _, ok := m.credentials[deviceID]; ok {
+		return AdmissionAllow
+	}
+	for _, rule := range m.rules {
+		if rule.Action == AdmissionAllow && rule.matches(deviceID, addr) {
+			return AdmissionAllow
+		}
+	}
+	if mode == AdmissionModeOpen {
+		return AdmissionAllow
+	}
+	return AdmissionPending
+}
+
+// Password 设备独立密码
+func (m *AdmissionManager) Password(deviceID string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	password, ok := m.credentials[deviceID]
+	return password, ok
+}
+
+// Policy 返回策略快照
+func (m *AdmissionManager) Policy(mode string) *AdmissionPolicy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	policy := &AdmissionPolicy{
+		Mode:        mode,
+		Rules:       append([]*AdmissionRule{}, m.rules...),
+		Credentials: make([]string, 0, len(m.credentials)+len(m.lockedCredentials)),
+	}
+	for deviceID := range m.credentials {
+		policy.Credentials = append(policy.Credentials, deviceID)
+	}
+	for deviceID := range m.lockedCredentials {
+		policy.Credentials = append(policy.Credentials, deviceID)
+	}
+	sort.Strings(policy.Credentials)
+	return policy
+}
+
+// AddRule 添加准入规则
+func (m *AdmissionManager) AddRule(rule AdmissionRule) (*AdmissionRule, error) {
+	if err := rule.compile(); err != nil {
+		return nil, err
+	}
+	rule.ID = newAdmissionRuleID()
+	rule.CreatedAt = time.Now().Unix()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rules := append(append([]*AdmissionRule{}, m.rules...), &rule)
+	if err := m.save(rules, m.credentials); err != nil {
+		return nil, err
+	}
+	m.rules = rules
+	return &rule, nil
+}
+
+// DeleteRule 删除准入规则
+func (m *AdmissionManager) DeleteRule(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rule := range m.rules {
+		if rule.ID == id {
+			rules := append(append([]*AdmissionRule{}, m.rules[:i]...), m.rules[i+1:]...)
+			if err := m.save(rules, m.credentials); err != nil {
+				return err
+			}
+			m.rules = rules
+			return nil
+		}
+	}
+	return fmt.Errorf("准入规则 %s 不存在", id)
+}
+
+// SetCredential 设置设备独立密码
+func (m *AdmissionManager) SetCredential(deviceID, password string) error {
+	if deviceID == "" || password == "" {
+		return fmt.Errorf("设备ID和密码不能为空")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	credentials := copyCredentials(m.credentials)
+	credentials[deviceID] = password
+	if err := m.save(m.rules, credentials); err != nil {
+		return err
+	}
+	m.credentials = credentials
+	delete(m.lockedCredentials, deviceID)
+	return nil
+}
+
+// DeleteCredential 删除设备独立密码，之后该设备使用全局密码认证
+func (m *AdmissionManager) DeleteCredential(deviceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if encrypted, ok := m.lockedCredentials[deviceID]; ok {
+		delete(m.lockedCredentials, deviceID)
+		if err := m.save(m.rules, m.credentials); err != nil {
+			m.lockedCredentials[deviceID] = encrypted
+			return err
+		}
+		return nil
+	}
+	if _, ok := m.credentials[deviceID]; !ok {
+		return fmt.Errorf("设备 %s 未配置独立密码", deviceID)
+	}
+	credentials := copyCredentials(m.credentials)
+	delete(credentials, deviceID)
+	if err := m.save(m.rules, credentials); err != nil {
+		return err
+	}
+	m.credentials = credentials
+	return nil
+}
+
+// copyCredentials 复制设备密码表，修改在保存成功后才替换原表
+func copyCredentials(credentials map[string]string) map[string]string {
+	copied := make(map[string]string, len(credentials)+1)
+	for deviceID, password := range credentials {
+		copied[deviceID] = password
+	}
+	return copied
+}
+
+// addPending 记录待审批设备，返回是否为首次出现
+// 来源 IP 登记数已达上限，或队列已满且没有可淘汰的设备时不登记
+func (m *AdmissionManager) addPending(deviceID, ip string, port int, transport, method string) bool {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.pending[deviceID]; ok {
+		pending := elem.Value.(*PendingDevice)
+		if pending.IP != ip {
+			if m.pendingIPs[ip] >= maxPendingPerIP {
+				return false
+			}
+			m.releasePendingIP(pending.IP)
+			m.pendingIPs[ip]++
+		}
+		pending.IP, pending.Port, pending.Transport, pending.Method = ip, port, transport, method
+		pending.LastSeen = now.Unix()
+		pending.Attempts++
+		m.pendingLRU.MoveToBack(elem)
+		return false
+	}
+
+	if m.pendingIPs[ip] >= maxPendingPerIP {
+		debug.Debug("gb28181", "来源 %s 的待审批设备已达上限 %d，忽略设备 %s", ip, maxPendingPerIP, deviceID)
+		return false
+	}
+	if len(m.pending) >= maxPendingDevices {
+		oldest := m.pendingLRU.Front().Value.(*PendingDevice)
+		if now.Sub(time.Unix(oldest.LastSeen, 0)) < minPendingAge {
+			debug.Debug("gb28181", "待审批队列已满，忽略设备 %s (来自 %s)", deviceID, ip)
+			return false
+		}
+		m.removePending(oldest.DeviceID)
+	}
+
+	m.pending[deviceID] = m.pendingLRU.PushBack(&PendingDevice{
+		DeviceID:  deviceID,
+		IP:        ip,
+		Port:      port,
+		Transport: transport,
+		Method:    method,
+		FirstSeen: now.Unix(),
+		LastSeen:  now.Unix(),
+		Attempts:  1,
+	})
+	m.pendingIPs[ip]++
+	return true
+}
+
+// removePending 移出待审批队列（调用方持有锁）
+func (m *AdmissionManager) removePending(deviceID string) {
+	elem, ok := m.pending[deviceID]
+	if !ok {
+		return
+	}
+	m.pendingLRU.Remove(elem)
+	delete(m.pending, deviceID)
+	m.releasePendingIP(elem.Value.(*PendingDevice).IP)
+}
+
+// releasePendingIP 来源 IP 的待审批设备数减一（调用方持有锁）
+func (m *AdmissionManager) releasePendingIP(ip string) {
+	if m.pendingIPs[ip] <= 1 {
+		delete(m.pendingIPs, ip)
+	} else {
+		m.pendingIPs[ip]--
+	}
+}
+
+// Pending 返回待审批设备，按首次出现时间排序
+func (m *AdmissionManager) Pending() []*PendingDevice {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	devices := make([]*PendingDevice, 0, len(m.pending))
+	for elem := m.pendingLRU.Front(); elem != nil; elem = elem.Next() {
+		copied := *elem.Value.(*PendingDevice)
+		devices = append(devices, &copied)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].FirstSeen != devices[j].FirstSeen {
+			return devices[i].FirstSeen < devices[j].FirstSeen
+		}
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+	return devices
+}
+
+// Approve 批准待审批设备：加入白名单，可同时设置独立密码
+func (m *AdmissionManager) Approve(deviceID, password string) (*AdmissionRule, error) {
+	return m.resolvePending(deviceID, AdmissionAllow, password)
+}
+
+// Reject 拒绝待审批设备：加入黑名单
+func (m *AdmissionManager) Reject(deviceID string) (*AdmissionRule, error) {
+	return m.resolvePending(deviceID, AdmissionDeny, "")
+}
+
+// resolvePending 将待审批设备转为按设备ID匹配的规则
+func (m *AdmissionManager) resolvePending(deviceID, action, password string) (*AdmissionRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pending[deviceID]; !ok {
+		return nil, fmt.Errorf("设备 %s 不在待审批队列中", deviceID)
+	}
+
+	rule := &AdmissionRule{
+		ID:        newAdmissionRuleID(),
+		Action:    action,
+		DeviceID:  deviceID,
+		CreatedAt: time.Now().Unix(),
+	}
+	credentials := m.credentials
+	if action == AdmissionAllow {
+		rule.Comment = "审批通过"
+		if password != "" {
+			credentials = copyCredentials(m.credentials)
+			credentials[deviceID] = password
+		}
+	} else {
+		rule.Comment = "审批拒绝"
+	}
+	rules := append(append([]*AdmissionRule{}, m.rules...), rule)
+	if err := m.save(rules, credentials); err != nil {
+		return nil, err
+	}
+	m.rules, m.credentials = rules, credentials
+	if password != "" {
+		delete(m.lockedCredentials, deviceID)
+	}
+	m.removePending(deviceID)
+	return rule, nil
+}
+
+// newAdmissionRuleID 生成规则ID
+func newAdmissionRuleID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AdmissionFilePath 准入策略存储文件，未配置时使用 DefaultAdmissionFile
+func AdmissionFilePath(cfg *config.GB28181Config) string {
+	if cfg != nil && cfg.AdmissionFile != "" {
+		return cfg.AdmissionFile
+	}
+	return DefaultAdmissionFile
+}
+
+// admissionMode 准入模式。配置中缺少 AdmissionMode 时按 open 处理，
+// 避免升级后已在现网接入的设备因不在白名单中被拒绝；新配置模板默认为 approval
+func (s *Server) admissionMode() string {
+	mode := strings.TrimSpace(s.config.AdmissionMode)
+	if mode == "" || strings.EqualFold(mode, AdmissionModeOpen) {
+		return AdmissionModeOpen
+	}
+	return AdmissionModeApproval
+}
+
+// Admission 返回设备准入管理器
+func (s *Server) Admission() *AdmissionManager {
+	return s.admission
+}
+
+// AdmissionPolicy 返回当前准入策略
+func (s *Server) AdmissionPolicy() *AdmissionPolicy {
+	return s.admission.Policy(s.admissionMode())
+}
+
+// admitDevice 检查设备能否接入，未通过时记录日志，待审批设备进入队列
+func (s *Server) admitDevice(deviceID, ip string, port int, transport, method string) bool {
+	switch s.admission.Evaluate(deviceID, ip, s.admissionMode()) {
+	case AdmissionAllow:
+		return true
+	case AdmissionDeny:
+		debug.Warn("gb28181", "拒绝黑名单设备 %s 的%s (来自 %s:%d)", deviceID, method, ip, port)
+		return false
+	}
+
+	if s.admission.addPending(deviceID, ip, port, transport, method) {
+		log.Printf("[GB28181] 未知设备等待审批: %s (%s:%d) [%s]", deviceID, ip, port, transport)
+	}
+	debug.Info("gb28181", "设备 %s 待审批，拒绝%s", deviceID, method)
+	return false
+}
+
+// admitUnregisteredMessage 未注册设备发送 MESSAGE 时是否允许自动注册
+// MESSAGE 不携带认证信息，设备需要密码时必须先 REGISTER
+func (s *Server) admitUnregisteredMessage(deviceID, ip string, port int, transport string) bool {
+	if !s.admitDevice(deviceID, ip, port, transport, "MESSAGE") {
+		return false
+	}
+	if s.devicePassword(deviceID) != "" {
+		debug.Info("gb28181", "设备 %s 未注册且需要认证，拒绝MESSAGE", deviceID)
+		return false
+	}
+	return true
+}
+
+// devicePassword 设备认证使用的密码：优先设备独立密码，否则全局密码
+func (s *Server) devicePassword(deviceID string) string {
+	if password, ok := s.admission.Password(deviceID); ok {
+		return password
+	}
+	return s.config.Password
+}
+
+// AddAdmissionRule 添加准入规则，黑名单规则会立即移除已注册的匹配设备
+func (s *Server) AddAdmissionRule(rule AdmissionRule) (*AdmissionRule, error) {
+	added, err := s.admission.AddRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if added.Action == AdmissionDeny {
+		s.evictDeniedDevices()
+	}
+	return added, nil
+}
+
+// RejectDevice 拒绝待审批设备
+func (s *Server) RejectDevice(deviceID string) (*AdmissionRule, error) {
+	rule, err := s.admission.Reject(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	s.evictDeniedDevices()
+	return rule, nil
+}
+
+// evictDeniedDevices 移除命中黑名单的已注册设备
+func (s *Server) evictDeniedDevices() {
+	for _, device := range s.GetDevices() {
+		ip := device.SourceIP
+		if ip == "" {
+			ip = device.SipIP
+		}
+		if s.admission.Evaluate(device.DeviceID, ip, AdmissionModeOpen) == AdmissionDeny {
+			log.Printf("[GB28181] 移除黑名单设备: %s", device.DeviceID)
+			s.RemoveDevice(device.DeviceID)
+		}
+	}
+}
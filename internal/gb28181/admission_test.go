@@ -0,0 +1,383 @@
+package gb28181
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+	"gb28181-onvif-server/internal/gb28181/simulator"
+	"gb28181-onvif-server/internal/secrets"
+)
+
+// newTestAdmission 创建写入临时目录的准入管理器
+func newTestAdmission(t *testing.T) *AdmissionManager {
+	t.Helper()
+	keyring, err := secrets.NewKeyring(secrets.GenerateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetDefault(keyring)
+	return NewAdmissionManager(filepath.Join(t.TempDir(), "admission.json"))
+}
+
+func TestAdmissionRuleCompile(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    AdmissionRule
+		network string // 为空表示不匹配网段
+		wantErr bool
+	}{
+		{name: "BareIPv4", rule: AdmissionRule{Action: AdmissionAllow, CIDR: "10.1.2.3"}, network: "10.1.2.3/32"},
+		{name: "BareIPv6", rule: AdmissionRule{Action: AdmissionAllow, CIDR: "fd00::1"}, network: "fd00::1/128"},
+		{name: "CIDR", rule: AdmissionRule{Action: AdmissionDeny, CIDR: "192.168.1.77/24"}, network: "192.168.1.0/24"},
+		{name: "PrefixOnly", rule: AdmissionRule{Action: AdmissionDeny, DeviceIDPrefix: "3402000000132"}},
+		{name: "DeviceIDOnly", rule: AdmissionRule{Action: AdmissionAllow, DeviceID: "34020000001320000001"}},
+		{name: "InvalidCIDR", rule: AdmissionRule{Action: AdmissionAllow, CIDR: "10.0.0.300"}, wantErr: true},
+		{name: "InvalidAction", rule: AdmissionRule{Action: AdmissionPending, DeviceIDPrefix: "34"}, wantErr: true},
+		{name: "NoMatcher", rule: AdmissionRule{Action: AdmissionAllow}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.compile()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("compile() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			got := ""
+			if tc.rule.network != nil {
+				got = tc.rule.network.String()
+			}
+			if got != tc.network {
+				t.Errorf("network = %q, want %q", got, tc.network)
+			}
+		})
+	}
+}
+
+func TestAdmissionEvaluateOrder(t *testing.T) {
+	m := newTestAdmission(t)
+	for _, rule := range []AdmissionRule{
+		{Action: AdmissionDeny, DeviceIDPrefix: "34020000001329"},
+		{Action: AdmissionAllow, CIDR: "10.0.0.0/8"},
+		{Action: AdmissionAllow, DeviceIDPrefix: "34020000001318", CIDR: "192.168.0.0/16"},
+	} {
+		if _, err := m.AddRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, deviceID := range []string{"34020000001329000001", "34020000001320000002"} {
+		if err := m.SetCredential(deviceID, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		deviceID string
+		ip       string
+		mode     string
+		want     string
+	}{
+		{"DenyBeatsCredential", "34020000001329000001", "172.16.0.1", AdmissionModeApproval, AdmissionDeny},
+		{"DenyBeatsAllow", "34020000001329000003", "10.0.0.1", AdmissionModeOpen, AdmissionDeny},
+		{"CredentialAllows", "34020000001320000002", "172.16.0.1", AdmissionModeApproval, AdmissionAllow},
+		{"AllowByCIDR", "34020000001320000003", "10.9.9.9", AdmissionModeApproval, AdmissionAllow},
+		{"AllowNeedsBothMatchers", "34020000001318000001", "10.9.9.9", AdmissionModeApproval, AdmissionAllow},
+		{"PrefixWithoutCIDR", "34020000001318000001", "172.16.0.1", AdmissionModeApproval, AdmissionPending},
+		{"UnknownApproval", "34020000001320000004", "172.16.0.1", AdmissionModeApproval, AdmissionPending},
+		{"UnknownOpen", "34020000001320000004", "172.16.0.1", AdmissionModeOpen, AdmissionAllow},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := m.Evaluate(tc.deviceID, tc.ip, tc.mode); got != tc.want {
+				t.Errorf("Evaluate(%s, %s, %s) = %s, want %s", tc.deviceID, tc.ip, tc.mode, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdmissionApproveReject(t *testing.T) {
+	m := newTestAdmission(t)
+	approved, rejected := "34020000001320000001", "34020000001320000002"
+	for _, deviceID := range []string{approved, rejected} {
+		if !m.addPending(deviceID, "172.16.0.1", 5060, "UDP", "REGISTER") {
+			t.Fatalf("%s 应为首次出现", deviceID)
+		}
+	}
+	if m.addPending(approved, "172.16.0.2", 5062, "UDP", "REGISTER") {
+		t.Error("重复出现的设备不应视为首次出现")
+	}
+	pending := m.Pending()
+	if len(pending) != 2 || pending[0].DeviceID != approved || pending[0].Attempts != 2 || pending[0].IP != "172.16.0.2" {
+		t.Fatalf("Pending() = %+v", pending)
+	}
+	// 伪造的短设备ID审批后只匹配自身，不能当作前缀放行或拦截其他设备
+	spoofedAllow, spoofedDeny := "3402", "34"
+	m.addPending(spoofedAllow, "172.16.0.3", 5060, "UDP", "REGISTER")
+	m.addPending(spoofedDeny, "172.16.0.3", 5060, "UDP", "REGISTER")
+
+	if _, err := m.Approve(approved, "device-secret"); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if _, err := m.Reject(rejected); err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+	if _, err := m.Approve(spoofedAllow, ""); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if _, err := m.Reject(spoofedDeny); err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+	if _, err := m.Approve("34020000001320000009", ""); err == nil || errors.Is(err, ErrAdmissionStore) {
+		t.Errorf("审批不在队列中的设备 error = %v", err)
+	}
+	if len(m.Pending()) != 0 {
+		t.Errorf("审批后队列 = %+v", m.Pending())
+	}
+
+	// 重新加载后规则与设备密码保持不变，密码加密落盘
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "device-secret") {
+		t.Error("设备密码以明文落盘")
+	}
+	reloaded := NewAdmissionManager(m.file)
+	if got := reloaded.Evaluate(approved, "172.16.0.1", AdmissionModeApproval); got != AdmissionAllow {
+		t.Errorf("批准的设备 = %s", got)
+	}
+	if got := reloaded.Evaluate(rejected, "172.16.0.1", AdmissionModeOpen); got != AdmissionDeny {
+		t.Errorf("拒绝的设备 = %s", got)
+	}
+	if got := reloaded.Evaluate("34020000001320000003", "172.16.0.1", AdmissionModeApproval); got != AdmissionPending {
+		t.Errorf("未审批的设备 = %s, want pending", got)
+	}
+	if got := reloaded.Evaluate("34020000001320000003", "172.16.0.1", AdmissionModeOpen); got != AdmissionAllow {
+		t.Errorf("open 模式下未审批的设备 = %s, want allow", got)
+	}
+	if password, ok := reloaded.Password(approved); !ok || password != "device-secret" {
+		t.Errorf("Password() = %q, %v", password, ok)
+	}
+}
+
+func TestAdmissionSaveFailureKeepsPolicy(t *testing.T) {
+	m := newTestAdmission(t)
+	m.file = filepath.Join(t.TempDir(), "missing", "admission.json")
+	deviceID := "34020000001320000001"
+	m.addPending(deviceID, "172.16.0.1", 5060, "UDP", "REGISTER")
+
+	if _, err := m.AddRule(AdmissionRule{Action: AdmissionAllow, DeviceIDPrefix: "3402"}); !errors.Is(err, ErrAdmissionStore) {
+		t.Errorf("AddRule error = %v", err)
+	}
+	if err := m.SetCredential(deviceID, "secret"); !errors.Is(err, ErrAdmissionStore) {
+		t.Errorf("SetCredential error = %v", err)
+	}
+	if _, err := m.Approve(deviceID, "secret"); !errors.Is(err, ErrAdmissionStore) {
+		t.Errorf("Approve error = %v", err)
+	}
+
+	if policy := m.Policy(AdmissionModeApproval); len(policy.Rules) != 0 || len(policy.Credentials) != 0 {
+		t.Errorf("保存失败后策略被修改: %+v", policy)
+	}
+	if got := m.Evaluate(deviceID, "172.16.0.1", AdmissionModeApproval); got != AdmissionPending {
+		t.Errorf("Evaluate = %s, want pending", got)
+	}
+	if len(m.Pending()) != 1 {
+		t.Error("保存失败后设备应仍在待审批队列中")
+	}
+}
+
+func TestAdmissionLoadKeepsUndecryptable(t *testing.T) {
+	m := newTestAdmission(t)
+	other, err := secrets.NewKeyring(secrets.GenerateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := other.Encrypt("old-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 明文密码会触发加载后重写，无效规则与其他密钥加密的密码都应原样保留
+	data := fmt.Sprintf(`{"rules":[{"id":"bad","action":"allow","cidr":"not-a-cidr","future":true}],
+		"credentials":{"34020000001320000001":%q,"34020000001320000002":"plain"}}`, foreign)
+	if err := os.WriteFile(m.file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewAdmissionManager(m.file)
+	locked := "34020000001320000001"
+	if got := reloaded.Evaluate(locked, "172.16.0.1", AdmissionModeOpen); got != AdmissionDeny {
+		t.Errorf("密码无法解密的设备 Evaluate = %s, want deny", got)
+	}
+	if _, err := reloaded.AddRule(AdmissionRule{Action: AdmissionAllow, DeviceIDPrefix: "3402"}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(m.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), foreign) || !strings.Contains(string(raw), `"future": true`) {
+		t.Fatalf("重写后丢失了无法处理的条目:\n%s", raw)
+	}
+	if policy := reloaded.Policy(AdmissionModeOpen); len(policy.Credentials) != 2 {
+		t.Errorf("策略密码列表 = %v", policy.Credentials)
+	}
+
+	// 重新设置密码后恢复正常
+	if err := reloaded.SetCredential(locked, "new-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Evaluate(locked, "172.16.0.1", AdmissionModeOpen); got != AdmissionAllow {
+		t.Errorf("重新设置密码后 Evaluate = %s", got)
+	}
+}
+
+func TestAdmissionCorruptFileNotOverwritten(t *testing.T) {
+	m := newTestAdmission(t)
+	if err := os.WriteFile(m.file, []byte(`{"rules":[`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewAdmissionManager(m.file)
+	if _, err := reloaded.AddRule(AdmissionRule{Action: AdmissionAllow, DeviceIDPrefix: "3402"}); !errors.Is(err, ErrAdmissionStore) {
+		t.Errorf("AddRule error = %v", err)
+	}
+	if raw, _ := os.ReadFile(m.file); string(raw) != `{"rules":[` {
+		t.Errorf("损坏的策略文件被覆盖: %s", raw)
+	}
+}
+
+func TestAdmissionPendingLimits(t *testing.T) {
+	m := newTestAdmission(t)
+
+	// 单个来源 IP 的登记数受限
+	for i := 0; i < maxPendingPerIP; i++ {
+		m.addPending(fmt.Sprintf("3402000000132%07d", i), "172.16.0.1", 5060, "UDP", "REGISTER")
+	}
+	if m.addPending("34020000001329999999", "172.16.0.1", 5060, "UDP", "REGISTER") {
+		t.Error("超出单 IP 上限的设备不应登记")
+	}
+
+	// 队列已满且都是最近出现的设备时不淘汰
+	for i := len(m.pending); i < maxPendingDevices; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/maxPendingPerIP, i%maxPendingPerIP)
+		m.addPending(fmt.Sprintf("3402000000131%07d", i), ip, 5060, "UDP", "REGISTER")
+	}
+	if len(m.pending) != maxPendingDevices {
+		t.Fatalf("队列长度 = %d", len(m.pending))
+	}
+	newcomer := "34020000001310999999"
+	if m.addPending(newcomer, "10.255.0.1", 5060, "UDP", "REGISTER") {
+		t.Fatal("队列已满时不应淘汰最近出现的设备")
+	}
+
+	// 最久未出现的设备超过最小保留时间后才被淘汰
+	oldest := m.pendingLRU.Front().Value.(*PendingDevice)
+	oldest.LastSeen -= int64(minPendingAge / time.Second)
+	if !m.addPending(newcomer, "10.255.0.1", 5060, "UDP", "REGISTER") {
+		t.Fatal("应淘汰过期设备后登记新设备")
+	}
+	if _, ok := m.pending[oldest.DeviceID]; ok || len(m.pending) != maxPendingDevices {
+		t.Errorf("未淘汰 %s，队列长度 %d", oldest.DeviceID, len(m.pending))
+	}
+	if m.pendingIPs["172.16.0.1"] != maxPendingPerIP-1 {
+		t.Errorf("172.16.0.1 登记数 = %d", m.pendingIPs["172.16.0.1"])
+	}
+}
+
+func TestDevicePassword(t *testing.T) {
+	srv := NewServer(&config.GB28181Config{Password: "global"})
+	srv.admission = newTestAdmission(t)
+	if err := srv.admission.SetCredential("34020000001320000001", "device"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.devicePassword("34020000001320000001"); got != "device" {
+		t.Errorf("独立密码设备 = %q", got)
+	}
+	if got := srv.devicePassword("34020000001320000002"); got != "global" {
+		t.Errorf("其他设备 = %q", got)
+	}
+}
+
+func TestAdmissionApprovalFlow(t *testing.T) {
+	srv := startLoopbackServer(t, "12345678", AdmissionModeApproval)
+	deviceID := "34020000001320000001"
+	cfg := simulator.Config{DeviceID: deviceID, Transport: "UDP", Password: "device-secret", Channels: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := newSimulatedDevice(t, srv, cfg).Start(ctx); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("未审批设备注册 error = %v, want 403", err)
+	}
+	if _, ok := srv.GetDeviceByID(deviceID); ok {
+		t.Fatal("未审批设备不应登记")
+	}
+	pending := srv.Admission().Pending()
+	if len(pending) != 1 || pending[0].DeviceID != deviceID || pending[0].Method != "REGISTER" {
+		t.Fatalf("Pending() = %+v", pending)
+	}
+
+	// 批准时设置独立密码，设备使用该密码完成摘要认证
+	if _, err := srv.Admission().Approve(deviceID, "device-secret"); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	startSimulatedDevice(t, srv, cfg)
+	if _, ok := srv.GetDeviceByID(deviceID); !ok {
+		t.Fatal("批准后设备未登记")
+	}
+}
+
+func TestNotifyFromUnregisteredDevice(t *testing.T) {
+	srv := startLoopbackServer(t, "", AdmissionModeOpen)
+	deviceID := "34020000001320000001"
+
+	udp, err := net.DialUDP("udp", nil, srv.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<Notify>
+<CmdType>MobilePosition</CmdType>
+<SN>1</SN>
+<DeviceID>%s</DeviceID>
+<Time>%s</Time>
+<Longitude>116.3</Longitude>
+<Latitude>39.9</Latitude>
+</Notify>`, deviceID, time.Now().Format("2006-01-02T15:04:05"))
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	fmt.Fprintf(udp, "NOTIFY sip:34020000002000000001@3402000000 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 127.0.0.1:%d;rport;branch=z9hG4bK%d\r\n"+
+		"From: <sip:%s@3402000000>;tag=1001\r\n"+
+		"To: <sip:34020000002000000001@3402000000>\r\n"+
+		"Call-ID: %d@127.0.0.1\r\n"+
+		"CSeq: 1 NOTIFY\r\n"+
+		"Event: presence\r\n"+
+		"Content-Type: Application/MANSCDP+xml\r\n"+
+		"Content-Length: %d\r\n\r\n%s",
+		port, time.Now().UnixNano(), deviceID, time.Now().UnixNano(), len(body), body)
+
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 4096)
+	n, err := udp.Read(buffer)
+	if err != nil {
+		t.Fatalf("read NOTIFY response: %v", err)
+	}
+	if !strings.HasPrefix(string(buffer[:n]), "SIP/2.0 403") {
+		t.Fatalf("NOTIFY response = %q, want 403 Forbidden", buffer[:n])
+	}
+	if track, _ := srv.GetTrack(deviceID, time.Time{}, time.Time{}); len(track) != 0 {
+		t.Errorf("未注册设备的位置被记录: %+v", track)
+	}
+}
@@ -0,0 +1,72 @@
+package gb28181
+
+import (
+	"log"
+)
+
+// 目录节点类型
+const (
+	CatalogNodeCivilCode     = "civilCode"     // 行政区划（2/4/6/8 位编码）
+	CatalogNodeBusinessGroup = "businessGroup" // 业务分组（类型编码 215）
+	CatalogNodeVirtualOrg    = "virtualOrg"    // 虚拟组织（类型编码 216）
+)
+
+// CatalogNode 设备目录中的组织节点（行政区划、业务分组、虚拟组织），不是可播放的通道
+type CatalogNode struct {
+	ID              string `json:"id"`
+	DeviceID        string `json:"deviceId"` // 上报该目录的设备
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	ParentID        string `json:"parentId,omitempty"`
+	BusinessGroupID string `json:"businessGroupId,omitempty"`
+	CivilCode       string `json:"civilCode,omitempty"`
+}
+
+// CatalogNodeType 按 GB28181 编码规则判断目录项是否为组织节点，返回空串表示是设备/通道
+// 20 位编码的第 11~13 位为类型编码；行政区划编码为 2/4/6/8 位
+func CatalogNodeType(id string) string {
+	switch len(id) {
+	case 2, 4, 6, 8:
+		return CatalogNodeCivilCode
+	case 20:
+		switch id[10:13] {
+		case "215":
+			return CatalogNodeBusinessGroup
+		case "216":
+			return CatalogNodeVirtualOrg
+		}
+	}
+	return ""
+}
+
+// AddCatalogNode 添加或更新设备目录中的组织节点
+func (s *Server) AddCatalogNode(deviceID string, node *CatalogNode) {
+	s.devicesMux.Lock()
+	defer s.devicesMux.Unlock()
+
+	device, ok := s.devices[deviceID]
+	if !ok {
+		return
+	}
+	node.DeviceID = deviceID
+
+	for i, existing := range device.CatalogNodes {
+		if existing.ID == node.ID {
+			device.CatalogNodes[i] = node
+			return
+		}
+	}
+	device.CatalogNodes = append(device.CatalogNodes, node)
+	log.Printf("[GB28181] 📁 目录节点添加: 设备=%s | 节点=%s | 名称=%s | 类型=%s", deviceID, node.ID, node.Name, node.Type)
+}
+
+// GetCatalogNodes 获取设备目录中的组织节点
+func (s *Server) GetCatalogNodes(deviceID string) []*CatalogNode {
+	s.devicesMux.RLock()
+	defer s.devicesMux.RUnlock()
+
+	if device, ok := s.devices[deviceID]; ok {
+		return append([]*CatalogNode(nil), device.CatalogNodes...)
+	}
+	return nil
+}
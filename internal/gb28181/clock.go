@@ -0,0 +1,104 @@
+package gb28181
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+const (
+	// gbDateLayout GB28181 Date 头格式（本地时间，毫秒精度），设备注册成功后据此校时
+	gbDateLayout = "2006-01-02T15:04:05.000"
+
+	// defaultClockSkewThreshold 默认时钟偏差告警阈值
+	defaultClockSkewThreshold = 10 * time.Second
+)
+
+// 时钟偏差来源
+const (
+	ClockSourceKeepalive    = "Keepalive"    // 心跳 MESSAGE 的 Date 头
+	ClockSourceDeviceStatus = "DeviceStatus" // DeviceStatus 应答中的 DeviceTime
+	ClockSourceRecordInfo   = "RecordInfo"   // 录像结束时间晚于平台当前时间
+)
+
+// parseDeviceTime 解析设备上报的时间，支持 GB28181 本地时间格式和 RFC 1123（SIP Date 头）
+func parseDeviceTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{gbDateLayout, "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC1123Z, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// clockSkewThreshold 时钟偏差告警阈值，0 表示不告警
+func (s *Server) clockSkewThreshold() time.Duration {
+	switch {
+	case s.config.ClockSkewThreshold < 0:
+		return 0
+	case s.config.ClockSkewThreshold == 0:
+		return defaultClockSkewThreshold
+	}
+	return time.Duration(s.config.ClockSkewThreshold) * time.Second
+}
+
+// observeDeviceClock 记录设备时钟相对平台的偏差（设备时间 - 平台时间），超过阈值时告警
+// 仅在偏差首次超过阈值时告警，恢复正常后再次超过才会重新告警
+func (s *Server) observeDeviceClock(deviceID string, deviceTime time.Time, source string) {
+	if deviceID == "" || deviceTime.IsZero() {
+		return
+	}
+	skew := deviceTime.Sub(time.Now()).Round(time.Second)
+	threshold := s.clockSkewThreshold()
+
+	s.devicesMux.Lock()
+	device, ok := s.devices[deviceID]
+	if !ok {
+		s.devicesMux.Unlock()
+		return
+	}
+	wasWarning := device.ClockSkewWarning
+	device.ClockSkew = int64(skew / time.Second)
+	device.ClockSkewSource = source
+	device.ClockSkewAt = time.Now().Unix()
+	device.ClockSkewWarning = threshold > 0 && (skew > threshold || skew < -threshold)
+	warning := device.ClockSkewWarning
+	s.devicesMux.Unlock()
+
+	switch {
+	case warning && !wasWarning:
+		log.Printf("[GB28181] ⚠ 设备 %s 时钟偏差 %s 超过阈值 %s (来源: %s)，录像检索时间可能错位", deviceID, skew, threshold, source)
+		debug.Warn("gb28181", "设备时钟偏差超过阈值: 设备=%s 偏差=%s 阈值=%s 来源=%s", deviceID, skew, threshold, source)
+	case !warning && wasWarning:
+		debug.Info("gb28181", "设备时钟偏差恢复正常: 设备=%s 偏差=%s 来源=%s", deviceID, skew, source)
+	default:
+		debug.Debug("gb28181", "设备时钟偏差: 设备=%s 偏差=%s 来源=%s", deviceID, skew, source)
+	}
+}
+
+// observeRecordClock 根据录像时间推断设备时钟偏差
+// 录像结束时间只能说明设备时钟至少超前多少，因此仅在结束时间晚于平台当前时间时记录
+func (s *Server) observeRecordClock(deviceID string, records []DeviceRecordInfo) {
+	var latest time.Time
+	for _, record := range records {
+		if end, ok := parseDeviceTime(record.EndTime); ok && end.After(latest) {
+			latest = end
+		}
+	}
+	if latest.After(time.Now().Add(time.Second)) {
+		s.observeDeviceClock(deviceID, latest, ClockSourceRecordInfo)
+	}
+}
@@ -1,6 +1,8 @@
 package gb28181
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/xml"
 	"fmt"
@@ -8,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"gb28181-onvif-server/internal/debug"
 )
 
 // PTZCommand PTZ控制命令结构体
@@ -29,103 +33,264 @@ type PTZResponse struct {
 	ResultDesp string   `xml:"ResultDesp,omitempty"`
 }
 
-// generatePTZCmdBytes 生成 GB28181 PTZ 控制字节码
-// GB28181 PTZ 命令格式: A50F01[指令字][水平速度][垂直速度][缩放速度][校验码]
-// 指令字:
-//   - 上: 0x08, 下: 0x04, 左: 0x02, 右: 0x01
-//   - 左上: 0x0A, 左下: 0x06, 右上: 0x09, 右下: 0x05
-//   - 放大: 0x10, 缩小: 0x20
-//   - 停止: 0x00
-func generatePTZCmdBytes(command string, speed int) string {
-	// 标准前缀 A50F01
-	prefix := []byte{0xA5, 0x0F, 0x01}
+// PTZ 指令码（GB/T 28181 附录 A.3）
+const (
+	ptzCodeStop = 0x00
+
+	// PTZ 指令：Bit0 右, Bit1 左, Bit2 下, Bit3 上, Bit4 放大, Bit5 缩小
+	ptzCodeRight   = 0x01
+	ptzCodeLeft    = 0x02
+	ptzCodeDown    = 0x04
+	ptzCodeUp      = 0x08
+	ptzCodeZoomIn  = 0x10
+	ptzCodeZoomOut = 0x20
+
+	// FI 指令：高4位 0100，Bit0 聚焦远, Bit1 聚焦近, Bit2 光圈放大, Bit3 光圈缩小
+	ptzCodeFocusFar  = 0x41
+	ptzCodeFocusNear = 0x42
+	ptzCodeIrisOpen  = 0x44
+	ptzCodeIrisClose = 0x48
+
+	// 预置位指令
+	ptzCodePresetSet    = 0x81
+	ptzCodePresetGoto   = 0x82
+	ptzCodePresetDelete = 0x83
+
+	// 巡航指令
+	ptzCodeCruiseAdd    = 0x84 // 加入巡航点
+	ptzCodeCruiseDelete = 0x85 // 删除巡航点（预置位号为 0 时删除整条巡航）
+	ptzCodeCruiseSpeed  = 0x86 // 设置巡航速度
+	ptzCodeCruiseDwell  = 0x87 // 设置巡航停留时间
+	ptzCodeCruiseStart  = 0x88 // 开始巡航
+
+	// 自动扫描指令
+	ptzCodeScan      = 0x89 // 开始自动扫描/设置左右边界
+	ptzCodeScanSpeed = 0x8A // 设置自动扫描速度
+)
+
+// 自动扫描 0x89 指令的操作类型（字节6）
+const (
+	scanOpStart = 0x00
+	scanOpLeft  = 0x01
+	scanOpRight = 0x02
+)
 
-	// 根据命令确定指令字和速度分配
-	var cmdByte byte = 0x00
-	var panSpeed byte = 0x00  // 水平速度
-	var tiltSpeed byte = 0x00 // 垂直速度
-	var zoomSpeed byte = 0x00 // 缩放速度
+// buildPTZCmd 组装 8 字节 PTZ 指令
+// 字节1 A5；字节2 组合码1（高4位版本 0，低4位为前三个半字节之和的低4位，即 0F）；字节3 地址低8位；
+// 字节4 指令码；字节5、6 数据1、2；字节7 高4位为数据3、低4位为地址高4位；字节8 为前7字节之和模256
+func buildPTZCmd(code, data1, data2, data3 byte) string {
+	const address = 0x001
 
-	// 将速度转换为 0-255 范围
+	cmd := []byte{
+		0xA5,
+		0x0F,
+		byte(address & 0xFF),
+		code,
+		data1,
+		data2,
+		(data3&0x0F)<<4 | byte(address>>8)&0x0F,
+	}
+
+	var sum int
+	for _, b := range cmd {
+		sum += int(b)
+	}
+	cmd = append(cmd, byte(sum%256))
+
+	return strings.ToUpper(hex.EncodeToString(cmd))
+}
+
+// clampByte 将数值限制在 [min, max] 后转为字节
+func clampByte(v, min, max int) byte {
+	if v < min {
+		v = min
+	}
+	if v > max {
+		v = max
+	}
+	return byte(v)
+}
+
+// generatePTZCmdBytes 生成 GB28181 PTZ/FI 控制字节码
+// speed 为 1-255：方向控制作为水平/垂直速度，缩放速度取高4位（0-15），聚焦/光圈速度直接使用
+// 支持的命令: up, down, left, right, leftup, leftdown, rightup, rightdown, zoomin, zoomout,
+// focusnear, focusfar, irisopen, irisclose, stop
+func generatePTZCmdBytes(command string, speed int) string {
 	if speed <= 0 {
 		speed = 128
 	}
-	if speed > 255 {
-		speed = 255
-	}
-	speedByte := byte(speed)
+	speedByte := clampByte(speed, 1, 255)
+	zoomSpeed := clampByte(speed>>4, 1, 15)
 
 	switch strings.ToLower(command) {
 	case "up":
-		cmdByte = 0x08
-		tiltSpeed = speedByte
+		return buildPTZCmd(ptzCodeUp, 0, speedByte, 0)
 	case "down":
-		cmdByte = 0x04
-		tiltSpeed = speedByte
+		return buildPTZCmd(ptzCodeDown, 0, speedByte, 0)
 	case "left":
-		cmdByte = 0x02
-		panSpeed = speedByte
+		return buildPTZCmd(ptzCodeLeft, speedByte, 0, 0)
 	case "right":
-		cmdByte = 0x01
-		panSpeed = speedByte
+		return buildPTZCmd(ptzCodeRight, speedByte, 0, 0)
 	case "leftup", "upleft":
-		cmdByte = 0x0A
-		panSpeed = speedByte
-		tiltSpeed = speedByte
+		return buildPTZCmd(ptzCodeLeft|ptzCodeUp, speedByte, speedByte, 0)
 	case "leftdown", "downleft":
-		cmdByte = 0x06
-		panSpeed = speedByte
-		tiltSpeed = speedByte
+		return buildPTZCmd(ptzCodeLeft|ptzCodeDown, speedByte, speedByte, 0)
 	case "rightup", "upright":
-		cmdByte = 0x09
-		panSpeed = speedByte
-		tiltSpeed = speedByte
+		return buildPTZCmd(ptzCodeRight|ptzCodeUp, speedByte, speedByte, 0)
 	case "rightdown", "downright":
-		cmdByte = 0x05
-		panSpeed = speedByte
-		tiltSpeed = speedByte
+		return buildPTZCmd(ptzCodeRight|ptzCodeDown, speedByte, speedByte, 0)
 	case "zoomin", "zoom_in":
-		cmdByte = 0x10
-		zoomSpeed = speedByte & 0x0F // 缩放速度只取低4位
+		return buildPTZCmd(ptzCodeZoomIn, 0, 0, zoomSpeed)
 	case "zoomout", "zoom_out":
-		cmdByte = 0x20
-		zoomSpeed = speedByte & 0x0F // 缩放速度只取低4位
-	case "stop":
-		cmdByte = 0x00
-		panSpeed = 0
-		tiltSpeed = 0
-		zoomSpeed = 0
+		return buildPTZCmd(ptzCodeZoomOut, 0, 0, zoomSpeed)
+	case "focusnear", "focus_near":
+		return buildPTZCmd(ptzCodeFocusNear, speedByte, 0, 0)
+	case "focusfar", "focus_far":
+		return buildPTZCmd(ptzCodeFocusFar, speedByte, 0, 0)
+	case "irisopen", "iris_open":
+		return buildPTZCmd(ptzCodeIrisOpen, 0, speedByte, 0)
+	case "irisclose", "iris_close":
+		return buildPTZCmd(ptzCodeIrisClose, 0, speedByte, 0)
 	default:
 		// 默认停止
-		cmdByte = 0x00
+		return buildPTZCmd(ptzCodeStop, 0, 0, 0)
+	}
+}
+
+// generatePresetCmd 生成预置位指令，action: set, goto, delete；preset 为 1-255
+func generatePresetCmd(action string, preset int) (string, error) {
+	if preset < 1 || preset > 255 {
+		return "", fmt.Errorf("预置位号必须在 1-255 之间: %d", preset)
+	}
+
+	var code byte
+	switch strings.ToLower(action) {
+	case "set":
+		code = ptzCodePresetSet
+	case "goto", "call":
+		code = ptzCodePresetGoto
+	case "delete", "remove":
+		code = ptzCodePresetDelete
+	default:
+		return "", fmt.Errorf("不支持的预置位操作: %s", action)
 	}
+	return buildPTZCmd(code, 0, byte(preset), 0), nil
+}
 
-	// 组装命令字节
-	cmdBytes := append(prefix, cmdByte, panSpeed, tiltSpeed, zoomSpeed)
+// generateCruiseCmd 生成巡航指令
+// action: add（加入预置位 value）, delete（删除预置位 value，0 删除整条巡航）, speed（巡航速度 value）,
+// dwell（停留时间 value 秒）, start, stop；group 为巡航组号 0-255
+// 速度与停留时间为 12 位数值，低8位放在字节6，高4位放在字节7高4位
+func generateCruiseCmd(action string, group, value int) (string, error) {
+	if group < 0 || group > 255 {
+		return "", fmt.Errorf("巡航组号必须在 0-255 之间: %d", group)
+	}
 
-	// 计算校验码 (所有字节异或)
-	checksum := byte(0)
-	for _, b := range cmdBytes {
-		checksum ^= b
+	switch strings.ToLower(action) {
+	case "add":
+		if value < 1 || value > 255 {
+			return "", fmt.Errorf("预置位号必须在 1-255 之间: %d", value)
+		}
+		return buildPTZCmd(ptzCodeCruiseAdd, byte(group), byte(value), 0), nil
+	case "delete", "remove":
+		if value < 0 || value > 255 {
+			return "", fmt.Errorf("预置位号必须在 0-255 之间: %d", value)
+		}
+		return buildPTZCmd(ptzCodeCruiseDelete, byte(group), byte(value), 0), nil
+	case "speed", "dwell":
+		if value < 0 || value > 0xFFF {
+			return "", fmt.Errorf("数值必须在 0-4095 之间: %d", value)
+		}
+		code := byte(ptzCodeCruiseSpeed)
+		if strings.ToLower(action) == "dwell" {
+			code = ptzCodeCruiseDwell
+		}
+		return buildPTZCmd(code, byte(group), byte(value&0xFF), byte(value>>8)), nil
+	case "start":
+		return buildPTZCmd(ptzCodeCruiseStart, byte(group), 0, 0), nil
+	case "stop":
+		return buildPTZCmd(ptzCodeStop, 0, 0, 0), nil
+	default:
+		return "", fmt.Errorf("不支持的巡航操作: %s", action)
+	}
+}
+
+// generateScanCmd 生成自动扫描指令
+// action: start, left（设置左边界）, right（设置右边界）, speed（扫描速度 value，0-4095）, stop；group 为扫描组号 0-255
+func generateScanCmd(action string, group, value int) (string, error) {
+	if group < 0 || group > 255 {
+		return "", fmt.Errorf("扫描组号必须在 0-255 之间: %d", group)
 	}
-	cmdBytes = append(cmdBytes, checksum)
 
-	// 转换为大写十六进制字符串
-	return strings.ToUpper(hex.EncodeToString(cmdBytes))
+	switch strings.ToLower(action) {
+	case "start":
+		return buildPTZCmd(ptzCodeScan, byte(group), scanOpStart, 0), nil
+	case "left":
+		return buildPTZCmd(ptzCodeScan, byte(group), scanOpLeft, 0), nil
+	case "right":
+		return buildPTZCmd(ptzCodeScan, byte(group), scanOpRight, 0), nil
+	case "speed":
+		if value < 0 || value > 0xFFF {
+			return "", fmt.Errorf("扫描速度必须在 0-4095 之间: %d", value)
+		}
+		return buildPTZCmd(ptzCodeScanSpeed, byte(group), byte(value&0xFF), byte(value>>8)), nil
+	case "stop":
+		return buildPTZCmd(ptzCodeStop, 0, 0, 0), nil
+	default:
+		return "", fmt.Errorf("不支持的扫描操作: %s", action)
+	}
 }
 
 // SendPTZCommand 发送PTZ控制命令
 func (s *Server) SendPTZCommand(deviceID, channel, ptzCmd string, speed int) error {
+	// 生成 GB28181 PTZ 字节码
+	ptzCmdHex := generatePTZCmdBytes(ptzCmd, speed)
+	log.Printf("[PTZ] 发送命令到设备 %s: 命令=%s, 速度=%d, 字节码=%s", deviceID, ptzCmd, speed, ptzCmdHex)
+
+	return s.sendPTZCmd(deviceID, channel, ptzCmdHex)
+}
+
+// PresetControl 预置位控制，action: set, goto, delete
+func (s *Server) PresetControl(deviceID, channel, action string, preset int) error {
+	ptzCmdHex, err := generatePresetCmd(action, preset)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PTZ] 预置位控制 %s: 操作=%s, 预置位=%d, 字节码=%s", deviceID, action, preset, ptzCmdHex)
+
+	return s.sendPTZCmd(deviceID, channel, ptzCmdHex)
+}
+
+// CruiseControl 巡航控制，action: add, delete, speed, dwell, start, stop
+func (s *Server) CruiseControl(deviceID, channel, action string, group, value int) error {
+	ptzCmdHex, err := generateCruiseCmd(action, group, value)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PTZ] 巡航控制 %s: 操作=%s, 巡航组=%d, 参数=%d, 字节码=%s", deviceID, action, group, value, ptzCmdHex)
+
+	return s.sendPTZCmd(deviceID, channel, ptzCmdHex)
+}
+
+// ScanControl 自动扫描控制，action: start, left, right, speed, stop
+func (s *Server) ScanControl(deviceID, channel, action string, group, value int) error {
+	ptzCmdHex, err := generateScanCmd(action, group, value)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PTZ] 扫描控制 %s: 操作=%s, 扫描组=%d, 参数=%d, 字节码=%s", deviceID, action, group, value, ptzCmdHex)
+
+	return s.sendPTZCmd(deviceID, channel, ptzCmdHex)
+}
+
+// sendPTZCmd 以 DeviceControl 下发 PTZCmd 字节码
+func (s *Server) sendPTZCmd(deviceID, channel, ptzCmdHex string) error {
 	device, exists := s.GetDeviceByID(deviceID)
 	if !exists {
 		log.Printf("[PTZ] [ERROR] 设备不存在: %s", deviceID)
 		return fmt.Errorf("设备不存在: %s", deviceID)
 	}
 
-	// 生成 GB28181 PTZ 字节码
-	ptzCmdHex := generatePTZCmdBytes(ptzCmd, speed)
-	log.Printf("[PTZ] 发送命令到设备 %s: 命令=%s, 速度=%d, 字节码=%s", deviceID, ptzCmd, speed, ptzCmdHex)
-
 	// 发送的目标设备ID应该是通道ID
 	targetDeviceID := channel
 	if targetDeviceID == "" {
@@ -135,7 +300,7 @@ func (s *Server) SendPTZCommand(deviceID, channel, ptzCmd string, speed int) err
 	// 生成PTZ控制XML内容
 	ptzCmdXML := &PTZCommand{
 		CmdType:  "DeviceControl",
-		SN:       strconv.FormatInt(time.Now().UnixNano()/1000000, 10),
+		SN:       strconv.FormatInt(nextSN(), 10),
 		DeviceID: targetDeviceID,
 		PTZCmd:   ptzCmdHex,
 	}
@@ -205,12 +370,236 @@ func (s *Server) SendCatalogQuery(deviceID string) error {
 	return nil
 }
 
+// randomHex 生成 n 字节的随机十六进制串
+// Call-ID、tag、branch 需要不可预测，否则同网段的攻击者可伪造 BYE/CANCEL 拆除会话
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // generateTag 生成SIP消息的Tag
 func generateTag() string {
-	return fmt.Sprintf("tag_%d", time.Now().UnixNano())
+	return randomHex(8)
 }
 
 // generateCallID 生成SIP消息的Call-ID
 func generateCallID() string {
-	return fmt.Sprintf("callid_%d", time.Now().UnixNano())
+	return randomHex(16)
+}
+
+// generateBranch 生成 Via branch，保留 RFC 3261 要求的 z9hG4bK 前缀
+func generateBranch() string {
+	return "z9hG4bK" + randomHex(8)
+}
+
+// DragZoom 拉框放大/缩小参数（GB/T 28181 A.2.3），坐标以播放窗口像素为单位
+type DragZoom struct {
+	Length    int `xml:"Length" json:"length"`       // 播放窗口长度像素值
+	Width     int `xml:"Width" json:"width"`         // 播放窗口宽度像素值
+	MidPointX int `xml:"MidPointX" json:"midPointX"` // 拉框中心横轴坐标
+	MidPointY int `xml:"MidPointY" json:"midPointY"` // 拉框中心纵轴坐标
+	LengthX   int `xml:"LengthX" json:"lengthX"`     // 拉框长度像素值
+	LengthY   int `xml:"LengthY" json:"lengthY"`     // 拉框宽度像素值
+}
+
+// deviceControl DeviceControl 控制命令，每次只携带一个命令元素
+type deviceControl struct {
+	XMLName     xml.Name  `xml:"Control"`
+	CmdType     string    `xml:"CmdType"`
+	SN          string    `xml:"SN"`
+	DeviceID    string    `xml:"DeviceID"`
+	TeleBoot    string    `xml:"TeleBoot,omitempty"`
+	RecordCmd   string    `xml:"RecordCmd,omitempty"`
+	GuardCmd    string    `xml:"GuardCmd,omitempty"`
+	AlarmCmd    string    `xml:"AlarmCmd,omitempty"`
+	IFameCmd    string    `xml:"IFameCmd,omitempty"`
+	DragZoomIn  *DragZoom `xml:"DragZoomIn,omitempty"`
+	DragZoomOut *DragZoom `xml:"DragZoomOut,omitempty"`
+}
+
+// sendDeviceControl 下发 DeviceControl 命令并按 SN 等待设备 Response，返回设备应答的 Result（OK/ERROR）
+func (s *Server) sendDeviceControl(ctx context.Context, deviceID, channelID string, control *deviceControl) (string, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return "", fmt.Errorf("设备不存在: %s", deviceID)
+	}
+	if channelID == "" {
+		channelID = deviceID
+	}
+
+	sn := nextSN()
+	control.CmdType = "DeviceControl"
+	control.SN = strconv.FormatInt(sn, 10)
+	control.DeviceID = channelID
+
+	xmlBytes, err := xml.MarshalIndent(control, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("生成控制命令XML失败: %v", err)
+	}
+	xmlContent := `<?xml version="1.0" encoding="GB2312"?>` + "\r\n" + string(xmlBytes)
+	sipMessage := s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", xmlContent)
+
+	q := s.registerQuery("DeviceControl", sn, channelID)
+	if err := s.runQuery(ctx, device, q, sipMessage); err != nil {
+		return "", err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	log.Printf("[GB28181] 设备控制应答: 设备=%s 通道=%s 结果=%s", deviceID, channelID, q.result)
+	return q.result, nil
+}
+
+// SetDeviceConfig 下发设备配置（DeviceConfig），返回设备应答结果
+// VideoParamOpt 为只读的能力描述，下发时忽略
+func (s *Server) SetDeviceConfig(ctx context.Context, deviceID, channelID string, config DeviceConfig) (string, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return "", fmt.Errorf("设备不存在: %s", deviceID)
+	}
+	if channelID == "" {
+		channelID = deviceID
+	}
+
+	config.VideoParamOpt = nil
+	if config.BasicParam == nil && config.SVACEncodeConfig == nil && config.SVACDecodeConfig == nil {
+		return "", fmt.Errorf("没有需要下发的配置")
+	}
+
+	sn := nextSN()
+	xmlBytes, err := xml.MarshalIndent(&DeviceConfigControl{
+		CmdType:      "DeviceConfig",
+		SN:           strconv.FormatInt(sn, 10),
+		DeviceID:     channelID,
+		DeviceConfig: config,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("生成设备配置XML失败: %v", err)
+	}
+	xmlContent := `<?xml version="1.0" encoding="GB2312"?>` + "\r\n" + string(xmlBytes)
+	sipMessage := s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", xmlContent)
+
+	q := s.registerQuery("DeviceConfig", sn, channelID)
+	if err := s.runQuery(ctx, device, q, sipMessage); err != nil {
+		return "", err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	log.Printf("[GB28181] 设备配置应答: 设备=%s 通道=%s 结果=%s", deviceID, channelID, q.result)
+	return q.result, nil
+}
+
+// TeleBoot 远程重启设备
+// 设备收到后直接重启，不保证返回 Response，因此只等待 SIP 应答
+func (s *Server) TeleBoot(ctx context.Context, deviceID string) error {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return fmt.Errorf("设备不存在: %s", deviceID)
+	}
+
+	xmlBytes, err := xml.MarshalIndent(&deviceControl{
+		CmdType:  "DeviceControl",
+		SN:       strconv.FormatInt(nextSN(), 10),
+		DeviceID: deviceID,
+		TeleBoot: "Boot",
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("生成控制命令XML失败: %v", err)
+	}
+	xmlContent := `<?xml version="1.0" encoding="GB2312"?>` + "\r\n" + string(xmlBytes)
+
+	type result struct {
+		response *SIPMessage
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := s.SendSIPRequest(device, s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", xmlContent))
+		done <- result{response, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return res.err
+		}
+		if res.response.StatusCode >= 300 {
+			return fmt.Errorf("设备拒绝重启命令: %d %s", res.response.StatusCode, res.response.Reason)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	log.Printf("[GB28181] ✓ 已向设备 %s 发送远程重启命令", deviceID)
+	return nil
+}
+
+// RecordControl 开始/停止设备端录像
+func (s *Server) RecordControl(ctx context.Context, deviceID, channelID string, start bool) (string, error) {
+	cmd := "StopRecord"
+	if start {
+		cmd = "Record"
+	}
+	return s.sendDeviceControl(ctx, deviceID, channelID, &deviceControl{RecordCmd: cmd})
+}
+
+// GuardControl 布防/撤防
+func (s *Server) GuardControl(ctx context.Context, deviceID, channelID string, arm bool) (string, error) {
+	cmd := "ResetGuard"
+	if arm {
+		cmd = "SetGuard"
+	}
+	return s.sendDeviceControl(ctx, deviceID, channelID, &deviceControl{GuardCmd: cmd})
+}
+
+// ResetAlarm 报警复位
+func (s *Server) ResetAlarm(ctx context.Context, deviceID, channelID string) (string, error) {
+	return s.sendDeviceControl(ctx, deviceID, channelID, &deviceControl{AlarmCmd: "ResetAlarm"})
+}
+
+// ForceKeyFrame 强制关键帧，设备收到后立即发送 I 帧
+func (s *Server) ForceKeyFrame(ctx context.Context, deviceID, channelID string) (string, error) {
+	return s.sendDeviceControl(ctx, deviceID, channelID, &deviceControl{IFameCmd: "Send"})
+}
+
+// DragZoomControl 拉框放大/缩小
+func (s *Server) DragZoomControl(ctx context.Context, deviceID, channelID string, zoomIn bool, area DragZoom) (string, error) {
+	if area.Length <= 0 || area.Width <= 0 {
+		return "", fmt.Errorf("播放窗口尺寸无效")
+	}
+	control := &deviceControl{DragZoomOut: &area}
+	if zoomIn {
+		control = &deviceControl{DragZoomIn: &area}
+	}
+	return s.sendDeviceControl(ctx, deviceID, channelID, control)
+}
+
+// parseDeviceControlResponse 解析设备控制应答
+func (s *Server) parseDeviceControlResponse(deviceID string, body string) {
+	body = strings.Replace(body, `encoding="GB2312"`, `encoding="UTF-8"`, 1)
+	body = strings.Replace(body, `encoding='GB2312'`, `encoding='UTF-8'`, 1)
+
+	var response PTZResponse
+	if err := xml.Unmarshal([]byte(body), &response); err != nil {
+		debug.Warn("gb28181", "解析设备控制应答失败: %v", err)
+		return
+	}
+
+	targetID := response.DeviceID
+	if targetID == "" {
+		targetID = deviceID
+	}
+	sn, _ := strconv.ParseInt(response.SN, 10, 64)
+
+	// DeviceControl 与 DeviceConfig 共用 Response 格式，按应答的 CmdType 交给对应查询
+	cmdType := response.CmdType
+	if cmdType == "" {
+		cmdType = "DeviceControl"
+	}
+	s.deliverQuery(cmdType, sn, targetID, 1, func(q *pendingQuery) int {
+		q.result = response.Result
+		return 1
+	})
 }
@@ -0,0 +1,157 @@
+package gb28181
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// 期望值按 GB/T 28181 附录 A.3 手工计算：A5 0F 01 指令码 数据1 数据2 数据3|地址高4位 校验码(前7字节和 mod 256)
+
+func TestGeneratePTZCmdBytes(t *testing.T) {
+	tests := []struct {
+		command string
+		speed   int
+		want    string
+	}{
+		{"stop", 0, "A50F0100000000B5"}, // 标准中的停止指令示例
+		{"up", 128, "A50F01080080003D"},
+		{"left", 64, "A50F0102400000F7"},
+		{"rightup", 255, "A50F0109FFFF00BC"},
+		{"zoomin", 128, "A50F011000008045"},  // 缩放速度 128>>4=8，位于字节7高4位
+		{"zoomout", 255, "A50F01200000F0C5"}, // 缩放速度 15
+		{"focusnear", 100, "A50F01426400005B"},
+		{"focusfar", 100, "A50F01416400005A"},
+		{"irisopen", 100, "A50F01440064005D"},
+		{"irisclose", 100, "A50F014800640061"},
+		{"unknown", 100, "A50F0100000000B5"},
+	}
+
+	for _, tt := range tests {
+		if got := generatePTZCmdBytes(tt.command, tt.speed); got != tt.want {
+			t.Errorf("generatePTZCmdBytes(%q, %d) = %s, want %s", tt.command, tt.speed, got, tt.want)
+		}
+	}
+}
+
+func TestGeneratePresetCmd(t *testing.T) {
+	tests := []struct {
+		action string
+		preset int
+		want   string
+	}{
+		{"set", 1, "A50F018100010037"},
+		{"goto", 1, "A50F018200010038"},
+		{"delete", 255, "A50F018300FF0037"},
+	}
+
+	for _, tt := range tests {
+		got, err := generatePresetCmd(tt.action, tt.preset)
+		if err != nil {
+			t.Fatalf("generatePresetCmd(%q, %d): %v", tt.action, tt.preset, err)
+		}
+		if got != tt.want {
+			t.Errorf("generatePresetCmd(%q, %d) = %s, want %s", tt.action, tt.preset, got, tt.want)
+		}
+	}
+
+	for _, preset := range []int{0, 256} {
+		if _, err := generatePresetCmd("set", preset); err == nil {
+			t.Errorf("generatePresetCmd(set, %d) expected error", preset)
+		}
+	}
+	if _, err := generatePresetCmd("rename", 1); err == nil {
+		t.Error("generatePresetCmd(rename) expected error")
+	}
+}
+
+func TestGenerateCruiseCmd(t *testing.T) {
+	tests := []struct {
+		action string
+		group  int
+		value  int
+		want   string
+	}{
+		{"add", 1, 5, "A50F01840105003F"},
+		{"delete", 1, 0, "A50F01850100003B"},    // 预置位 0 删除整条巡航
+		{"speed", 1, 0x123, "A50F01860123106F"}, // 低8位 0x23 在字节6，高4位 0x1 在字节7高4位
+		{"dwell", 2, 30, "A50F0187021E005C"},
+		{"start", 1, 0, "A50F01880100003E"},
+		{"stop", 1, 0, "A50F0100000000B5"},
+	}
+
+	for _, tt := range tests {
+		got, err := generateCruiseCmd(tt.action, tt.group, tt.value)
+		if err != nil {
+			t.Fatalf("generateCruiseCmd(%q, %d, %d): %v", tt.action, tt.group, tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("generateCruiseCmd(%q, %d, %d) = %s, want %s", tt.action, tt.group, tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := generateCruiseCmd("add", 1, 0); err == nil {
+		t.Error("generateCruiseCmd(add, preset 0) expected error")
+	}
+	if _, err := generateCruiseCmd("speed", 1, 0x1000); err == nil {
+		t.Error("generateCruiseCmd(speed, 4096) expected error")
+	}
+	if _, err := generateCruiseCmd("start", 256, 0); err == nil {
+		t.Error("generateCruiseCmd(group 256) expected error")
+	}
+}
+
+func TestGenerateScanCmd(t *testing.T) {
+	tests := []struct {
+		action string
+		group  int
+		value  int
+		want   string
+	}{
+		{"start", 1, 0, "A50F01890100003F"},
+		{"left", 1, 0, "A50F018901010040"},
+		{"right", 1, 0, "A50F018901020041"},
+		{"speed", 1, 0xFFF, "A50F018A01FFF02F"},
+		{"stop", 1, 0, "A50F0100000000B5"},
+	}
+
+	for _, tt := range tests {
+		got, err := generateScanCmd(tt.action, tt.group, tt.value)
+		if err != nil {
+			t.Fatalf("generateScanCmd(%q, %d, %d): %v", tt.action, tt.group, tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("generateScanCmd(%q, %d, %d) = %s, want %s", tt.action, tt.group, tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := generateScanCmd("pause", 1, 0); err == nil {
+		t.Error("generateScanCmd(pause) expected error")
+	}
+}
+
+// TestBuildPTZCmdChecksum 校验所有指令码的字节2组合码与字节8校验和
+func TestBuildPTZCmdChecksum(t *testing.T) {
+	for code := 0; code <= 0xFF; code++ {
+		cmd, err := hex.DecodeString(buildPTZCmd(byte(code), 0x12, 0x34, 0x5))
+		if err != nil {
+			t.Fatalf("code %02X: %v", code, err)
+		}
+		if len(cmd) != 8 {
+			t.Fatalf("code %02X: length = %d, want 8", code, len(cmd))
+		}
+		// 组合码1：版本 0，校验位 = (A + 5 + 0) mod 16
+		if cmd[1] != 0x0F {
+			t.Errorf("code %02X: byte2 = %02X, want 0F", code, cmd[1])
+		}
+		if cmd[6] != 0x50 {
+			t.Errorf("code %02X: byte7 = %02X, want 50", code, cmd[6])
+		}
+		sum := 0
+		for _, b := range cmd[:7] {
+			sum += int(b)
+		}
+		if cmd[7] != byte(sum%256) {
+			t.Errorf("code %02X: checksum = %02X, want %02X", code, cmd[7], byte(sum%256))
+		}
+	}
+}
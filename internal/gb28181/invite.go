@@ -28,6 +28,100 @@ type MediaSession struct {
 	MediaIP    string `json:"media_ip"`    // 媒体服务器IP
 	MediaPort  int    `json:"media_port"`  // 媒体服务器端口
 	Transport  string `json:"transport"`   // 传输协议 TCP/UDP
+	Quality    string `json:"quality"`     // 码流: main, sub, third
+	StreamMode string `json:"stream_mode"` // 媒体传输模式: UDP, TCP-Passive, TCP-Active
+
+	// 设备在 200 OK SDP 中应答的媒体地址，TCP-Active 模式下由 ZLM 主动连接
+	DeviceMediaIP   string `json:"device_media_ip,omitempty"`
+	DeviceMediaPort int    `json:"device_media_port,omitempty"`
+
+	answered     chan struct{} // 收到 INVITE 最终响应时关闭
+	answeredOnce sync.Once
+	mu           sync.Mutex // 保护应答后更新的 Status、ToTag、StartTime 和设备媒体地址
+}
+
+// status 加锁读取会话状态
+func (m *MediaSession) status() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Status
+}
+
+// setStatus 加锁更新会话状态
+func (m *MediaSession) setStatus(status string) {
+	m.mu.Lock()
+	m.Status = status
+	m.mu.Unlock()
+}
+
+// 媒体传输模式（RFC 4571 TCP 媒体的 setup 协商）
+const (
+	StreamModeUDP        = "UDP"
+	StreamModeTCPPassive = "TCP-Passive" // 平台被动：ZLM 监听 TCP 端口，设备主动连接（适用于设备在 NAT 后）
+	StreamModeTCPActive  = "TCP-Active"  // 平台主动：设备监听 TCP 端口，ZLM 主动连接
+)
+
+// ParseStreamMode 解析媒体传输模式，空值为 UDP
+func ParseStreamMode(mode string) (string, error) {
+	switch strings.ToUpper(strings.TrimSpace(mode)) {
+	case "", "UDP":
+		return StreamModeUDP, nil
+	case "TCP-PASSIVE", "TCP_PASSIVE", "PASSIVE":
+		return StreamModeTCPPassive, nil
+	case "TCP-ACTIVE", "TCP_ACTIVE", "ACTIVE":
+		return StreamModeTCPActive, nil
+	}
+	return "", fmt.Errorf("不支持的媒体传输模式: %s（可选 UDP/TCP-Passive/TCP-Active）", mode)
+}
+
+// ZLMTCPMode 媒体传输模式对应 ZLM openRtpServer 的 tcp_mode：0 UDP，1 TCP 被动，2 TCP 主动
+func ZLMTCPMode(mode string) int {
+	switch mode {
+	case StreamModeTCPPassive:
+		return 1
+	case StreamModeTCPActive:
+		return 2
+	}
+	return 0
+}
+
+// 码流类型
+const (
+	StreamQualityMain  = "main"  // 主码流
+	StreamQualitySub   = "sub"   // 子码流
+	StreamQualityThird = "third" // 第三码流
+)
+
+// ParseStreamQuality 解析码流类型，空值为主码流
+func ParseStreamQuality(quality string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(quality)) {
+	case "", StreamQualityMain, "0":
+		return StreamQualityMain, nil
+	case StreamQualitySub, "1":
+		return StreamQualitySub, nil
+	case StreamQualityThird, "2":
+		return StreamQualityThird, nil
+	}
+	return "", fmt.Errorf("不支持的码流类型: %s（可选 main/sub/third）", quality)
+}
+
+// StreamNumber 码流编号：0 主码流，1 子码流，2 第三码流
+func StreamNumber(quality string) int {
+	switch quality {
+	case StreamQualitySub:
+		return 1
+	case StreamQualityThird:
+		return 2
+	}
+	return 0
+}
+
+// QualityStreamID 为流ID追加码流后缀，主码流不追加以兼容已有流ID
+func QualityStreamID(streamID, quality string) string {
+	if quality == "" || quality == StreamQualityMain {
+		return streamID
+	}
+	return streamID + "_" + quality
 }
 
 // MediaSessionManager 媒体会话管理器
@@ -45,28 +139,30 @@ func GetSessionManager() *MediaSessionManager {
 	return sessionManager
 }
 
+// sessionKey 会话键，同一通道的不同码流各自独立
+func sessionKey(deviceID, channelID, quality string) string {
+	return QualityStreamID(fmt.Sprintf("%s_%s", deviceID, channelID), quality)
+}
+
 // GetSession 获取会话
-func (m *MediaSessionManager) GetSession(deviceID, channelID string) *MediaSession {
+func (m *MediaSessionManager) GetSession(deviceID, channelID, quality string) *MediaSession {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-	key := fmt.Sprintf("%s_%s", deviceID, channelID)
-	return m.sessions[key]
+	return m.sessions[sessionKey(deviceID, channelID, quality)]
 }
 
 // AddSession 添加会话
 func (m *MediaSessionManager) AddSession(session *MediaSession) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	key := fmt.Sprintf("%s_%s", session.DeviceID, session.ChannelID)
-	m.sessions[key] = session
+	m.sessions[sessionKey(session.DeviceID, session.ChannelID, session.Quality)] = session
 }
 
 // RemoveSession 移除会话
-func (m *MediaSessionManager) RemoveSession(deviceID, channelID string) {
+func (m *MediaSessionManager) RemoveSession(deviceID, channelID, quality string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	key := fmt.Sprintf("%s_%s", deviceID, channelID)
-	delete(m.sessions, key)
+	delete(m.sessions, sessionKey(deviceID, channelID, quality))
 }
 
 // GetAllSessions 获取所有会话
@@ -113,8 +209,10 @@ func generateStreamID(deviceID, channelID string) string {
 }
 
 // InviteRequest 发起实时视频请求
-// 向设备发送 INVITE 请求，请求设备推送 PS 流
-func (s *Server) InviteRequest(deviceID, channelID string, rtpPort int, mediaIP string) (*MediaSession, error) {
+// 向设备发送 INVITE 请求，请求设备推送 PS 流；quality 指定主/子/第三码流
+// streamMode 指定媒体传输模式，为空时使用设备配置的模式
+// 等待设备最终响应后返回：设备拒绝或事务超时返回错误
+func (s *Server) InviteRequest(deviceID, channelID, quality, streamMode string, rtpPort int, mediaIP string) (*MediaSession, error) {
 	// 获取设备信息
 	device, exists := s.GetDeviceByID(deviceID)
 	if !exists {
@@ -127,12 +225,24 @@ func (s *Server) InviteRequest(deviceID, channelID string, rtpPort int, mediaIP
 	}
 
 	// 检查是否已有会话
-	if session := sessionManager.GetSession(deviceID, channelID); session != nil {
-		if session.Status == "playing" {
+	quality, err := ParseStreamQuality(quality)
+	if err != nil {
+		return nil, err
+	}
+	if streamMode == "" {
+		streamMode = device.StreamMode
+	}
+	if streamMode, err = ParseStreamMode(streamMode); err != nil {
+		return nil, err
+	}
+
+	// 检查是否已有会话
+	if session := sessionManager.GetSession(deviceID, channelID, quality); session != nil {
+		if session.status() == "playing" {
 			return session, nil // 返回现有会话
 		}
 		// 清理旧会话
-		sessionManager.RemoveSession(deviceID, channelID)
+		sessionManager.RemoveSession(deviceID, channelID, quality)
 	}
 
 	// 确保 mediaIP 有效且设备可以访问
@@ -146,8 +256,7 @@ func (s *Server) InviteRequest(deviceID, channelID string, rtpPort int, mediaIP
 
 	// 尝试获取与设备在同一网段的本机IP（总是探测一次，避免选到不可达接口）
 	// 通过与设备通信的本地地址来获取IP
-	conn, err := net.Dial("udp", device.SipIP+":"+strconv.Itoa(device.SipPort))
-	if err == nil {
+	if conn, err := net.Dial("udp", device.SipIP+":"+strconv.Itoa(device.SipPort)); err == nil {
 		defer conn.Close()
 		localAddr := conn.LocalAddr().String()
 		if idx := strings.LastIndex(localAddr, ":"); idx > 0 {
@@ -165,7 +274,7 @@ func (s *Server) InviteRequest(deviceID, channelID string, rtpPort int, mediaIP
 	callID := generateCallID()
 	fromTag := generateTag()
 	ssrc := generateSSRC(s.config.Realm, false)
-	streamID := generateStreamID(deviceID, channelID)
+	streamID := QualityStreamID(generateStreamID(deviceID, channelID), quality)
 
 	// 创建会话
 	session := &MediaSession{
@@ -182,6 +291,9 @@ func (s *Server) InviteRequest(deviceID, channelID string, rtpPort int, mediaIP
 		MediaIP:    mediaIP,
 		MediaPort:  rtpPort,
 		Transport:  device.Transport,
+		Quality:    quality,
+		StreamMode: streamMode,
+		answered:   make(chan struct{}),
 	}
 
 	// 构建 SDP
@@ -190,22 +302,64 @@ func (s *Server) InviteRequest(deviceID, channelID string, rtpPort int, mediaIP
 	// 构建 INVITE 消息，优先使用 mediaIP 作为本地 SIP 地址
 	inviteMsg := s.buildInviteMessage(device, channelID, callID, fromTag, sdp, mediaIP)
 
-	debug.Info("gb28181", "INVITE: device=%s channel=%s rtp=%d ssrc=%s [%s]", deviceID, channelID, rtpPort, ssrc, device.Transport)
-
-	// 使用统一方法发送 INVITE（根据设备 Transport 自动选择 TCP/UDP）
-	err = s.SendSIPMessageToDevice(device, inviteMsg)
-	if err != nil {
-		return nil, fmt.Errorf("发送 INVITE 失败: %v", err)
-	}
+	debug.Info("gb28181", "INVITE: device=%s channel=%s quality=%s media=%s rtp=%d ssrc=%s [%s]", deviceID, channelID, quality, streamMode, rtpPort, ssrc, device.Transport)
 
 	// 保存会话
 	sessionManager.AddSession(session)
 
+	// 以事务发送 INVITE 并等待最终响应（根据设备 Transport 自动选择 TCP/UDP）
+	response, err := s.SendSIPRequest(device, inviteMsg)
+	if err != nil {
+		sessionManager.RemoveSession(deviceID, channelID, quality)
+		return nil, fmt.Errorf("发送 INVITE 失败: %w", err)
+	}
+	s.HandleInviteResponse(response.StatusCode, callID, extractTag(response.Headers["To"]), response.Body)
+	if response.StatusCode >= 300 {
+		sessionManager.RemoveSession(deviceID, channelID, quality)
+		return nil, fmt.Errorf("设备拒绝 INVITE: %d %s", response.StatusCode, response.Reason)
+	}
+
 	return session, nil
 }
 
+// WaitInviteAnswer 等待设备对 INVITE 的最终响应
+func (s *Server) WaitInviteAnswer(session *MediaSession, timeout time.Duration) error {
+	if session.answered == nil {
+		return nil
+	}
+
+	select {
+	case <-session.answered:
+	case <-time.After(timeout):
+		return fmt.Errorf("等待设备应答超时")
+	}
+	if session.status() != "playing" {
+		return fmt.Errorf("设备拒绝了 INVITE")
+	}
+	return nil
+}
+
 // buildInviteSDP 构建 INVITE SDP
 func (s *Server) buildInviteSDP(session *MediaSession, mediaIP string) string {
+	// 码流选择：GB/T 28181-2022 使用 a=streamnumber，部分厂商（海康、大华旧固件）使用 a=streamprofile
+	// 主码流不携带，保持与只支持默认码流的设备兼容
+	streamAttrs := ""
+	if session.Quality != "" && session.Quality != StreamQualityMain {
+		number := StreamNumber(session.Quality)
+		streamAttrs = fmt.Sprintf("a=streamnumber:%d\na=streamprofile:%d\n", number, number)
+	}
+
+	// TCP 媒体按 RFC 4571 协商连接方向：setup:passive 表示本端监听，setup:active 表示本端发起连接
+	mediaProto := "RTP/AVP"
+	switch session.StreamMode {
+	case StreamModeTCPPassive:
+		mediaProto = "TCP/RTP/AVP"
+		streamAttrs = "a=setup:passive\na=connection:new\n" + streamAttrs
+	case StreamModeTCPActive:
+		mediaProto = "TCP/RTP/AVP"
+		streamAttrs = "a=setup:active\na=connection:new\n" + streamAttrs
+	}
+
 	// SDP 内容
 	// GB28181 使用 PS 流封装
 	sdp := fmt.Sprintf(`v=0
@@ -213,11 +367,11 @@ o=%s 0 0 IN IP4 %s
 s=Play
 c=IN IP4 %s
 t=0 0
-m=video %d RTP/AVP 96
+m=video %d %s 96
 a=recvonly
 a=rtpmap:96 PS/90000
-y=%s
-`, s.config.ServerID, mediaIP, mediaIP, session.RTPPort, session.SSRC)
+%sy=%s
+`, s.config.ServerID, mediaIP, mediaIP, session.RTPPort, mediaProto, streamAttrs, session.SSRC)
 
 	return sdp
 }
@@ -234,8 +388,8 @@ func (s *Server) buildInviteMessage(device *Device, channelID, callID, fromTag,
 		localAddrIP = localIP
 	}
 
-	via := fmt.Sprintf("SIP/2.0/%s %s:%d;rport;branch=z9hG4bK%d",
-		device.Transport, localAddrIP, s.config.SipPort, time.Now().UnixNano())
+	via := fmt.Sprintf("SIP/2.0/%s %s:%d;rport;branch=%s",
+		device.Transport, localAddrIP, s.config.SipPort, generateBranch())
 
 	// From
 	from := fmt.Sprintf("<sip:%s@%s:%d>;tag=%s",
@@ -264,9 +418,14 @@ func (s *Server) buildInviteMessage(device *Device, channelID, callID, fromTag,
 	return msg
 }
 
-// ByeRequest 发送 BYE 请求停止流
+// ByeRequest 发送 BYE 请求停止主码流
 func (s *Server) ByeRequest(deviceID, channelID string) error {
-	session := sessionManager.GetSession(deviceID, channelID)
+	return s.ByeStreamRequest(deviceID, channelID, StreamQualityMain)
+}
+
+// ByeStreamRequest 发送 BYE 请求停止指定码流
+func (s *Server) ByeStreamRequest(deviceID, channelID, quality string) error {
+	session := sessionManager.GetSession(deviceID, channelID, quality)
 	if session == nil {
 		return fmt.Errorf("会话不存在")
 	}
@@ -274,7 +433,7 @@ func (s *Server) ByeRequest(deviceID, channelID string) error {
 	device, exists := s.GetDeviceByID(deviceID)
 	if !exists {
 		// 设备不存在，直接清理会话
-		sessionManager.RemoveSession(deviceID, channelID)
+		sessionManager.RemoveSession(deviceID, channelID, quality)
 		return nil
 	}
 
@@ -290,8 +449,8 @@ func (s *Server) ByeRequest(deviceID, channelID string) error {
 	}
 
 	// 清理会话
-	session.Status = "stopped"
-	sessionManager.RemoveSession(deviceID, channelID)
+	session.setStatus("stopped")
+	sessionManager.RemoveSession(deviceID, channelID, quality)
 
 	return nil
 }
@@ -302,8 +461,8 @@ func (s *Server) buildByeMessage(device *Device, session *MediaSession) string {
 	requestURI := fmt.Sprintf("sip:%s@%s:%d", session.ChannelID, device.SipIP, device.SipPort)
 
 	// Via
-	via := fmt.Sprintf("SIP/2.0/%s %s:%d;rport;branch=z9hG4bK%d",
-		device.Transport, s.config.SipIP, s.config.SipPort, time.Now().UnixNano())
+	via := fmt.Sprintf("SIP/2.0/%s %s:%d;rport;branch=%s",
+		device.Transport, s.config.SipIP, s.config.SipPort, generateBranch())
 
 	// From (与 INVITE 相同)
 	from := fmt.Sprintf("<sip:%s@%s:%d>;tag=%s",
@@ -311,8 +470,11 @@ func (s *Server) buildByeMessage(device *Device, session *MediaSession) string {
 
 	// To
 	to := fmt.Sprintf("<sip:%s@%s:%d>", session.ChannelID, device.SipIP, device.SipPort)
-	if session.ToTag != "" {
-		to += fmt.Sprintf(";tag=%s", session.ToTag)
+	session.mu.Lock()
+	toTag := session.ToTag
+	session.mu.Unlock()
+	if toTag != "" {
+		to += fmt.Sprintf(";tag=%s", toTag)
 	}
 
 	// 构建消息
@@ -329,29 +491,90 @@ func (s *Server) buildByeMessage(device *Device, session *MediaSession) string {
 	return msg
 }
 
-// HandleInviteResponse 处理 INVITE 响应
-func (s *Server) HandleInviteResponse(statusCode int, callID, toTag string) {
+// HandleInviteResponse 处理 INVITE 最终响应，sdp 为设备应答的媒体描述
+func (s *Server) HandleInviteResponse(statusCode int, callID, toTag, sdp string) {
+	if statusCode < 200 {
+		return
+	}
+
 	// 查找对应的会话
 	sessions := sessionManager.GetAllSessions()
 	for _, session := range sessions {
 		if session.CallID == callID {
+			session.mu.Lock()
 			session.ToTag = toTag
 			if statusCode == 200 {
 				session.Status = "playing"
 				session.StartTime = time.Now().Unix()
-				log.Printf("[GB28181] ✓ INVITE成功: %s/%s", session.DeviceID, session.ChannelID)
+				session.DeviceMediaIP, session.DeviceMediaPort = parseSDPMedia(sdp)
 			} else {
 				session.Status = "failed"
+			}
+			session.mu.Unlock()
+			if statusCode == 200 {
+				log.Printf("[GB28181] ✓ INVITE成功: %s/%s", session.DeviceID, session.ChannelID)
+			} else {
 				debug.Warn("gb28181", "INVITE失败: status=%d device=%s", statusCode, session.DeviceID)
 			}
+			if session.answered != nil {
+				session.answeredOnce.Do(func() { close(session.answered) })
+			}
 			return
 		}
 	}
 }
 
-// GetMediaSession 获取媒体会话
+// extractTag 从 From/To 头中提取 tag 参数
+func extractTag(header string) string {
+	idx := strings.Index(header, ";tag=")
+	if idx < 0 {
+		return ""
+	}
+	tag := header[idx+len(";tag="):]
+	if end := strings.IndexAny(tag, ";>\r\n "); end >= 0 {
+		tag = tag[:end]
+	}
+	return tag
+}
+
+// parseSDPMedia 解析 SDP 中的连接地址和视频端口
+func parseSDPMedia(sdp string) (string, int) {
+	var ip string
+	var port int
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "c=") {
+			if fields := strings.Fields(line); len(fields) >= 3 {
+				ip = fields[2]
+			}
+		} else if strings.HasPrefix(line, "m=video") {
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				port, _ = strconv.Atoi(fields[1])
+			}
+		}
+	}
+	return ip, port
+}
+
+// GetStreamSession 获取指定码流的媒体会话
+func (s *Server) GetStreamSession(deviceID, channelID, quality string) *MediaSession {
+	return sessionManager.GetSession(deviceID, channelID, quality)
+}
+
+// MediaFallbackTimeout UDP 取流无数据时回退到 TCP 被动模式的等待时间，0 表示禁用回退
+func (s *Server) MediaFallbackTimeout() time.Duration {
+	switch {
+	case s.config.MediaFallbackTimeout < 0:
+		return 0
+	case s.config.MediaFallbackTimeout == 0:
+		return 5 * time.Second
+	}
+	return time.Duration(s.config.MediaFallbackTimeout) * time.Second
+}
+
+// GetMediaSession 获取主码流媒体会话
 func (s *Server) GetMediaSession(deviceID, channelID string) *MediaSession {
-	return sessionManager.GetSession(deviceID, channelID)
+	return sessionManager.GetSession(deviceID, channelID, StreamQualityMain)
 }
 
 // GetAllMediaSessions 获取所有媒体会话
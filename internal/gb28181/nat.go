@@ -0,0 +1,159 @@
+package gb28181
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+// defaultNATKeepaliveInterval 默认 OPTIONS 保活间隔，小于常见家用/4G 路由器 30 秒的 UDP 映射老化时间
+const defaultNATKeepaliveInterval = 20 * time.Second
+
+// addrIPPort 从网络地址中提取 IP 和端口
+func addrIPPort(addr net.Addr) (string, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String(), a.Port
+	case *net.TCPAddr:
+		return a.IP.String(), a.Port
+	}
+	return "", 0
+}
+
+// stampVia 按 RFC 3581/3261 在请求的顶层 Via 上记录实际源地址
+// 请求携带 rport 时填入源端口；携带 rport 或 sent-by 与源 IP 不一致时添加 received
+// 响应从请求复制 Via，因此设备能从响应中得知自己的 NAT 映射地址
+func stampVia(message *SIPMessage, ip string, port int) {
+	via := message.Headers["Via"]
+	if via == "" || message.IsResponse {
+		return
+	}
+
+	// 多个 Via 合并在一行时只处理第一个
+	top, rest := via, ""
+	if idx := strings.Index(via, ","); idx >= 0 {
+		top, rest = via[:idx], via[idx:]
+	}
+
+	params := strings.Split(top, ";")
+	sentBy := ""
+	if fields := strings.Fields(params[0]); len(fields) >= 2 {
+		sentBy = fields[1]
+	}
+	host := sentBy
+	if h, _, err := net.SplitHostPort(sentBy); err == nil {
+		host = h
+	}
+
+	hasRport := false
+	stamped := []string{params[0]}
+	for _, param := range params[1:] {
+		name := strings.ToLower(strings.TrimSpace(strings.SplitN(param, "=", 2)[0]))
+		switch name {
+		case "rport":
+			hasRport = true
+			stamped = append(stamped, fmt.Sprintf("rport=%d", port))
+		case "received":
+			// 丢弃设备自带的 received，以实际源地址为准
+		default:
+			stamped = append(stamped, param)
+		}
+	}
+	if hasRport || host != ip {
+		stamped = append(stamped, "received="+ip)
+	}
+
+	message.Headers["Via"] = strings.Join(stamped, ";") + rest
+}
+
+// recordDeviceAddresses 记录设备 Contact 声明地址与实际源地址，用于 NAT 诊断
+func (s *Server) recordDeviceAddresses(deviceID, contactIP string, contactPort int, sourceIP string, sourcePort int) {
+	s.devicesMux.Lock()
+	defer s.devicesMux.Unlock()
+
+	device, ok := s.devices[deviceID]
+	if !ok {
+		return
+	}
+	device.ContactIP = contactIP
+	device.ContactPort = contactPort
+	device.SourceIP = sourceIP
+	device.SourcePort = sourcePort
+	device.BehindNAT = behindNAT(device.Transport, contactIP, contactPort, sourceIP, sourcePort)
+}
+
+// behindNAT 判断 Contact 声明地址与实际源地址是否不一致
+// TCP/TLS 连接的源端口是临时端口，与 Contact 中的监听端口天然不同，只比较 IP
+func behindNAT(transport, contactIP string, contactPort int, sourceIP string, sourcePort int) bool {
+	if contactIP == "" {
+		return false
+	}
+	if transport == "TCP" || transport == "TLS" {
+		return contactIP != sourceIP
+	}
+	return contactIP != sourceIP || contactPort != sourcePort
+}
+
+// natKeepaliveInterval OPTIONS 保活间隔，0 表示禁用
+func (s *Server) natKeepaliveInterval() time.Duration {
+	switch {
+	case s.config.NATKeepaliveInterval < 0:
+		return 0
+	case s.config.NATKeepaliveInterval == 0:
+		return defaultNATKeepaliveInterval
+	}
+	return time.Duration(s.config.NATKeepaliveInterval) * time.Second
+}
+
+// natKeepalive 定期向在线的 UDP 设备发送 OPTIONS，保持 NAT 映射，确保平台能主动下发 MESSAGE/INVITE
+func (s *Server) natKeepalive() {
+	interval := s.natKeepaliveInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.devicesMux.RLock()
+			devices := make([]*Device, 0, len(s.devices))
+			for _, device := range s.devices {
+				if device.Status == "online" && device.Transport == "UDP" {
+					devices = append(devices, device)
+				}
+			}
+			s.devicesMux.RUnlock()
+
+			for _, device := range devices {
+				if err := s.SendSIPMessageToDevice(device, s.buildOptionsMessage(device)); err != nil {
+					debug.Debug("gb28181", "OPTIONS保活发送失败: device=%s error=%v", device.DeviceID, err)
+				}
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// buildOptionsMessage 构建 OPTIONS 保活请求
+func (s *Server) buildOptionsMessage(device *Device) string {
+	return fmt.Sprintf("OPTIONS sip:%s@%s:%d SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP %s:%d;rport;branch=%s\r\n"+
+		"From: <sip:%s@%s>;tag=%s\r\n"+
+		"To: <sip:%s@%s:%d>\r\n"+
+		"Call-ID: %s@%s\r\n"+
+		"CSeq: 1 OPTIONS\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"Content-Length: 0\r\n\r\n",
+		device.DeviceID, device.SipIP, device.SipPort,
+		s.config.SipIP, s.config.SipPort, generateBranch(),
+		s.config.ServerID, s.config.Realm, generateTag(),
+		device.DeviceID, device.SipIP, device.SipPort,
+		generateCallID(), s.config.SipIP)
+}
@@ -0,0 +1,52 @@
+package gb28181
+
+import (
+	"testing"
+
+	"gb28181-onvif-server/internal/config"
+)
+
+func TestBehindNAT(t *testing.T) {
+	cases := []struct {
+		name        string
+		transport   string
+		contactIP   string
+		contactPort int
+		sourceIP    string
+		sourcePort  int
+		want        bool
+	}{
+		{"UDPSameAddress", "UDP", "192.168.1.10", 5060, "192.168.1.10", 5060, false},
+		{"UDPPortMapped", "UDP", "192.168.1.10", 5060, "192.168.1.10", 40000, true},
+		{"UDPAddressMapped", "UDP", "10.0.0.5", 5060, "203.0.113.7", 5060, true},
+		{"TCPEphemeralPort", "TCP", "192.168.1.10", 5060, "192.168.1.10", 53122, false},
+		{"TLSEphemeralPort", "TLS", "192.168.1.10", 5061, "192.168.1.10", 53122, false},
+		{"TCPAddressMapped", "TCP", "10.0.0.5", 5060, "203.0.113.7", 53122, true},
+		{"NoContact", "UDP", "", 0, "203.0.113.7", 5060, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := behindNAT(tc.transport, tc.contactIP, tc.contactPort, tc.sourceIP, tc.sourcePort); got != tc.want {
+				t.Errorf("behindNAT = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeepaliveRecomputesNAT(t *testing.T) {
+	srv := NewServer(&config.GB28181Config{SipIP: "127.0.0.1", Realm: "3402000000", ServerID: "34020000002000000001"})
+	deviceID := "34020000001320000001"
+
+	srv.RegisterDevice(deviceID, "", "192.168.1.10", 5060, 3600)
+	srv.recordDeviceAddresses(deviceID, "192.168.1.10", 5060, "192.168.1.10", 5060)
+	if device, _ := srv.GetDeviceByID(deviceID); device.BehindNAT {
+		t.Fatal("地址一致时不应判定为 NAT")
+	}
+
+	// 心跳源地址变化（NAT 重新映射）
+	srv.UpdateKeepAliveWithAddr(deviceID, "203.0.113.7", 40000)
+	device, _ := srv.GetDeviceByID(deviceID)
+	if !device.BehindNAT || device.SourceIP != "203.0.113.7" || device.SourcePort != 40000 {
+		t.Fatalf("心跳后 NAT 状态未更新: behindNAT=%v source=%s:%d", device.BehindNAT, device.SourceIP, device.SourcePort)
+	}
+}
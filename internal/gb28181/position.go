@@ -0,0 +1,204 @@
+package gb28181
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+const (
+	// defaultPositionRetention 默认轨迹点保留时长
+	defaultPositionRetention = 24 * time.Hour
+	// defaultMaxTrackPoints 默认单个通道最多保留的轨迹点数（按 5 秒上报约 14 小时）
+	defaultMaxTrackPoints = 10000
+)
+
+// positionRetention 轨迹点保留时长
+func (s *Server) positionRetention() time.Duration {
+	if s.config.PositionRetention > 0 {
+		return time.Duration(s.config.PositionRetention) * time.Hour
+	}
+	return defaultPositionRetention
+}
+
+// maxTrackPoints 单个通道最多保留的轨迹点数
+func (s *Server) maxTrackPoints() int {
+	if s.config.MaxTrackPoints > 0 {
+		return s.config.MaxTrackPoints
+	}
+	return defaultMaxTrackPoints
+}
+
+// MobilePositionNotify 移动设备位置通知（NOTIFY 或 MESSAGE 携带）
+type MobilePositionNotify struct {
+	CmdType   string `xml:"CmdType"`
+	SN        int    `xml:"SN"`
+	DeviceID  string `xml:"DeviceID"`
+	Time      string `xml:"Time"`
+	Longitude string `xml:"Longitude"`
+	Latitude  string `xml:"Latitude"`
+	Speed     string `xml:"Speed"`     // 速度（km/h）
+	Direction string `xml:"Direction"` // 方向（与正北方向顺时针夹角，度）
+	Altitude  string `xml:"Altitude"`  // 海拔（米）
+}
+
+// Position 轨迹点
+type Position struct {
+	DeviceID  string    `json:"deviceId"`
+	ChannelID string    `json:"channelId"`
+	Time      time.Time `json:"time"`
+	Longitude float64   `json:"longitude"`
+	Latitude  float64   `json:"latitude"`
+	Speed     float64   `json:"speed"`
+	Direction float64   `json:"direction"`
+	Altitude  float64   `json:"altitude"`
+}
+
+// parseMobilePosition 解析移动位置通知，记录轨迹并更新通道坐标
+func (s *Server) parseMobilePosition(deviceID string, body string) {
+	body = strings.Replace(body, `encoding="GB2312"`, `encoding="UTF-8"`, 1)
+	body = strings.Replace(body, `encoding='GB2312'`, `encoding='UTF-8'`, 1)
+
+	var notify MobilePositionNotify
+	if err := xml.Unmarshal([]byte(body), &notify); err != nil {
+		debug.Warn("gb28181", "解析移动位置通知失败: %v", err)
+		return
+	}
+
+	longitude, errLon := strconv.ParseFloat(strings.TrimSpace(notify.Longitude), 64)
+	latitude, errLat := strconv.ParseFloat(strings.TrimSpace(notify.Latitude), 64)
+	if errLon != nil || errLat != nil || (longitude == 0 && latitude == 0) ||
+		longitude < -180 || longitude > 180 || latitude < -90 || latitude > 90 {
+		debug.Debug("gb28181", "忽略无效的移动位置: 设备=%s 经度=%s 纬度=%s", deviceID, notify.Longitude, notify.Latitude)
+		return
+	}
+
+	channelID := notify.DeviceID
+	if channelID == "" {
+		channelID = deviceID
+	}
+
+	position := Position{
+		DeviceID:  deviceID,
+		ChannelID: channelID,
+		Time:      parsePositionTime(notify.Time),
+		Longitude: longitude,
+		Latitude:  latitude,
+	}
+	position.Speed, _ = strconv.ParseFloat(strings.TrimSpace(notify.Speed), 64)
+	position.Direction, _ = strconv.ParseFloat(strings.TrimSpace(notify.Direction), 64)
+	position.Altitude, _ = strconv.ParseFloat(strings.TrimSpace(notify.Altitude), 64)
+
+	s.addPosition(position)
+
+	debug.Debug("gb28181", "移动位置: 通道=%s 经度=%.6f 纬度=%.6f 速度=%.1f 方向=%.1f",
+		channelID, longitude, latitude, position.Speed, position.Direction)
+}
+
+// parsePositionTime 解析设备上报时间（本地时间，无时区），无法解析时使用接收时间
+func parsePositionTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// addPosition 记录轨迹点，并更新通道的最新坐标
+func (s *Server) addPosition(position Position) {
+	s.positionMux.Lock()
+	track := s.positions[position.ChannelID]
+	// 设备补传的历史位置可能乱序到达，按时间插入
+	idx := sort.Search(len(track), func(i int) bool { return track[i].Time.After(position.Time) })
+	track = append(track, Position{})
+	copy(track[idx+1:], track[idx:])
+	track[idx] = position
+
+	cutoff := time.Now().Add(-s.positionRetention())
+	start := sort.Search(len(track), func(i int) bool { return !track[i].Time.Before(cutoff) })
+	if limit := s.maxTrackPoints(); len(track)-start > limit {
+		start = len(track) - limit
+		s.trackTruncated[position.ChannelID] = track[start].Time
+	}
+	if start > 0 {
+		track = append([]Position(nil), track[start:]...)
+	}
+	if len(track) == 0 {
+		// 补传的位置已超出保留时长
+		delete(s.positions, position.ChannelID)
+		s.positionMux.Unlock()
+		return
+	}
+	s.positions[position.ChannelID] = track
+	latest := track[len(track)-1]
+	s.positionMux.Unlock()
+
+	s.devicesMux.Lock()
+	if channel, ok := s.channels[position.ChannelID]; ok {
+		channel.Longitude = strconv.FormatFloat(latest.Longitude, 'f', 6, 64)
+		channel.Latitude = strconv.FormatFloat(latest.Latitude, 'f', 6, 64)
+	}
+	s.devicesMux.Unlock()
+}
+
+// GetTrack 获取通道在 [start, end] 时间范围内的轨迹，零值表示不限制
+// 轨迹只在内存中保留 PositionRetention 时长和 MaxTrackPoints 个点，
+// start 早于仍保留的最早时间时返回错误，避免把不完整的轨迹当作完整结果
+func (s *Server) GetTrack(channelID string, start, end time.Time) ([]Position, error) {
+	s.positionMux.RLock()
+	defer s.positionMux.RUnlock()
+
+	earliest := time.Now().Add(-s.positionRetention())
+	if truncated, ok := s.trackTruncated[channelID]; ok && truncated.After(earliest) {
+		earliest = truncated
+	}
+	if !start.IsZero() && start.Before(earliest) {
+		return nil, fmt.Errorf("轨迹仅保留 %s 之后的数据（保留 %s，每通道最多 %d 个点）",
+			earliest.Format(time.RFC3339), s.positionRetention(), s.maxTrackPoints())
+	}
+
+	var track []Position
+	for _, position := range s.positions[channelID] {
+		if position.Time.Before(earliest) {
+			continue
+		}
+		if !start.IsZero() && position.Time.Before(start) {
+			continue
+		}
+		if !end.IsZero() && position.Time.After(end) {
+			break
+		}
+		track = append(track, position)
+	}
+	return track, nil
+}
+
+// GetLatestPositions 获取每个设备的最新位置（取该设备所有通道中最新的轨迹点）
+func (s *Server) GetLatestPositions() []Position {
+	s.positionMux.RLock()
+	latest := make(map[string]Position)
+	for _, track := range s.positions {
+		if len(track) == 0 {
+			continue
+		}
+		position := track[len(track)-1]
+		if current, ok := latest[position.DeviceID]; !ok || position.Time.After(current.Time) {
+			latest[position.DeviceID] = position
+		}
+	}
+	s.positionMux.RUnlock()
+
+	positions := make([]Position, 0, len(latest))
+	for _, position := range latest {
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].DeviceID < positions[j].DeviceID })
+	return positions
+}
@@ -0,0 +1,45 @@
+package gb28181
+
+import (
+	"testing"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+)
+
+func TestTrackRetention(t *testing.T) {
+	srv := NewServer(&config.GB28181Config{PositionRetention: 1, MaxTrackPoints: 3})
+	channelID := "34020000001320000001"
+	now := time.Now()
+
+	// 超出保留时长的点在插入时被丢弃
+	srv.addPosition(Position{ChannelID: channelID, Time: now.Add(-2 * time.Hour), Longitude: 116, Latitude: 39})
+	for i := 4; i >= 1; i-- {
+		srv.addPosition(Position{ChannelID: channelID, Time: now.Add(-time.Duration(i) * time.Minute), Longitude: 116, Latitude: 39})
+	}
+
+	track, err := srv.GetTrack(channelID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("GetTrack: %v", err)
+	}
+	if len(track) != 3 || !track[0].Time.Equal(now.Add(-3*time.Minute)) {
+		t.Fatalf("轨迹 = %d 个点，首点 %v", len(track), track)
+	}
+
+	cases := []struct {
+		name    string
+		start   time.Time
+		wantErr bool
+	}{
+		{"WithinWindow", now.Add(-3 * time.Minute), false},
+		{"TruncatedByPointLimit", now.Add(-5 * time.Minute), true},
+		{"BeforeRetention", now.Add(-2 * time.Hour), true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := srv.GetTrack(channelID, tc.start, time.Time{}); (err != nil) != tc.wantErr {
+				t.Errorf("GetTrack err = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package gb28181
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+// querySN 查询命令序列号，递增保证同一时刻的查询 SN 不重复
+var querySN = time.Now().Unix() % 100000
+
+// nextSN 生成查询 SN
+func nextSN() int64 {
+	return atomic.AddInt64(&querySN, 1)
+}
+
+// pendingQuery 等待设备应答的查询，按 CmdType + SN 关联设备分包返回的 Response
+type pendingQuery struct {
+	cmdType  string
+	sn       int64
+	targetID string // Catalog/DeviceInfo 为设备ID，RecordInfo 为通道ID
+
+	mu        sync.Mutex
+	responses int // 已收到的 Response 包数
+	sumNum    int // 设备声明的总条数
+	received  int // 已收到的条数
+	channels  []*Channel
+	records   []DeviceRecordInfo
+	info      *DeviceInfoResponse
+	status    *DeviceStatus
+	presets   []PresetItem
+	result    string // DeviceControl/DeviceConfig/ConfigDownload 应答结果
+	err       error
+	done      chan struct{}
+	doneOnce  sync.Once
+
+	config      *DeviceConfig // ConfigDownload 合并后的配置
+	configTypes []string      // ConfigDownload 请求的配置类型
+}
+
+// finish 结束查询
+func (q *pendingQuery) finish(err error) {
+	q.doneOnce.Do(func() {
+		q.mu.Lock()
+		q.err = err
+		q.mu.Unlock()
+		close(q.done)
+	})
+}
+
+// complete 是否已收齐 SumNum 条结果
+func (q *pendingQuery) complete() bool {
+	return q.responses > 0 && q.received >= q.sumNum
+}
+
+// queryKey 查询表键
+func queryKey(cmdType string, sn int64) string {
+	return fmt.Sprintf("%s|%d", cmdType, sn)
+}
+
+// registerQuery 登记等待中的查询（需在发送前登记，避免响应先到）
+func (s *Server) registerQuery(cmdType string, sn int64, targetID string) *pendingQuery {
+	q := &pendingQuery{
+		cmdType:  cmdType,
+		sn:       sn,
+		targetID: targetID,
+		done:     make(chan struct{}),
+	}
+	s.queryMux.Lock()
+	s.queries[queryKey(cmdType, sn)] = q
+	s.queryMux.Unlock()
+	return q
+}
+
+// unregisterQuery 移除查询
+func (s *Server) unregisterQuery(q *pendingQuery) {
+	s.queryMux.Lock()
+	if s.queries[queryKey(q.cmdType, q.sn)] == q {
+		delete(s.queries, queryKey(q.cmdType, q.sn))
+	}
+	s.queryMux.Unlock()
+}
+
+// deliverQuery 将设备 Response 交给对应的查询，按 SN 匹配
+// 部分设备不回填 SN（或回填 0），此时按 CmdType + 目标ID 匹配唯一的等待查询；
+// SN 非 0 但未登记的应答属于其他查询（如保活触发的异步目录查询），不参与匹配
+func (s *Server) deliverQuery(cmdType string, sn int64, targetID string, sumNum int, apply func(q *pendingQuery) int) {
+	s.queryMux.Lock()
+	q, ok := s.queries[queryKey(cmdType, sn)]
+	if !ok && sn == 0 {
+		var matched []*pendingQuery
+		for _, candidate := range s.queries {
+			if candidate.cmdType == cmdType && candidate.targetID == targetID {
+				matched = append(matched, candidate)
+			}
+		}
+		if len(matched) == 1 {
+			q, ok = matched[0], true
+		}
+	}
+	s.queryMux.Unlock()
+	if !ok {
+		return
+	}
+
+	q.mu.Lock()
+	q.responses++
+	q.sumNum = sumNum
+	q.received += apply(q)
+	received := q.received
+	complete := q.complete()
+	q.mu.Unlock()
+
+	debug.Debug("gb28181", "查询应答: %s SN=%d 已收 %d/%d", cmdType, q.sn, received, sumNum)
+	if complete {
+		q.finish(nil)
+	}
+}
+
+// runQuery 以事务发送查询 MESSAGE 并等待设备 Response 收齐或 ctx 到期
+// 设备拒绝或事务超时时立即返回错误；ctx 到期时返回已收到的部分结果和 ctx 错误
+func (s *Server) runQuery(ctx context.Context, device *Device, q *pendingQuery, message string) error {
+	defer s.unregisterQuery(q)
+
+	go func() {
+		response, err := s.SendSIPRequest(device, message)
+		if err == nil && response.StatusCode >= 300 {
+			err = fmt.Errorf("设备拒绝查询: %d %s", response.StatusCode, response.Reason)
+		}
+		if err != nil {
+			q.finish(err)
+		}
+	}()
+
+	select {
+	case <-q.done:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.err
+	case <-ctx.Done():
+		return fmt.Errorf("等待设备应答%s: %w", q.cmdType, ctx.Err())
+	}
+}
+
+// QueryCatalogSync 查询设备目录并等待全部分包返回，返回本次查询到的通道
+func (s *Server) QueryCatalogSync(ctx context.Context, deviceID string) ([]*Channel, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return nil, fmt.Errorf("设备 %s 不存在", deviceID)
+	}
+
+	sn := nextSN()
+	q := s.registerQuery("Catalog", sn, deviceID)
+	err := s.runQuery(ctx, device, q, s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", buildCatalogQueryXML(deviceID, sn)))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.channels, err
+}
+
+// QueryDeviceInfoSync 查询设备信息并等待设备返回
+func (s *Server) QueryDeviceInfoSync(ctx context.Context, deviceID string) (*DeviceInfoResponse, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return nil, fmt.Errorf("设备 %s 不存在", deviceID)
+	}
+
+	sn := nextSN()
+	q := s.registerQuery("DeviceInfo", sn, deviceID)
+	err := s.runQuery(ctx, device, q, s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", buildDeviceInfoQueryXML(deviceID, sn)))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.info, err
+}
+
+// QueryRecordInfoSync 查询通道录像并等待全部分包返回
+func (s *Server) QueryRecordInfoSync(ctx context.Context, channelID, startTime, endTime, recordType string) ([]DeviceRecordInfo, error) {
+	device := s.findChannelDevice(channelID)
+	if device == nil {
+		return nil, fmt.Errorf("未找到通道 %s 所属的设备", channelID)
+	}
+
+	sn := nextSN()
+	q := s.registerQuery("RecordInfo", sn, channelID)
+	err := s.runQuery(ctx, device, q, s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", buildRecordInfoQueryXML(channelID, startTime, endTime, recordType, sn)))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.records, err
+}
+
+// QueryDeviceStatusSync 查询设备状态并等待设备返回
+func (s *Server) QueryDeviceStatusSync(ctx context.Context, deviceID string) (*DeviceStatus, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return nil, fmt.Errorf("设备 %s 不存在", deviceID)
+	}
+
+	sn := nextSN()
+	q := s.registerQuery("DeviceStatus", sn, deviceID)
+	err := s.runQuery(ctx, device, q, s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", buildDeviceStatusQueryXML(deviceID, sn)))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.status, err
+}
+
+// QueryPresetsSync 查询通道预置位列表并等待设备返回
+func (s *Server) QueryPresetsSync(ctx context.Context, deviceID, channelID string) ([]PresetItem, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return nil, fmt.Errorf("设备 %s 不存在", deviceID)
+	}
+	if channelID == "" {
+		channelID = deviceID
+	}
+
+	sn := nextSN()
+	queryXML := fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Query>
+<CmdType>PresetQuery</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+</Query>`, sn, channelID)
+
+	q := s.registerQuery("PresetQuery", sn, channelID)
+	err := s.runQuery(ctx, device, q, s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", queryXML))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.presets, err
+}
+
+// ParseConfigTypes 解析配置类型列表（逗号或 / 分隔），为空时查询基本参数
+func ParseConfigTypes(value string) ([]string, error) {
+	known := map[string]string{
+		strings.ToLower(ConfigTypeBasicParam):       ConfigTypeBasicParam,
+		strings.ToLower(ConfigTypeVideoParamOpt):    ConfigTypeVideoParamOpt,
+		strings.ToLower(ConfigTypeSVACEncodeConfig): ConfigTypeSVACEncodeConfig,
+		strings.ToLower(ConfigTypeSVACDecodeConfig): ConfigTypeSVACDecodeConfig,
+	}
+
+	var types []string
+	seen := make(map[string]bool)
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '/' }) {
+		configType, ok := known[strings.ToLower(strings.TrimSpace(item))]
+		if !ok {
+			return nil, fmt.Errorf("不支持的配置类型: %s", item)
+		}
+		if !seen[configType] {
+			seen[configType] = true
+			types = append(types, configType)
+		}
+	}
+	if len(types) == 0 {
+		types = []string{ConfigTypeBasicParam}
+	}
+	return types, nil
+}
+
+// configTypeCount 统计应答中包含的已请求配置类型数
+func configTypeCount(config *DeviceConfig, types []string) int {
+	count := 0
+	for _, configType := range types {
+		switch {
+		case configType == ConfigTypeBasicParam && config.BasicParam != nil,
+			configType == ConfigTypeVideoParamOpt && config.VideoParamOpt != nil,
+			configType == ConfigTypeSVACEncodeConfig && config.SVACEncodeConfig != nil,
+			configType == ConfigTypeSVACDecodeConfig && config.SVACDecodeConfig != nil:
+			count++
+		}
+	}
+	return count
+}
+
+// QueryConfigSync 查询设备配置（ConfigDownload），等待所有请求的配置类型返回
+// 设备可能将多个配置类型合并在一个应答中，也可能分多次返回，按 SN 合并
+func (s *Server) QueryConfigSync(ctx context.Context, deviceID, channelID string, types []string) (*DeviceConfig, error) {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return nil, fmt.Errorf("设备 %s 不存在", deviceID)
+	}
+	if channelID == "" {
+		channelID = deviceID
+	}
+
+	sn := nextSN()
+	queryXML := fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Query>
+<CmdType>ConfigDownload</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<ConfigType>%s</ConfigType>
+</Query>`, sn, channelID, strings.Join(types, "/"))
+
+	q := s.registerQuery("ConfigDownload", sn, channelID)
+	q.config = &DeviceConfig{}
+	q.configTypes = types
+	err := s.runQuery(ctx, device, q, s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", queryXML))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err == nil && q.result != "" && !strings.EqualFold(q.result, "OK") {
+		err = fmt.Errorf("设备返回配置查询失败: %s", q.result)
+	}
+	return q.config, err
+}
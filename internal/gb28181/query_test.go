@@ -0,0 +1,78 @@
+package gb28181
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gb28181-onvif-server/internal/config"
+)
+
+// catalogResponseXML 构造目录应答，channels 为本包携带的通道ID
+func catalogResponseXML(deviceID string, sn, sumNum int, channels ...string) string {
+	var items strings.Builder
+	for _, channelID := range channels {
+		fmt.Fprintf(&items, "<Item><DeviceID>%s</DeviceID><Name>%s</Name><Status>ON</Status></Item>", channelID, channelID)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Response>
+<CmdType>Catalog</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<SumNum>%d</SumNum>
+<DeviceList Num="%d">%s</DeviceList>
+</Response>`, sn, deviceID, sumNum, len(channels), items.String())
+}
+
+// queryDone 查询是否已结束
+func queryDone(q *pendingQuery) bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDeliverQueryCatalogBySN(t *testing.T) {
+	s := NewServer(&config.GB28181Config{})
+	deviceID := "34020000001110000001"
+	q := s.registerQuery("Catalog", 100, deviceID)
+	defer s.unregisterQuery(q)
+
+	// 保活触发的异步目录查询使用其他 SN，应答不能并入同步查询
+	s.parseCatalogResponse(deviceID, catalogResponseXML(deviceID, 101, 1, "34020000001320000009"))
+	if q.responses != 0 || len(q.channels) != 0 {
+		t.Fatalf("其他 SN 的应答被并入查询: responses=%d channels=%d", q.responses, len(q.channels))
+	}
+
+	// 分包返回，收齐 SumNum 条后结束
+	s.parseCatalogResponse(deviceID, catalogResponseXML(deviceID, 100, 3, "34020000001320000001", "34020000001320000002"))
+	if queryDone(q) {
+		t.Fatal("未收齐 SumNum 条时不应结束")
+	}
+	s.parseCatalogResponse(deviceID, catalogResponseXML(deviceID, 100, 3, "34020000001320000003"))
+	if !queryDone(q) {
+		t.Fatalf("收齐后应结束: received=%d sumNum=%d", q.received, q.sumNum)
+	}
+	if q.responses != 2 || q.received != 3 || len(q.channels) != 3 {
+		t.Errorf("responses=%d received=%d channels=%d", q.responses, q.received, len(q.channels))
+	}
+}
+
+func TestDeliverQueryWithoutSN(t *testing.T) {
+	s := NewServer(&config.GB28181Config{})
+	deviceID := "34020000001110000001"
+	q := s.registerQuery("Catalog", 200, deviceID)
+	defer s.unregisterQuery(q)
+
+	// 设备不回填 SN 时按目标设备匹配
+	s.parseCatalogResponse("34020000001110000002", catalogResponseXML("34020000001110000002", 0, 1, "34020000001320000011"))
+	if q.responses != 0 {
+		t.Fatal("其他设备的应答被并入查询")
+	}
+	s.parseCatalogResponse(deviceID, catalogResponseXML(deviceID, 0, 1, "34020000001320000001"))
+	if !queryDone(q) || len(q.channels) != 1 {
+		t.Errorf("SN 为 0 的应答未按设备匹配: done=%v channels=%d", queryDone(q), len(q.channels))
+	}
+}
@@ -18,6 +18,7 @@ import (
 type Server struct {
 	config           *config.GB28181Config
 	listener         net.Listener // TCP 监听器
+	tlsListener      net.Listener // TLS 监听器
 	udpConn          *net.UDPConn // UDP 连接
 	devices          map[string]*Device
 	channels         map[string]*Channel // 通道列表
@@ -29,6 +30,13 @@ type Server struct {
 	playbackSessions map[string]*PlaybackSession   // 录像回放会话，key为streamID
 	playbackMux      sync.RWMutex                  // 回放会话锁
 	localIP          string                        // 本地可达 IP (用于向设备告诉 RTP 接收地址)
+	transactions     *transactionTable             // SIP 事务表
+	queries          map[string]*pendingQuery      // 等待设备应答的查询，key 为 CmdType|SN
+	queryMux         sync.Mutex                    // 查询表锁
+	positions        map[string][]Position         // 移动位置轨迹，key为channelID，按时间升序
+	trackTruncated   map[string]time.Time          // 因点数上限丢弃过轨迹点的通道，值为最早保留点的时间
+	positionMux      sync.RWMutex                  // 轨迹锁
+	admission        *AdmissionManager             // 设备准入策略
 }
 
 // PlaybackSession 录像回放会话
@@ -56,44 +64,59 @@ type PlaybackInfo struct {
 
 // Device GB28181设备结构体
 type Device struct {
-	DeviceID        string     `json:"deviceId"`
-	Name            string     `json:"name"`
-	Manufacturer    string     `json:"manufacturer"`
-	Model           string     `json:"model"`
-	Firmware        string     `json:"firmware"`
-	Status          string     `json:"status"`
-	SipIP           string     `json:"sipIP"`
-	SipPort         int        `json:"sipPort"`
-	Transport       string     `json:"transport"` // TCP/UDP
-	RegisterTime    int64      `json:"registerTime"`
-	LastKeepAlive   int64      `json:"lastKeepAlive"`
-	Expires         int        `json:"expires"`
-	Channels        []*Channel `json:"channels"`
-	ChannelCount    int        `json:"channelCount"`
-	OnlineChannels  int        `json:"onlineChannels"`
-	PTZSupported    bool       `json:"ptzSupported"`
-	RecordSupported bool       `json:"recordSupported"`
-	StreamMode      string     `json:"streamMode"` // TCP-Active, TCP-Passive, UDP
-	TCPConn         net.Conn   `json:"-"`          // TCP连接（用于复用）
-	ConnMux         sync.Mutex `json:"-"`          // 连接锁
+	DeviceID         string         `json:"deviceId"`
+	Name             string         `json:"name"`
+	Manufacturer     string         `json:"manufacturer"`
+	Model            string         `json:"model"`
+	Firmware         string         `json:"firmware"`
+	Status           string         `json:"status"`
+	SipIP            string         `json:"sipIP"`
+	SipPort          int            `json:"sipPort"`
+	Transport        string         `json:"transport"` // TCP/UDP/TLS
+	RegisterTime     int64          `json:"registerTime"`
+	LastKeepAlive    int64          `json:"lastKeepAlive"`
+	Expires          int            `json:"expires"`
+	Channels         []*Channel     `json:"channels"`
+	ChannelCount     int            `json:"channelCount"`
+	OnlineChannels   int            `json:"onlineChannels"`
+	PTZSupported     bool           `json:"ptzSupported"`
+	RecordSupported  bool           `json:"recordSupported"`
+	StreamMode       string         `json:"streamMode"`                // TCP-Active, TCP-Passive, UDP
+	ContactIP        string         `json:"contactIP,omitempty"`       // REGISTER Contact 头声明的地址
+	ContactPort      int            `json:"contactPort,omitempty"`     // REGISTER Contact 头声明的端口
+	SourceIP         string         `json:"sourceIP,omitempty"`        // 实际观测到的报文源地址（NAT 映射后的地址）
+	SourcePort       int            `json:"sourcePort,omitempty"`      // 实际观测到的报文源端口
+	BehindNAT        bool           `json:"behindNAT"`                 // Contact 与源地址不一致，设备位于 NAT 之后
+	DeviceStatus     *DeviceStatus  `json:"deviceStatus,omitempty"`    // 最近一次 DeviceStatus 查询结果
+	ClockSkew        int64          `json:"clockSkew"`                 // 设备时钟偏差（秒，设备时间 - 平台时间）
+	ClockSkewSource  string         `json:"clockSkewSource,omitempty"` // 偏差来源: Keepalive/DeviceStatus/RecordInfo
+	ClockSkewAt      int64          `json:"clockSkewAt,omitempty"`     // 偏差记录时间
+	ClockSkewWarning bool           `json:"clockSkewWarning"`          // 偏差超过告警阈值
+	CatalogNodes     []*CatalogNode `json:"catalogNodes,omitempty"`    // 目录中的行政区划、业务分组、虚拟组织节点
+	TLSSubject       string         `json:"tlsSubject,omitempty"`      // TLS 注册时客户端证书的主题
+	TCPConn          net.Conn       `json:"-"`                         // TCP连接（用于复用）
+	ConnMux          sync.Mutex     `json:"-"`                         // 连接锁
 }
 
 // Channel GB28181通道结构体
 type Channel struct {
-	ChannelID     string `json:"channelId"`
-	DeviceID      string `json:"deviceId"`
-	Name          string `json:"name"`
-	Manufacturer  string `json:"manufacturer"`
-	Model         string `json:"model"`
-	Status        string `json:"status"`
-	PTZType       int    `json:"ptzType"`      // 0-未知, 1-球机, 2-半球, 3-固定枪机, 4-遥控枪机
-	PTZSupported  bool   `json:"ptzSupported"` // 是否支持PTZ (ptzType=1或4时为true)
-	Longitude     string `json:"longitude"`
-	Latitude      string `json:"latitude"`
-	StreamURL     string `json:"streamURL"`
-	SubStreamURL  string `json:"subStreamURL"`
-	RecordingPath string `json:"recordingPath"`
-	CreateTime    int64  `json:"createTime"`
+	ChannelID       string `json:"channelId"`
+	DeviceID        string `json:"deviceId"`
+	Name            string `json:"name"`
+	Manufacturer    string `json:"manufacturer"`
+	Model           string `json:"model"`
+	Status          string `json:"status"`
+	PTZType         int    `json:"ptzType"`      // 0-未知, 1-球机, 2-半球, 3-固定枪机, 4-遥控枪机
+	PTZSupported    bool   `json:"ptzSupported"` // 是否支持PTZ (ptzType=1或4时为true)
+	Longitude       string `json:"longitude"`
+	Latitude        string `json:"latitude"`
+	ParentID        string `json:"parentId,omitempty"`        // 目录中的父节点（设备、业务分组或虚拟组织）
+	BusinessGroupID string `json:"businessGroupId,omitempty"` // 所属业务分组
+	CivilCode       string `json:"civilCode,omitempty"`       // 行政区划
+	StreamURL       string `json:"streamURL"`
+	SubStreamURL    string `json:"subStreamURL"`
+	RecordingPath   string `json:"recordingPath"`
+	CreateTime      int64  `json:"createTime"`
 }
 
 // NewServer 创建GB28181服务器实例
@@ -105,6 +128,11 @@ func NewServer(cfg *config.GB28181Config) *Server {
 		stopChan:         make(chan struct{}),
 		recordCache:      make(map[string][]DeviceRecordInfo),
 		playbackSessions: make(map[string]*PlaybackSession),
+		transactions:     newTransactionTable(),
+		queries:          make(map[string]*pendingQuery),
+		positions:        make(map[string][]Position),
+		trackTruncated:   make(map[string]time.Time),
+		admission:        NewAdmissionManager(AdmissionFilePath(cfg)),
 	}
 }
 
@@ -127,6 +155,10 @@ func (s *Server) Start() error {
 	}
 	debug.Debug("gb28181", "本地可达 IP: %s", s.localIP)
 
+	if strings.TrimSpace(s.config.AdmissionMode) == "" {
+		log.Printf("[GB28181] ⚠ 未配置 AdmissionMode，按 open 模式接入未知设备；确认现网设备已加入白名单后建议改为 approval")
+	}
+
 	// 启动 UDP 监听 (GB28181 标准主要使用 UDP)
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
@@ -155,17 +187,38 @@ func (s *Server) Start() error {
 	debug.Debug("gb28181", "配置信息: SIP IP=%s, SIP Port=%d, Realm=%s, ServerID=%s",
 		s.config.SipIP, s.config.SipPort, s.config.Realm, s.config.ServerID)
 
+	// 启动 TLS 监听 (配置了 TLSPort 时)
+	if err := s.startTLSListener(); err != nil {
+		debug.Error("gb28181", "TLS监听启动失败: %v", err)
+		udpConn.Close()
+		s.udpConn = nil
+		if s.listener != nil {
+			s.listener.Close()
+			s.listener = nil
+		}
+		return err
+	}
+
 	// 启动 UDP 处理协程
 	go s.handleUDPConnections()
 
 	// 启动 TCP 处理协程 (如果监听成功)
 	if s.listener != nil {
-		go s.acceptConnections()
+		go s.acceptConnections(s.listener)
+	}
+	if s.tlsListener != nil {
+		go s.acceptConnections(s.tlsListener)
 	}
 
 	// 启动心跳检查协程
 	go s.heartbeatChecker()
 
+	// 启动 NAT 保活协程
+	go s.natKeepalive()
+
+	// 启动设备状态轮询协程
+	go s.pollDeviceStatus()
+
 	return nil
 }
 
@@ -276,6 +329,11 @@ func (s *Server) Stop() error {
 		s.udpConn.Close()
 		s.udpConn = nil
 	}
+	if s.tlsListener != nil {
+		log.Println("[GB28181] 正在关闭 TLS listener...")
+		s.tlsListener.Close()
+		s.tlsListener = nil
+	}
 	if s.listener != nil {
 		log.Println("[GB28181] 正在关闭 TCP listener...")
 		err := s.listener.Close()
@@ -340,6 +398,15 @@ func (s *Server) handleUDPMessage(data []byte, remoteAddr *net.UDPAddr) {
 			Port: remoteAddr.Port,
 		}
 		s.sendACKUDP(remoteUDP, message)
+		s.matchClientTransaction(message)
+		return
+	}
+
+	// 响应按 Via 中的 received/rport 回送（RFC 3581）
+	stampVia(message, remoteAddr.IP.String(), remoteAddr.Port)
+
+	// 设备重传的请求不重复处理
+	if s.absorbRetransmission(message, remoteAddr) {
 		return
 	}
 
@@ -359,6 +426,8 @@ func (s *Server) handleUDPMessage(data []byte, remoteAddr *net.UDPAddr) {
 		s.handleByeUDP(remoteAddr, message)
 	case "OPTIONS":
 		s.handleOptionsUDP(remoteAddr, message)
+	case "NOTIFY":
+		s.handleNotifyUDP(remoteAddr, message)
 	default:
 		// SIP/2.0 响应消息也可能包含目录数据
 		if strings.HasPrefix(message.Type, "SIP/2.0") {
@@ -369,8 +438,8 @@ func (s *Server) handleUDPMessage(data []byte, remoteAddr *net.UDPAddr) {
 	}
 }
 
-// acceptConnections 处理客户端连接
-func (s *Server) acceptConnections() {
+// acceptConnections 处理客户端连接（TCP 与 TLS 监听共用）
+func (s *Server) acceptConnections(listener net.Listener) {
 	debug.Info("gb28181", "开始接受TCP客户端连接")
 	log.Println("[GB28181] TCP监听已启动，等待TCP连接...")
 
@@ -385,7 +454,7 @@ func (s *Server) acceptConnections() {
 			// 继续处理
 		}
 
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			// 记录详细的错误信息用于诊断
 			log.Printf("[WARN] Accept错误: %v (类型: %T)", err, err)
@@ -431,8 +500,17 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 	debug.Debug("gb28181", "处理连接: %s", conn.RemoteAddr())
 
-	// 创建一个缓冲区来接收SIP消息
+	// TLS 连接先完成握手，握手失败（证书不受信任等）直接关闭
+	if err := handshakeTLS(conn); err != nil {
+		log.Printf("[GB28181] ✗ TLS握手失败: %s - %v", conn.RemoteAddr(), err)
+		debug.Warn("gb28181", "TLS握手失败: %s - %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	// 创建一个缓冲区来接收SIP消息，流式数据由 sipStream 分帧
 	buffer := make([]byte, 4096)
+	var stream sipStream
 
 	for {
 		// 设置读取超时，防止连接挂死
@@ -460,11 +538,12 @@ func (s *Server) handleConnection(conn net.Conn) {
 			}
 		}
 
-		// 处理接收到的SIP消息
+		// 按 Content-Length 分帧后处理接收到的SIP消息
 		if n > 0 {
-			data := buffer[:n]
-			debug.Debug("gb28181", "收到SIP消息，长度: %d 字节", n)
-			s.HandleSIPMessage(conn, data)
+			debug.Debug("gb28181", "收到TCP数据，长度: %d 字节", n)
+			for _, data := range stream.feed(buffer[:n]) {
+				s.HandleSIPMessage(conn, data)
+			}
 		}
 	}
 }
@@ -539,8 +618,8 @@ func (s *Server) RegisterDeviceWithConn(deviceID, name, sipIP string, sipPort in
 		existing.LastKeepAlive = now
 		existing.Expires = expires
 		existing.Transport = transport
-		// 如果是 TCP 连接，更新连接
-		if transport == "TCP" && conn != nil {
+		// 如果是 TCP/TLS 连接，更新连接
+		if (transport == "TCP" || transport == "TLS") && conn != nil {
 			// 关闭旧连接（如果有）
 			if existing.TCPConn != nil && existing.TCPConn != conn {
 				existing.TCPConn.Close()
@@ -562,7 +641,7 @@ func (s *Server) RegisterDeviceWithConn(deviceID, name, sipIP string, sipPort in
 		LastKeepAlive: now,
 		Expires:       expires,
 		Channels:      make([]*Channel, 0),
-		StreamMode:    "TCP-Passive",
+		StreamMode:    s.defaultStreamMode(),
 		TCPConn:       conn,
 	}
 
@@ -609,6 +688,9 @@ func (s *Server) UpdateKeepAliveWithAddr(deviceID, sipIP string, sipPort int) {
 			device.SipIP = sipIP
 			device.SipPort = sipPort
 		}
+		device.SourceIP = sipIP
+		device.SourcePort = sipPort
+		device.BehindNAT = behindNAT(device.Transport, device.ContactIP, device.ContactPort, sipIP, sipPort)
 	}
 }
 
@@ -631,6 +713,9 @@ func (s *Server) AddChannel(deviceID string, channel *Channel) {
 		existingChannel.PTZSupported = channel.PTZType == 1 || channel.PTZType == 4
 		existingChannel.Longitude = channel.Longitude
 		existingChannel.Latitude = channel.Latitude
+		existingChannel.ParentID = channel.ParentID
+		existingChannel.BusinessGroupID = channel.BusinessGroupID
+		existingChannel.CivilCode = channel.CivilCode
 		log.Printf("[GB28181] 📺 通道更新: 设备=%s | 通道=%s | 名称=%s", deviceID, channel.ChannelID, channel.Name)
 		return
 	}
@@ -698,6 +783,49 @@ func (s *Server) GetChannelByID(channelID string) (*Channel, bool) {
 	return channel, exists
 }
 
+// defaultStreamMode 新注册设备的媒体传输模式
+func (s *Server) defaultStreamMode() string {
+	if mode, err := ParseStreamMode(s.config.StreamMode); err == nil {
+		return mode
+	}
+	return StreamModeUDP
+}
+
+// SetDeviceStreamMode 设置设备的媒体传输模式
+func (s *Server) SetDeviceStreamMode(deviceID, mode string) error {
+	mode, err := ParseStreamMode(mode)
+	if err != nil {
+		return err
+	}
+
+	s.devicesMux.Lock()
+	defer s.devicesMux.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return fmt.Errorf("设备不存在: %s", deviceID)
+	}
+	device.StreamMode = mode
+	return nil
+}
+
+// SetChannelStreamURL 记录通道主/子码流的播放地址（第三码流不记录）
+func (s *Server) SetChannelStreamURL(channelID, quality, url string) {
+	s.devicesMux.Lock()
+	defer s.devicesMux.Unlock()
+
+	channel, exists := s.channels[channelID]
+	if !exists {
+		return
+	}
+	switch quality {
+	case StreamQualityMain, "":
+		channel.StreamURL = url
+	case StreamQualitySub:
+		channel.SubStreamURL = url
+	}
+}
+
 // RemoveDevice 移除设备
 func (s *Server) RemoveDevice(deviceID string) bool {
 	s.devicesMux.Lock()
@@ -782,17 +910,8 @@ func (s *Server) QueryCatalog(deviceID string) error {
 		return fmt.Errorf("设备 %s 不存在", deviceID)
 	}
 
-	// 生成目录查询 XML
-	sn := time.Now().UnixNano() % 1000000
-	catalogXML := fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
-<Query>
-<CmdType>Catalog</CmdType>
-<SN>%d</SN>
-<DeviceID>%s</DeviceID>
-</Query>`, sn, deviceID)
-
 	// 使用统一的方法构建 SIP MESSAGE
-	sipMessage := s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", catalogXML)
+	sipMessage := s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", buildCatalogQueryXML(deviceID, nextSN()))
 
 	// 使用统一的方法发送（根据设备 Transport 自动选择 TCP/UDP）
 	err := s.SendSIPMessageToDevice(device, sipMessage)
@@ -815,17 +934,8 @@ func (s *Server) QueryDeviceInfo(deviceID string) error {
 		return fmt.Errorf("设备 %s 不存在", deviceID)
 	}
 
-	// 生成设备信息查询 XML
-	sn := time.Now().UnixNano() % 1000000
-	queryXML := fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
-<Query>
-<CmdType>DeviceInfo</CmdType>
-<SN>%d</SN>
-<DeviceID>%s</DeviceID>
-</Query>`, sn, deviceID)
-
 	// 使用统一的方法构建 SIP MESSAGE
-	sipMessage := s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", queryXML)
+	sipMessage := s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", buildDeviceInfoQueryXML(deviceID, nextSN()))
 
 	// 使用统一的方法发送（根据设备 Transport 自动选择 TCP/UDP）
 	err := s.SendSIPMessageToDevice(device, sipMessage)
@@ -844,30 +954,78 @@ func (s *Server) QueryDeviceInfo(deviceID string) error {
 // endTime: 结束时间 (格式: 2025-12-23T23:59:59)
 // recordType: 录像类型 (all/time/alarm/manual)
 func (s *Server) QueryRecordInfo(channelID, startTime, endTime, recordType string) error {
-	// 从通道ID获取设备ID (通常前缀相同)
-	var device *Device
-	var deviceID string
+	device := s.findChannelDevice(channelID)
+	if device == nil {
+		return fmt.Errorf("未找到通道 %s 所属的设备", channelID)
+	}
 
+	// 使用统一的方法构建 SIP MESSAGE
+	queryXML := buildRecordInfoQueryXML(channelID, startTime, endTime, recordType, nextSN())
+	sipMessage := s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", queryXML)
+
+	// 以事务发送并等待设备应答（根据设备 Transport 自动选择 TCP/UDP）
+	response, err := s.SendSIPRequest(device, sipMessage)
+	if err != nil {
+		log.Printf("[GB28181] 发送录像查询失败: %v", err)
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("设备拒绝录像查询: %d %s", response.StatusCode, response.Reason)
+	}
+
+	log.Printf("[GB28181] ✓ 已向设备 %s 发送录像查询请求 (通道: %s, 时间: %s ~ %s) [%s]",
+		device.DeviceID, channelID, startTime, endTime, device.Transport)
+	return nil
+}
+
+// findChannelDevice 查找通道所属的设备
+func (s *Server) findChannelDevice(channelID string) *Device {
 	s.devicesMux.RLock()
-	for id, dev := range s.devices {
+	defer s.devicesMux.RUnlock()
+
+	for _, dev := range s.devices {
 		// 通道ID通常属于设备ID的前缀或者通过通道列表查找
 		for _, ch := range dev.Channels {
 			if ch.ChannelID == channelID {
-				device = dev
-				deviceID = id
-				break
+				return dev
 			}
 		}
-		if device != nil {
-			break
-		}
 	}
-	s.devicesMux.RUnlock()
+	return nil
+}
 
-	if device == nil {
-		return fmt.Errorf("未找到通道 %s 所属的设备", channelID)
-	}
+// buildCatalogQueryXML 生成目录查询 XML
+func buildCatalogQueryXML(deviceID string, sn int64) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Query>
+<CmdType>Catalog</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+</Query>`, sn, deviceID)
+}
+
+// buildDeviceInfoQueryXML 生成设备信息查询 XML
+func buildDeviceInfoQueryXML(deviceID string, sn int64) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Query>
+<CmdType>DeviceInfo</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+</Query>`, sn, deviceID)
+}
+
+// buildDeviceStatusQueryXML 生成设备状态查询 XML
+func buildDeviceStatusQueryXML(deviceID string, sn int64) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Query>
+<CmdType>DeviceStatus</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+</Query>`, sn, deviceID)
+}
 
+// buildRecordInfoQueryXML 生成录像查询 XML (GB28181 标准格式)
+func buildRecordInfoQueryXML(channelID, startTime, endTime, recordType string, sn int64) string {
 	// 录像类型映射
 	typeMap := map[string]string{
 		"all":    "all",
@@ -880,9 +1038,7 @@ func (s *Server) QueryRecordInfo(channelID, startTime, endTime, recordType strin
 		recType = "all"
 	}
 
-	// 生成录像查询 XML (GB28181 标准格式)
-	sn := time.Now().UnixNano() % 1000000
-	queryXML := fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
 <Query>
 <CmdType>RecordInfo</CmdType>
 <SN>%d</SN>
@@ -892,20 +1048,6 @@ func (s *Server) QueryRecordInfo(channelID, startTime, endTime, recordType strin
 <Secrecy>0</Secrecy>
 <Type>%s</Type>
 </Query>`, sn, channelID, startTime, endTime, recType)
-
-	// 使用统一的方法构建 SIP MESSAGE
-	sipMessage := s.BuildSIPMessageString(device, channelID, "Application/MANSCDP+xml", queryXML)
-
-	// 使用统一的方法发送（根据设备 Transport 自动选择 TCP/UDP）
-	err := s.SendSIPMessageToDevice(device, sipMessage)
-	if err != nil {
-		log.Printf("[GB28181] 发送录像查询失败: %v", err)
-		return err
-	}
-
-	log.Printf("[GB28181] ✓ 已向设备 %s 发送录像查询请求 (通道: %s, 时间: %s ~ %s) [%s]",
-		deviceID, channelID, startTime, endTime, device.Transport)
-	return nil
 }
 
 // GetRecordList 获取通道的录像列表
@@ -967,8 +1109,8 @@ func (s *Server) StartRecordPlayback(channelID, startTime, endTime string) (*Pla
 	zlmIP := s.getLocalIPForRemote(device.SipIP)
 
 	// 生成 SIP 会话标识
-	callID := fmt.Sprintf("%d@%s", time.Now().UnixNano(), s.config.SipIP)
-	fromTag := fmt.Sprintf("playback%d", time.Now().UnixNano()%1000000)
+	callID := generateCallID() + "@" + s.config.SipIP
+	fromTag := generateTag()
 
 	// 构建 SDP (Session Description Protocol)
 	// 录像回放使用 playback 类型
@@ -1068,8 +1210,8 @@ func (s *Server) StartRecordPlaybackWithPort(channelID, startTime, endTime, stre
 	zlmIP := s.getLocalIPForRemote(device.SipIP)
 
 	// 生成 SIP 会话标识
-	callID := fmt.Sprintf("%d@%s", time.Now().UnixNano(), s.config.SipIP)
-	fromTag := fmt.Sprintf("playback%d", time.Now().UnixNano()%1000000)
+	callID := generateCallID() + "@" + s.config.SipIP
+	fromTag := generateTag()
 
 	// 构建 SDP (Session Description Protocol)
 	sdpContent := fmt.Sprintf(`v=0
@@ -1180,7 +1322,7 @@ func (s *Server) StopRecordPlayback(channelID, streamID string) error {
 
 // buildPlaybackInvite 构建录像回放 INVITE 请求
 func (s *Server) buildPlaybackInvite(device *Device, channelID, callID, fromTag, sdp string) string {
-	branch := fmt.Sprintf("z9hG4bK%d", time.Now().UnixNano())
+	branch := generateBranch()
 	cseq := time.Now().Unix() % 100000
 
 	invite := fmt.Sprintf(`INVITE sip:%s@%s:%d SIP/2.0
@@ -1213,7 +1355,7 @@ Content-Length: %d
 
 // buildPlaybackBye 构建录像回放 BYE 请求
 func (s *Server) buildPlaybackBye(device *Device, session *PlaybackSession) string {
-	branch := fmt.Sprintf("z9hG4bK%d", time.Now().UnixNano())
+	branch := generateBranch()
 	cseq := time.Now().Unix() % 100000
 
 	bye := fmt.Sprintf(`BYE sip:%s@%s:%d SIP/2.0
@@ -1258,24 +1400,36 @@ func convertToNTP(timeStr string) string {
 }
 
 // SendSIPMessageToDevice 统一的 SIP 消息发送方法
-// 优先使用 TCP，如果设备明确指定 UDP 或 TCP 发送失败则使用 UDP
+// 请求以客户端事务发送（UDP 自动重传），不等待响应；需要结果时使用 SendSIPRequest
 func (s *Server) SendSIPMessageToDevice(device *Device, message string) error {
+	_, err := s.startClientTransaction(device, message)
+	return err
+}
+
+// sendToDevice 发送一次 SIP 消息，返回是否使用了可靠传输（TCP/TLS）
+// 优先使用 TCP，如果设备明确指定 UDP 或 TCP 发送失败则使用 UDP
+// TLS 设备只通过其注册连接发送，不回退到明文传输
+func (s *Server) sendToDevice(device *Device, message string) (bool, error) {
 	if device == nil {
-		return fmt.Errorf("设备为空")
+		return false, fmt.Errorf("设备为空")
+	}
+
+	if device.Transport == "TLS" {
+		return true, s.sendViaTLS(device, message)
 	}
 
 	// 优先使用 TCP（除非设备明确指定 UDP）
 	if device.Transport == "UDP" {
-		return s.sendViaUDP(device, message)
+		return false, s.sendViaUDP(device, message)
 	}
 
 	// 默认使用 TCP，失败后回退到 UDP
 	err := s.sendViaTCP(device, message)
 	if err != nil {
 		debug.Warn("gb28181", "TCP发送失败，回退到UDP: %v", err)
-		return s.sendViaUDP(device, message)
+		return false, s.sendViaUDP(device, message)
 	}
-	return nil
+	return true, nil
 }
 
 // sendViaTCP 通过 TCP 发送 SIP 消息（复用已有连接）
@@ -1323,9 +1477,28 @@ func (s *Server) sendViaTCP(device *Device, message string) error {
 	return nil
 }
 
+// sendViaTLS 通过设备注册时建立的 TLS 连接发送 SIP 消息
+func (s *Server) sendViaTLS(device *Device, message string) error {
+	device.ConnMux.Lock()
+	defer device.ConnMux.Unlock()
+
+	if device.TCPConn == nil {
+		return fmt.Errorf("设备 %s 的 TLS 连接已断开", device.DeviceID)
+	}
+	if _, err := device.TCPConn.Write([]byte(message)); err != nil {
+		debug.Warn("gb28181", "TLS发送失败: device=%s error=%v", device.DeviceID, err)
+		device.TCPConn.Close()
+		device.TCPConn = nil
+		return fmt.Errorf("TLS发送失败: %w", err)
+	}
+	debug.Debug("gb28181", "TLS消息已发送到设备 %s", device.DeviceID)
+	return nil
+}
+
 // handleTCPResponse 处理 TCP 连接上的响应
 func (s *Server) handleTCPResponse(device *Device, conn net.Conn) {
 	buffer := make([]byte, 4096)
+	var stream sipStream
 	for {
 		conn.SetReadDeadline(time.Now().Add(120 * time.Second))
 		n, err := conn.Read(buffer)
@@ -1341,7 +1514,9 @@ func (s *Server) handleTCPResponse(device *Device, conn net.Conn) {
 		}
 		if n > 0 {
 			// 处理响应消息
-			s.HandleSIPMessage(conn, buffer[:n])
+			for _, data := range stream.feed(buffer[:n]) {
+				s.HandleSIPMessage(conn, data)
+			}
 		}
 	}
 }
@@ -1369,9 +1544,9 @@ func (s *Server) sendViaUDP(device *Device, message string) error {
 
 // BuildSIPMessageString 构建完整的 SIP MESSAGE 请求字符串
 func (s *Server) BuildSIPMessageString(device *Device, targetID, contentType, body string) string {
-	callID := fmt.Sprintf("%d@%s", time.Now().UnixNano(), s.config.SipIP)
-	branch := fmt.Sprintf("z9hG4bK%d", time.Now().UnixNano())
-	tag := fmt.Sprintf("%d", time.Now().UnixNano()%100000000)
+	callID := generateCallID() + "@" + s.config.SipIP
+	branch := generateBranch()
+	tag := generateTag()
 
 	// Via 头使用正确的传输协议
 	transport := device.Transport
@@ -0,0 +1,710 @@
+// Package simulator 模拟 GB28181 设备（IPC），用于协议一致性测试和平台压测
+//
+// 模拟设备使用 UDP 或 TCP 向平台注册（支持 Digest 认证），定时发送心跳，
+// 应答 Catalog / DeviceInfo / DeviceStatus / RecordInfo 查询，并在收到 INVITE 后
+// 以 PS over RTP（UDP 或 RFC 4571 TCP）推送纯 Go 生成的 H.264 彩条测试图案。
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// userAgent 模拟设备的 User-Agent
+const userAgent = "GB28181-Simulator"
+
+// Config 模拟设备配置，零值字段使用默认值
+type Config struct {
+	DeviceID          string        // 设备国标编码（20 位）
+	ServerID          string        // 平台国标编码
+	Realm             string        // SIP 域，默认取平台编码前 10 位
+	ServerAddr        string        // 平台 SIP 地址 host:port
+	Transport         string        // SIP 传输：UDP 或 TCP，默认 UDP
+	LocalIP           string        // Via/Contact/SDP 中使用的本机地址，默认取连接的本地地址
+	Password          string        // 注册密码，平台返回 401 时用于 Digest 认证
+	Expires           int           // 注册有效期（秒），默认 3600
+	KeepaliveInterval time.Duration // 心跳间隔，默认 60 秒，小于 0 时不发送心跳
+	Timeout           time.Duration // 等待平台响应的超时，默认 5 秒
+
+	Channels          int    // 通道数，默认 1
+	PageSize          int    // Catalog/RecordInfo 每个 MESSAGE 携带的条目数，默认 10
+	RecordsPerChannel int    // RecordInfo 在查询时段内返回的录像段数，默认 4
+	Manufacturer      string // 默认 Simulator
+	Model             string // 默认 SIM-IPC
+	Firmware          string // 默认 V1.0.0
+
+	Width  int // 测试图案宽度（16 的倍数），默认 176
+	Height int // 测试图案高度（16 的倍数），默认 144
+	FPS    int // 帧率，默认 25
+	GOP    int // 关键帧间隔（帧），默认 2 秒
+
+	Logf func(format string, args ...interface{}) // 日志输出，为空时不输出
+}
+
+// withDefaults 校验配置并填充默认值
+func (c Config) withDefaults() (Config, error) {
+	if len(c.DeviceID) != 20 {
+		return c, fmt.Errorf("设备编码应为 20 位: %q", c.DeviceID)
+	}
+	if c.ServerID == "" || c.ServerAddr == "" {
+		return c, fmt.Errorf("需要配置平台编码和平台地址")
+	}
+	if c.Realm == "" {
+		c.Realm = c.ServerID
+		if len(c.Realm) > 10 {
+			c.Realm = c.Realm[:10]
+		}
+	}
+	c.Transport = strings.ToUpper(c.Transport)
+	if c.Transport == "" {
+		c.Transport = "UDP"
+	}
+	if c.Transport != "UDP" && c.Transport != "TCP" {
+		return c, fmt.Errorf("不支持的传输方式: %s", c.Transport)
+	}
+	if c.Expires <= 0 {
+		c.Expires = 3600
+	}
+	if c.KeepaliveInterval == 0 {
+		c.KeepaliveInterval = 60 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.Channels <= 0 {
+		c.Channels = 1
+	}
+	if c.PageSize <= 0 {
+		c.PageSize = 10
+	}
+	if c.RecordsPerChannel <= 0 {
+		c.RecordsPerChannel = 4
+	}
+	if c.Manufacturer == "" {
+		c.Manufacturer = "Simulator"
+	}
+	if c.Model == "" {
+		c.Model = "SIM-IPC"
+	}
+	if c.Firmware == "" {
+		c.Firmware = "V1.0.0"
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		c.Width, c.Height = 176, 144
+	}
+	if c.Width%16 != 0 || c.Height%16 != 0 {
+		return c, fmt.Errorf("分辨率应为 16 的倍数: %dx%d", c.Width, c.Height)
+	}
+	if c.FPS <= 0 {
+		c.FPS = 25
+	}
+	if c.GOP <= 0 {
+		c.GOP = 2 * c.FPS
+	}
+	return c, nil
+}
+
+// Stats 模拟设备运行统计
+type Stats struct {
+	Registered    bool
+	Keepalives    int64 // 已成功发送的心跳数
+	Queries       int64 // 已应答的查询数
+	Invites       int64 // 已接受的 INVITE 数
+	ActiveStreams int   // 正在推流的会话数
+	RTPPackets    int64
+	RTPBytes      int64
+}
+
+// dialog INVITE 建立的会话
+type dialog struct {
+	channelID string
+	cseq      string
+	response  []byte // 缓存的 200 OK，INVITE 重传时原样重发
+	media     *mediaSession
+}
+
+// Device 模拟 GB28181 设备
+type Device struct {
+	cfg      Config
+	channels []string
+	pattern  *testPattern
+
+	udpConn    *net.UDPConn
+	serverUDP  *net.UDPAddr
+	tcpConn    net.Conn
+	localIP    string
+	localPort  int
+	writeMu    sync.Mutex
+	registerID string // REGISTER 使用固定 Call-ID
+	fromTag    string
+
+	mu         sync.Mutex
+	cseq       int
+	sn         int
+	pending    map[string]chan *message // Call-ID|CSeq -> 平台响应
+	dialogs    map[string]*dialog       // Call-ID -> 会话
+	registered bool
+
+	keepalives int64
+	queries    int64
+	invites    int64
+	rtpPackets int64
+	rtpBytes   int64
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New 创建模拟设备，Start 后开始工作
+func New(cfg Config) (*Device, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Device{
+		cfg:        cfg,
+		pattern:    newTestPattern(cfg.Width, cfg.Height),
+		registerID: randomToken(8) + "@" + cfg.DeviceID,
+		fromTag:    randomToken(4),
+		pending:    make(map[string]chan *message),
+		dialogs:    make(map[string]*dialog),
+		closed:     make(chan struct{}),
+	}
+	d.channels = channelIDs(cfg.DeviceID, cfg.Channels)
+	return d, nil
+}
+
+// channelIDs 按设备编码派生通道编码：前 10 位中心编码 + 类型 131（摄像机）+ 7 位序号
+func channelIDs(deviceID string, count int) []string {
+	serial, _ := strconv.Atoi(deviceID[13:])
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s131%07d", deviceID[:10], (serial*100+i+1)%10000000)
+	}
+	return ids
+}
+
+// DeviceID 设备编码
+func (d *Device) DeviceID() string {
+	return d.cfg.DeviceID
+}
+
+// ChannelIDs 通道编码列表
+func (d *Device) ChannelIDs() []string {
+	return append([]string(nil), d.channels...)
+}
+
+// Stats 返回运行统计
+func (d *Device) Stats() Stats {
+	d.mu.Lock()
+	registered := d.registered
+	active := 0
+	for _, dlg := range d.dialogs {
+		if dlg.media != nil && atomic.LoadInt32(&dlg.media.started) == 1 && !dlg.media.stopped() {
+			active++
+		}
+	}
+	d.mu.Unlock()
+
+	return Stats{
+		Registered:    registered,
+		Keepalives:    atomic.LoadInt64(&d.keepalives),
+		Queries:       atomic.LoadInt64(&d.queries),
+		Invites:       atomic.LoadInt64(&d.invites),
+		ActiveStreams: active,
+		RTPPackets:    atomic.LoadInt64(&d.rtpPackets),
+		RTPBytes:      atomic.LoadInt64(&d.rtpBytes),
+	}
+}
+
+// logf 输出日志
+func (d *Device) logf(format string, args ...interface{}) {
+	if d.cfg.Logf != nil {
+		d.cfg.Logf("[%s] "+format, append([]interface{}{d.cfg.DeviceID}, args...)...)
+	}
+}
+
+// Start 连接平台、注册并启动心跳
+func (d *Device) Start(ctx context.Context) error {
+	if err := d.connect(); err != nil {
+		return err
+	}
+
+	d.wg.Add(1)
+	go d.readLoop()
+
+	if err := d.Register(ctx); err != nil {
+		d.Close()
+		return err
+	}
+
+	if d.cfg.KeepaliveInterval > 0 {
+		d.wg.Add(1)
+		go d.keepaliveLoop()
+	}
+	return nil
+}
+
+// connect 建立到平台的 SIP 连接
+func (d *Device) connect() error {
+	if d.cfg.Transport == "TCP" {
+		conn, err := net.DialTimeout("tcp", d.cfg.ServerAddr, d.cfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("连接平台失败: %w", err)
+		}
+		d.tcpConn = conn
+		d.setLocalAddr(conn.LocalAddr())
+		return nil
+	}
+
+	server, err := net.ResolveUDPAddr("udp", d.cfg.ServerAddr)
+	if err != nil {
+		return fmt.Errorf("解析平台地址失败: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		return fmt.Errorf("打开 SIP 端口失败: %w", err)
+	}
+	// 使用未连接的套接字，平台可能从其它地址发起请求
+	local := conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		return fmt.Errorf("打开 SIP 端口失败: %w", err)
+	}
+	d.udpConn = udpConn
+	d.serverUDP = server
+	d.setLocalAddr(udpConn.LocalAddr())
+	return nil
+}
+
+// setLocalAddr 记录本地 SIP 地址，LocalIP 未配置时使用连接的本地地址
+func (d *Device) setLocalAddr(addr net.Addr) {
+	host, port, _ := net.SplitHostPort(addr.String())
+	d.localPort, _ = strconv.Atoi(port)
+	d.localIP = d.cfg.LocalIP
+	if d.localIP == "" {
+		d.localIP = host
+	}
+}
+
+// Close 停止推流并断开连接（不注销，需要注销时先调用 Unregister）
+func (d *Device) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+
+		d.mu.Lock()
+		for callID, dlg := range d.dialogs {
+			if dlg.media != nil {
+				dlg.media.close()
+			}
+			delete(d.dialogs, callID)
+		}
+		d.registered = false
+		d.mu.Unlock()
+
+		if d.udpConn != nil {
+			d.udpConn.Close()
+		}
+		if d.tcpConn != nil {
+			d.tcpConn.Close()
+		}
+	})
+	d.wg.Wait()
+	return nil
+}
+
+// isClosed 设备是否已关闭
+func (d *Device) isClosed() bool {
+	select {
+	case <-d.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// write 发送一条 SIP 消息，TCP 上整条消息一次写入
+func (d *Device) write(data []byte) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if d.tcpConn != nil {
+		_, err := d.tcpConn.Write(data)
+		return err
+	}
+	_, err := d.udpConn.WriteToUDP(data, d.serverUDP)
+	return err
+}
+
+// readLoop 接收平台消息
+func (d *Device) readLoop() {
+	defer d.wg.Done()
+
+	if d.tcpConn != nil {
+		reader := bufio.NewReader(d.tcpConn)
+		for {
+			data, err := readStreamMessage(reader)
+			if err != nil {
+				if !d.isClosed() {
+					d.logf("SIP 连接断开: %v", err)
+					d.mu.Lock()
+					d.registered = false
+					d.mu.Unlock()
+				}
+				return
+			}
+			d.dispatch(data)
+		}
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := d.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if d.isClosed() {
+				return
+			}
+			continue
+		}
+		d.dispatch(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// dispatch 分发平台消息：响应交给等待的事务，请求按方法处理
+func (d *Device) dispatch(data []byte) {
+	msg, err := parseMessage(data)
+	if err != nil {
+		d.logf("丢弃无法解析的消息: %v", err)
+		return
+	}
+
+	if msg.isResponse() {
+		d.mu.Lock()
+		ch := d.pending[msg.header("Call-ID")+"|"+msg.header("CSeq")]
+		d.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- msg:
+			default:
+			}
+		}
+		return
+	}
+
+	switch msg.Method {
+	case "MESSAGE":
+		d.handleMessage(msg)
+	case "INVITE":
+		d.handleInvite(msg)
+	case "ACK":
+		d.handleAck(msg)
+	case "BYE":
+		d.handleBye(msg)
+	case "OPTIONS", "INFO", "NOTIFY", "SUBSCRIBE", "CANCEL":
+		d.reply(msg, 200, "OK")
+	default:
+		d.reply(msg, 501, "Not Implemented")
+	}
+}
+
+// reply 回复无消息体的响应
+func (d *Device) reply(req *message, code int, reason string) {
+	if err := d.write(buildResponse(req, code, reason, d.fromTag, nil, "", "")); err != nil {
+		d.logf("发送 %d 响应失败: %v", code, err)
+	}
+}
+
+// outgoing 设备发起的请求
+type outgoing struct {
+	method      string
+	uri         string
+	to          string
+	callID      string
+	extra       [][2]string
+	contentType string
+	body        string
+}
+
+// transact 发送请求并等待最终响应，UDP 上按 RFC 3261 定时器 A 重传
+func (d *Device) transact(ctx context.Context, out *outgoing) (*message, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	d.mu.Lock()
+	d.cseq++
+	cseq := fmt.Sprintf("%d %s", d.cseq, out.method)
+	key := out.callID + "|" + cseq
+	ch := make(chan *message, 4)
+	d.pending[key] = ch
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, key)
+		d.mu.Unlock()
+	}()
+
+	headers := [][2]string{
+		{"Via", fmt.Sprintf("SIP/2.0/%s %s:%d;rport;branch=z9hG4bK%s", d.cfg.Transport, d.localIP, d.localPort, randomToken(6))},
+		{"From", fmt.Sprintf("<sip:%s@%s>;tag=%s", d.cfg.DeviceID, d.cfg.Realm, d.fromTag)},
+		{"To", out.to},
+		{"Call-ID", out.callID},
+		{"CSeq", cseq},
+		{"Max-Forwards", "70"},
+		{"User-Agent", userAgent},
+	}
+	data := buildRequest(out.method, out.uri, append(headers, out.extra...), out.contentType, out.body)
+	if err := d.write(data); err != nil {
+		return nil, fmt.Errorf("发送 %s 失败: %w", out.method, err)
+	}
+
+	interval := 500 * time.Millisecond
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case resp := <-ch:
+			if resp.StatusCode >= 200 {
+				return resp, nil
+			}
+		case <-timer.C:
+			if d.udpConn != nil {
+				d.write(data)
+			}
+			if interval < 4*time.Second {
+				interval *= 2
+			}
+			timer.Reset(interval)
+		case <-d.closed:
+			return nil, fmt.Errorf("设备已关闭")
+		case <-ctx.Done():
+			return nil, fmt.Errorf("等待平台响应 %s 超时", out.method)
+		}
+	}
+}
+
+// Register 向平台注册，平台要求认证时按 Digest 质询重发
+func (d *Device) Register(ctx context.Context) error {
+	return d.register(ctx, d.cfg.Expires)
+}
+
+// Unregister 注销（Expires: 0）
+func (d *Device) Unregister(ctx context.Context) error {
+	return d.register(ctx, 0)
+}
+
+// register 发送 REGISTER
+func (d *Device) register(ctx context.Context, expires int) error {
+	uri := fmt.Sprintf("sip:%s@%s", d.cfg.ServerID, d.cfg.Realm)
+	out := &outgoing{
+		method: "REGISTER",
+		uri:    uri,
+		to:     fmt.Sprintf("<sip:%s@%s>", d.cfg.DeviceID, d.cfg.Realm),
+		callID: d.registerID,
+		extra: [][2]string{
+			{"Contact", fmt.Sprintf("<sip:%s@%s:%d>", d.cfg.DeviceID, d.localIP, d.localPort)},
+			{"Expires", strconv.Itoa(expires)},
+		},
+	}
+
+	resp, err := d.transact(ctx, out)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == 401 || resp.StatusCode == 407 {
+		if d.cfg.Password == "" {
+			return fmt.Errorf("平台要求认证，但未配置密码")
+		}
+		challenge := parseAuthParams(resp.header("WWW-Authenticate"))
+		if challenge["nonce"] == "" {
+			challenge = parseAuthParams(resp.header("Proxy-Authenticate"))
+		}
+		out.extra = append(out.extra, [2]string{"Authorization", digestAuthorization(d.cfg.DeviceID, d.cfg.Password, "REGISTER", uri, challenge)})
+		if resp, err = d.transact(ctx, out); err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("注册失败: %d %s", resp.StatusCode, resp.Reason)
+	}
+
+	d.mu.Lock()
+	d.registered = expires > 0
+	d.mu.Unlock()
+	if expires > 0 {
+		d.logf("注册成功 (%s)", d.cfg.Transport)
+	} else {
+		d.logf("已注销")
+	}
+	return nil
+}
+
+// keepaliveLoop 定时发送心跳，心跳失败后尝试重新注册
+func (d *Device) keepaliveLoop() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.cfg.KeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closed:
+			return
+		case <-ticker.C:
+		}
+
+		body := fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Notify>
+<CmdType>Keepalive</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<Status>OK</Status>
+</Notify>`, d.nextSN(), d.cfg.DeviceID)
+
+		resp, err := d.sendMessage(context.Background(), body)
+		if err == nil && resp.StatusCode == 200 {
+			atomic.AddInt64(&d.keepalives, 1)
+			continue
+		}
+		if d.isClosed() {
+			return
+		}
+		d.logf("心跳失败，重新注册: %v", err)
+		if err := d.Register(context.Background()); err != nil {
+			d.logf("重新注册失败: %v", err)
+		}
+	}
+}
+
+// nextSN 设备主动发送的 MESSAGE 序列号
+func (d *Device) nextSN() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sn++
+	return d.sn
+}
+
+// sendMessage 向平台发送 MANSCDP MESSAGE
+func (d *Device) sendMessage(ctx context.Context, body string) (*message, error) {
+	return d.transact(ctx, &outgoing{
+		method:      "MESSAGE",
+		uri:         fmt.Sprintf("sip:%s@%s", d.cfg.ServerID, d.cfg.Realm),
+		to:          fmt.Sprintf("<sip:%s@%s>", d.cfg.ServerID, d.cfg.Realm),
+		callID:      randomToken(8) + "@" + d.localIP,
+		contentType: "Application/MANSCDP+xml",
+		body:        body,
+	})
+}
+
+// handleInvite 应答 INVITE：准备媒体端口并回复 200 OK，收到 ACK 后开始推流
+func (d *Device) handleInvite(req *message) {
+	callID := req.header("Call-ID")
+
+	d.mu.Lock()
+	if dlg, ok := d.dialogs[callID]; ok && dlg.cseq == req.header("CSeq") {
+		d.mu.Unlock()
+		d.write(dlg.response) // INVITE 重传
+		return
+	}
+	d.mu.Unlock()
+
+	channelID := strings.SplitN(uriUser(req.URI), "@", 2)[0]
+	if !d.hasChannel(channelID) {
+		d.reply(req, 404, "Not Found")
+		return
+	}
+
+	offer, err := parseSDPOffer(req.Body)
+	if err != nil {
+		d.logf("INVITE SDP 无效: %v", err)
+		d.reply(req, 488, "Not Acceptable Here")
+		return
+	}
+	media, port, err := newMediaSession(offer, d.localIP)
+	if err != nil {
+		d.logf("准备媒体失败: %v", err)
+		d.reply(req, 500, "Server Internal Error")
+		return
+	}
+
+	sdp := media.answerSDP(channelID, d.localIP, port, offer)
+	contact := [][2]string{{"Contact", fmt.Sprintf("<sip:%s@%s:%d>", channelID, d.localIP, d.localPort)}, {"User-Agent", userAgent}}
+	response := buildResponse(req, 200, "OK", d.fromTag, contact, "APPLICATION/SDP", sdp)
+
+	d.mu.Lock()
+	d.dialogs[callID] = &dialog{channelID: channelID, cseq: req.header("CSeq"), response: response, media: media}
+	d.mu.Unlock()
+
+	atomic.AddInt64(&d.invites, 1)
+	d.logf("接受 INVITE: 通道=%s 媒体=%s %s SSRC=%s", channelID, media.mode, media.remote, offer.ssrc)
+	if err := d.write(response); err != nil {
+		d.logf("发送 INVITE 应答失败: %v", err)
+	}
+}
+
+// handleAck 收到 ACK 后开始推流
+func (d *Device) handleAck(req *message) {
+	d.mu.Lock()
+	dlg := d.dialogs[req.header("Call-ID")]
+	d.mu.Unlock()
+	if dlg == nil || dlg.media == nil {
+		return
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if err := dlg.media.run(d); err != nil {
+			d.logf("推流结束: 通道=%s %v", dlg.channelID, err)
+		}
+	}()
+}
+
+// handleBye 结束会话并停止推流
+func (d *Device) handleBye(req *message) {
+	callID := req.header("Call-ID")
+
+	d.mu.Lock()
+	dlg := d.dialogs[callID]
+	delete(d.dialogs, callID)
+	d.mu.Unlock()
+
+	if dlg == nil {
+		d.reply(req, 481, "Call/Transaction Does Not Exist")
+		return
+	}
+	if dlg.media != nil {
+		dlg.media.close()
+	}
+	d.logf("会话结束: 通道=%s", dlg.channelID)
+	d.reply(req, 200, "OK")
+}
+
+// hasChannel 是否为本设备的通道（或设备自身）
+func (d *Device) hasChannel(id string) bool {
+	if id == d.cfg.DeviceID {
+		return true
+	}
+	for _, channelID := range d.channels {
+		if channelID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// uriUser 提取 sip:user@host 中的 user@host 部分
+func uriUser(uri string) string {
+	uri = strings.TrimPrefix(strings.TrimPrefix(uri, "sip:"), "sips:")
+	if semi := strings.Index(uri, ";"); semi >= 0 {
+		uri = uri[:semi]
+	}
+	return uri
+}
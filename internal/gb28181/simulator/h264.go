@@ -0,0 +1,242 @@
+package simulator
+
+// 测试图案编码：纯 Go 生成的 H.264 Baseline 码流
+// 关键帧的每个宏块都使用 I_PCM 直接携带像素（75% 彩条），非关键帧为全跳过的 P 帧，
+// 无需依赖任何编码库即可得到可被解码器正常播放的静态画面。
+
+// 75% 彩条的 YCbCr 取值（BT.601）：白、黄、青、绿、品红、红、蓝、黑
+var colorBars = [8][3]byte{
+	{180, 128, 128},
+	{162, 44, 142},
+	{131, 156, 44},
+	{112, 72, 58},
+	{84, 184, 198},
+	{65, 100, 212},
+	{35, 212, 114},
+	{16, 128, 128},
+}
+
+// log2MaxFrameNum SPS 中 log2_max_frame_num，frame_num 以 16 循环
+const log2MaxFrameNum = 4
+
+// nal 单元类型
+const (
+	nalSlice = 1
+	nalIDR   = 5
+	nalSPS   = 7
+	nalPPS   = 8
+)
+
+// bitWriter 按位写入 RBSP
+type bitWriter struct {
+	buf   []byte
+	cur   byte
+	nbits uint
+}
+
+// writeBits 写入 value 的低 n 位
+func (w *bitWriter) writeBits(value uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(value>>uint(i)&1)
+		w.nbits++
+		if w.nbits == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.nbits = 0, 0
+		}
+	}
+}
+
+// writeFlag 写入 1 位标志
+func (w *bitWriter) writeFlag(flag bool) {
+	if flag {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+// writeUE 写入无符号指数哥伦布码 ue(v)
+func (w *bitWriter) writeUE(value uint32) {
+	v := value + 1
+	n := uint(0)
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(v, n+1)
+}
+
+// writeSE 写入有符号指数哥伦布码 se(v)
+func (w *bitWriter) writeSE(value int32) {
+	if value > 0 {
+		w.writeUE(uint32(2*value - 1))
+	} else {
+		w.writeUE(uint32(-2 * value))
+	}
+}
+
+// alignZero 以 0 填充到字节边界
+func (w *bitWriter) alignZero() {
+	for w.nbits != 0 {
+		w.writeBits(0, 1)
+	}
+}
+
+// writeBytes 在字节对齐位置写入原始字节
+func (w *bitWriter) writeBytes(data []byte) {
+	w.buf = append(w.buf, data...)
+}
+
+// trailing 写入 rbsp_trailing_bits 并返回 RBSP
+func (w *bitWriter) trailing() []byte {
+	w.writeBits(1, 1)
+	w.alignZero()
+	return w.buf
+}
+
+// nalUnit 加 Annex B 起始码与 NAL 头，并插入防竞争字节
+func nalUnit(refIdc, nalType byte, rbsp []byte) []byte {
+	out := make([]byte, 0, len(rbsp)+len(rbsp)/64+5)
+	out = append(out, 0, 0, 0, 1, refIdc<<5|nalType)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// testPattern 预先生成的测试图案访问单元
+type testPattern struct {
+	idr    [2][]byte                    // SPS+PPS+IDR，按 idr_pic_id 交替
+	pFrame [1 << log2MaxFrameNum][]byte // 按 frame_num 的全跳过 P 帧
+}
+
+// newTestPattern 生成指定分辨率（16 的倍数）的彩条测试图案
+func newTestPattern(width, height int) *testPattern {
+	mbWidth, mbHeight := width/16, height/16
+	sps, pps := buildSPS(mbWidth, mbHeight), buildPPS()
+
+	p := &testPattern{}
+	for id := range p.idr {
+		au := append([]byte{}, sps...)
+		au = append(au, pps...)
+		p.idr[id] = append(au, buildIDRSlice(mbWidth, mbHeight, uint32(id))...)
+	}
+	for frameNum := range p.pFrame {
+		p.pFrame[frameNum] = buildSkipSlice(mbWidth*mbHeight, uint32(frameNum))
+	}
+	return p
+}
+
+// frame 返回 GOP 内第 index 帧（0 为关键帧）的访问单元，gopIndex 用于交替 idr_pic_id
+func (p *testPattern) frame(gopIndex, index int) []byte {
+	if index == 0 {
+		return p.idr[gopIndex%2]
+	}
+	return p.pFrame[index%len(p.pFrame)]
+}
+
+// buildSPS Baseline Profile、Level 3.0、POC type 2、无 VUI
+func buildSPS(mbWidth, mbHeight int) []byte {
+	w := &bitWriter{}
+	w.writeBits(66, 8)   // profile_idc: Baseline
+	w.writeBits(0xC0, 8) // constraint_set0/1
+	w.writeBits(30, 8)   // level_idc
+	w.writeUE(0)         // seq_parameter_set_id
+	w.writeUE(log2MaxFrameNum - 4)
+	w.writeUE(2)       // pic_order_cnt_type
+	w.writeUE(1)       // max_num_ref_frames
+	w.writeFlag(false) // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint32(mbWidth - 1))
+	w.writeUE(uint32(mbHeight - 1))
+	w.writeFlag(true)  // frame_mbs_only_flag
+	w.writeFlag(true)  // direct_8x8_inference_flag
+	w.writeFlag(false) // frame_cropping_flag
+	w.writeFlag(false) // vui_parameters_present_flag
+	return nalUnit(3, nalSPS, w.trailing())
+}
+
+// buildPPS CAVLC、单 slice group、开启去块滤波控制
+func buildPPS() []byte {
+	w := &bitWriter{}
+	w.writeUE(0)       // pic_parameter_set_id
+	w.writeUE(0)       // seq_parameter_set_id
+	w.writeFlag(false) // entropy_coding_mode_flag: CAVLC
+	w.writeFlag(false) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)       // num_slice_groups_minus1
+	w.writeUE(0)       // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)       // num_ref_idx_l1_default_active_minus1
+	w.writeFlag(false) // weighted_pred_flag
+	w.writeBits(0, 2)  // weighted_bipred_idc
+	w.writeSE(0)       // pic_init_qp_minus26
+	w.writeSE(0)       // pic_init_qs_minus26
+	w.writeSE(0)       // chroma_qp_index_offset
+	w.writeFlag(true)  // deblocking_filter_control_present_flag
+	w.writeFlag(false) // constrained_intra_pred_flag
+	w.writeFlag(false) // redundant_pic_cnt_present_flag
+	return nalUnit(3, nalPPS, w.trailing())
+}
+
+// buildIDRSlice 全部宏块为 I_PCM 的 IDR slice
+func buildIDRSlice(mbWidth, mbHeight int, idrPicID uint32) []byte {
+	w := &bitWriter{}
+	w.writeUE(0) // first_mb_in_slice
+	w.writeUE(7) // slice_type: I（全部 slice）
+	w.writeUE(0) // pic_parameter_set_id
+	w.writeBits(0, log2MaxFrameNum)
+	w.writeUE(idrPicID)
+	w.writeFlag(false) // no_output_of_prior_pics_flag
+	w.writeFlag(false) // long_term_reference_flag
+	w.writeSE(0)       // slice_qp_delta
+	w.writeUE(1)       // disable_deblocking_filter_idc
+
+	width := mbWidth * 16
+	samples := make([]byte, 0, 384)
+	for mby := 0; mby < mbHeight; mby++ {
+		for mbx := 0; mbx < mbWidth; mbx++ {
+			w.writeUE(25) // mb_type: I_PCM
+			w.alignZero() // pcm_alignment_zero_bit
+
+			samples = samples[:0]
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					samples = append(samples, colorBars[(mbx*16+x)*8/width][0])
+				}
+			}
+			for plane := 1; plane <= 2; plane++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						samples = append(samples, colorBars[(mbx*16+x*2)*8/width][plane])
+					}
+				}
+			}
+			w.writeBytes(samples)
+		}
+	}
+	return nalUnit(3, nalIDR, w.trailing())
+}
+
+// buildSkipSlice 全部宏块跳过的 P slice，画面保持参考帧不变
+func buildSkipSlice(mbCount int, frameNum uint32) []byte {
+	w := &bitWriter{}
+	w.writeUE(0) // first_mb_in_slice
+	w.writeUE(5) // slice_type: P（全部 slice）
+	w.writeUE(0) // pic_parameter_set_id
+	w.writeBits(frameNum, log2MaxFrameNum)
+	w.writeFlag(false) // num_ref_idx_active_override_flag
+	w.writeFlag(false) // ref_pic_list_modification_flag_l0
+	w.writeFlag(false) // adaptive_ref_pic_marking_mode_flag
+	w.writeSE(0)       // slice_qp_delta
+	w.writeUE(1)       // disable_deblocking_filter_idc
+	w.writeUE(uint32(mbCount))
+	return nalUnit(2, nalSlice, w.trailing())
+}
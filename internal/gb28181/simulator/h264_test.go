@@ -0,0 +1,134 @@
+package simulator
+
+import (
+	"bytes"
+	"testing"
+)
+
+// bitReader 按位读取 RBSP，用于校验生成的码流
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) bits(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		v = v<<1 | uint32(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) ue() uint32 {
+	zeros := uint(0)
+	for r.bits(1) == 0 {
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// unescape 去除防竞争字节
+func unescape(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+func TestExpGolombRoundTrip(t *testing.T) {
+	values := []uint32{0, 1, 2, 3, 7, 25, 98, 1 << 16, 1<<31 - 1}
+	w := &bitWriter{}
+	for _, v := range values {
+		w.writeUE(v)
+	}
+	r := &bitReader{data: w.trailing()}
+	for _, want := range values {
+		if got := r.ue(); got != want {
+			t.Fatalf("ue = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestNALEmulationPrevention(t *testing.T) {
+	nal := nalUnit(3, nalSlice, []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x05})
+	want := []byte{0, 0, 0, 1, 0x61, 0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x05}
+	if !bytes.Equal(nal, want) {
+		t.Fatalf("nal = % x, want % x", nal, want)
+	}
+}
+
+func TestTestPatternSPS(t *testing.T) {
+	cases := []struct{ width, height int }{
+		{176, 144},
+		{352, 288},
+		{640, 480},
+	}
+	for _, tc := range cases {
+		p := newTestPattern(tc.width, tc.height)
+		nals := bytes.Split(p.idr[0], []byte{0, 0, 0, 1})[1:]
+		if len(nals) != 3 || nals[0][0]&0x1F != nalSPS || nals[1][0]&0x1F != nalPPS || nals[2][0]&0x1F != nalIDR {
+			t.Fatalf("%dx%d: 关键帧应为 SPS/PPS/IDR", tc.width, tc.height)
+		}
+
+		r := &bitReader{data: unescape(nals[0][1:])}
+		if profile := r.bits(8); profile != 66 {
+			t.Errorf("profile_idc = %d", profile)
+		}
+		r.bits(16) // constraint flags + level_idc
+		r.ue()     // seq_parameter_set_id
+		r.ue()     // log2_max_frame_num_minus4
+		if poc := r.ue(); poc != 2 {
+			t.Errorf("pic_order_cnt_type = %d", poc)
+		}
+		r.ue()    // max_num_ref_frames
+		r.bits(1) // gaps_in_frame_num_value_allowed_flag
+		width := int(r.ue()+1) * 16
+		height := int(r.ue()+1) * 16
+		if width != tc.width || height != tc.height {
+			t.Errorf("SPS 分辨率 = %dx%d, want %dx%d", width, height, tc.width, tc.height)
+		}
+
+		// I_PCM 宏块：每个宏块 384 字节像素，加上头部和对齐开销
+		mbs := tc.width * tc.height / 256
+		if size := len(unescape(nals[2])); size < mbs*384 || size > mbs*386+16 {
+			t.Errorf("IDR 大小 = %d, 宏块数 %d", size, mbs)
+		}
+	}
+}
+
+func TestMuxPSKeyframe(t *testing.T) {
+	frame := bytes.Repeat([]byte{0x11}, maxPESPayload+100)
+	ps := muxPS(frame, 3600, true)
+
+	if !bytes.HasPrefix(ps, []byte{0, 0, 1, 0xBA}) {
+		t.Fatal("缺少 pack header")
+	}
+	if !bytes.Contains(ps, []byte{0, 0, 1, 0xBB}) || !bytes.Contains(ps, []byte{0, 0, 1, 0xBC}) {
+		t.Fatal("关键帧缺少 system header 或 PSM")
+	}
+	if n := bytes.Count(ps, []byte{0, 0, 1, 0xE0}); n != 2 {
+		t.Fatalf("PES 包数 = %d, want 2", n)
+	}
+
+	psm := ps[bytes.Index(ps, []byte{0, 0, 1, 0xBC}):]
+	psm = psm[:6+(int(psm[4])<<8|int(psm[5]))]
+	if crc32MPEG(psm) != 0 {
+		t.Error("PSM CRC 校验失败")
+	}
+
+	if bytes.Contains(muxPS([]byte{1, 2, 3}, 0, false), []byte{0, 0, 1, 0xBC}) {
+		t.Error("非关键帧不应携带 PSM")
+	}
+}
@@ -0,0 +1,232 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// recordTimeLayout MANSCDP 录像时间格式
+const recordTimeLayout = "2006-01-02T15:04:05"
+
+// query 平台下发的 MANSCDP 查询/控制命令
+type query struct {
+	XMLName   xml.Name
+	CmdType   string `xml:"CmdType"`
+	SN        int64  `xml:"SN"`
+	DeviceID  string `xml:"DeviceID"`
+	StartTime string `xml:"StartTime"`
+	EndTime   string `xml:"EndTime"`
+	Type      string `xml:"Type"`
+}
+
+// parseQuery 解析 MANSCDP 消息体，GB2312 声明按 ASCII 处理
+func parseQuery(body string) (*query, error) {
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	q := &query{}
+	if err := decoder.Decode(q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// handleMessage 应答平台 MESSAGE：先回复 200，再异步发送 Response
+func (d *Device) handleMessage(req *message) {
+	q, err := parseQuery(req.Body)
+	if err != nil {
+		d.reply(req, 400, "Bad Request")
+		return
+	}
+	d.reply(req, 200, "OK")
+	if q.XMLName.Local != "Query" {
+		return
+	}
+
+	var bodies []string
+	switch q.CmdType {
+	case "Catalog":
+		bodies = d.catalogResponses(q)
+	case "DeviceInfo":
+		bodies = []string{d.deviceInfoResponse(q)}
+	case "DeviceStatus":
+		bodies = []string{d.deviceStatusResponse(q)}
+	case "RecordInfo":
+		bodies = d.recordInfoResponses(q)
+	default:
+		d.logf("忽略查询: %s", q.CmdType)
+		return
+	}
+
+	atomic.AddInt64(&d.queries, 1)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for _, body := range bodies {
+			resp, err := d.sendMessage(context.Background(), body)
+			if err != nil {
+				d.logf("发送 %s 应答失败: %v", q.CmdType, err)
+				return
+			}
+			if resp.StatusCode != 200 {
+				d.logf("平台拒绝 %s 应答: %d %s", q.CmdType, resp.StatusCode, resp.Reason)
+				return
+			}
+		}
+	}()
+}
+
+// pages 按 PageSize 分包
+func (d *Device) pages(total int) [][2]int {
+	if total == 0 {
+		return [][2]int{{0, 0}}
+	}
+	var result [][2]int
+	for start := 0; start < total; start += d.cfg.PageSize {
+		end := start + d.cfg.PageSize
+		if end > total {
+			end = total
+		}
+		result = append(result, [2]int{start, end})
+	}
+	return result
+}
+
+// catalogResponses 目录应答，按 PageSize 分多个 MESSAGE 返回
+func (d *Device) catalogResponses(q *query) []string {
+	var bodies []string
+	for _, page := range d.pages(len(d.channels)) {
+		var items bytes.Buffer
+		for i := page[0]; i < page[1]; i++ {
+			fmt.Fprintf(&items, `<Item>
+<DeviceID>%s</DeviceID>
+<Name>Camera %02d</Name>
+<Manufacturer>%s</Manufacturer>
+<Model>%s</Model>
+<Owner>Owner</Owner>
+<CivilCode>%s</CivilCode>
+<Address>Simulator</Address>
+<Parental>0</Parental>
+<ParentID>%s</ParentID>
+<SafetyWay>0</SafetyWay>
+<RegisterWay>1</RegisterWay>
+<Secrecy>0</Secrecy>
+<Status>ON</Status>
+<Info>
+<PTZType>3</PTZType>
+</Info>
+</Item>
+`, d.channels[i], i+1, xmlEscape(d.cfg.Manufacturer), xmlEscape(d.cfg.Model), d.cfg.DeviceID[:6], d.cfg.DeviceID)
+		}
+		bodies = append(bodies, fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Response>
+<CmdType>Catalog</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<SumNum>%d</SumNum>
+<DeviceList Num="%d">
+%s</DeviceList>
+</Response>`, q.SN, d.cfg.DeviceID, len(d.channels), page[1]-page[0], items.String()))
+	}
+	return bodies
+}
+
+// deviceInfoResponse 设备信息应答
+func (d *Device) deviceInfoResponse(q *query) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Response>
+<CmdType>DeviceInfo</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<DeviceName>%s</DeviceName>
+<Result>OK</Result>
+<Manufacturer>%s</Manufacturer>
+<Model>%s</Model>
+<Firmware>%s</Firmware>
+<Channel>%d</Channel>
+</Response>`, q.SN, d.cfg.DeviceID, xmlEscape(d.cfg.Model), xmlEscape(d.cfg.Manufacturer), xmlEscape(d.cfg.Model), xmlEscape(d.cfg.Firmware), len(d.channels))
+}
+
+// deviceStatusResponse 设备状态应答
+func (d *Device) deviceStatusResponse(q *query) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Response>
+<CmdType>DeviceStatus</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<Result>OK</Result>
+<Online>ONLINE</Online>
+<Status>OK</Status>
+<Encode>ON</Encode>
+<Record>ON</Record>
+<DeviceTime>%s</DeviceTime>
+<Alarmstatus Num="0">
+</Alarmstatus>
+</Response>`, q.SN, d.cfg.DeviceID, time.Now().Format(recordTimeLayout))
+}
+
+// recordInfoResponses 录像查询应答：将查询时段均分为 RecordsPerChannel 段
+func (d *Device) recordInfoResponses(q *query) []string {
+	type record struct{ start, end time.Time }
+	var records []record
+
+	start, err1 := time.ParseInLocation(recordTimeLayout, q.StartTime, time.Local)
+	end, err2 := time.ParseInLocation(recordTimeLayout, q.EndTime, time.Local)
+	if err1 == nil && err2 == nil && end.After(start) && d.hasChannel(q.DeviceID) {
+		if now := time.Now(); end.After(now) {
+			end = now // 不返回未来的录像
+		}
+		if end.After(start) {
+			step := end.Sub(start) / time.Duration(d.cfg.RecordsPerChannel)
+			for i := 0; i < d.cfg.RecordsPerChannel && step >= time.Second; i++ {
+				segStart := start.Add(step * time.Duration(i))
+				records = append(records, record{segStart, segStart.Add(step - time.Second)})
+			}
+		}
+	}
+
+	var bodies []string
+	for _, page := range d.pages(len(records)) {
+		var items bytes.Buffer
+		for i := page[0]; i < page[1]; i++ {
+			r := records[i]
+			fmt.Fprintf(&items, `<Item>
+<DeviceID>%s</DeviceID>
+<Name>Camera</Name>
+<FilePath>/record/%s/%d.ps</FilePath>
+<Address>Simulator</Address>
+<StartTime>%s</StartTime>
+<EndTime>%s</EndTime>
+<Secrecy>0</Secrecy>
+<Type>time</Type>
+<FileSize>%d</FileSize>
+</Item>
+`, q.DeviceID, q.DeviceID, r.start.Unix(), r.start.Format(recordTimeLayout), r.end.Format(recordTimeLayout), int64(r.end.Sub(r.start).Seconds())*64*1024)
+		}
+		bodies = append(bodies, fmt.Sprintf(`<?xml version="1.0" encoding="GB2312"?>
+<Response>
+<CmdType>RecordInfo</CmdType>
+<SN>%d</SN>
+<DeviceID>%s</DeviceID>
+<Name>Camera</Name>
+<SumNum>%d</SumNum>
+<RecordList Num="%d">
+%s</RecordList>
+</Response>`, q.SN, q.DeviceID, len(records), page[1]-page[0], items.String()))
+	}
+	return bodies
+}
+
+// xmlEscape 转义 XML 文本
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,280 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 媒体传输方式（设备侧视角）
+const (
+	mediaUDP       = "UDP"
+	mediaTCPDial   = "TCP-Active"  // 平台 setup:passive，设备主动连接平台
+	mediaTCPListen = "TCP-Passive" // 平台 setup:active，设备监听等待平台连接
+)
+
+// rtpPayloadType PS 流的 RTP 负载类型
+const rtpPayloadType = 96
+
+// maxRTPPayload 单个 RTP 包的最大负载，保证 UDP 包不超过常见 MTU
+const maxRTPPayload = 1400
+
+// mediaConnectTimeout TCP 媒体连接建立超时
+const mediaConnectTimeout = 10 * time.Second
+
+// sdpOffer 平台 INVITE 中的媒体描述
+type sdpOffer struct {
+	session string // s= 行：Play / Playback / Download
+	ip      string
+	port    int
+	proto   string // RTP/AVP 或 TCP/RTP/AVP
+	setup   string // active / passive
+	ssrc    string
+}
+
+// parseSDPOffer 解析 INVITE SDP
+func parseSDPOffer(body string) (*sdpOffer, error) {
+	offer := &sdpOffer{}
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "s="):
+			offer.session = line[2:]
+		case strings.HasPrefix(line, "c=IN IP4 "):
+			offer.ip = strings.TrimSpace(line[len("c=IN IP4 "):])
+		case strings.HasPrefix(line, "m=video "):
+			fields := strings.Fields(line[2:])
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("无效的媒体行: %s", line)
+			}
+			port, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("无效的媒体端口: %s", fields[1])
+			}
+			offer.port = port
+			offer.proto = strings.ToUpper(fields[2])
+		case strings.HasPrefix(line, "a=setup:"):
+			offer.setup = strings.ToLower(line[len("a=setup:"):])
+		case strings.HasPrefix(line, "y="):
+			offer.ssrc = line[2:]
+		}
+	}
+	if offer.ip == "" || offer.port <= 0 {
+		return nil, fmt.Errorf("SDP 缺少媒体地址")
+	}
+	return offer, nil
+}
+
+// mode 设备侧媒体传输方式
+func (o *sdpOffer) mode() string {
+	if !strings.HasPrefix(o.proto, "TCP/") {
+		return mediaUDP
+	}
+	if o.setup == "active" {
+		return mediaTCPListen
+	}
+	return mediaTCPDial
+}
+
+// ssrcValue y= 字段（十进制）对应的 RTP SSRC
+func (o *sdpOffer) ssrcValue() uint32 {
+	value, _ := strconv.ParseUint(o.ssrc, 10, 64)
+	return uint32(value)
+}
+
+// mediaSession 一路推流
+type mediaSession struct {
+	mode   string
+	remote string // 平台媒体地址 host:port
+	ssrc   uint32
+
+	udpConn  *net.UDPConn // UDP：本地发送端口
+	listener net.Listener // TCP-Passive：本地监听端口
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	started  int32
+}
+
+// newMediaSession 按平台 SDP 准备本地媒体端口，返回会话和应答 SDP 中的本地端口
+func newMediaSession(offer *sdpOffer, localIP string) (*mediaSession, int, error) {
+	ms := &mediaSession{
+		mode:   offer.mode(),
+		remote: net.JoinHostPort(offer.ip, strconv.Itoa(offer.port)),
+		ssrc:   offer.ssrcValue(),
+		stop:   make(chan struct{}),
+	}
+
+	switch ms.mode {
+	case mediaUDP:
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(localIP)})
+		if err != nil {
+			return nil, 0, fmt.Errorf("打开媒体端口失败: %w", err)
+		}
+		ms.udpConn = conn
+		return ms, conn.LocalAddr().(*net.UDPAddr).Port, nil
+	case mediaTCPListen:
+		listener, err := net.Listen("tcp", net.JoinHostPort(localIP, "0"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("打开媒体监听失败: %w", err)
+		}
+		ms.listener = listener
+		return ms, listener.Addr().(*net.TCPAddr).Port, nil
+	}
+	// 设备主动连接时本地端口由系统分配，应答中按惯例填 9（discard）
+	return ms, 9, nil
+}
+
+// answerSDP 构建 200 OK 中的 SDP 应答
+func (ms *mediaSession) answerSDP(channelID, localIP string, port int, offer *sdpOffer) string {
+	proto, attrs := "RTP/AVP", ""
+	switch ms.mode {
+	case mediaTCPDial:
+		proto, attrs = "TCP/RTP/AVP", "a=setup:active\r\na=connection:new\r\n"
+	case mediaTCPListen:
+		proto, attrs = "TCP/RTP/AVP", "a=setup:passive\r\na=connection:new\r\n"
+	}
+	session := offer.session
+	if session == "" {
+		session = "Play"
+	}
+	return fmt.Sprintf("v=0\r\n"+
+		"o=%s 0 0 IN IP4 %s\r\n"+
+		"s=%s\r\n"+
+		"c=IN IP4 %s\r\n"+
+		"t=0 0\r\n"+
+		"m=video %d %s %d\r\n"+
+		"a=sendonly\r\n"+
+		"a=rtpmap:%d PS/90000\r\n"+
+		"%sy=%s\r\n"+
+		"f=\r\n",
+		channelID, localIP, session, localIP, port, proto, rtpPayloadType, rtpPayloadType, attrs, offer.ssrc)
+}
+
+// close 停止推流并释放端口
+func (ms *mediaSession) close() {
+	ms.stopOnce.Do(func() {
+		close(ms.stop)
+		if ms.udpConn != nil {
+			ms.udpConn.Close()
+		}
+		if ms.listener != nil {
+			ms.listener.Close()
+		}
+	})
+}
+
+// stopped 是否已停止
+func (ms *mediaSession) stopped() bool {
+	select {
+	case <-ms.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// connect 建立媒体发送通道，返回单个 RTP 包的发送函数
+func (ms *mediaSession) connect() (func(packet []byte) error, func(), error) {
+	switch ms.mode {
+	case mediaUDP:
+		remote, err := net.ResolveUDPAddr("udp", ms.remote)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(packet []byte) error {
+			_, err := ms.udpConn.WriteToUDP(packet, remote)
+			return err
+		}, func() {}, nil
+	case mediaTCPDial:
+		conn, err := net.DialTimeout("tcp", ms.remote, mediaConnectTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("连接平台媒体端口失败: %w", err)
+		}
+		return rfc4571Writer(conn), func() { conn.Close() }, nil
+	}
+
+	if tcpListener, ok := ms.listener.(*net.TCPListener); ok {
+		tcpListener.SetDeadline(time.Now().Add(mediaConnectTimeout))
+	}
+	conn, err := ms.listener.Accept()
+	if err != nil {
+		return nil, nil, fmt.Errorf("等待平台媒体连接失败: %w", err)
+	}
+	return rfc4571Writer(conn), func() { conn.Close() }, nil
+}
+
+// rfc4571Writer TCP 上以 2 字节长度前缀承载 RTP（RFC 4571）
+func rfc4571Writer(conn net.Conn) func(packet []byte) error {
+	return func(packet []byte) error {
+		frame := make([]byte, 2+len(packet))
+		binary.BigEndian.PutUint16(frame, uint16(len(packet)))
+		copy(frame[2:], packet)
+		_, err := conn.Write(frame)
+		return err
+	}
+}
+
+// run 按帧率推送测试图案，直到会话停止或发送失败
+func (ms *mediaSession) run(d *Device) error {
+	if !atomic.CompareAndSwapInt32(&ms.started, 0, 1) {
+		return nil
+	}
+	send, release, err := ms.connect()
+	if err != nil {
+		if ms.stopped() {
+			return nil
+		}
+		return err
+	}
+	defer release()
+
+	fps, gop := d.cfg.FPS, d.cfg.GOP
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer ticker.Stop()
+
+	seq := uint16(time.Now().UnixNano())
+	for index := 0; ; index++ {
+		frame := d.pattern.frame(index/gop, index%gop)
+		pts := uint64(index) * 90000 / uint64(fps)
+		ps := muxPS(frame, pts, index%gop == 0)
+
+		for offset := 0; offset < len(ps); offset += maxRTPPayload {
+			end := offset + maxRTPPayload
+			if end > len(ps) {
+				end = len(ps)
+			}
+			packet := make([]byte, 12, 12+end-offset)
+			packet[0] = 0x80
+			packet[1] = rtpPayloadType
+			if end == len(ps) {
+				packet[1] |= 0x80 // 帧的最后一个包置 marker
+			}
+			binary.BigEndian.PutUint16(packet[2:], seq)
+			binary.BigEndian.PutUint32(packet[4:], uint32(pts))
+			binary.BigEndian.PutUint32(packet[8:], ms.ssrc)
+			packet = append(packet, ps[offset:end]...)
+			seq++
+
+			if err := send(packet); err != nil {
+				if ms.stopped() {
+					return nil
+				}
+				return err
+			}
+			atomic.AddInt64(&d.rtpPackets, 1)
+			atomic.AddInt64(&d.rtpBytes, int64(len(packet)))
+		}
+
+		select {
+		case <-ms.stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package simulator
+
+import "encoding/binary"
+
+// PS（MPEG-2 Program Stream）封装，GB28181 媒体流格式
+
+// 流标识
+const (
+	psStreamIDVideo  = 0xE0
+	psStreamTypeH264 = 0x1B
+)
+
+// maxPESPayload 单个 PES 包承载的最大 ES 字节数（PES_packet_length 为 16 位）
+const maxPESPayload = 65000
+
+// psMuxRate 包头中的复用码率（单位 50 字节/秒）
+const psMuxRate = 6106
+
+// muxPS 将一个 H.264 访问单元封装为 PS 包，关键帧携带系统头和节目流映射
+func muxPS(frame []byte, pts uint64, keyframe bool) []byte {
+	out := make([]byte, 0, len(frame)+64+len(frame)/maxPESPayload*14)
+	out = appendPackHeader(out, pts)
+	if keyframe {
+		out = appendSystemHeader(out)
+		out = appendPSM(out)
+	}
+
+	for first := true; len(frame) > 0; first = false {
+		n := len(frame)
+		if n > maxPESPayload {
+			n = maxPESPayload
+		}
+		out = appendPES(out, frame[:n], pts, first)
+		frame = frame[n:]
+	}
+	return out
+}
+
+// appendPackHeader 写入 pack_header，SCR 取 PTS
+func appendPackHeader(out []byte, scr uint64) []byte {
+	return append(out,
+		0x00, 0x00, 0x01, 0xBA,
+		0x44|byte(scr>>27&0x38)|byte(scr>>28&0x03),
+		byte(scr>>20),
+		byte(scr>>12&0xF8)|0x04|byte(scr>>13&0x03),
+		byte(scr>>5),
+		byte(scr<<3&0xF8)|0x04,
+		0x01,
+		byte(psMuxRate>>14),
+		byte(psMuxRate>>6&0xFF),
+		byte(psMuxRate<<2&0xFC)|0x03,
+		0xF8, // reserved + pack_stuffing_length = 0
+	)
+}
+
+// appendSystemHeader 写入 system_header，仅声明一路视频
+func appendSystemHeader(out []byte) []byte {
+	return append(out,
+		0x00, 0x00, 0x01, 0xBB,
+		0x00, 0x09,
+		0x80|byte(psMuxRate>>15&0x7F),
+		byte(psMuxRate>>7&0xFF),
+		byte(psMuxRate<<1&0xFE)|0x01,
+		0x00, // audio_bound = 0
+		0xE1, // lock flags, marker, video_bound = 1
+		0xFF, // packet_rate_restriction_flag + reserved
+		psStreamIDVideo, 0xE8, 0x00,
+	)
+}
+
+// appendPSM 写入 program_stream_map，视频流类型为 H.264
+func appendPSM(out []byte) []byte {
+	start := len(out)
+	out = append(out,
+		0x00, 0x00, 0x01, 0xBC,
+		0x00, 0x0E, // program_stream_map_length
+		0xE0, 0xFF, // current_next_indicator/version, marker
+		0x00, 0x00, // program_stream_info_length
+		0x00, 0x04, // elementary_stream_map_length
+		psStreamTypeH264, psStreamIDVideo, 0x00, 0x00,
+	)
+	return binary.BigEndian.AppendUint32(out, crc32MPEG(out[start:]))
+}
+
+// appendPES 写入视频 PES 包，首个分片携带 PTS
+func appendPES(out []byte, payload []byte, pts uint64, withPTS bool) []byte {
+	headerLen := 0
+	flags := byte(0x00)
+	if withPTS {
+		headerLen = 5
+		flags = 0x80
+	}
+	out = append(out, 0x00, 0x00, 0x01, psStreamIDVideo)
+	out = binary.BigEndian.AppendUint16(out, uint16(3+headerLen+len(payload)))
+	out = append(out, 0x80, flags, byte(headerLen))
+	if withPTS {
+		out = append(out,
+			0x21|byte(pts>>29&0x0E),
+			byte(pts>>22),
+			byte(pts>>14)|0x01,
+			byte(pts>>7),
+			byte(pts<<1)|0x01,
+		)
+	}
+	return append(out, payload...)
+}
+
+// crc32MPEG MPEG-2 CRC32（多项式 0x04C11DB7，不反转，初值全 1）
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
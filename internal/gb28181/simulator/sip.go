@@ -0,0 +1,281 @@
+package simulator
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// compactHeaders SIP 紧凑头名到完整头名的映射（RFC 3261 7.3.3）
+var compactHeaders = map[string]string{
+	"v": "Via",
+	"f": "From",
+	"t": "To",
+	"i": "Call-ID",
+	"m": "Contact",
+	"l": "Content-Length",
+	"c": "Content-Type",
+}
+
+// message 模拟设备使用的最小 SIP 消息
+type message struct {
+	Method     string // 请求方法，响应为空
+	URI        string // 请求URI
+	StatusCode int    // 响应状态码，请求为 0
+	Reason     string
+	Headers    map[string]string // 规范化头名 -> 值
+	Body       string
+}
+
+// isResponse 是否为响应
+func (m *message) isResponse() bool {
+	return m.StatusCode > 0
+}
+
+// header 读取头字段，头名大小写不敏感
+func (m *message) header(name string) string {
+	if value, ok := m.Headers[name]; ok {
+		return value
+	}
+	for key, value := range m.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// cseqMethod CSeq 中的方法名
+func (m *message) cseqMethod() string {
+	fields := strings.Fields(m.header("CSeq"))
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.ToUpper(fields[1])
+}
+
+// canonicalHeader 规范化头名
+func canonicalHeader(name string) string {
+	name = strings.TrimSpace(name)
+	if full, ok := compactHeaders[strings.ToLower(name)]; ok {
+		return full
+	}
+	switch strings.ToLower(name) {
+	case "call-id":
+		return "Call-ID"
+	case "cseq":
+		return "CSeq"
+	case "www-authenticate":
+		return "WWW-Authenticate"
+	}
+	parts := strings.Split(strings.ToLower(name), "-")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// parseMessage 解析一条完整的 SIP 消息
+func parseMessage(data []byte) (*message, error) {
+	text := string(data)
+	headerEnd := strings.Index(text, "\r\n\r\n")
+	sepLen := 4
+	if headerEnd < 0 {
+		headerEnd = strings.Index(text, "\n\n")
+		sepLen = 2
+	}
+	if headerEnd < 0 {
+		return nil, fmt.Errorf("SIP 消息缺少头部结束标记")
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text[:headerEnd], "\r\n", "\n"), "\n")
+	startLine := strings.TrimSpace(lines[0])
+	parts := strings.SplitN(startLine, " ", 3)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("无效的起始行: %s", startLine)
+	}
+
+	msg := &message{Headers: make(map[string]string)}
+	if strings.HasPrefix(parts[0], "SIP/") {
+		code, err := strconv.Atoi(parts[1])
+		if err != nil || code <= 0 {
+			return nil, fmt.Errorf("无效的状态码: %s", parts[1])
+		}
+		msg.StatusCode = code
+		msg.Reason = parts[2]
+	} else {
+		msg.Method = strings.ToUpper(parts[0])
+		msg.URI = parts[1]
+	}
+
+	lastKey := ""
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && lastKey != "" {
+			msg.Headers[lastKey] += " " + strings.TrimSpace(line)
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("无效的头字段: %s", line)
+		}
+		key := canonicalHeader(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+		if existing, ok := msg.Headers[key]; ok && key == "Via" {
+			// 多个 Via 合并，应答时原样回填
+			value = existing + ", " + value
+		}
+		msg.Headers[key] = value
+		lastKey = key
+	}
+
+	body := text[headerEnd+sepLen:]
+	if length, err := strconv.Atoi(msg.header("Content-Length")); err == nil && length >= 0 && length < len(body) {
+		body = body[:length]
+	}
+	msg.Body = body
+	return msg, nil
+}
+
+// readStreamMessage 从 TCP 流读取一条 SIP 消息，按 Content-Length 分帧
+func readStreamMessage(reader *bufio.Reader) ([]byte, error) {
+	var head strings.Builder
+	contentLength := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "" {
+			if head.Len() == 0 {
+				continue // 消息之间的 CRLF 心跳
+			}
+			head.WriteString("\r\n")
+			break
+		}
+		head.WriteString(trimmed + "\r\n")
+		if colon := strings.Index(trimmed, ":"); colon > 0 && canonicalHeader(trimmed[:colon]) == "Content-Length" {
+			contentLength, _ = strconv.Atoi(strings.TrimSpace(trimmed[colon+1:]))
+		}
+	}
+
+	data := []byte(head.String())
+	if contentLength > 0 {
+		body := make([]byte, contentLength)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+		data = append(data, body...)
+	}
+	return data, nil
+}
+
+// buildRequest 构建 SIP 请求，headers 按给定顺序输出
+func buildRequest(method, uri string, headers [][2]string, contentType, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s SIP/2.0\r\n", method, uri)
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	if body != "" {
+		fmt.Fprintf(&b, "Content-Type: %s\r\n", contentType)
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return []byte(b.String())
+}
+
+// buildResponse 按请求构建 SIP 响应，toTag 非空且 To 中没有 tag 时补上
+func buildResponse(req *message, code int, reason, toTag string, extra [][2]string, contentType, body string) []byte {
+	to := req.header("To")
+	if toTag != "" && !strings.Contains(to, ";tag=") {
+		to += ";tag=" + toTag
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", code, reason)
+	fmt.Fprintf(&b, "Via: %s\r\n", req.header("Via"))
+	fmt.Fprintf(&b, "From: %s\r\n", req.header("From"))
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", req.header("Call-ID"))
+	fmt.Fprintf(&b, "CSeq: %s\r\n", req.header("CSeq"))
+	for _, h := range extra {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	if body != "" {
+		fmt.Fprintf(&b, "Content-Type: %s\r\n", contentType)
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return []byte(b.String())
+}
+
+// parseAuthParams 解析 Digest 质询参数，支持引号内的逗号
+func parseAuthParams(header string) map[string]string {
+	params := make(map[string]string)
+	header = strings.TrimSpace(header)
+	if len(header) >= 6 && strings.EqualFold(header[:6], "Digest") {
+		header = header[6:]
+	}
+
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		eq := strings.Index(header, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(header[:eq]))
+		header = strings.TrimLeft(header[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(header, `"`) {
+			end := strings.Index(header[1:], `"`)
+			if end < 0 {
+				value, header = header[1:], ""
+			} else {
+				value, header = header[1:end+1], header[end+2:]
+			}
+		} else {
+			end := strings.Index(header, ",")
+			if end < 0 {
+				value, header = header, ""
+			} else {
+				value, header = header[:end], header[end:]
+			}
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
+
+// digestAuthorization 按 RFC 2617 计算 Digest 认证头（平台使用 MD5、无 qop）
+func digestAuthorization(username, password, method, uri string, challenge map[string]string) string {
+	realm := challenge["realm"]
+	nonce := challenge["nonce"]
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	response := md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s", algorithm=MD5`,
+		username, realm, nonce, uri, response)
+}
+
+// md5Hex MD5 十六进制摘要
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken 生成随机十六进制串，用于 tag / branch / Call-ID
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
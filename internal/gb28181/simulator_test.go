@@ -0,0 +1,400 @@
+package gb28181
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+	"gb28181-onvif-server/internal/gb28181/simulator"
+	"gb28181-onvif-server/internal/secrets"
+)
+
+// startSimulatorTestServer 启动回环地址上的平台（open 准入模式）
+func startSimulatorTestServer(t *testing.T, password string) *Server {
+	t.Helper()
+	return startLoopbackServer(t, password, AdmissionModeOpen)
+}
+
+// startLoopbackServer 启动回环地址上的平台，准入规则与凭据写入临时目录
+func startLoopbackServer(t *testing.T, password, admissionMode string) *Server {
+	t.Helper()
+	keyring, err := secrets.NewKeyring(secrets.GenerateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetDefault(keyring)
+
+	srv := NewServer(&config.GB28181Config{
+		SipIP:         "127.0.0.1",
+		SipPort:       freeTCPPort(t),
+		Realm:         "3402000000",
+		ServerID:      "34020000002000000001",
+		Password:      password,
+		AdmissionMode: admissionMode,
+	})
+	srv.admission = NewAdmissionManager(filepath.Join(t.TempDir(), "admission.json"))
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv
+}
+
+// newSimulatedDevice 创建连接到测试平台的模拟设备（未启动）
+func newSimulatedDevice(t *testing.T, srv *Server, cfg simulator.Config) *simulator.Device {
+	t.Helper()
+	cfg.ServerID = srv.config.ServerID
+	cfg.Realm = srv.config.Realm
+	cfg.ServerAddr = fmt.Sprintf("%s:%d", srv.config.SipIP, srv.config.SipPort)
+	if strings.EqualFold(cfg.Transport, "UDP") {
+		cfg.ServerAddr = srv.udpConn.LocalAddr().String()
+	}
+	cfg.KeepaliveInterval = -1
+	cfg.Timeout = 3 * time.Second
+	cfg.Logf = t.Logf
+
+	dev, err := simulator.New(cfg)
+	if err != nil {
+		t.Fatalf("simulator.New: %v", err)
+	}
+	t.Cleanup(func() { dev.Close() })
+	return dev
+}
+
+// startSimulatedDevice 创建并注册模拟设备
+func startSimulatedDevice(t *testing.T, srv *Server, cfg simulator.Config) *simulator.Device {
+	t.Helper()
+	dev := newSimulatedDevice(t, srv, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dev.Start(ctx); err != nil {
+		t.Fatalf("模拟设备注册失败: %v", err)
+	}
+	return dev
+}
+
+func TestSimulatedDeviceConformance(t *testing.T) {
+	cases := []struct {
+		name       string
+		transport  string
+		password   string // 平台全局密码
+		credential string // 设备独立密码
+		channels   int
+		pageSize   int
+	}{
+		{name: "UDP", transport: "UDP", channels: 3, pageSize: 10},
+		{name: "TCP", transport: "TCP", channels: 3, pageSize: 10},
+		{name: "UDPDigestPaged", transport: "UDP", password: "12345678", channels: 25, pageSize: 4},
+		{name: "TCPDigestPaged", transport: "TCP", password: "12345678", channels: 25, pageSize: 4},
+		{name: "TCPDeviceCredential", transport: "TCP", credential: "device-secret", channels: 2, pageSize: 1},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := startSimulatorTestServer(t, tc.password)
+			deviceID := fmt.Sprintf("34020000001320%06d", 100+i)
+			password := tc.password
+			if tc.credential != "" {
+				if err := srv.Admission().SetCredential(deviceID, tc.credential); err != nil {
+					t.Fatal(err)
+				}
+				password = tc.credential
+			}
+
+			dev := startSimulatedDevice(t, srv, simulator.Config{
+				DeviceID:  deviceID,
+				Transport: tc.transport,
+				Password:  password,
+				Channels:  tc.channels,
+				PageSize:  tc.pageSize,
+			})
+
+			device, ok := srv.GetDeviceByID(deviceID)
+			if !ok {
+				t.Fatal("平台未登记设备")
+			}
+			if device.Transport != tc.transport {
+				t.Errorf("Transport = %s, want %s", device.Transport, tc.transport)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			channels, err := srv.QueryCatalogSync(ctx, deviceID)
+			if err != nil {
+				t.Fatalf("QueryCatalogSync: %v", err)
+			}
+			got := make(map[string]bool)
+			for _, ch := range channels {
+				got[ch.ChannelID] = true
+			}
+			for _, id := range dev.ChannelIDs() {
+				if !got[id] {
+					t.Errorf("目录缺少通道 %s", id)
+				}
+			}
+			if len(channels) != tc.channels {
+				t.Errorf("目录通道数 = %d, want %d", len(channels), tc.channels)
+			}
+
+			info, err := srv.QueryDeviceInfoSync(ctx, deviceID)
+			if err != nil {
+				t.Fatalf("QueryDeviceInfoSync: %v", err)
+			}
+			if info.Manufacturer != "Simulator" || info.Channel != tc.channels {
+				t.Errorf("DeviceInfo = %+v", info)
+			}
+
+			end := time.Now().Add(-time.Hour).Truncate(time.Second)
+			start := end.Add(-4 * time.Hour)
+			channelID := dev.ChannelIDs()[0]
+			records, err := srv.QueryRecordInfoSync(ctx, channelID, start.Format("2006-01-02T15:04:05"), end.Format("2006-01-02T15:04:05"), "all")
+			if err != nil {
+				t.Fatalf("QueryRecordInfoSync: %v", err)
+			}
+			if len(records) != 4 {
+				t.Fatalf("录像条数 = %d, want 4", len(records))
+			}
+			for _, record := range records {
+				if record.StartTime == "" || record.EndTime == "" {
+					t.Errorf("录像时间为空: %+v", record)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatedDeviceRegisterWrongPassword(t *testing.T) {
+	for _, transport := range []string{"UDP", "TCP"} {
+		t.Run(transport, func(t *testing.T) {
+			srv := startSimulatorTestServer(t, "12345678")
+			dev := newSimulatedDevice(t, srv, simulator.Config{
+				DeviceID:  "34020000001320000200",
+				Transport: transport,
+				Password:  "wrong",
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := dev.Start(ctx); err == nil {
+				t.Fatal("错误密码注册成功")
+			}
+			if _, ok := srv.GetDeviceByID(dev.DeviceID()); ok {
+				t.Error("错误密码的设备被登记")
+			}
+		})
+	}
+}
+
+func TestSimulatedDeviceStreaming(t *testing.T) {
+	cases := []struct {
+		name       string
+		transport  string
+		streamMode string
+	}{
+		{name: "UDPMedia", transport: "UDP", streamMode: StreamModeUDP},
+		{name: "TCPPassiveMedia", transport: "TCP", streamMode: StreamModeTCPPassive},
+		{name: "TCPActiveMedia", transport: "UDP", streamMode: StreamModeTCPActive},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := startSimulatorTestServer(t, "")
+			dev := startSimulatedDevice(t, srv, simulator.Config{
+				DeviceID:  fmt.Sprintf("34020000001320%06d", 300+i),
+				Transport: tc.transport,
+				FPS:       50,
+			})
+			deviceID := dev.DeviceID()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := srv.QueryCatalogSync(ctx, deviceID); err != nil {
+				t.Fatalf("QueryCatalogSync: %v", err)
+			}
+			channelID := dev.ChannelIDs()[0]
+
+			receiver := newRTPReceiver(t, tc.streamMode)
+			session, err := srv.InviteRequest(deviceID, channelID, StreamQualityMain, tc.streamMode, receiver.port, "127.0.0.1")
+			if err != nil {
+				t.Fatalf("InviteRequest: %v", err)
+			}
+			if session.Status != "playing" {
+				t.Fatalf("会话状态 = %s", session.Status)
+			}
+			if tc.streamMode == StreamModeTCPActive {
+				receiver.dial(t, session)
+			}
+
+			ssrc, _ := strconv.ParseUint(session.SSRC, 10, 32)
+			ps := receiver.readFrame(t, uint32(ssrc))
+			assertKeyframePS(t, ps)
+
+			if err := srv.ByeStreamRequest(deviceID, channelID, StreamQualityMain); err != nil {
+				t.Fatalf("ByeStreamRequest: %v", err)
+			}
+			deadline := time.Now().Add(3 * time.Second)
+			for dev.Stats().ActiveStreams > 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("BYE 后模拟设备仍在推流")
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// rtpReceiver 测试用媒体接收端，UDP 或 RFC 4571 TCP
+type rtpReceiver struct {
+	port     int
+	udp      *net.UDPConn
+	listener net.Listener
+	conn     net.Conn
+}
+
+// newRTPReceiver 按平台媒体模式准备接收端口
+func newRTPReceiver(t *testing.T, streamMode string) *rtpReceiver {
+	t.Helper()
+	r := &rtpReceiver{}
+	switch streamMode {
+	case StreamModeUDP:
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		r.udp, r.port = conn, conn.LocalAddr().(*net.UDPAddr).Port
+	case StreamModeTCPPassive:
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		r.listener, r.port = listener, listener.Addr().(*net.TCPAddr).Port
+	default:
+		r.port = freeTCPPort(t) // TCP-Active 由平台连接设备，本端口只写入 SDP
+	}
+	return r
+}
+
+// dial TCP-Active：按设备应答的媒体地址主动连接
+func (r *rtpReceiver) dial(t *testing.T, session *MediaSession) {
+	t.Helper()
+	if session.DeviceMediaPort == 0 {
+		t.Fatal("设备未在 SDP 应答中给出媒体端口")
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(session.DeviceMediaIP, strconv.Itoa(session.DeviceMediaPort)), 3*time.Second)
+	if err != nil {
+		t.Fatalf("连接设备媒体端口: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	r.conn = conn
+}
+
+// readPacket 读取一个 RTP 包
+func (r *rtpReceiver) readPacket(t *testing.T) []byte {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	if r.udp != nil {
+		buf := make([]byte, 2048)
+		r.udp.SetReadDeadline(deadline)
+		n, _, err := r.udp.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("接收 RTP: %v", err)
+		}
+		return buf[:n]
+	}
+
+	if r.conn == nil {
+		if tcpListener, ok := r.listener.(*net.TCPListener); ok {
+			tcpListener.SetDeadline(deadline)
+		}
+		conn, err := r.listener.Accept()
+		if err != nil {
+			t.Fatalf("等待设备媒体连接: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		r.conn = conn
+	}
+	r.conn.SetReadDeadline(deadline)
+	var length [2]byte
+	if _, err := io.ReadFull(r.conn, length[:]); err != nil {
+		t.Fatalf("接收 RTP 长度: %v", err)
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r.conn, packet); err != nil {
+		t.Fatalf("接收 RTP: %v", err)
+	}
+	return packet
+}
+
+// readFrame 校验 RTP 头并拼接第一帧（到 marker 为止）的 PS 数据
+func (r *rtpReceiver) readFrame(t *testing.T, ssrc uint32) []byte {
+	t.Helper()
+	var ps []byte
+	for {
+		packet := r.readPacket(t)
+		if len(packet) < 12 {
+			t.Fatalf("RTP 包过短: %d", len(packet))
+		}
+		if packet[0]>>6 != 2 {
+			t.Fatalf("RTP 版本 = %d", packet[0]>>6)
+		}
+		if pt := packet[1] & 0x7F; pt != 96 {
+			t.Fatalf("RTP 负载类型 = %d", pt)
+		}
+		if got := binary.BigEndian.Uint32(packet[8:]); got != ssrc {
+			t.Fatalf("RTP SSRC = %d, want %d", got, ssrc)
+		}
+		ps = append(ps, packet[12:]...)
+		if packet[1]&0x80 != 0 {
+			return ps
+		}
+	}
+}
+
+// assertKeyframePS 校验 PS 关键帧：包头、节目流映射声明 H.264、PES 中含 SPS/PPS/IDR
+func assertKeyframePS(t *testing.T, ps []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(ps, []byte{0x00, 0x00, 0x01, 0xBA}) {
+		t.Fatalf("PS 数据不以 pack header 开头: % x", ps[:min(len(ps), 8)])
+	}
+
+	var es []byte
+	hasPSM := false
+	for pos := 14 + int(ps[13]&0x07); pos+6 <= len(ps); {
+		if !bytes.Equal(ps[pos:pos+3], []byte{0x00, 0x00, 0x01}) {
+			t.Fatalf("偏移 %d 处缺少起始码", pos)
+		}
+		streamID := ps[pos+3]
+		length := int(binary.BigEndian.Uint16(ps[pos+4:]))
+		body := ps[pos+6 : min(len(ps), pos+6+length)]
+		switch streamID {
+		case 0xBC:
+			hasPSM = len(body) > 8 && body[6] == 0x1B && body[7] == 0xE0
+		case 0xE0:
+			es = append(es, body[3+int(body[2]):]...)
+		}
+		pos += 6 + length
+	}
+	if !hasPSM {
+		t.Error("关键帧缺少声明 H.264 的 PSM")
+	}
+
+	var nalTypes []byte
+	for _, nal := range bytes.Split(es, []byte{0x00, 0x00, 0x00, 0x01})[1:] {
+		nalTypes = append(nalTypes, nal[0]&0x1F)
+	}
+	if !bytes.Equal(nalTypes, []byte{7, 8, 5}) {
+		t.Errorf("NAL 类型 = %v, want [7 8 5]", nalTypes)
+	}
+}
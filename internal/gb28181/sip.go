@@ -2,6 +2,7 @@ package gb28181
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/xml"
@@ -45,23 +46,24 @@ type CatalogDeviceInfo struct {
 }
 
 type CatalogDevice struct {
-	DeviceID     string            `xml:"DeviceID"`
-	Name         string            `xml:"Name"`
-	Manufacturer string            `xml:"Manufacturer"`
-	Model        string            `xml:"Model"`
-	Owner        string            `xml:"Owner"`
-	CivilCode    string            `xml:"CivilCode"`
-	Address      string            `xml:"Address"`
-	Parental     int               `xml:"Parental"`
-	ParentID     string            `xml:"ParentID"`
-	SafetyWay    int               `xml:"SafetyWay"`
-	RegisterWay  int               `xml:"RegisterWay"`
-	Secrecy      int               `xml:"Secrecy"`
-	Status       string            `xml:"Status"`
-	Longitude    string            `xml:"Longitude"`
-	Latitude     string            `xml:"Latitude"`
-	PTZType      int               `xml:"PTZType"` // 直接在Item下的PTZType（部分设备）
-	Info         CatalogDeviceInfo `xml:"Info"`    // 嵌套在Info标签内的PTZType（大华等设备）
+	DeviceID        string            `xml:"DeviceID"`
+	Name            string            `xml:"Name"`
+	Manufacturer    string            `xml:"Manufacturer"`
+	Model           string            `xml:"Model"`
+	Owner           string            `xml:"Owner"`
+	CivilCode       string            `xml:"CivilCode"`
+	Address         string            `xml:"Address"`
+	Parental        int               `xml:"Parental"`
+	ParentID        string            `xml:"ParentID"`
+	BusinessGroupID string            `xml:"BusinessGroupID"`
+	SafetyWay       int               `xml:"SafetyWay"`
+	RegisterWay     int               `xml:"RegisterWay"`
+	Secrecy         int               `xml:"Secrecy"`
+	Status          string            `xml:"Status"`
+	Longitude       string            `xml:"Longitude"`
+	Latitude        string            `xml:"Latitude"`
+	PTZType         int               `xml:"PTZType"` // 直接在Item下的PTZType（部分设备）
+	Info            CatalogDeviceInfo `xml:"Info"`    // 嵌套在Info标签内的PTZType（大华等设备）
 }
 
 // DeviceInfoResponse 设备信息响应结构
@@ -78,6 +80,50 @@ type DeviceInfoResponse struct {
 	Channel      int      `xml:"Channel"`
 }
 
+// DeviceStatusResponse 设备状态查询应答
+type DeviceStatusResponse struct {
+	XMLName     xml.Name `xml:"Response"`
+	CmdType     string   `xml:"CmdType"`
+	SN          int      `xml:"SN"`
+	DeviceID    string   `xml:"DeviceID"`
+	Result      string   `xml:"Result"`
+	Online      string   `xml:"Online"` // ONLINE/OFFLINE
+	Status      string   `xml:"Status"` // OK/ERROR
+	Reason      string   `xml:"Reason"`
+	Encode      string   `xml:"Encode"` // ON/OFF
+	Record      string   `xml:"Record"` // ON/OFF
+	DeviceTime  string   `xml:"DeviceTime"`
+	AlarmStatus struct {
+		Num   int                  `xml:"Num,attr"`
+		Items []AlarmChannelStatus `xml:"Item"`
+	} `xml:"Alarmstatus"`
+}
+
+// AlarmChannelStatus 报警输入通道状态
+type AlarmChannelStatus struct {
+	DeviceID   string `xml:"DeviceID" json:"deviceId"`
+	DutyStatus string `xml:"DutyStatus" json:"dutyStatus"` // ONDUTY/OFFDUTY/ALARM
+}
+
+// PresetQueryResponse 预置位查询应答
+type PresetQueryResponse struct {
+	XMLName    xml.Name `xml:"Response"`
+	CmdType    string   `xml:"CmdType"`
+	SN         int      `xml:"SN"`
+	DeviceID   string   `xml:"DeviceID"`
+	SumNum     int      `xml:"SumNum"` // 标准未定义，部分设备分包返回时携带
+	PresetList struct {
+		Num   int          `xml:"Num,attr"`
+		Items []PresetItem `xml:"Item"`
+	} `xml:"PresetList"`
+}
+
+// PresetItem 预置位
+type PresetItem struct {
+	PresetID   string `xml:"PresetID" json:"presetId"`
+	PresetName string `xml:"PresetName" json:"presetName"`
+}
+
 // RecordInfoResponse GB28181 录像查询响应结构
 type RecordInfoResponse struct {
 	XMLName    xml.Name       `xml:"Response"`
@@ -107,6 +153,123 @@ type RecordItem struct {
 	FileSize   int64  `xml:"FileSize"`
 }
 
+// 设备配置类型（ConfigDownload 的 ConfigType）
+const (
+	ConfigTypeBasicParam       = "BasicParam"
+	ConfigTypeVideoParamOpt    = "VideoParamOpt"
+	ConfigTypeSVACEncodeConfig = "SVACEncodeConfig"
+	ConfigTypeSVACDecodeConfig = "SVACDecodeConfig"
+)
+
+// BasicParam 设备基本参数（GB/T 28181 A.2.4.7 / A.2.6.8）
+// DeviceConfig 下发时仅 Name、Expiration、HeartBeatInterval、HeartBeatCount 可修改
+type BasicParam struct {
+	Name               string `xml:"Name,omitempty" json:"name,omitempty"`
+	DeviceID           string `xml:"DeviceID,omitempty" json:"deviceId,omitempty"`
+	SIPServerID        string `xml:"SIPServerID,omitempty" json:"sipServerId,omitempty"`
+	SIPServerIP        string `xml:"SIPServerIP,omitempty" json:"sipServerIp,omitempty"`
+	SIPServerPort      int    `xml:"SIPServerPort,omitempty" json:"sipServerPort,omitempty"`
+	DomainName         string `xml:"DomainName,omitempty" json:"domainName,omitempty"`
+	Expiration         int    `xml:"Expiration,omitempty" json:"expiration,omitempty"`               // 注册过期时间（秒）
+	Password           string `xml:"Password,omitempty" json:"-"`                                    // 注册口令，不对外返回
+	HeartBeatInterval  int    `xml:"HeartBeatInterval,omitempty" json:"heartBeatInterval,omitempty"` // 心跳间隔（秒）
+	HeartBeatCount     int    `xml:"HeartBeatCount,omitempty" json:"heartBeatCount,omitempty"`       // 心跳超时次数
+	PositionCapability *int   `xml:"PositionCapability,omitempty" json:"positionCapability,omitempty"`
+	Longitude          string `xml:"Longitude,omitempty" json:"longitude,omitempty"`
+	Latitude           string `xml:"Latitude,omitempty" json:"latitude,omitempty"`
+}
+
+// VideoParamOpt 视频参数范围（只读），取值以 "/" 分隔
+type VideoParamOpt struct {
+	DownloadSpeed string `xml:"DownloadSpeed,omitempty" json:"downloadSpeed,omitempty"` // 下载倍速范围，如 1/2/4
+	Resolution    string `xml:"Resolution,omitempty" json:"resolution,omitempty"`       // 摄像机支持的分辨率，如 1/2/3/4/5/6
+}
+
+// SVACROIItem SVAC 感兴趣区域
+type SVACROIItem struct {
+	ROISeq      int `xml:"ROISeq" json:"roiSeq"`           // 感兴趣区域编号 1-16
+	TopLeft     int `xml:"TopLeft" json:"topLeft"`         // 左上角坐标（宏块序号）
+	BottomRight int `xml:"BottomRight" json:"bottomRight"` // 右下角坐标（宏块序号）
+	ROIQP       int `xml:"ROIQP" json:"roiQp"`             // 编码质量等级
+}
+
+// SVACROIParam SVAC 感兴趣区域参数
+type SVACROIParam struct {
+	ROIFlag            *int          `xml:"ROIFlag,omitempty" json:"roiFlag,omitempty"` // 0 关闭, 1 打开
+	ROINumber          *int          `xml:"ROINumber,omitempty" json:"roiNumber,omitempty"`
+	Items              []SVACROIItem `xml:"Item,omitempty" json:"items,omitempty"`
+	BackGroundQP       *int          `xml:"BackGroundQP,omitempty" json:"backGroundQp,omitempty"`
+	BackGroundSkipFlag *int          `xml:"BackGroundSkipFlag,omitempty" json:"backGroundSkipFlag,omitempty"`
+}
+
+// SVACSVCParam SVAC 可伸缩编码参数
+type SVACSVCParam struct {
+	SVCFlag            *int `xml:"SVCFlag,omitempty" json:"svcFlag,omitempty"`
+	SVCSTMMode         *int `xml:"SVCSTMMode,omitempty" json:"svcStmMode,omitempty"`
+	SVCSpaceDomainMode *int `xml:"SVCSpaceDomainMode,omitempty" json:"svcSpaceDomainMode,omitempty"`
+	SVCTimeDomainMode  *int `xml:"SVCTimeDomainMode,omitempty" json:"svcTimeDomainMode,omitempty"`
+}
+
+// SVACSurveillanceParam SVAC 监控专用信息参数
+type SVACSurveillanceParam struct {
+	TimeFlag  *int `xml:"TimeFlag,omitempty" json:"timeFlag,omitempty"`
+	EventFlag *int `xml:"EventFlag,omitempty" json:"eventFlag,omitempty"`
+	AlertFlag *int `xml:"AlertFlag,omitempty" json:"alertFlag,omitempty"`
+}
+
+// SVACAudioParam SVAC 音频参数
+type SVACAudioParam struct {
+	AudioRecognitionFlag *int `xml:"AudioRecognitionFlag,omitempty" json:"audioRecognitionFlag,omitempty"`
+}
+
+// SVACEncodeConfig SVAC 编码配置
+type SVACEncodeConfig struct {
+	ROIParam          *SVACROIParam          `xml:"ROIParam,omitempty" json:"roiParam,omitempty"`
+	SVCParam          *SVACSVCParam          `xml:"SVCParam,omitempty" json:"svcParam,omitempty"`
+	SurveillanceParam *SVACSurveillanceParam `xml:"SurveillanceParam,omitempty" json:"surveillanceParam,omitempty"`
+	AudioParam        *SVACAudioParam        `xml:"AudioParam,omitempty" json:"audioParam,omitempty"`
+}
+
+// SVACDecodeSurveillanceParam SVAC 解码监控专用信息显示参数
+type SVACDecodeSurveillanceParam struct {
+	TimeShowFlag  *int `xml:"TimeShowFlag,omitempty" json:"timeShowFlag,omitempty"`
+	EventShowFlag *int `xml:"EventShowFlag,omitempty" json:"eventShowFlag,omitempty"`
+	AlertShowFlag *int `xml:"AlertShowFlag,omitempty" json:"alertShowFlag,omitempty"`
+}
+
+// SVACDecodeConfig SVAC 解码配置
+type SVACDecodeConfig struct {
+	SVCParam          *SVACSVCParam                `xml:"SVCParam,omitempty" json:"svcParam,omitempty"`
+	SurveillanceParam *SVACDecodeSurveillanceParam `xml:"SurveillanceParam,omitempty" json:"surveillanceParam,omitempty"`
+}
+
+// DeviceConfig 设备配置，ConfigDownload 查询结果与 DeviceConfig 下发共用
+type DeviceConfig struct {
+	BasicParam       *BasicParam       `xml:"BasicParam,omitempty" json:"basicParam,omitempty"`
+	VideoParamOpt    *VideoParamOpt    `xml:"VideoParamOpt,omitempty" json:"videoParamOpt,omitempty"`
+	SVACEncodeConfig *SVACEncodeConfig `xml:"SVACEncodeConfig,omitempty" json:"svacEncodeConfig,omitempty"`
+	SVACDecodeConfig *SVACDecodeConfig `xml:"SVACDecodeConfig,omitempty" json:"svacDecodeConfig,omitempty"`
+}
+
+// ConfigDownloadResponse 设备配置查询应答，设备可能按配置类型分多次返回
+type ConfigDownloadResponse struct {
+	XMLName  xml.Name `xml:"Response"`
+	CmdType  string   `xml:"CmdType"`
+	SN       int      `xml:"SN"`
+	DeviceID string   `xml:"DeviceID"`
+	Result   string   `xml:"Result"`
+	DeviceConfig
+}
+
+// DeviceConfigControl 设备配置下发命令
+type DeviceConfigControl struct {
+	XMLName  xml.Name `xml:"Control"`
+	CmdType  string   `xml:"CmdType"`
+	SN       string   `xml:"SN"`
+	DeviceID string   `xml:"DeviceID"`
+	DeviceConfig
+}
+
 // DeviceRecordInfo 设备端录像信息（用于API返回）
 type DeviceRecordInfo struct {
 	DeviceID  string `json:"deviceId"`
@@ -234,7 +397,12 @@ func BuildSIPResponse(request *SIPMessage, statusCode int, reasonPhrase string)
 	headers += fmt.Sprintf("To: %s\r\n", request.Headers["To"])
 	headers += fmt.Sprintf("Call-ID: %s\r\n", request.Headers["Call-ID"])
 	headers += fmt.Sprintf("CSeq: %s\r\n", cseq)
-	headers += fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123))
+	if statusCode == 200 && cseqMethod(cseq) == "REGISTER" {
+		// GB28181 设备从注册成功响应的 Date 头校时，使用标准规定的本地时间格式
+		headers += fmt.Sprintf("Date: %s\r\n", time.Now().Format(gbDateLayout))
+	} else {
+		headers += fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123))
+	}
 	headers += "Content-Length: 0\r\n"
 
 	// 组合完整响应
@@ -255,12 +423,23 @@ func (s *Server) HandleSIPMessage(conn net.Conn, data []byte) {
 	// 如果是响应，进行响应处理
 	if message.IsResponse {
 		debug.Debug("gb28181", "收到状态响应: %d %s 来自: %s", message.StatusCode, message.Reason, conn.RemoteAddr())
+		s.matchClientTransaction(message)
 		s.handleSIPResponse(conn, message)
 		return
 	}
 
+	// 响应按 Via 中的 received/rport 回送（RFC 3581）
+	if ip, port := addrIPPort(conn.RemoteAddr()); ip != "" {
+		stampVia(message, ip, port)
+	}
+
+	// TLS 连接上的请求必须来自客户端证书绑定的设备
+	if !s.verifyTLSIdentity(conn, message) {
+		return
+	}
+
 	// 根据请求类型进行处理
-	debug.Debug("gb28181", "TCP SIP消息: 类型=%s 来自: %s", message.Type, conn.RemoteAddr())
+	debug.Debug("gb28181", "%s SIP消息: 类型=%s 来自: %s", connTransport(conn), message.Type, conn.RemoteAddr())
 	switch message.Type {
 	case "REGISTER":
 		s.handleRegister(conn, message)
@@ -274,6 +453,8 @@ func (s *Server) HandleSIPMessage(conn net.Conn, data []byte) {
 		s.handleMessage(conn, message)
 	case "OPTIONS":
 		s.handleOptions(conn, message)
+	case "NOTIFY":
+		s.handleNotify(conn, message)
 	default:
 		debug.Warn("gb28181", "未知的SIP消息类型: %s", message.Type)
 	}
@@ -430,6 +611,21 @@ func (s *Server) handleRegister(conn net.Conn, message *SIPMessage) {
 		return
 	}
 
+	// 已通过 TLS 证书注册的设备不允许经明文 TCP 重新注册
+	transport := connTransport(conn)
+	if transport != "TLS" && s.tlsBoundSubject(deviceID) != "" {
+		debug.Warn("gb28181", "拒绝设备 %s 的明文TCP注册：设备已绑定TLS证书", deviceID)
+		conn.Write(BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
+	// 准入检查：黑名单与待审批设备不允许注册
+	srcIP, srcPort := addrIPPort(conn.RemoteAddr())
+	if !s.admitDevice(deviceID, srcIP, srcPort, transport, message.Type) {
+		conn.Write(BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
 	// 认证检查
 	if !s.authenticateMessage(message) {
 		// 发送401未授权响应
@@ -456,14 +652,20 @@ func (s *Server) handleRegister(conn net.Conn, message *SIPMessage) {
 		return
 	}
 
-	ip, port := extractIPPortFromContact(contactHeader)
-	if ip == "" {
+	contactIP, contactPort := extractIPPortFromContact(contactHeader)
+	if contactIP == "" {
 		debug.Warn("gb28181", "无法从Contact头提取IP和端口")
 		response := BuildSIPResponse(message, 400, "Bad Request")
 		conn.Write(response)
 		return
 	}
 
+	// NAT 后的设备 Contact 为内网地址，优先使用连接的实际源地址
+	ip, port := contactIP, contactPort
+	if srcIP != "" {
+		ip, port = srcIP, srcPort
+	}
+
 	// 解析Expires头
 	expires := 3600 // 默认3600秒
 	expiresHeader := message.Headers["Expires"]
@@ -473,8 +675,12 @@ func (s *Server) handleRegister(conn net.Conn, message *SIPMessage) {
 		}
 	}
 
-	// 注册设备（TCP模式，保存连接）
-	s.RegisterDeviceWithConn(deviceID, "", ip, port, expires, "TCP", conn)
+	// 注册设备（TCP/TLS模式，保存连接）
+	s.RegisterDeviceWithConn(deviceID, "", ip, port, expires, transport, conn)
+	s.recordDeviceAddresses(deviceID, contactIP, contactPort, ip, port)
+	if transport == "TLS" {
+		s.bindTLSSubject(deviceID, conn)
+	}
 
 	// 发送200 OK响应
 	response := BuildSIPResponse(message, 200, "OK")
@@ -483,8 +689,9 @@ func (s *Server) handleRegister(conn net.Conn, message *SIPMessage) {
 
 // authenticateMessage 认证SIP消息
 func (s *Server) authenticateMessage(message *SIPMessage) bool {
-	// 如果配置中没有设置密码，则跳过认证
-	if s.config.Password == "" {
+	// 设备独立密码优先，未设置任何密码时跳过认证
+	password := s.devicePassword(extractDeviceID(message.Headers["From"]))
+	if password == "" {
 		return true
 	}
 
@@ -532,7 +739,7 @@ func (s *Server) authenticateMessage(message *SIPMessage) bool {
 
 	// 计算期望的响应值
 	// A1 = username:realm:password
-	A1 := fmt.Sprintf("%s:%s:%s", username, realm, s.config.Password)
+	A1 := fmt.Sprintf("%s:%s:%s", username, realm, password)
 	md5A1 := md5.Sum([]byte(A1))
 
 	// A2 = method:uri
@@ -581,11 +788,8 @@ func parseAuthParams(authHeader string) map[string]string {
 
 // generateNonce 生成随机的nonce值并保存
 func generateNonce() string {
-	// 使用当前时间戳和随机数生成nonce
-	timestamp := time.Now().UnixNano()
-	random := fmt.Sprintf("%d", timestamp)
-	hash := md5.Sum([]byte(random))
-	nonce := hex.EncodeToString(hash[:])
+	// nonce 必须不可预测，否则可被预先计算摘要响应
+	nonce := randomHex(16)
 
 	// 保存 nonce
 	nonceStore.Lock()
@@ -648,28 +852,37 @@ func (s *Server) handleMessage(conn net.Conn, message *SIPMessage) {
 	fromHeader := message.Headers["From"]
 	deviceID := extractDeviceID(fromHeader)
 
+	// 获取远程地址信息
+	remoteAddr := conn.RemoteAddr().String()
+	ip, portStr, _ := net.SplitHostPort(remoteAddr)
+	port := 5060
+	if p, err := strconv.Atoi(portStr); err == nil {
+		port = p
+	}
+
+	// 未注册设备只有通过准入检查且无需认证时才自动注册，否则拒绝，由设备重新 REGISTER
+	s.devicesMux.RLock()
+	_, exists := s.devices[deviceID]
+	s.devicesMux.RUnlock()
+	if deviceID != "" && !exists && !s.admitUnregisteredMessage(deviceID, ip, port, connTransport(conn)) {
+		conn.Write(BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
 	// 发送200 OK响应
 	response := BuildSIPResponse(message, 200, "OK")
 	conn.Write(response)
 
 	// 如果设备ID有效，检查设备是否已注册
 	if deviceID != "" {
-		s.devicesMux.RLock()
-		_, exists := s.devices[deviceID]
-		s.devicesMux.RUnlock()
-
-		// 获取远程地址信息
-		remoteAddr := conn.RemoteAddr().String()
-		ip, portStr, _ := net.SplitHostPort(remoteAddr)
-		port := 5060
-		if p, err := strconv.Atoi(portStr); err == nil {
-			port = p
-		}
-
 		if !exists {
-			// 设备未注册，自动注册（TCP 模式，保存连接）
-			log.Printf("[GB28181] ✓ 设备自动注册(TCP): %s", deviceID)
-			s.RegisterDeviceWithConn(deviceID, "", ip, port, 3600, "TCP", conn)
+			// 设备未注册，自动注册（TCP/TLS 模式，保存连接）
+			transport := connTransport(conn)
+			log.Printf("[GB28181] ✓ 设备自动注册(%s): %s", transport, deviceID)
+			s.RegisterDeviceWithConn(deviceID, "", ip, port, 3600, transport, conn)
+			if transport == "TLS" {
+				s.bindTLSSubject(deviceID, conn)
+			}
 
 			// 自动查询设备信息和目录
 			go func() {
@@ -690,13 +903,26 @@ func (s *Server) handleMessage(conn net.Conn, message *SIPMessage) {
 			// 心跳消息，只更新状态
 			if deviceID != "" {
 				s.UpdateKeepAlive(deviceID)
+				if deviceTime, ok := parseDeviceTime(message.Headers["Date"]); ok {
+					s.observeDeviceClock(deviceID, deviceTime, ClockSourceKeepalive)
+				}
 			}
+		} else if strings.Contains(message.Body, "MobilePosition") {
+			s.parseMobilePosition(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "Catalog") && strings.Contains(message.Body, "Response") {
 			s.parseCatalogResponse(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "DeviceInfo") && strings.Contains(message.Body, "Response") {
 			s.parseDeviceInfoResponse(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "RecordInfo") && strings.Contains(message.Body, "Response") {
 			s.parseRecordInfoResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "DeviceStatus") && strings.Contains(message.Body, "Response") {
+			s.parseDeviceStatusResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "PresetQuery") && strings.Contains(message.Body, "Response") {
+			s.parsePresetQueryResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "ConfigDownload") && strings.Contains(message.Body, "Response") {
+			s.parseConfigDownloadResponse(deviceID, message.Body)
+		} else if (strings.Contains(message.Body, "DeviceControl") || strings.Contains(message.Body, "DeviceConfig")) && strings.Contains(message.Body, "Response") {
+			s.parseDeviceControlResponse(deviceID, message.Body)
 		} else {
 			// 其他消息类型
 			debug.Debug("gb28181", "TCP收到MESSAGE (设备 %s): %d字节", deviceID, len(message.Body))
@@ -711,6 +937,40 @@ func (s *Server) handleOptions(conn net.Conn, message *SIPMessage) {
 	conn.Write(response)
 }
 
+// handleNotify 处理NOTIFY请求（移动位置等订阅通知）
+func (s *Server) handleNotify(conn net.Conn, message *SIPMessage) {
+	deviceID := extractDeviceID(message.Headers["From"])
+	if !s.admitNotify(deviceID) {
+		conn.Write(BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
+	response := BuildSIPResponse(message, 200, "OK")
+	conn.Write(response)
+
+	s.handleNotifyBody(deviceID, message.Body)
+}
+
+// admitNotify NOTIFY 只接受已注册设备，未注册、待审批或黑名单设备的通知直接丢弃
+func (s *Server) admitNotify(deviceID string) bool {
+	s.devicesMux.RLock()
+	_, exists := s.devices[deviceID]
+	s.devicesMux.RUnlock()
+	if !exists {
+		debug.Info("gb28181", "设备 %s 未注册，拒绝NOTIFY", deviceID)
+	}
+	return exists
+}
+
+// handleNotifyBody 处理NOTIFY消息体
+func (s *Server) handleNotifyBody(deviceID string, body string) {
+	if strings.Contains(body, "MobilePosition") {
+		s.parseMobilePosition(deviceID, body)
+	} else {
+		debug.Debug("gb28181", "收到NOTIFY (设备 %s): %d字节", deviceID, len(body))
+	}
+}
+
 // extractDeviceID 从From头中提取设备ID
 func extractDeviceID(fromHeader string) string {
 	// From: <sip:34020000001320000001@3402000000>;tag=123456
@@ -765,7 +1025,7 @@ func (s *Server) handleRegisterUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 	if fromHeader == "" {
 		debug.Warn("gb28181", "UDP REGISTER消息缺少From头")
 		response := BuildSIPResponse(message, 400, "Bad Request")
-		s.udpConn.WriteToUDP(response, remoteAddr)
+		s.replyUDP(remoteAddr, message, response)
 		return
 	}
 
@@ -774,7 +1034,20 @@ func (s *Server) handleRegisterUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 	if deviceID == "" {
 		debug.Warn("gb28181", "UDP 无法从From头提取设备ID")
 		response := BuildSIPResponse(message, 400, "Bad Request")
-		s.udpConn.WriteToUDP(response, remoteAddr)
+		s.replyUDP(remoteAddr, message, response)
+		return
+	}
+
+	// 已通过 TLS 证书注册的设备不允许经 UDP 重新注册
+	if s.tlsBoundSubject(deviceID) != "" {
+		debug.Warn("gb28181", "拒绝设备 %s 的UDP注册：设备已绑定TLS证书", deviceID)
+		s.replyUDP(remoteAddr, message, BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
+	// 准入检查：黑名单与待审批设备不允许注册
+	if !s.admitDevice(deviceID, remoteAddr.IP.String(), remoteAddr.Port, "UDP", message.Type) {
+		s.replyUDP(remoteAddr, message, BuildSIPResponse(message, 403, "Forbidden"))
 		return
 	}
 
@@ -798,7 +1071,7 @@ func (s *Server) handleRegisterUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 			realm,
 			generateNonce(),
 		))
-		s.udpConn.WriteToUDP(response, remoteAddr)
+		s.replyUDP(remoteAddr, message, response)
 		return
 	}
 
@@ -809,12 +1082,14 @@ func (s *Server) handleRegisterUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 	ip := remoteAddr.IP.String()
 	port := remoteAddr.Port
 
-	// 记录 Contact 中的地址用于调试
+	// 记录 Contact 中的地址用于诊断
+	var contactIP string
+	var contactPort int
 	if contactHeader != "" {
-		extractedIP, extractedPort := extractIPPortFromContact(contactHeader)
-		if extractedIP != "" && extractedIP != ip {
+		contactIP, contactPort = extractIPPortFromContact(contactHeader)
+		if contactIP != "" && (contactIP != ip || contactPort != port) {
 			log.Printf("[GB28181] NAT检测: Contact=%s:%d, 实际源=%s:%d (使用实际源地址)",
-				extractedIP, extractedPort, ip, port)
+				contactIP, contactPort, ip, port)
 		}
 	}
 
@@ -829,10 +1104,11 @@ func (s *Server) handleRegisterUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 
 	// 注册设备
 	s.RegisterDevice(deviceID, "", ip, port, expires)
+	s.recordDeviceAddresses(deviceID, contactIP, contactPort, ip, port)
 
 	// 发送200 OK响应
 	response := BuildSIPResponse(message, 200, "OK")
-	s.udpConn.WriteToUDP(response, remoteAddr)
+	s.replyUDP(remoteAddr, message, response)
 }
 
 // handleMessageUDP 处理 UDP MESSAGE 请求
@@ -841,16 +1117,21 @@ func (s *Server) handleMessageUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 	fromHeader := message.Headers["From"]
 	deviceID := extractDeviceID(fromHeader)
 
+	// 未注册设备只有通过准入检查且无需认证时才自动注册，否则拒绝，由设备重新 REGISTER
+	s.devicesMux.RLock()
+	_, exists := s.devices[deviceID]
+	s.devicesMux.RUnlock()
+	if deviceID != "" && !exists && !s.admitUnregisteredMessage(deviceID, remoteAddr.IP.String(), remoteAddr.Port, "UDP") {
+		s.replyUDP(remoteAddr, message, BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
 	// 发送200 OK响应
 	response := BuildSIPResponse(message, 200, "OK")
-	s.udpConn.WriteToUDP(response, remoteAddr)
+	s.replyUDP(remoteAddr, message, response)
 
 	// 如果设备ID有效，检查设备是否已注册
 	if deviceID != "" {
-		s.devicesMux.RLock()
-		_, exists := s.devices[deviceID]
-		s.devicesMux.RUnlock()
-
 		if !exists {
 			// 设备未注册，自动注册（从 MESSAGE 消息中提取信息）
 			// 使用 UDP 数据包的实际源地址，这对 NAT 穿透很重要
@@ -858,6 +1139,8 @@ func (s *Server) handleMessageUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 				DeviceID:      deviceID,
 				SipIP:         remoteAddr.IP.String(),
 				SipPort:       remoteAddr.Port,
+				SourceIP:      remoteAddr.IP.String(),
+				SourcePort:    remoteAddr.Port,
 				Transport:     "UDP",
 				Status:        "online",
 				RegisterTime:  time.Now().Unix(),
@@ -886,6 +1169,9 @@ func (s *Server) handleMessageUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 			// 心跳消息，更新状态
 			if deviceID != "" {
 				s.UpdateKeepAlive(deviceID)
+				if deviceTime, ok := parseDeviceTime(message.Headers["Date"]); ok {
+					s.observeDeviceClock(deviceID, deviceTime, ClockSourceKeepalive)
+				}
 				// 如果设备还没有通道，利用心跳的 NAT 窗口发送目录查询
 				s.devicesMux.RLock()
 				device, exists := s.devices[deviceID]
@@ -895,12 +1181,22 @@ func (s *Server) handleMessageUDP(remoteAddr *net.UDPAddr, message *SIPMessage)
 					go s.QueryCatalog(deviceID)
 				}
 			}
+		} else if strings.Contains(message.Body, "MobilePosition") {
+			s.parseMobilePosition(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "Catalog") && strings.Contains(message.Body, "Response") {
 			s.parseCatalogResponse(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "DeviceInfo") && strings.Contains(message.Body, "Response") {
 			s.parseDeviceInfoResponse(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "RecordInfo") && strings.Contains(message.Body, "Response") {
 			s.parseRecordInfoResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "DeviceStatus") && strings.Contains(message.Body, "Response") {
+			s.parseDeviceStatusResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "PresetQuery") && strings.Contains(message.Body, "Response") {
+			s.parsePresetQueryResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "ConfigDownload") && strings.Contains(message.Body, "Response") {
+			s.parseConfigDownloadResponse(deviceID, message.Body)
+		} else if (strings.Contains(message.Body, "DeviceControl") || strings.Contains(message.Body, "DeviceConfig")) && strings.Contains(message.Body, "Response") {
+			s.parseDeviceControlResponse(deviceID, message.Body)
 		}
 	}
 }
@@ -946,16 +1242,32 @@ func (s *Server) parseCatalogResponse(deviceID string, body string) {
 			continue
 		}
 
+		// 行政区划、业务分组、虚拟组织是目录层级节点，保留层级关系，不作为通道
+		if nodeType := CatalogNodeType(channelID); nodeType != "" {
+			s.AddCatalogNode(deviceID, &CatalogNode{
+				ID:              channelID,
+				Name:            item.Name,
+				Type:            nodeType,
+				ParentID:        item.ParentID,
+				BusinessGroupID: item.BusinessGroupID,
+				CivilCode:       item.CivilCode,
+			})
+			continue
+		}
+
 		channel := &Channel{
-			ChannelID:    channelID,
-			DeviceID:     deviceID,
-			Name:         item.Name,
-			Manufacturer: item.Manufacturer,
-			Model:        item.Model,
-			Status:       item.Status,
-			PTZType:      ptzType,
-			Longitude:    item.Longitude,
-			Latitude:     item.Latitude,
+			ChannelID:       channelID,
+			DeviceID:        deviceID,
+			Name:            item.Name,
+			Manufacturer:    item.Manufacturer,
+			Model:           item.Model,
+			Status:          item.Status,
+			PTZType:         ptzType,
+			Longitude:       item.Longitude,
+			Latitude:        item.Latitude,
+			ParentID:        item.ParentID,
+			BusinessGroupID: item.BusinessGroupID,
+			CivilCode:       item.CivilCode,
 		}
 
 		// 添加到设备
@@ -965,6 +1277,19 @@ func (s *Server) parseCatalogResponse(deviceID string, body string) {
 	if len(catalog.DeviceList.Devices) > 0 {
 		log.Printf("[GB28181] ✓ 解析 %d 个通道", len(catalog.DeviceList.Devices))
 	}
+
+	s.deliverQuery("Catalog", int64(catalog.SN), deviceID, catalog.SumNum, func(q *pendingQuery) int {
+		// 返回通道快照，调用方读取时不与后续目录更新共享同一对象
+		s.devicesMux.RLock()
+		for _, item := range catalog.DeviceList.Devices {
+			if channel, ok := s.channels[item.DeviceID]; ok {
+				snapshot := *channel
+				q.channels = append(q.channels, &snapshot)
+			}
+		}
+		s.devicesMux.RUnlock()
+		return len(catalog.DeviceList.Devices)
+	})
 }
 
 // parseDeviceInfoResponse 解析设备信息响应
@@ -994,6 +1319,116 @@ func (s *Server) parseDeviceInfoResponse(deviceID string, body string) {
 	s.devicesMux.Unlock()
 
 	debug.Debug("gb28181", "设备信息更新: ID=%s, 名称=%s, 厂商=%s", deviceID, info.DeviceName, info.Manufacturer)
+
+	s.deliverQuery("DeviceInfo", int64(info.SN), deviceID, 1, func(q *pendingQuery) int {
+		q.info = &info
+		return 1
+	})
+}
+
+// parseDeviceStatusResponse 解析设备状态应答并更新到设备
+func (s *Server) parseDeviceStatusResponse(deviceID string, body string) {
+	body = strings.Replace(body, `encoding="GB2312"`, `encoding="UTF-8"`, 1)
+	body = strings.Replace(body, `encoding='GB2312'`, `encoding='UTF-8'`, 1)
+
+	var response DeviceStatusResponse
+	if err := xml.Unmarshal([]byte(body), &response); err != nil {
+		debug.Warn("gb28181", "解析设备状态应答失败: %v", err)
+		return
+	}
+
+	if response.DeviceID != "" {
+		deviceID = response.DeviceID
+	}
+
+	status := &DeviceStatus{
+		Online:     response.Online,
+		Status:     response.Status,
+		Reason:     response.Reason,
+		Encode:     response.Encode,
+		Record:     response.Record,
+		DeviceTime: response.DeviceTime,
+		Alarms:     response.AlarmStatus.Items,
+		UpdatedAt:  time.Now().Unix(),
+	}
+	s.updateDeviceStatus(deviceID, status)
+	if deviceTime, ok := parseDeviceTime(response.DeviceTime); ok {
+		s.observeDeviceClock(deviceID, deviceTime, ClockSourceDeviceStatus)
+	}
+
+	debug.Debug("gb28181", "设备状态更新: ID=%s, 在线=%s, 状态=%s, 编码=%s, 录像=%s, 报警通道=%d",
+		deviceID, status.Online, status.Status, status.Encode, status.Record, len(status.Alarms))
+
+	s.deliverQuery("DeviceStatus", int64(response.SN), deviceID, 1, func(q *pendingQuery) int {
+		q.status = status
+		return 1
+	})
+}
+
+// parsePresetQueryResponse 解析预置位查询应答
+func (s *Server) parsePresetQueryResponse(deviceID string, body string) {
+	body = strings.Replace(body, `encoding="GB2312"`, `encoding="UTF-8"`, 1)
+	body = strings.Replace(body, `encoding='GB2312'`, `encoding='UTF-8'`, 1)
+
+	var response PresetQueryResponse
+	if err := xml.Unmarshal([]byte(body), &response); err != nil {
+		debug.Warn("gb28181", "解析预置位应答失败: %v", err)
+		return
+	}
+
+	targetID := response.DeviceID
+	if targetID == "" {
+		targetID = deviceID
+	}
+
+	// 标准中预置位列表一次返回，携带 SumNum 的设备按总数等待分包
+	sumNum := response.SumNum
+	if sumNum == 0 {
+		sumNum = len(response.PresetList.Items)
+	}
+	s.deliverQuery("PresetQuery", int64(response.SN), targetID, sumNum, func(q *pendingQuery) int {
+		q.presets = append(q.presets, response.PresetList.Items...)
+		return len(response.PresetList.Items)
+	})
+}
+
+// parseConfigDownloadResponse 解析设备配置查询应答，合并设备分多次返回的配置类型
+func (s *Server) parseConfigDownloadResponse(deviceID string, body string) {
+	body = strings.Replace(body, `encoding="GB2312"`, `encoding="UTF-8"`, 1)
+	body = strings.Replace(body, `encoding='GB2312'`, `encoding='UTF-8'`, 1)
+
+	var response ConfigDownloadResponse
+	if err := xml.Unmarshal([]byte(body), &response); err != nil {
+		debug.Warn("gb28181", "解析设备配置应答失败: %v", err)
+		return
+	}
+
+	targetID := response.DeviceID
+	if targetID == "" {
+		targetID = deviceID
+	}
+
+	s.deliverQuery("ConfigDownload", int64(response.SN), targetID, 0, func(q *pendingQuery) int {
+		if response.BasicParam != nil {
+			q.config.BasicParam = response.BasicParam
+		}
+		if response.VideoParamOpt != nil {
+			q.config.VideoParamOpt = response.VideoParamOpt
+		}
+		if response.SVACEncodeConfig != nil {
+			q.config.SVACEncodeConfig = response.SVACEncodeConfig
+		}
+		if response.SVACDecodeConfig != nil {
+			q.config.SVACDecodeConfig = response.SVACDecodeConfig
+		}
+		q.sumNum = len(q.configTypes)
+		// 设备返回失败时不会再有后续应答，直接结束查询
+		if response.Result != "" && !strings.EqualFold(response.Result, "OK") {
+			q.result = response.Result
+			return q.sumNum - q.received
+		}
+		return configTypeCount(q.config, q.configTypes) - q.received
+	})
 }
 
 // parseRecordInfoResponse 解析录像信息响应
@@ -1046,6 +1481,12 @@ func (s *Server) parseRecordInfoResponse(deviceID string, body string) {
 
 	log.Printf("[GB28181] ✓ 收到 %d 条录像信息 (通道: %s, 总计: %d)",
 		len(records), channelID, recordInfo.SumNum)
+	s.observeRecordClock(deviceID, records)
+
+	s.deliverQuery("RecordInfo", int64(recordInfo.SN), channelID, recordInfo.SumNum, func(q *pendingQuery) int {
+		q.records = append(q.records, records...)
+		return len(records)
+	})
 }
 
 // handleInviteUDP 处理 UDP INVITE 请求
@@ -1055,26 +1496,40 @@ func (s *Server) handleInviteUDP(remoteAddr *net.UDPAddr, message *SIPMessage) {
 	if deviceID == "" {
 		debug.Warn("gb28181", "UDP INVITE消息缺少有效设备ID")
 		response := BuildSIPResponse(message, 400, "Bad Request")
-		s.udpConn.WriteToUDP(response, remoteAddr)
+		s.replyUDP(remoteAddr, message, response)
 		return
 	}
 
 	// 简化处理，直接返回200 OK
 	response := BuildSIPResponse(message, 200, "OK")
-	s.udpConn.WriteToUDP(response, remoteAddr)
+	s.replyUDP(remoteAddr, message, response)
 }
 
 // handleByeUDP 处理 UDP BYE 请求
 func (s *Server) handleByeUDP(remoteAddr *net.UDPAddr, message *SIPMessage) {
 	response := BuildSIPResponse(message, 200, "OK")
-	s.udpConn.WriteToUDP(response, remoteAddr)
+	s.replyUDP(remoteAddr, message, response)
 }
 
 // handleOptionsUDP 处理 UDP OPTIONS 请求（心跳）
 func (s *Server) handleOptionsUDP(remoteAddr *net.UDPAddr, message *SIPMessage) {
 	// 发送200 OK响应
 	response := BuildSIPResponse(message, 200, "OK")
-	s.udpConn.WriteToUDP(response, remoteAddr)
+	s.replyUDP(remoteAddr, message, response)
+}
+
+// handleNotifyUDP 处理 UDP NOTIFY请求
+func (s *Server) handleNotifyUDP(remoteAddr *net.UDPAddr, message *SIPMessage) {
+	deviceID := extractDeviceID(message.Headers["From"])
+	if !s.admitNotify(deviceID) {
+		s.replyUDP(remoteAddr, message, BuildSIPResponse(message, 403, "Forbidden"))
+		return
+	}
+
+	response := BuildSIPResponse(message, 200, "OK")
+	s.replyUDP(remoteAddr, message, response)
+
+	s.handleNotifyBody(deviceID, message.Body)
 }
 
 // handleSIPResponseUDP 处理 UDP SIP 响应消息
@@ -1091,6 +1546,85 @@ func (s *Server) handleSIPResponseUDP(remoteAddr *net.UDPAddr, message *SIPMessa
 			s.parseDeviceInfoResponse(deviceID, message.Body)
 		} else if strings.Contains(message.Body, "RecordInfo") && strings.Contains(message.Body, "Response") {
 			s.parseRecordInfoResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "DeviceStatus") && strings.Contains(message.Body, "Response") {
+			s.parseDeviceStatusResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "PresetQuery") && strings.Contains(message.Body, "Response") {
+			s.parsePresetQueryResponse(deviceID, message.Body)
+		} else if strings.Contains(message.Body, "ConfigDownload") && strings.Contains(message.Body, "Response") {
+			s.parseConfigDownloadResponse(deviceID, message.Body)
+		} else if (strings.Contains(message.Body, "DeviceControl") || strings.Contains(message.Body, "DeviceConfig")) && strings.Contains(message.Body, "Response") {
+			s.parseDeviceControlResponse(deviceID, message.Body)
+		}
+	}
+}
+
+// maxSIPStreamBuffer TCP/TLS 流上等待完整消息时的最大缓存，超出视为异常数据丢弃
+const maxSIPStreamBuffer = 256 * 1024
+
+// sipStream TCP/TLS 流式传输的 SIP 消息分帧（RFC 3261 18.3）
+// 一次读取可能包含多条消息或半条消息，按头部结束标记和 Content-Length 切分
+type sipStream struct {
+	buf []byte
+}
+
+// feed 追加读取到的数据，返回已收齐的完整消息，不完整的部分留待下次读取
+func (st *sipStream) feed(data []byte) [][]byte {
+	st.buf = append(st.buf, data...)
+
+	var messages [][]byte
+	for {
+		// 跳过消息之间的 CRLF 心跳（RFC 5626）
+		start := 0
+		for start < len(st.buf) && (st.buf[start] == '\r' || st.buf[start] == '\n') {
+			start++
+		}
+		st.buf = st.buf[start:]
+		if len(st.buf) == 0 {
+			break
+		}
+
+		headerEnd, sepLen := bytes.Index(st.buf, []byte("\r\n\r\n")), 4
+		if lfEnd := bytes.Index(st.buf, []byte("\n\n")); lfEnd >= 0 && (headerEnd < 0 || lfEnd < headerEnd) {
+			headerEnd, sepLen = lfEnd, 2
+		}
+		if headerEnd < 0 {
+			break
+		}
+
+		total := headerEnd + sepLen + sipContentLength(st.buf[:headerEnd])
+		if len(st.buf) < total {
+			break
+		}
+		messages = append(messages, append([]byte(nil), st.buf[:total]...))
+		st.buf = st.buf[total:]
+	}
+
+	if len(st.buf) > maxSIPStreamBuffer {
+		debug.Warn("gb28181", "TCP流缓存超过 %d 字节仍未收齐消息，丢弃", maxSIPStreamBuffer)
+		st.buf = nil
+	}
+	if len(st.buf) == 0 {
+		st.buf = nil
+	}
+	return messages
+}
+
+// sipContentLength 从头部解析 Content-Length（兼容紧凑形式 l:），缺失或无效时为 0
+func sipContentLength(header []byte) int {
+	for _, line := range strings.Split(string(header), "\n") {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:colon])
+		if !strings.EqualFold(name, "Content-Length") && !strings.EqualFold(name, "l") {
+			continue
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(line[colon+1:]))
+		if err != nil || length < 0 {
+			return 0
 		}
+		return length
 	}
+	return 0
 }
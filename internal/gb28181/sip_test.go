@@ -0,0 +1,68 @@
+package gb28181
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSIPStreamFraming(t *testing.T) {
+	first := "MESSAGE sip:a@b SIP/2.0\r\nCall-ID: 1\r\nContent-Length: 5\r\n\r\nhello"
+	second := "SIP/2.0 200 OK\r\nCall-ID: 2\r\nl: 3\r\n\r\nabc"
+	third := "OPTIONS sip:a@b SIP/2.0\r\nCall-ID: 3\r\n\r\n"
+	stream := "\r\n\r\n" + first + second + third
+
+	cases := []struct {
+		name   string
+		chunks []int // 每次读取的字节数，0 表示剩余全部
+	}{
+		{name: "SingleRead", chunks: []int{0}},
+		{name: "SplitInsideHeader", chunks: []int{20, 0}},
+		{name: "SplitInsideBody", chunks: []int{len("\r\n\r\n") + len(first) - 2, 0}},
+		{name: "ByteByByte", chunks: nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var st sipStream
+			var got []string
+			data := stream
+			for i := 0; len(data) > 0; i++ {
+				n := 1
+				if tc.chunks != nil {
+					n = len(data)
+					if i < len(tc.chunks) && tc.chunks[i] > 0 {
+						n = tc.chunks[i]
+					}
+				}
+				for _, msg := range st.feed([]byte(data[:n])) {
+					got = append(got, string(msg))
+				}
+				data = data[n:]
+			}
+
+			want := []string{first, second, third}
+			if strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Fatalf("分帧结果 = %q, want %q", got, want)
+			}
+			if len(st.buf) != 0 {
+				t.Errorf("残留 %d 字节", len(st.buf))
+			}
+		})
+	}
+}
+
+func TestSIPIdentifiersRandom(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		branch := generateBranch()
+		if !strings.HasPrefix(branch, "z9hG4bK") || len(branch) != len("z9hG4bK")+16 {
+			t.Fatalf("branch = %q", branch)
+		}
+		for _, id := range []string{branch, generateCallID(), generateTag()} {
+			if seen[id] {
+				t.Fatalf("标识重复: %s", id)
+			}
+			seen[id] = true
+		}
+	}
+}
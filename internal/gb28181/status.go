@@ -0,0 +1,93 @@
+package gb28181
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+// defaultDeviceStatusInterval 默认设备状态轮询间隔
+const defaultDeviceStatusInterval = 5 * time.Minute
+
+// DeviceStatus 设备运行状态（DeviceStatus 查询结果）
+type DeviceStatus struct {
+	Online     string               `json:"online"`           // ONLINE/OFFLINE
+	Status     string               `json:"status"`           // OK/ERROR
+	Reason     string               `json:"reason,omitempty"` // 故障原因
+	Encode     string               `json:"encode"`           // 编码状态 ON/OFF
+	Record     string               `json:"record"`           // 录像状态 ON/OFF
+	DeviceTime string               `json:"deviceTime"`       // 设备时间
+	Alarms     []AlarmChannelStatus `json:"alarms"`           // 报警输入通道布防状态
+	UpdatedAt  int64                `json:"updatedAt"`
+}
+
+// updateDeviceStatus 保存设备状态
+func (s *Server) updateDeviceStatus(deviceID string, status *DeviceStatus) {
+	s.devicesMux.Lock()
+	defer s.devicesMux.Unlock()
+
+	if device, ok := s.devices[deviceID]; ok {
+		device.DeviceStatus = status
+	}
+}
+
+// QueryDeviceStatus 发送设备状态查询（异步，应答到达后更新到设备）
+func (s *Server) QueryDeviceStatus(deviceID string) error {
+	device, exists := s.GetDeviceByID(deviceID)
+	if !exists {
+		return fmt.Errorf("设备 %s 不存在", deviceID)
+	}
+
+	sipMessage := s.BuildSIPMessageString(device, deviceID, "Application/MANSCDP+xml", buildDeviceStatusQueryXML(deviceID, nextSN()))
+	if err := s.SendSIPMessageToDevice(device, sipMessage); err != nil {
+		log.Printf("[GB28181] 发送设备状态查询失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// deviceStatusInterval 设备状态轮询间隔，0 表示禁用
+func (s *Server) deviceStatusInterval() time.Duration {
+	switch {
+	case s.config.DeviceStatusInterval < 0:
+		return 0
+	case s.config.DeviceStatusInterval == 0:
+		return defaultDeviceStatusInterval
+	}
+	return time.Duration(s.config.DeviceStatusInterval) * time.Second
+}
+
+// pollDeviceStatus 定期向在线设备查询运行状态
+func (s *Server) pollDeviceStatus() {
+	interval := s.deviceStatusInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.devicesMux.RLock()
+			deviceIDs := make([]string, 0, len(s.devices))
+			for id, device := range s.devices {
+				if device.Status == "online" {
+					deviceIDs = append(deviceIDs, id)
+				}
+			}
+			s.devicesMux.RUnlock()
+
+			for _, id := range deviceIDs {
+				if err := s.QueryDeviceStatus(id); err != nil {
+					debug.Debug("gb28181", "设备状态查询发送失败: device=%s error=%v", id, err)
+				}
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
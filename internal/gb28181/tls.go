@@ -0,0 +1,229 @@
+package gb28181
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+	"gb28181-onvif-server/internal/debug"
+)
+
+// TLS 客户端证书校验模式
+const (
+	TLSClientAuthNone     = "none"     // 不要求客户端证书
+	TLSClientAuthOptional = "optional" // 客户端提供证书时校验
+	TLSClientAuthRequire  = "require"  // 必须提供受信任的客户端证书
+)
+
+// tlsHandshakeTimeout TLS 握手超时
+const tlsHandshakeTimeout = 10 * time.Second
+
+// loadTLSConfig 根据配置加载服务端证书与客户端 CA
+// 配置了 TLSClientCAFile 而未指定 TLSClientAuth 时默认要求客户端证书
+func loadTLSConfig(cfg *config.GB28181Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("未配置 TLS 证书或私钥")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载 TLS 证书失败: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	mode := strings.ToLower(cfg.TLSClientAuth)
+	if mode == "" {
+		mode = TLSClientAuthNone
+		if cfg.TLSClientCAFile != "" {
+			mode = TLSClientAuthRequire
+		}
+	}
+	if mode != TLSClientAuthNone && mode != TLSClientAuthOptional && mode != TLSClientAuthRequire {
+		return nil, fmt.Errorf("不支持的 TLSClientAuth: %s", cfg.TLSClientAuth)
+	}
+	if mode == TLSClientAuthNone {
+		return tlsConfig, nil
+	}
+
+	if cfg.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("TLSClientAuth=%s 需要配置 TLSClientCAFile", mode)
+	}
+	caPEM, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端 CA 失败: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("客户端 CA 文件中没有有效证书: %s", cfg.TLSClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	if mode == TLSClientAuthRequire {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// startTLSListener 启动 SIP over TLS 监听，TLSPort 为 0 时不启用
+func (s *Server) startTLSListener() error {
+	if s.config.TLSPort <= 0 {
+		return nil
+	}
+
+	tlsConfig, err := loadTLSConfig(s.config)
+	if err != nil {
+		return err
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.config.SipIP, s.config.TLSPort)
+	listener, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("TLS监听失败: %w", err)
+	}
+	s.tlsListener = listener
+
+	log.Printf("[GB28181] ✓ SIP over TLS 监听: %s", listener.Addr())
+	debug.Info("gb28181", "TLS监听启动: %s (客户端证书: %s)", listener.Addr(), tlsClientAuthName(tlsConfig.ClientAuth))
+	return nil
+}
+
+// TLSAddr 返回 TLS 监听地址，未启用时返回 nil
+func (s *Server) TLSAddr() net.Addr {
+	if s.tlsListener == nil {
+		return nil
+	}
+	return s.tlsListener.Addr()
+}
+
+// tlsClientAuthName 客户端证书校验模式名称（日志用）
+func tlsClientAuthName(auth tls.ClientAuthType) string {
+	switch auth {
+	case tls.RequireAndVerifyClientCert:
+		return TLSClientAuthRequire
+	case tls.VerifyClientCertIfGiven:
+		return TLSClientAuthOptional
+	}
+	return TLSClientAuthNone
+}
+
+// handshakeTLS 在读取 SIP 消息前完成 TLS 握手，非 TLS 连接直接返回 nil
+func handshakeTLS(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer tlsConn.SetDeadline(time.Time{})
+	return tlsConn.Handshake()
+}
+
+// connTransport 连接的 SIP 传输类型：TLS 或 TCP
+func connTransport(conn net.Conn) string {
+	if _, ok := conn.(*tls.Conn); ok {
+		return "TLS"
+	}
+	return "TCP"
+}
+
+// peerCertificate 返回 TLS 连接上已校验的客户端证书，非 TLS 连接或未提供证书时返回 nil
+func peerCertificate(conn net.Conn) *x509.Certificate {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// certificateDeviceIDs 证书绑定的设备ID：Subject CN 以及 SAN 中 sip URI 的用户部分
+func certificateDeviceIDs(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	for _, uri := range cert.URIs {
+		if id := sipURIUser(uri); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// sipURIUser 提取 sip:/sips: URI 的用户部分
+func sipURIUser(uri *url.URL) string {
+	if uri.Scheme != "sip" && uri.Scheme != "sips" {
+		return ""
+	}
+	user := uri.Opaque
+	if at := strings.Index(user, "@"); at >= 0 {
+		user = user[:at]
+	}
+	return user
+}
+
+// certificateMatchesDevice 证书是否绑定了该设备ID
+func certificateMatchesDevice(cert *x509.Certificate, deviceID string) bool {
+	for _, id := range certificateDeviceIDs(cert) {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyTLSIdentity 校验 TLS 连接上请求的设备ID与客户端证书一致
+// 未提供客户端证书（TLSClientAuth=none/optional）时不做绑定校验；不一致时回复 403 并返回 false
+func (s *Server) verifyTLSIdentity(conn net.Conn, message *SIPMessage) bool {
+	cert := peerCertificate(conn)
+	if cert == nil || message.Type == "ACK" {
+		return true
+	}
+
+	deviceID := extractDeviceID(message.Headers["From"])
+	if deviceID != "" && certificateMatchesDevice(cert, deviceID) {
+		return true
+	}
+
+	log.Printf("[GB28181] ✗ TLS 证书与设备ID不符: 设备=%s 证书=%s 来自 %s", deviceID, cert.Subject, conn.RemoteAddr())
+	debug.Warn("gb28181", "拒绝TLS请求 %s: 设备 %s 不在证书 %v 中", message.Type, deviceID, certificateDeviceIDs(cert))
+	conn.Write(BuildSIPResponse(message, 403, "Forbidden"))
+	return false
+}
+
+// tlsBoundSubject 返回设备已绑定的证书主题，设备未通过 TLS 证书注册时返回空
+func (s *Server) tlsBoundSubject(deviceID string) string {
+	s.devicesMux.RLock()
+	defer s.devicesMux.RUnlock()
+	if device, ok := s.devices[deviceID]; ok && device.Transport == "TLS" {
+		return device.TLSSubject
+	}
+	return ""
+}
+
+// bindTLSSubject 记录设备注册时使用的客户端证书主题
+func (s *Server) bindTLSSubject(deviceID string, conn net.Conn) {
+	subject := ""
+	if cert := peerCertificate(conn); cert != nil {
+		subject = cert.Subject.String()
+	}
+
+	s.devicesMux.Lock()
+	defer s.devicesMux.Unlock()
+	if device, ok := s.devices[deviceID]; ok {
+		device.TLSSubject = subject
+	}
+}
@@ -0,0 +1,321 @@
+package gb28181
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+)
+
+const tlsTestDeviceID = "34020000001320000001"
+
+// testCA 测试用自签名 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "GB28181 Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发证书，返回证书与私钥的 PEM
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// clientCert 签发设备客户端证书
+func (ca *testCA) clientCert(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func freeTCPPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startTLSTestServer 启动仅监听回环地址、启用 TLS 的服务器
+func startTLSTestServer(t *testing.T, ca *testCA, clientAuth string) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "34020000002000000001"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	srv := NewServer(&config.GB28181Config{
+		SipIP:           "127.0.0.1",
+		SipPort:         freeTCPPort(t),
+		Realm:           "3402000000",
+		ServerID:        "34020000002000000001",
+		TLSPort:         freeTCPPort(t),
+		TLSCertFile:     writeTestFile(t, dir, "server.crt", certPEM),
+		TLSKeyFile:      writeTestFile(t, dir, "server.key", keyPEM),
+		TLSClientCAFile: writeTestFile(t, dir, "ca.crt", ca.pem),
+		TLSClientAuth:   clientAuth,
+		AdmissionMode:   AdmissionModeOpen,
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv
+}
+
+// dialTLS 以回环 SIP 客户端连接服务器
+func dialTLS(t *testing.T, srv *Server, ca *testCA, certs ...tls.Certificate) *tls.Conn {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", srv.TLSAddr().String(), &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "127.0.0.1",
+		Certificates: certs,
+	})
+	if err != nil {
+		t.Fatalf("tls.Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func registerRequest(deviceID, transport string, port int) string {
+	return fmt.Sprintf("REGISTER sip:34020000002000000001@3402000000 SIP/2.0\r\n"+
+		"Via: SIP/2.0/%s 127.0.0.1:%d;rport;branch=z9hG4bK%d\r\n"+
+		"From: <sip:%s@3402000000>;tag=1001\r\n"+
+		"To: <sip:%s@3402000000>\r\n"+
+		"Call-ID: %d@127.0.0.1\r\n"+
+		"CSeq: 1 REGISTER\r\n"+
+		"Contact: <sip:%s@127.0.0.1:%d>\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"Expires: 3600\r\n"+
+		"Content-Length: 0\r\n\r\n",
+		transport, port, time.Now().UnixNano(), deviceID, deviceID, time.Now().UnixNano(), deviceID, port)
+}
+
+// readSIPMessage 读取一条无消息体的 SIP 消息头
+func readSIPMessage(t *testing.T, conn net.Conn, reader *bufio.Reader) (string, error) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var sb strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteString(line)
+		if line == "\r\n" {
+			return sb.String(), nil
+		}
+	}
+}
+
+func TestTLSRegisterBindsCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startTLSTestServer(t, ca, TLSClientAuthRequire)
+	conn := dialTLS(t, srv, ca, ca.clientCert(t, tlsTestDeviceID))
+	reader := bufio.NewReader(conn)
+
+	if _, err := conn.Write([]byte(registerRequest(tlsTestDeviceID, "TLS", 5061))); err != nil {
+		t.Fatal(err)
+	}
+	response, err := readSIPMessage(t, conn, reader)
+	if err != nil {
+		t.Fatalf("read REGISTER response: %v", err)
+	}
+	if !strings.HasPrefix(response, "SIP/2.0 200") {
+		t.Fatalf("REGISTER response = %q, want 200 OK", response)
+	}
+
+	device, ok := srv.GetDeviceByID(tlsTestDeviceID)
+	if !ok {
+		t.Fatal("device not registered")
+	}
+	if device.Transport != "TLS" {
+		t.Errorf("Transport = %q, want TLS", device.Transport)
+	}
+	if !strings.Contains(device.TLSSubject, "CN="+tlsTestDeviceID) {
+		t.Errorf("TLSSubject = %q, want CN=%s", device.TLSSubject, tlsTestDeviceID)
+	}
+
+	// 平台下发的请求经同一 TLS 连接到达设备
+	message := srv.BuildSIPMessageString(device, tlsTestDeviceID, "Application/MANSCDP+xml", "")
+	if err := srv.SendSIPMessageToDevice(device, message); err != nil {
+		t.Fatalf("SendSIPMessageToDevice: %v", err)
+	}
+	request, err := readSIPMessage(t, conn, reader)
+	if err != nil {
+		t.Fatalf("read MESSAGE: %v", err)
+	}
+	if !strings.HasPrefix(request, "MESSAGE ") || !strings.Contains(request, "SIP/2.0/TLS") {
+		t.Errorf("request over TLS = %q, want MESSAGE with TLS Via", request)
+	}
+}
+
+func TestTLSRegisterRejectsMismatchedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startTLSTestServer(t, ca, TLSClientAuthRequire)
+	conn := dialTLS(t, srv, ca, ca.clientCert(t, "34020000001320000099"))
+
+	if _, err := conn.Write([]byte(registerRequest(tlsTestDeviceID, "TLS", 5061))); err != nil {
+		t.Fatal(err)
+	}
+	response, err := readSIPMessage(t, conn, bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("read REGISTER response: %v", err)
+	}
+	if !strings.HasPrefix(response, "SIP/2.0 403") {
+		t.Fatalf("REGISTER response = %q, want 403 Forbidden", response)
+	}
+	if _, ok := srv.GetDeviceByID(tlsTestDeviceID); ok {
+		t.Error("device registered with a certificate for another device ID")
+	}
+}
+
+func TestTLSRequireClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startTLSTestServer(t, ca, TLSClientAuthRequire)
+	conn := dialTLS(t, srv, ca)
+
+	// TLS 1.3 下客户端证书在握手完成后才被服务端校验，拒绝体现在后续读写上
+	conn.Write([]byte(registerRequest(tlsTestDeviceID, "TLS", 5061)))
+	if response, err := readSIPMessage(t, conn, bufio.NewReader(conn)); err == nil {
+		t.Fatalf("connection without client certificate got response %q", response)
+	}
+	if _, ok := srv.GetDeviceByID(tlsTestDeviceID); ok {
+		t.Error("device registered without client certificate")
+	}
+}
+
+func TestUDPRegisterRejectedForTLSBoundDevice(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startTLSTestServer(t, ca, TLSClientAuthOptional)
+	conn := dialTLS(t, srv, ca, ca.clientCert(t, tlsTestDeviceID))
+	conn.Write([]byte(registerRequest(tlsTestDeviceID, "TLS", 5061)))
+	if response, err := readSIPMessage(t, conn, bufio.NewReader(conn)); err != nil || !strings.HasPrefix(response, "SIP/2.0 200") {
+		t.Fatalf("TLS REGISTER response = %q, %v", response, err)
+	}
+
+	udp, err := net.DialUDP("udp", nil, srv.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	udp.Write([]byte(registerRequest(tlsTestDeviceID, "UDP", udp.LocalAddr().(*net.UDPAddr).Port)))
+
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 4096)
+	n, err := udp.Read(buffer)
+	if err != nil {
+		t.Fatalf("read UDP REGISTER response: %v", err)
+	}
+	if !strings.HasPrefix(string(buffer[:n]), "SIP/2.0 403") {
+		t.Fatalf("UDP REGISTER response = %q, want 403 Forbidden", buffer[:n])
+	}
+
+	device, _ := srv.GetDeviceByID(tlsTestDeviceID)
+	if device.Transport != "TLS" {
+		t.Errorf("Transport = %q after rejected UDP REGISTER, want TLS", device.Transport)
+	}
+}
+
+func TestCertificateDeviceIDs(t *testing.T) {
+	uri, _ := url.Parse("sip:34020000001320000002@3402000000")
+	other, _ := url.Parse("https://example.com/device")
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: tlsTestDeviceID},
+		URIs:    []*url.URL{uri, other},
+	}
+
+	got := certificateDeviceIDs(cert)
+	want := []string{tlsTestDeviceID, "34020000001320000002"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("certificateDeviceIDs = %v, want %v", got, want)
+	}
+	if !certificateMatchesDevice(cert, "34020000001320000002") {
+		t.Error("certificate should match URI SAN device ID")
+	}
+	if certificateMatchesDevice(cert, "34020000001320000003") {
+		t.Error("certificate should not match unrelated device ID")
+	}
+}
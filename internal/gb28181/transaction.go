@@ -0,0 +1,375 @@
+package gb28181
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+// SIP 定时器（RFC 3261 17.1 / 17.2）
+const (
+	sipT1 = 500 * time.Millisecond // RTT 估计值，UDP 重传起始间隔
+	sipT2 = 4 * time.Second        // 非 INVITE 请求的最大重传间隔
+	sipT4 = 5 * time.Second        // 消息在网络中的最大存活时间
+
+	// transactionTimeout Timer B/F：64*T1 内没有最终响应则事务超时
+	transactionTimeout = 64 * sipT1
+)
+
+// ErrTransactionTimeout 事务超时：设备在 Timer B/F 内没有最终响应
+var ErrTransactionTimeout = errors.New("SIP 事务超时：设备无响应")
+
+// clientTransaction 客户端事务（平台发出的请求）
+type clientTransaction struct {
+	key        string
+	method     string
+	requestURI string
+	request    *SIPMessage
+	raw        string
+	device     *Device
+
+	mu          sync.Mutex
+	reliable    bool        // TCP 传输，无需重传
+	proceeding  bool        // 已收到临时响应
+	final       *SIPMessage // 最终响应
+	err         error
+	done        chan struct{}
+	doneOnce    sync.Once
+	terminateAt time.Time
+}
+
+// serverTransaction 服务端事务（设备发来的请求），用于吸收 UDP 重传
+type serverTransaction struct {
+	response []byte // 已发送的响应，重传请求到达时原样重发
+}
+
+// transactionTable 事务表
+type transactionTable struct {
+	mu      sync.Mutex
+	clients map[string]*clientTransaction
+	servers map[string]*serverTransaction
+
+	// 事务定时器，默认取 RFC 3261 推荐值，测试中可缩短
+	t1      time.Duration
+	t2      time.Duration
+	t4      time.Duration
+	timeout time.Duration
+}
+
+// newTransactionTable 创建事务表
+func newTransactionTable() *transactionTable {
+	return &transactionTable{
+		clients: make(map[string]*clientTransaction),
+		servers: make(map[string]*serverTransaction),
+		t1:      sipT1,
+		t2:      sipT2,
+		t4:      sipT4,
+		timeout: transactionTimeout,
+	}
+}
+
+// viaBranch 提取顶层 Via 的 branch 参数
+func viaBranch(via string) string {
+	if idx := strings.Index(via, ","); idx >= 0 {
+		via = via[:idx]
+	}
+	for _, param := range strings.Split(via, ";")[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "branch") {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// cseqMethod 提取 CSeq 中的方法名
+func cseqMethod(cseq string) string {
+	fields := strings.Fields(cseq)
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.ToUpper(fields[1])
+}
+
+// clientTransactionKey 客户端事务键：branch + CSeq 方法（RFC 3261 17.1.3）
+func clientTransactionKey(message *SIPMessage) string {
+	branch := viaBranch(message.Headers["Via"])
+	method := cseqMethod(message.Headers["CSeq"])
+	if branch == "" || method == "" {
+		return ""
+	}
+	return branch + "|" + method
+}
+
+// serverTransactionKey 服务端事务键：部分设备 branch 不规范（缺少 z9hG4bK 或跨请求复用），
+// 因此同时使用 Call-ID 和完整 CSeq，重传请求这三者均不变
+func serverTransactionKey(message *SIPMessage) string {
+	return message.Headers["Call-ID"] + "|" + viaBranch(message.Headers["Via"]) + "|" + message.Headers["CSeq"]
+}
+
+// complete 结束事务并唤醒等待者
+func (tx *clientTransaction) complete(final *SIPMessage, err error) {
+	tx.doneOnce.Do(func() {
+		tx.mu.Lock()
+		tx.final = final
+		tx.err = err
+		tx.mu.Unlock()
+		close(tx.done)
+	})
+}
+
+// wait 等待最终响应
+func (tx *clientTransaction) wait() (*SIPMessage, error) {
+	<-tx.done
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.final, tx.err
+}
+
+// SendSIPRequest 以客户端事务发送请求并等待最终响应
+// UDP 按 Timer A/E 重传，64*T1 内没有最终响应返回 ErrTransactionTimeout
+func (s *Server) SendSIPRequest(device *Device, message string) (*SIPMessage, error) {
+	tx, err := s.startClientTransaction(device, message)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("无法识别的 SIP 请求（缺少 Via branch 或 CSeq）")
+	}
+	return tx.wait()
+}
+
+// startClientTransaction 发送请求并创建客户端事务；ACK 及无法识别的消息直接发送，返回 nil
+func (s *Server) startClientTransaction(device *Device, message string) (*clientTransaction, error) {
+	if device == nil {
+		return nil, fmt.Errorf("设备为空")
+	}
+
+	request, err := ParseSIPMessage([]byte(message))
+	key := ""
+	if err == nil && !request.IsResponse && request.Type != "ACK" {
+		key = clientTransactionKey(request)
+	}
+	if key == "" {
+		_, err := s.sendToDevice(device, message)
+		return nil, err
+	}
+
+	requestURI := ""
+	if line := strings.SplitN(message, "\r\n", 2)[0]; len(strings.Fields(line)) >= 2 {
+		requestURI = strings.Fields(line)[1]
+	}
+
+	tx := &clientTransaction{
+		key:        key,
+		method:     request.Type,
+		requestURI: requestURI,
+		request:    request,
+		raw:        message,
+		device:     device,
+		done:       make(chan struct{}),
+	}
+
+	// 先登记再发送，避免响应先于登记到达
+	s.transactions.mu.Lock()
+	s.transactions.clients[key] = tx
+	s.transactions.mu.Unlock()
+
+	reliable, err := s.sendToDevice(device, message)
+	if err != nil {
+		s.removeClientTransaction(tx)
+		tx.complete(nil, err)
+		return nil, err
+	}
+
+	tx.mu.Lock()
+	tx.reliable = reliable
+	tx.mu.Unlock()
+
+	go s.runClientTransaction(tx)
+	return tx, nil
+}
+
+// runClientTransaction 事务定时器：UDP 重传（Timer A/E）与超时（Timer B/F）
+// INVITE 收到临时响应后停止重传；非 INVITE 收到临时响应后按 T2 重传
+// 对 GB28181 设备 INVITE 在 Proceeding 状态同样受 64*T1 限制，防止事务悬挂
+func (s *Server) runClientTransaction(tx *clientTransaction) {
+	timeout := time.NewTimer(s.transactions.timeout)
+	defer timeout.Stop()
+
+	tx.mu.Lock()
+	reliable := tx.reliable
+	tx.mu.Unlock()
+
+	interval := s.transactions.t1
+	var retransmit <-chan time.Time
+	if !reliable {
+		retransmit = time.After(interval)
+	}
+
+	for {
+		select {
+		case <-tx.done:
+			return
+		case <-s.stopChan:
+			s.removeClientTransaction(tx)
+			tx.complete(nil, fmt.Errorf("GB28181 服务已停止"))
+			return
+		case <-timeout.C:
+			s.removeClientTransaction(tx)
+			debug.Warn("gb28181", "SIP事务超时: %s device=%s", tx.method, tx.device.DeviceID)
+			tx.complete(nil, ErrTransactionTimeout)
+			return
+		case <-retransmit:
+			tx.mu.Lock()
+			proceeding := tx.proceeding
+			tx.mu.Unlock()
+
+			if proceeding && tx.method == "INVITE" {
+				retransmit = nil
+				continue
+			}
+			debug.Debug("gb28181", "SIP重传: %s device=%s interval=%s", tx.method, tx.device.DeviceID, interval)
+			if err := s.sendViaUDP(tx.device, tx.raw); err != nil {
+				debug.Warn("gb28181", "SIP重传失败: %v", err)
+			}
+
+			interval *= 2
+			if tx.method != "INVITE" && (interval > s.transactions.t2 || proceeding) {
+				interval = s.transactions.t2
+			}
+			retransmit = time.After(interval)
+		}
+	}
+}
+
+// removeClientTransaction 从事务表移除
+func (s *Server) removeClientTransaction(tx *clientTransaction) {
+	s.transactions.mu.Lock()
+	if s.transactions.clients[tx.key] == tx {
+		delete(s.transactions.clients, tx.key)
+	}
+	s.transactions.mu.Unlock()
+}
+
+// matchClientTransaction 将响应交给对应的客户端事务，返回是否为已结束事务的重传响应（应忽略）
+func (s *Server) matchClientTransaction(response *SIPMessage) bool {
+	key := clientTransactionKey(response)
+	if key == "" {
+		return false
+	}
+
+	s.transactions.mu.Lock()
+	tx, ok := s.transactions.clients[key]
+	s.transactions.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if response.StatusCode < 200 {
+		tx.mu.Lock()
+		tx.proceeding = true
+		tx.mu.Unlock()
+		return false
+	}
+
+	select {
+	case <-tx.done:
+		// 已完成的 INVITE 事务收到重传的失败响应，重发 ACK（Timer D）
+		if tx.method == "INVITE" && response.StatusCode >= 300 {
+			s.sendNon2xxACK(tx, response)
+		}
+		return true
+	default:
+	}
+
+	if tx.method == "INVITE" && response.StatusCode >= 300 {
+		// 失败响应的 ACK 属于同一事务，UDP 下保留事务吸收重传的失败响应
+		s.sendNon2xxACK(tx, response)
+		tx.mu.Lock()
+		reliable := tx.reliable
+		tx.mu.Unlock()
+		if !reliable {
+			tx.complete(response, nil)
+			time.AfterFunc(s.transactions.timeout, func() { s.removeClientTransaction(tx) })
+			return false
+		}
+	} else if tx.method != "INVITE" {
+		// 非 INVITE 事务完成后保留 T4 吸收重传的响应（Timer K）
+		tx.complete(response, nil)
+		time.AfterFunc(s.transactions.t4, func() { s.removeClientTransaction(tx) })
+		return false
+	}
+
+	s.removeClientTransaction(tx)
+	tx.complete(response, nil)
+	return false
+}
+
+// sendNon2xxACK 对 INVITE 失败响应发送 ACK（RFC 3261 17.1.1.3，与 INVITE 使用相同 branch）
+func (s *Server) sendNon2xxACK(tx *clientTransaction, response *SIPMessage) {
+	cseqParts := strings.Fields(tx.request.Headers["CSeq"])
+	if len(cseqParts) < 2 {
+		return
+	}
+
+	ack := fmt.Sprintf("ACK %s SIP/2.0\r\n", tx.requestURI)
+	ack += fmt.Sprintf("Via: %s\r\n", tx.request.Headers["Via"])
+	ack += fmt.Sprintf("From: %s\r\n", tx.request.Headers["From"])
+	ack += fmt.Sprintf("To: %s\r\n", response.Headers["To"])
+	ack += fmt.Sprintf("Call-ID: %s\r\n", tx.request.Headers["Call-ID"])
+	ack += fmt.Sprintf("CSeq: %s ACK\r\n", cseqParts[0])
+	ack += "Max-Forwards: 70\r\n"
+	ack += "Content-Length: 0\r\n\r\n"
+
+	if _, err := s.sendToDevice(tx.device, ack); err != nil {
+		debug.Warn("gb28181", "发送失败响应ACK失败: %v", err)
+	}
+}
+
+// absorbRetransmission 吸收设备重传的 UDP 请求：已应答的重发缓存响应，处理中的直接丢弃
+// 返回 true 表示该请求是重传，不应再次处理
+func (s *Server) absorbRetransmission(message *SIPMessage, remoteAddr *net.UDPAddr) bool {
+	if message.Type == "ACK" {
+		return false
+	}
+	key := serverTransactionKey(message)
+
+	s.transactions.mu.Lock()
+	st, ok := s.transactions.servers[key]
+	if !ok {
+		s.transactions.servers[key] = &serverTransaction{}
+		s.transactions.mu.Unlock()
+		// Timer J：64*T1 后不会再有该请求的重传
+		time.AfterFunc(s.transactions.timeout, func() {
+			s.transactions.mu.Lock()
+			delete(s.transactions.servers, key)
+			s.transactions.mu.Unlock()
+		})
+		return false
+	}
+	response := st.response
+	s.transactions.mu.Unlock()
+
+	debug.Debug("gb28181", "吸收重传请求: %s 来自 %s", message.Type, remoteAddr)
+	if response != nil {
+		s.udpConn.WriteToUDP(response, remoteAddr)
+	}
+	return true
+}
+
+// replyUDP 发送 UDP 响应并记录到服务端事务，供重传请求到达时重发
+func (s *Server) replyUDP(remoteAddr *net.UDPAddr, request *SIPMessage, response []byte) {
+	s.transactions.mu.Lock()
+	if st, ok := s.transactions.servers[serverTransactionKey(request)]; ok {
+		st.response = response
+	}
+	s.transactions.mu.Unlock()
+
+	s.udpConn.WriteToUDP(response, remoteAddr)
+}
@@ -0,0 +1,270 @@
+package gb28181
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gb28181-onvif-server/internal/config"
+)
+
+const transactionTestDeviceID = "34020000001320000001"
+
+// startTransactionTestServer 启动缩短了事务定时器的回环平台，并返回模拟设备的 UDP 套接字
+func startTransactionTestServer(t *testing.T) (*Server, *net.UDPConn, *Device) {
+	t.Helper()
+	srv := NewServer(&config.GB28181Config{
+		SipIP:         "127.0.0.1",
+		SipPort:       freeTCPPort(t),
+		Realm:         "3402000000",
+		ServerID:      "34020000002000000001",
+		Password:      "secret",
+		AdmissionMode: AdmissionModeOpen,
+	})
+	srv.admission = NewAdmissionManager(filepath.Join(t.TempDir(), "admission.json"))
+	srv.transactions.t1 = 20 * time.Millisecond
+	srv.transactions.t2 = 80 * time.Millisecond
+	srv.transactions.t4 = 100 * time.Millisecond
+	srv.transactions.timeout = 64 * srv.transactions.t1
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	device := &Device{
+		DeviceID:  transactionTestDeviceID,
+		Transport: "UDP",
+		SipIP:     "127.0.0.1",
+		SipPort:   conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	return srv, conn, device
+}
+
+// transactionRequest 构造平台发往设备的请求
+func transactionRequest(srv *Server, method string) string {
+	id := time.Now().UnixNano()
+	return fmt.Sprintf("%s sip:%s@3402000000 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP %s;rport;branch=z9hG4bK%d\r\n"+
+		"From: <sip:%s@3402000000>;tag=%d\r\n"+
+		"To: <sip:%s@3402000000>\r\n"+
+		"Call-ID: %d@127.0.0.1\r\n"+
+		"CSeq: 1 %s\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"Content-Length: 0\r\n\r\n",
+		method, transactionTestDeviceID, srv.udpConn.LocalAddr(), id,
+		srv.config.ServerID, id, transactionTestDeviceID, id, method)
+}
+
+// readUDPMessage 模拟设备读取一条 SIP 消息，超时返回错误
+func readUDPMessage(conn *net.UDPConn, timeout time.Duration) (*SIPMessage, string, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 4096)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, "", err
+	}
+	message, err := ParseSIPMessage(buffer[:n])
+	return message, string(buffer[:n]), err
+}
+
+// clientTransactionCount 事务表中的客户端事务数
+func clientTransactionCount(srv *Server) int {
+	srv.transactions.mu.Lock()
+	defer srv.transactions.mu.Unlock()
+	return len(srv.transactions.clients)
+}
+
+func TestClientTransactionRetransmitsUntilAnswered(t *testing.T) {
+	srv, conn, device := startTransactionTestServer(t)
+	result := make(chan *SIPMessage, 1)
+	go func() {
+		response, err := srv.SendSIPRequest(device, transactionRequest(srv, "MESSAGE"))
+		if err != nil {
+			t.Errorf("SendSIPRequest: %v", err)
+		}
+		result <- response
+	}()
+
+	// 丢弃首个请求，Timer E 到期后收到原样重传的请求
+	_, first, err := readUDPMessage(conn, time.Second)
+	if err != nil {
+		t.Fatalf("读取请求失败: %v", err)
+	}
+	request, second, err := readUDPMessage(conn, time.Second)
+	if err != nil {
+		t.Fatalf("未收到重传请求: %v", err)
+	}
+	if second != first {
+		t.Fatalf("重传请求与原请求不一致:\n%s\n%s", first, second)
+	}
+
+	response := BuildSIPResponse(request, 200, "OK")
+	conn.WriteToUDP(response, srv.udpConn.LocalAddr().(*net.UDPAddr))
+	select {
+	case got := <-result:
+		if got == nil || got.StatusCode != 200 {
+			t.Fatalf("最终响应 = %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("收到最终响应后事务未结束")
+	}
+
+	// 收到最终响应后停止重传（允许一个在途的重传）
+	if _, _, err := readUDPMessage(conn, 3*srv.transactions.t2); err == nil {
+		if _, _, err := readUDPMessage(conn, 3*srv.transactions.t2); err == nil {
+			t.Error("收到最终响应后仍在重传")
+		}
+	}
+
+	// Timer K：完成后保留 T4 吸收重传的响应，之后从事务表移除
+	if clientTransactionCount(srv) != 0 {
+		t.Errorf("Timer K 到期后事务未清理: %d", clientTransactionCount(srv))
+	}
+}
+
+func TestClientTransactionAbsorbsRetransmittedResponse(t *testing.T) {
+	srv, conn, device := startTransactionTestServer(t)
+	srv.transactions.t4 = time.Hour
+
+	tx, err := srv.startClientTransaction(device, transactionRequest(srv, "MESSAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, _, err := readUDPMessage(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ParseSIPMessage(BuildSIPResponse(request, 200, "OK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.matchClientTransaction(response) {
+		t.Fatal("首个最终响应不应视为重传")
+	}
+	if got, err := tx.wait(); err != nil || got.StatusCode != 200 {
+		t.Fatalf("wait() = %+v, %v", got, err)
+	}
+	if !srv.matchClientTransaction(response) {
+		t.Error("Timer K 期间重传的响应应被吸收")
+	}
+}
+
+func TestClientTransactionTimeout(t *testing.T) {
+	srv, conn, device := startTransactionTestServer(t)
+	result := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := srv.SendSIPRequest(device, transactionRequest(srv, "MESSAGE"))
+		result <- err
+	}()
+
+	// 设备始终不应答：重传间隔从 T1 翻倍至 T2 封顶，直到 Timer F 超时
+	retransmissions := -1
+	for {
+		if _, _, err := readUDPMessage(conn, 5*srv.transactions.t2); err != nil {
+			break
+		}
+		retransmissions++
+	}
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrTransactionTimeout) {
+			t.Fatalf("SendSIPRequest error = %v, want ErrTransactionTimeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timer F 到期后事务未结束")
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < srv.transactions.timeout {
+		t.Errorf("事务在 %s 后结束，早于 Timer F (%s)", elapsed, srv.transactions.timeout)
+	}
+	// 20+40+80 之后每 80ms 重传一次，1.28s 内约 16 次
+	if retransmissions < 10 || retransmissions > 20 {
+		t.Errorf("重传次数 = %d", retransmissions)
+	}
+	if clientTransactionCount(srv) != 0 {
+		t.Error("超时后事务未从事务表移除")
+	}
+}
+
+func TestInviteNon2xxResponseACK(t *testing.T) {
+	srv, conn, device := startTransactionTestServer(t)
+	tx, err := srv.startClientTransaction(device, transactionRequest(srv, "INVITE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite, _, err := readUDPMessage(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverAddr := srv.udpConn.LocalAddr().(*net.UDPAddr)
+	conn.WriteToUDP(BuildSIPResponse(invite, 100, "Trying"), serverAddr)
+	busy := BuildSIPResponse(invite, 486, "Busy Here")
+	conn.WriteToUDP(busy, serverAddr)
+
+	// readACK 跳过在途的 INVITE 重传，读取平台发出的 ACK
+	readACK := func() *SIPMessage {
+		t.Helper()
+		for {
+			message, raw, err := readUDPMessage(conn, time.Second)
+			if err != nil {
+				t.Fatalf("未收到 ACK: %v", err)
+			}
+			if message.Type == "ACK" {
+				return message
+			}
+			if message.Type != "INVITE" {
+				t.Fatalf("收到意外消息:\n%s", raw)
+			}
+		}
+	}
+
+	ack := readACK()
+	if viaBranch(ack.Headers["Via"]) != viaBranch(invite.Headers["Via"]) || ack.Headers["CSeq"] != "1 ACK" {
+		t.Errorf("ACK Via=%q CSeq=%q，应与 INVITE 同一事务", ack.Headers["Via"], ack.Headers["CSeq"])
+	}
+	if got, err := tx.wait(); err != nil || got.StatusCode != 486 {
+		t.Fatalf("wait() = %+v, %v", got, err)
+	}
+
+	// 设备未收到 ACK 时重传失败响应，平台重发 ACK（Timer D）
+	conn.WriteToUDP(busy, serverAddr)
+	readACK()
+}
+
+func TestAbsorbRetransmittedRequest(t *testing.T) {
+	srv, conn, _ := startTransactionTestServer(t)
+	serverAddr := srv.udpConn.LocalAddr().(*net.UDPAddr)
+	register := []byte(registerRequest(transactionTestDeviceID, "UDP", conn.LocalAddr().(*net.UDPAddr).Port))
+
+	// 首个 401 响应被丢弃，设备重传同一请求时平台重发缓存的响应（nonce 不变），不重新处理
+	conn.WriteToUDP(register, serverAddr)
+	_, first, err := readUDPMessage(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteToUDP(register, serverAddr)
+	_, second, err := readUDPMessage(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("重传请求的响应与缓存不一致:\n%s\n%s", first, second)
+	}
+
+	// 新的请求重新处理
+	conn.WriteToUDP([]byte(registerRequest(transactionTestDeviceID, "UDP", conn.LocalAddr().(*net.UDPAddr).Port)), serverAddr)
+	if _, third, err := readUDPMessage(conn, time.Second); err != nil || third == first {
+		t.Errorf("新请求返回了缓存的响应: %v", err)
+	}
+}
@@ -26,6 +26,7 @@ type HWAccelDetector struct {
 	mu              sync.RWMutex
 	availableAccels []HWAccelType
 	bestAccel       HWAccelType
+	encoders        map[string]bool // ffmpeg -encoders 列出的编码器
 	detected        bool
 	lastCheck       time.Time
 }
@@ -35,6 +36,7 @@ func NewHWAccelDetector() *HWAccelDetector {
 	return &HWAccelDetector{
 		availableAccels: []HWAccelType{},
 		bestAccel:       HWAccelNone,
+		encoders:        map[string]bool{},
 		detected:        false,
 	}
 }
@@ -104,6 +106,9 @@ func (d *HWAccelDetector) Detect() error {
 		}
 	}
 
+	// 硬件编码器需单独检测（解码可用不代表 ffmpeg 编译了对应编码器）
+	d.encoders = detectEncoders()
+
 	// 设置最佳加速类型
 	if len(d.availableAccels) > 0 {
 		d.bestAccel = d.availableAccels[0]
@@ -119,6 +124,29 @@ func (d *HWAccelDetector) Detect() error {
 	return nil
 }
 
+// detectEncoders 解析 ffmpeg -encoders 输出，返回编码器名称集合
+func detectEncoders() map[string]bool {
+	encoders := make(map[string]bool)
+
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-encoders")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		log.Printf("[硬件加速] 执行 ffmpeg -encoders 失败: %v", err)
+		return encoders
+	}
+
+	// 输出格式: " V....D libx264              libx264 H.264 / AVC ..."
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields[0]) != 6 || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		encoders[fields[1]] = true
+	}
+	return encoders
+}
+
 // testHWAccel 测试硬件加速是否真正可用
 func (d *HWAccelDetector) testHWAccel(accelType HWAccelType) bool {
 	// 使用 testsrc 测试硬件加速
@@ -259,6 +287,38 @@ func (d *HWAccelDetector) GetDecoderName(codec string) string {
 	return ""
 }
 
+// hwEncoderNames 各硬件加速类型对应的 H.264/H.265 编码器
+var hwEncoderNames = map[HWAccelType]map[string]string{
+	HWAccelCUDA:         {"h264": "h264_nvenc", "h265": "hevc_nvenc"},
+	HWAccelQSV:          {"h264": "h264_qsv", "h265": "hevc_qsv"},
+	HWAccelVAAPI:        {"h264": "h264_vaapi", "h265": "hevc_vaapi"},
+	HWAccelVideoToolbox: {"h264": "h264_videotoolbox", "h265": "hevc_videotoolbox"},
+}
+
+// GetEncoderName 获取特定编码格式的编码器名称
+// 按优先级选择可用的硬件编码器，没有时回退到 libx264/libx265，返回编码器及其加速类型
+func (d *HWAccelDetector) GetEncoderName(codec string) (string, HWAccelType) {
+	if codec == "hevc" {
+		codec = "h265"
+	}
+
+	accels := d.GetAvailableAccels()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, accel := range accels {
+		if name := hwEncoderNames[accel][codec]; name != "" && d.encoders[name] {
+			return name, accel
+		}
+	}
+
+	if codec == "h265" {
+		return "libx265", HWAccelNone
+	}
+	return "libx264", HWAccelNone
+}
+
 // 全局硬件加速检测器实例
 var globalDetector *HWAccelDetector
 var once sync.Once
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"gb28181-onvif-server/internal/config"
 	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/secrets"
 	"log"
 	"net"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -913,6 +915,7 @@ func (m *Manager) UpdateDeviceCredentials(deviceID, username, password string) e
 	if !exists {
 		return fmt.Errorf("设备不存在: %s", deviceID)
 	}
+	debug.RegisterSecret(password)
 
 	// 如果提供了用户名或密码，更新它们
 	if username != "" {
@@ -1077,6 +1080,9 @@ func (m *Manager) GetSnapshot(deviceID, profileToken string) ([]byte, string, er
 
 // AddDevice 添加ONVIF设备（支持多种地址格式）
 func (m *Manager) AddDevice(xaddr, username, password string) (*Device, error) {
+	// 设备密码不出现在日志中
+	debug.RegisterSecret(password)
+
 	// 规范化地址格式
 	xaddr = normalizeXAddr(xaddr)
 
@@ -1259,6 +1265,54 @@ func (m *Manager) GetProfiles(deviceID string) ([]map[string]interface{}, error)
 	return profiles, nil
 }
 
+// GetProfileTokenByRank 按分辨率从高到低选择配置文件：rank 0 主码流，1 子码流，2 第三码流
+// 配置文件不足时返回分辨率最低的一个
+func (m *Manager) GetProfileTokenByRank(deviceID string, rank int) (string, error) {
+	device, exists := m.GetDeviceByID(deviceID)
+	if !exists {
+		return "", fmt.Errorf("设备不存在: %s", deviceID)
+	}
+
+	m.devicesMux.RLock()
+	profiles := append([]MediaProfile(nil), device.Profiles...)
+	m.devicesMux.RUnlock()
+
+	if len(profiles) == 0 {
+		if _, err := m.GetProfiles(deviceID); err != nil {
+			return "", err
+		}
+		m.devicesMux.RLock()
+		profiles = append([]MediaProfile(nil), device.Profiles...)
+		m.devicesMux.RUnlock()
+	}
+
+	profile, ok := SelectProfileByRank(profiles, rank)
+	if !ok {
+		return "", fmt.Errorf("设备没有可用的媒体配置文件: %s", deviceID)
+	}
+	return profile.Token, nil
+}
+
+// SelectProfileByRank 按分辨率从高到低排序后取第 rank 个配置文件，分辨率相同时保持设备返回的顺序
+func SelectProfileByRank(profiles []MediaProfile, rank int) (MediaProfile, bool) {
+	if len(profiles) == 0 {
+		return MediaProfile{}, false
+	}
+
+	sorted := append([]MediaProfile(nil), profiles...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Width*sorted[i].Height > sorted[j].Width*sorted[j].Height
+	})
+
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank], true
+}
+
 // GetProfilesWithCredentials 使用指定的凭据获取设备的媒体配置文件
 func (m *Manager) GetProfilesWithCredentials(deviceID, username, password string) ([]map[string]interface{}, error) {
 	device, exists := m.GetDeviceByID(deviceID)
@@ -1790,11 +1844,17 @@ func (m *Manager) ExportDevices() []map[string]interface{} {
 
 	result := make([]map[string]interface{}, 0, len(m.devices))
 	for _, device := range m.devices {
+		// 备份文件中的密码加密保存
+		password, err := secrets.Encrypt(device.Password)
+		if err != nil {
+			debug.Error("onvif", "加密设备 %s 密码失败，导出时省略: %v", device.DeviceID, err)
+			password = ""
+		}
 		result = append(result, map[string]interface{}{
 			"ip":       device.IP,
 			"port":     device.Port,
 			"username": device.Username,
-			"password": device.Password,
+			"password": password,
 			"name":     device.Name,
 		})
 	}
@@ -1810,6 +1870,13 @@ func (m *Manager) ImportDevices(deviceList []map[string]interface{}) (added int,
 		username, _ := deviceInfo["username"].(string)
 		password, _ := deviceInfo["password"].(string)
 
+		password, decryptErr := secrets.Decrypt(password)
+		if decryptErr != nil {
+			failed++
+			errors = append(errors, fmt.Sprintf("%s:%.0f - %v", ip, port, decryptErr))
+			continue
+		}
+
 		if ip == "" || port == 0 {
 			failed++
 			errors = append(errors, fmt.Sprintf("无效的设备信息: ip=%s, port=%.0f", ip, port))
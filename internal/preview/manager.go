@@ -17,6 +17,7 @@ import (
 type PreviewResult struct {
 	DeviceID  string `json:"device_id"`
 	ChannelID string `json:"channel_id"`
+	Quality   string `json:"quality"` // 码流: main, sub, third
 	StreamID  string `json:"stream_id"`
 	RTPPort   int    `json:"rtp_port"`
 	SSRC      string `json:"ssrc"`
@@ -24,6 +25,10 @@ type PreviewResult struct {
 	WsFlvURL  string `json:"ws_flv_url"`
 	HlsURL    string `json:"hls_url"`
 	RtmpURL   string `json:"rtmp_url"`
+
+	// 观看租约，由 API 层在创建/加入预览会话时分配
+	LeaseID  string `json:"lease_id,omitempty"`
+	LeaseTTL int    `json:"lease_ttl,omitempty"` // 租约有效期(秒)，需在到期前发送心跳
 }
 
 // Manager 负责封装预览开始/停止的逻辑
@@ -38,19 +43,25 @@ func NewManager(gb *gb28181.Server, z *zlm.ZLMServer) *Manager {
 }
 
 // StartChannelPreview 为指定通道启动预览
+// quality 选择主/子/第三码流，非主码流的流ID带有码流后缀
 // zlmHost/httpPort/rtmpPort 用于生成外部可访问的流地址
-func (m *Manager) StartChannelPreview(deviceID, channelID, app, zlmHost string, httpPort, rtmpPort int) (*PreviewResult, error) {
+func (m *Manager) StartChannelPreview(deviceID, channelID, quality, app, zlmHost string, httpPort, rtmpPort int) (*PreviewResult, error) {
 	if m.zlm == nil || m.zlm.GetAPIClient() == nil {
 		return nil, fmt.Errorf("zlm 未配置")
 	}
 
+	quality, err := gb28181.ParseStreamQuality(quality)
+	if err != nil {
+		return nil, err
+	}
+
 	device, exists := m.gbServer.GetDeviceByID(deviceID)
 	if !exists {
 		return nil, fmt.Errorf("设备不存在")
 	}
 
 	zlmClient := m.zlm.GetAPIClient()
-	streamID := strings.ReplaceAll(channelID, "-", "")
+	streamID := gb28181.QualityStreamID(strings.ReplaceAll(channelID, "-", ""), quality)
 
 	// 判断 RTP 服务是否已存在
 	rtpOnline, rtpPort, ssrc, err := zlmClient.IsRtpServerOnline(streamID)
@@ -62,6 +73,7 @@ func (m *Manager) StartChannelPreview(deviceID, channelID, app, zlmHost string,
 		res := &PreviewResult{
 			DeviceID:  deviceID,
 			ChannelID: channelID,
+			Quality:   quality,
 			StreamID:  streamID,
 			RTPPort:   rtpPort,
 			SSRC:      ssrc,
@@ -73,8 +85,13 @@ func (m *Manager) StartChannelPreview(deviceID, channelID, app, zlmHost string,
 		return res, nil
 	}
 
+	streamMode, err := gb28181.ParseStreamMode(device.StreamMode)
+	if err != nil {
+		streamMode = gb28181.StreamModeUDP
+	}
+
 	// 打开RTP端口
-	rtpInfo, err := zlmClient.OpenRtpServer(streamID, 0, 0)
+	rtpInfo, err := zlmClient.OpenRtpServer(streamID, gb28181.ZLMTCPMode(streamMode), 0)
 	if err != nil {
 		// 如果 ZLM 报 stream already exists，尝试查询现有 RTP 服务信息并返回，避免重复创建
 		if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "This stream already exists") {
@@ -93,6 +110,7 @@ func (m *Manager) StartChannelPreview(deviceID, channelID, app, zlmHost string,
 							res := &PreviewResult{
 								DeviceID:  deviceID,
 								ChannelID: channelID,
+								Quality:   quality,
 								StreamID:  streamID,
 								RTPPort:   port,
 								SSRC:      ssrc,
@@ -112,32 +130,26 @@ func (m *Manager) StartChannelPreview(deviceID, channelID, app, zlmHost string,
 		return nil, fmt.Errorf("打开RTP端口失败: %w", err)
 	}
 
-	// 选择媒体IP：优先通过UDP探测本地出口地址
-	mediaIP := device.SipIP
-	conn, err := net.Dial("udp", device.SipIP+":"+strconv.Itoa(device.SipPort))
-	if err == nil {
-		defer conn.Close()
-		localAddr := conn.LocalAddr().String()
-		if idx := strings.LastIndex(localAddr, ":"); idx > 0 {
-			candidateIP := localAddr[:idx]
-			if candidateIP != "" && candidateIP != "127.0.0.1" && candidateIP != "::1" {
-				mediaIP = candidateIP
-			}
-		}
-	}
-
-	mediaSession, err := m.gbServer.InviteRequest(deviceID, channelID, rtpInfo.Port, mediaIP)
+	mediaSession, err := m.inviteChannel(device, channelID, quality, streamID, streamMode, rtpInfo.Port)
 	if err != nil {
 		// 清理ZLM端口
 		_ = zlmClient.CloseRtpServer(streamID)
-		return nil, fmt.Errorf("发送INVITE失败: %w", err)
+		return nil, err
 	}
 
-	debug.Info("preview", "预览启动: device=%s channel=%s stream=%s rtp=%d ssrc=%s", deviceID, channelID, streamID, rtpInfo.Port, mediaSession.SSRC)
+	debug.Info("preview", "预览启动: device=%s channel=%s quality=%s stream=%s media=%s rtp=%d ssrc=%s", deviceID, channelID, quality, streamID, streamMode, rtpInfo.Port, mediaSession.SSRC)
+
+	// UDP 可能被 NAT 阻断，超时无数据时自动改用 TCP 被动模式
+	if streamMode == gb28181.StreamModeUDP {
+		if timeout := m.gbServer.MediaFallbackTimeout(); timeout > 0 {
+			go m.fallbackToTCP(device, channelID, quality, streamID, mediaSession, timeout)
+		}
+	}
 
 	res := &PreviewResult{
 		DeviceID:  deviceID,
 		ChannelID: channelID,
+		Quality:   quality,
 		StreamID:  streamID,
 		RTPPort:   rtpInfo.Port,
 		SSRC:      mediaSession.SSRC,
@@ -152,15 +164,108 @@ func (m *Manager) StartChannelPreview(deviceID, channelID, app, zlmHost string,
 	return res, nil
 }
 
-// StopChannelPreview 停止指定通道的预览（发送BYE并关闭ZLM资源）
-func (m *Manager) StopChannelPreview(deviceID, channelID string) error {
+// inviteChannel 向设备发送 INVITE，TCP 主动模式下等待设备应答媒体端口后由 ZLM 发起连接
+func (m *Manager) inviteChannel(device *gb28181.Device, channelID, quality, streamID, streamMode string, rtpPort int) (*gb28181.MediaSession, error) {
+	// 选择媒体IP：优先通过UDP探测本地出口地址
+	mediaIP := device.SipIP
+	if conn, err := net.Dial("udp", device.SipIP+":"+strconv.Itoa(device.SipPort)); err == nil {
+		localAddr := conn.LocalAddr().String()
+		conn.Close()
+		if idx := strings.LastIndex(localAddr, ":"); idx > 0 {
+			candidateIP := localAddr[:idx]
+			if candidateIP != "" && candidateIP != "127.0.0.1" && candidateIP != "::1" {
+				mediaIP = candidateIP
+			}
+		}
+	}
+
+	mediaSession, err := m.gbServer.InviteRequest(device.DeviceID, channelID, quality, streamMode, rtpPort, mediaIP)
+	if err != nil {
+		return nil, fmt.Errorf("发送INVITE失败: %w", err)
+	}
+
+	if streamMode != gb28181.StreamModeTCPActive {
+		return mediaSession, nil
+	}
+
+	if err := m.gbServer.WaitInviteAnswer(mediaSession, 10*time.Second); err != nil {
+		_ = m.gbServer.ByeStreamRequest(device.DeviceID, channelID, quality)
+		return nil, fmt.Errorf("TCP主动模式协商失败: %w", err)
+	}
+	if mediaSession.DeviceMediaIP == "" || mediaSession.DeviceMediaPort == 0 {
+		_ = m.gbServer.ByeStreamRequest(device.DeviceID, channelID, quality)
+		return nil, fmt.Errorf("TCP主动模式协商失败: 设备应答中没有媒体端口")
+	}
+	if err := m.zlm.GetAPIClient().ConnectRtpServer(streamID, mediaSession.DeviceMediaIP, mediaSession.DeviceMediaPort); err != nil {
+		_ = m.gbServer.ByeStreamRequest(device.DeviceID, channelID, quality)
+		return nil, err
+	}
+	debug.Info("preview", "TCP主动连接设备媒体端口: stream=%s dst=%s:%d", streamID, mediaSession.DeviceMediaIP, mediaSession.DeviceMediaPort)
+
+	return mediaSession, nil
+}
+
+// fallbackToTCP UDP 取流超时无数据时，关闭本次会话并以 TCP 被动模式重新发起
+// 流ID 不变，已下发的播放地址继续有效；回退成功后该设备后续预览直接使用 TCP 被动模式
+func (m *Manager) fallbackToTCP(device *gb28181.Device, channelID, quality, streamID string, session *gb28181.MediaSession, timeout time.Duration) {
+	zlmClient := m.zlm.GetAPIClient()
+	if m.waitStreamOnline(streamID, timeout) {
+		return
+	}
+
+	// 等待期间预览已被停止或重新发起
+	if m.gbServer.GetStreamSession(device.DeviceID, channelID, quality) != session {
+		return
+	}
+
+	debug.Warn("preview", "UDP取流 %s 内无数据，改用TCP被动模式: device=%s channel=%s stream=%s", timeout, device.DeviceID, channelID, streamID)
+
+	_ = m.gbServer.ByeStreamRequest(device.DeviceID, channelID, quality)
+	_ = zlmClient.CloseRtpServer(streamID)
+
+	rtpInfo, err := zlmClient.OpenRtpServer(streamID, gb28181.ZLMTCPMode(gb28181.StreamModeTCPPassive), 0)
+	if err != nil {
+		debug.Error("preview", "TCP被动模式打开RTP端口失败: stream=%s error=%v", streamID, err)
+		return
+	}
+	if _, err := m.inviteChannel(device, channelID, quality, streamID, gb28181.StreamModeTCPPassive, rtpInfo.Port); err != nil {
+		_ = zlmClient.CloseRtpServer(streamID)
+		debug.Error("preview", "TCP被动模式重新INVITE失败: device=%s channel=%s error=%v", device.DeviceID, channelID, err)
+		return
+	}
+
+	if m.waitStreamOnline(streamID, timeout) {
+		_ = m.gbServer.SetDeviceStreamMode(device.DeviceID, gb28181.StreamModeTCPPassive)
+		debug.Info("preview", "TCP被动模式取流成功，设备 %s 后续使用 TCP-Passive", device.DeviceID)
+	}
+}
+
+// waitStreamOnline 等待 ZLM 收到 RTP 数据并生成流
+func (m *Manager) waitStreamOnline(streamID string, timeout time.Duration) bool {
+	zlmClient := m.zlm.GetAPIClient()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if online, err := zlmClient.IsStreamOnline("rtp", streamID); err == nil && online {
+			return true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return false
+}
+
+// StopChannelPreview 停止指定通道指定码流的预览（发送BYE并关闭ZLM资源）
+func (m *Manager) StopChannelPreview(deviceID, channelID, quality string) error {
 	if m.zlm == nil || m.zlm.GetAPIClient() == nil {
 		return fmt.Errorf("zlm 未配置")
 	}
 
-	streamID := strings.ReplaceAll(channelID, "-", "")
+	quality, err := gb28181.ParseStreamQuality(quality)
+	if err != nil {
+		return err
+	}
+	streamID := gb28181.QualityStreamID(strings.ReplaceAll(channelID, "-", ""), quality)
 
-	if err := m.gbServer.ByeRequest(deviceID, channelID); err != nil {
+	if err := m.gbServer.ByeStreamRequest(deviceID, channelID, quality); err != nil {
 		debug.Warn("preview", "发送BYE失败: %v", err)
 	}
 
@@ -178,23 +283,31 @@ func (m *Manager) StopChannelPreview(deviceID, channelID string) error {
 }
 
 // StartRTSPProxy 为指定设备启动 RTSP -> ZLM 流代理（用于 ONVIF 等场景）
-func (m *Manager) StartRTSPProxy(deviceID, rtspURL, app, zlmHost string, httpPort, rtmpPort int, rtspUser, rtspPassword string) (*PreviewResult, error) {
+// quality 仅用于区分流ID，调用方负责传入对应码流的 rtspURL
+func (m *Manager) StartRTSPProxy(deviceID, quality, rtspURL, app, zlmHost string, httpPort, rtmpPort int, rtspUser, rtspPassword string) (*PreviewResult, error) {
 	if m.zlm == nil || m.zlm.GetAPIClient() == nil {
 		return nil, fmt.Errorf("zlm 未配置")
 	}
 
+	quality, err := gb28181.ParseStreamQuality(quality)
+	if err != nil {
+		return nil, err
+	}
+
 	zlmClient := m.zlm.GetAPIClient()
 
 	// 生成 stream id，尽量避免特殊字符
 	streamID := strings.ReplaceAll(deviceID, "-", "_")
 	streamID = strings.ReplaceAll(streamID, ":", "_")
 	streamID = strings.ReplaceAll(streamID, ".", "_")
+	streamID = gb28181.QualityStreamID(streamID, quality)
 
 	// 如果流已存在，直接返回
 	online, _ := zlmClient.IsStreamOnline(app, streamID)
 	if online {
 		res := &PreviewResult{
 			DeviceID: deviceID,
+			Quality:  quality,
 			StreamID: streamID,
 			FlvURL:   fmt.Sprintf("/zlm/%s/%s.live.flv", app, streamID),
 			WsFlvURL: fmt.Sprintf("/zlm/%s/%s.live.flv", app, streamID),
@@ -206,7 +319,6 @@ func (m *Manager) StartRTSPProxy(deviceID, rtspURL, app, zlmHost string, httpPor
 
 	// 如果传入了 rtsp 凭据，则在 options 中传给 ZLM（字段名使用 rtsp_user/rtsp_pwd）
 	var proxyInfo *zlm.StreamProxyInfo
-	var err error
 	if rtspUser != "" || rtspPassword != "" {
 		opts := map[string]interface{}{
 			"rtp_type":    0,
@@ -231,6 +343,7 @@ func (m *Manager) StartRTSPProxy(deviceID, rtspURL, app, zlmHost string, httpPor
 		if strings.Contains(err.Error(), "already exists") {
 			res := &PreviewResult{
 				DeviceID: deviceID,
+				Quality:  quality,
 				StreamID: streamID,
 				FlvURL:   fmt.Sprintf("/zlm/%s/%s.live.flv", app, streamID),
 				WsFlvURL: fmt.Sprintf("/zlm/%s/%s.live.flv", app, streamID),
@@ -271,6 +384,7 @@ func (m *Manager) StartRTSPProxy(deviceID, rtspURL, app, zlmHost string, httpPor
 
 	res := &PreviewResult{
 		DeviceID: deviceID,
+		Quality:  quality,
 		StreamID: streamID,
 		FlvURL:   fmt.Sprintf("/zlm/%s/%s.live.flv", app, streamID),
 		WsFlvURL: fmt.Sprintf("/zlm/%s/%s.live.flv", app, streamID),
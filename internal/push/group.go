@@ -0,0 +1,588 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+// 推流组状态（由成员推流状态汇总）
+const (
+	GroupStatusStopped = "stopped"
+	GroupStatusRunning = "running" // 全部成员推流中
+	GroupStatusPartial = "partial" // 部分成员推流中或重连中
+)
+
+// PushGroup 推流组：同一路源流同时推送到多个推流目标，统一启停
+type PushGroup struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	ChannelID   string   `json:"channel_id"`
+	ChannelName string   `json:"channel_name"`
+	SourceURL   string   `json:"source_url"` // 源流地址，启动时下发给所有成员
+	TargetIDs   []string `json:"target_ids"` // 成员推流目标
+
+	Schedules           []Schedule `json:"schedules,omitempty"`    // 定时推流时间窗
+	StartOnSourceOnline bool       `json:"start_on_source_online"` // 源流上线时自动启动
+
+	// 调度状态
+	LastWindowStart time.Time `json:"last_window_start,omitempty"` // 最近一次已处理的时间窗开始时间
+	ActiveWindowEnd time.Time `json:"active_window_end,omitempty"` // 调度器启动的时间窗结束时间，到期自动停止
+
+	Status    string    `json:"status,omitempty"` // 由成员状态汇总，不落盘
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Schedule 推流时间窗（服务器本地时间）
+// 单次：设置 Start/End；每周重复：设置 Weekdays、StartTime、EndTime，EndTime 不晚于 StartTime 时表示跨午夜
+type Schedule struct {
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+
+	Weekdays  []int  `json:"weekdays,omitempty"`   // 0=周日 ... 6=周六
+	StartTime string `json:"start_time,omitempty"` // HH:MM
+	EndTime   string `json:"end_time,omitempty"`   // HH:MM
+}
+
+// isWeekly 是否为每周重复的时间窗
+func (s Schedule) isWeekly() bool {
+	return len(s.Weekdays) > 0 || s.StartTime != "" || s.EndTime != ""
+}
+
+// Validate 校验时间窗参数
+func (s Schedule) Validate() error {
+	if !s.isWeekly() {
+		if s.Start.IsZero() || s.End.IsZero() {
+			return errors.New("schedule requires start and end, or weekdays with start_time and end_time")
+		}
+		if !s.End.After(s.Start) {
+			return errors.New("schedule end must be after start")
+		}
+		return nil
+	}
+
+	if !s.Start.IsZero() || !s.End.IsZero() {
+		return errors.New("schedule cannot mix one-off and weekly fields")
+	}
+	if len(s.Weekdays) == 0 {
+		return errors.New("weekly schedule requires weekdays")
+	}
+	for _, day := range s.Weekdays {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("invalid weekday %d (0=Sunday ... 6=Saturday)", day)
+		}
+	}
+	start, err := parseClock(s.StartTime)
+	if err != nil {
+		return fmt.Errorf("invalid start_time: %w", err)
+	}
+	end, err := parseClock(s.EndTime)
+	if err != nil {
+		return fmt.Errorf("invalid end_time: %w", err)
+	}
+	if start == end {
+		return errors.New("schedule start_time and end_time must differ")
+	}
+	return nil
+}
+
+// parseClock 解析 HH:MM，返回距零点的时长
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New("expect HH:MM")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// window 返回 now 所在的时间窗
+func (s Schedule) window(now time.Time) (time.Time, time.Time, bool) {
+	if !s.isWeekly() {
+		if !now.Before(s.Start) && now.Before(s.End) {
+			return s.Start, s.End, true
+		}
+		return time.Time{}, time.Time{}, false
+	}
+
+	startOffset, err1 := parseClock(s.StartTime)
+	endOffset, err2 := parseClock(s.EndTime)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	// 跨午夜的时间窗可能从前一天开始
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if !containsWeekday(s.Weekdays, int(day.Weekday())) {
+			continue
+		}
+		start := day.Add(startOffset)
+		end := day.Add(endOffset)
+		if endOffset <= startOffset {
+			end = day.AddDate(0, 0, 1).Add(endOffset)
+		}
+		if !now.Before(start) && now.Before(end) {
+			return start, end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+func containsWeekday(days []int, day int) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// currentWindow 返回 now 所在的时间窗（多个重叠时取最早开始的）
+func (g *PushGroup) currentWindow(now time.Time) (time.Time, time.Time, bool) {
+	var start, end time.Time
+	found := false
+	for _, s := range g.Schedules {
+		ws, we, ok := s.window(now)
+		if ok && (!found || ws.Before(start)) {
+			start, end, found = ws, we, true
+		}
+	}
+	return start, end, found
+}
+
+// validateGroup 校验推流组（调用方持有锁）
+func (m *Manager) validateGroup(g *PushGroup) error {
+	if g.Name == "" {
+		return errors.New("group name is required")
+	}
+	if g.SourceURL == "" {
+		return errors.New("group source_url is required")
+	}
+	if g.StartOnSourceOnline {
+		if _, _, err := parseSourceStream(g.SourceURL); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range g.TargetIDs {
+		if seen[id] {
+			return fmt.Errorf("duplicate target %s", id)
+		}
+		seen[id] = true
+		if _, exists := m.targets[id]; !exists {
+			return fmt.Errorf("push target not found: %s", id)
+		}
+		for _, other := range m.groups {
+			if other.ID != g.ID && containsString(other.TargetIDs, id) {
+				return fmt.Errorf("push target %s already belongs to group %s", id, other.Name)
+			}
+		}
+	}
+
+	for i, s := range g.Schedules {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("schedule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// groupStatus 汇总成员推流状态（调用方持有锁）
+func (m *Manager) groupStatus(g *PushGroup) string {
+	active := 0
+	pushing := 0
+	for _, id := range g.TargetIDs {
+		target, exists := m.targets[id]
+		if !exists {
+			continue
+		}
+		switch target.Status {
+		case StatusPushing:
+			pushing++
+			active++
+		case StatusReconnecting:
+			active++
+		}
+	}
+	switch {
+	case active == 0:
+		return GroupStatusStopped
+	case pushing == len(g.TargetIDs):
+		return GroupStatusRunning
+	default:
+		return GroupStatusPartial
+	}
+}
+
+// snapshotGroup 复制推流组并填充汇总状态（调用方持有锁）
+func (m *Manager) snapshotGroup(g *PushGroup) *PushGroup {
+	copied := *g
+	copied.TargetIDs = append([]string(nil), g.TargetIDs...)
+	copied.Schedules = append([]Schedule(nil), g.Schedules...)
+	copied.Status = m.groupStatus(g)
+	return &copied
+}
+
+// GetGroups 获取所有推流组
+func (m *Manager) GetGroups() []*PushGroup {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	result := make([]*PushGroup, 0, len(m.groups))
+	for _, g := range m.groups {
+		result = append(result, m.snapshotGroup(g))
+	}
+	return result
+}
+
+// GetGroup 获取单个推流组
+func (m *Manager) GetGroup(id string) (*PushGroup, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	g, exists := m.groups[id]
+	if !exists {
+		return nil, errors.New("push group not found")
+	}
+	return m.snapshotGroup(g), nil
+}
+
+// AddGroup 添加推流组
+func (m *Manager) AddGroup(g *PushGroup) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.validateGroup(g); err != nil {
+		return err
+	}
+
+	if g.ID == "" {
+		g.ID = fmt.Sprintf("group_%d", time.Now().UnixNano())
+	}
+	g.LastWindowStart = time.Time{}
+	g.ActiveWindowEnd = time.Time{}
+	g.CreatedAt = time.Now()
+	g.UpdatedAt = time.Now()
+
+	m.groups[g.ID] = g
+	m.saveGroups()
+
+	debug.Info("push", "Added push group: %s (%d targets)", g.Name, len(g.TargetIDs))
+	return nil
+}
+
+// UpdateGroup 更新推流组
+func (m *Manager) UpdateGroup(id string, updates map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	g, exists := m.groups[id]
+	if !exists {
+		return errors.New("push group not found")
+	}
+
+	// 在副本上修改，校验通过后再生效
+	updated := *g
+	if name, ok := updates["name"].(string); ok {
+		updated.Name = name
+	}
+	if channelID, ok := updates["channel_id"].(string); ok {
+		updated.ChannelID = channelID
+	}
+	if channelName, ok := updates["channel_name"].(string); ok {
+		updated.ChannelName = channelName
+	}
+	if sourceURL, ok := updates["source_url"].(string); ok {
+		updated.SourceURL = sourceURL
+	}
+	if trigger, ok := updates["start_on_source_online"].(bool); ok {
+		updated.StartOnSourceOnline = trigger
+	}
+	if raw, ok := updates["target_ids"]; ok {
+		updated.TargetIDs = nil
+		if err := remarshal(raw, &updated.TargetIDs); err != nil {
+			return fmt.Errorf("invalid target_ids: %w", err)
+		}
+	}
+	if raw, ok := updates["schedules"]; ok {
+		updated.Schedules = nil
+		if err := remarshal(raw, &updated.Schedules); err != nil {
+			return fmt.Errorf("invalid schedules: %w", err)
+		}
+		// 时间窗变更后重新计算
+		updated.LastWindowStart = time.Time{}
+	}
+
+	if err := m.validateGroup(&updated); err != nil {
+		return err
+	}
+	updated.UpdatedAt = time.Now()
+	*g = updated
+	m.saveGroups()
+
+	return nil
+}
+
+// remarshal 将 JSON 解码得到的通用值转换为具体类型
+func remarshal(raw interface{}, out interface{}) error {
+	if raw == nil {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// DeleteGroup 删除推流组（成员推流目标保留，正在进行的推流不受影响）
+func (m *Manager) DeleteGroup(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.groups[id]; !exists {
+		return errors.New("push group not found")
+	}
+
+	delete(m.groups, id)
+	delete(m.sourceOnline, id)
+	m.saveGroups()
+
+	debug.Info("push", "Deleted push group: %s", id)
+	return nil
+}
+
+// removeTargetFromGroups 推流目标删除时从所属推流组移除（调用方持有锁）
+func (m *Manager) removeTargetFromGroups(targetID string) {
+	changed := false
+	for _, g := range m.groups {
+		for i, id := range g.TargetIDs {
+			if id == targetID {
+				g.TargetIDs = append(g.TargetIDs[:i:i], g.TargetIDs[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		m.saveGroups()
+	}
+}
+
+// StartGroup 启动推流组：将组的源流下发给所有成员并逐个启动，返回启动失败的成员及原因
+func (m *Manager) StartGroup(id string) (map[string]string, error) {
+	m.mutex.Lock()
+	g, exists := m.groups[id]
+	if !exists {
+		m.mutex.Unlock()
+		return nil, errors.New("push group not found")
+	}
+	if len(g.TargetIDs) == 0 {
+		m.mutex.Unlock()
+		return nil, errors.New("push group has no targets")
+	}
+
+	var pending []string
+	for _, targetID := range g.TargetIDs {
+		target, exists := m.targets[targetID]
+		if !exists {
+			continue
+		}
+		target.SourceURL = g.SourceURL
+		target.ChannelID = g.ChannelID
+		target.ChannelName = g.ChannelName
+		if target.Status != StatusPushing && target.Status != StatusReconnecting {
+			pending = append(pending, targetID)
+		}
+	}
+	m.saveTargets()
+	name := g.Name
+	m.mutex.Unlock()
+
+	failures := make(map[string]string)
+	for _, targetID := range pending {
+		if err := m.StartPush(targetID); err != nil {
+			failures[targetID] = err.Error()
+		}
+	}
+
+	if len(pending) > 0 && len(failures) == len(pending) {
+		return failures, fmt.Errorf("failed to start all %d targets of group %s", len(pending), name)
+	}
+	debug.Info("push", "Push group started: %s (%d started, %d failed)", name, len(pending)-len(failures), len(failures))
+	return failures, nil
+}
+
+// StopGroup 停止推流组的所有成员
+func (m *Manager) StopGroup(id string) error {
+	m.mutex.RLock()
+	g, exists := m.groups[id]
+	if !exists {
+		m.mutex.RUnlock()
+		return errors.New("push group not found")
+	}
+	targetIDs := append([]string(nil), g.TargetIDs...)
+	name := g.Name
+	m.mutex.RUnlock()
+
+	var errs []string
+	for _, targetID := range targetIDs {
+		if err := m.StopPush(targetID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", targetID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to stop targets: %s", strings.Join(errs, "; "))
+	}
+
+	debug.Info("push", "Push group stopped: %s", name)
+	return nil
+}
+
+// evaluateGroups 按时间窗和源流状态自动启停推流组
+func (m *Manager) evaluateGroups() {
+	now := m.now()
+
+	// 源流上线触发依赖 ZLM 流列表，仅在有组启用时查询
+	m.mutex.RLock()
+	needStreams := false
+	for _, g := range m.groups {
+		if g.StartOnSourceOnline {
+			needStreams = true
+			break
+		}
+	}
+	m.mutex.RUnlock()
+
+	var online map[string]bool
+	if needStreams && m.zlmClient != nil {
+		streams, err := m.zlmClient.GetMediaList()
+		if err != nil {
+			debug.Warn("push", "Failed to get media list for push triggers: %v", err)
+		} else {
+			online = make(map[string]bool, len(streams))
+			for _, s := range streams {
+				online[s.App+"/"+s.Stream] = true
+			}
+		}
+	}
+
+	var toStart, toStop []string
+	reasons := make(map[string]string)
+
+	m.mutex.Lock()
+	changed := false
+	for _, g := range m.groups {
+		if start, end, ok := g.currentWindow(now); ok && !start.Equal(g.LastWindowStart) {
+			g.LastWindowStart = start
+			g.ActiveWindowEnd = end
+			changed = true
+			toStart = append(toStart, g.ID)
+			reasons[g.ID] = fmt.Sprintf("schedule %s - %s", start.Format("2006-01-02 15:04"), end.Format("15:04"))
+			continue
+		}
+		if !g.ActiveWindowEnd.IsZero() && !now.Before(g.ActiveWindowEnd) {
+			g.ActiveWindowEnd = time.Time{}
+			changed = true
+			toStop = append(toStop, g.ID)
+			continue
+		}
+
+		if !g.StartOnSourceOnline || online == nil {
+			continue
+		}
+		app, stream, err := parseSourceStream(g.SourceURL)
+		if err != nil {
+			continue
+		}
+		isOnline := online[app+"/"+stream]
+		// 只响应离线 -> 上线的变化，服务启动后的首次观测仅记录状态，避免覆盖手动停止
+		wasOnline, known := m.sourceOnline[g.ID]
+		m.sourceOnline[g.ID] = isOnline
+		if known && !wasOnline && isOnline && m.groupStatus(g) == GroupStatusStopped {
+			toStart = append(toStart, g.ID)
+			reasons[g.ID] = "source online"
+		}
+	}
+	if changed {
+		m.saveGroups()
+	}
+	m.mutex.Unlock()
+
+	for _, id := range toStart {
+		debug.Info("push", "Auto starting push group %s (%s)", id, reasons[id])
+		if failures, err := m.StartGroup(id); err != nil {
+			debug.Error("push", "Failed to auto start push group %s: %v %v", id, err, failures)
+		}
+	}
+	for _, id := range toStop {
+		debug.Info("push", "Schedule ended, stopping push group %s", id)
+		if err := m.StopGroup(id); err != nil {
+			debug.Error("push", "Failed to stop push group %s: %v", id, err)
+		}
+	}
+}
+
+// loadGroups 加载推流组
+func (m *Manager) loadGroups() {
+	if m.groupsFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.groupsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			debug.Warn("push", "Failed to load push groups: %v", err)
+		}
+		return
+	}
+
+	var groups []*PushGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		debug.Warn("push", "Failed to parse push groups: %v", err)
+		return
+	}
+	for _, g := range groups {
+		m.groups[g.ID] = g
+	}
+	debug.Info("push", "Loaded %d push groups", len(groups))
+}
+
+// saveGroups 保存推流组（调用方持有锁）
+func (m *Manager) saveGroups() {
+	if m.groupsFile == "" {
+		return
+	}
+
+	groups := make([]*PushGroup, 0, len(m.groups))
+	for _, g := range m.groups {
+		copied := *g
+		copied.Status = ""
+		groups = append(groups, &copied)
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		debug.Warn("push", "Failed to marshal push groups: %v", err)
+		return
+	}
+	if err := os.WriteFile(m.groupsFile, data, 0644); err != nil {
+		debug.Warn("push", "Failed to save push groups: %v", err)
+	}
+}
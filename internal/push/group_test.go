@@ -0,0 +1,108 @@
+package push
+
+import (
+	"testing"
+	"time"
+)
+
+// scheduleTime 2026 年 10 月的 UTC 时间，2026-10-16 为周五
+func scheduleTime(day, hour, minute int) time.Time {
+	return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleWindow(t *testing.T) {
+	oneOff := Schedule{Start: scheduleTime(16, 9, 0), End: scheduleTime(16, 10, 0)}
+	weekly := Schedule{Weekdays: []int{1, 5}, StartTime: "08:00", EndTime: "18:00"}
+	overnight := Schedule{Weekdays: []int{5}, StartTime: "22:00", EndTime: "02:00"}
+
+	cases := []struct {
+		name      string
+		schedule  Schedule
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+		wantOK    bool
+	}{
+		{"OneOffBefore", oneOff, scheduleTime(16, 8, 59), time.Time{}, time.Time{}, false},
+		{"OneOffStart", oneOff, scheduleTime(16, 9, 0), scheduleTime(16, 9, 0), scheduleTime(16, 10, 0), true},
+		{"OneOffEndExclusive", oneOff, scheduleTime(16, 10, 0), time.Time{}, time.Time{}, false},
+		{"WeeklyInside", weekly, scheduleTime(16, 12, 0), scheduleTime(16, 8, 0), scheduleTime(16, 18, 0), true},
+		{"WeeklyOtherDay", weekly, scheduleTime(17, 12, 0), time.Time{}, time.Time{}, false},
+		{"WeeklyNextMonday", weekly, scheduleTime(19, 8, 0), scheduleTime(19, 8, 0), scheduleTime(19, 18, 0), true},
+		{"OvernightBeforeStart", overnight, scheduleTime(16, 21, 59), time.Time{}, time.Time{}, false},
+		{"OvernightSameDay", overnight, scheduleTime(16, 23, 0), scheduleTime(16, 22, 0), scheduleTime(17, 2, 0), true},
+		{"OvernightNextDay", overnight, scheduleTime(17, 1, 59), scheduleTime(16, 22, 0), scheduleTime(17, 2, 0), true},
+		{"OvernightEnded", overnight, scheduleTime(17, 2, 0), time.Time{}, time.Time{}, false},
+		// 周六 22:00 不在 Weekdays 中，不开始新的时间窗
+		{"OvernightNotScheduledDay", overnight, scheduleTime(17, 23, 0), time.Time{}, time.Time{}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, ok := tc.schedule.window(tc.now)
+			if ok != tc.wantOK || !start.Equal(tc.wantStart) || !end.Equal(tc.wantEnd) {
+				t.Errorf("window(%s) = %s, %s, %v; want %s, %s, %v", tc.now, start, end, ok, tc.wantStart, tc.wantEnd, tc.wantOK)
+			}
+		})
+	}
+
+	// 多个时间窗重叠时取最早开始的
+	g := &PushGroup{Schedules: []Schedule{weekly, {Start: scheduleTime(16, 7, 0), End: scheduleTime(16, 9, 0)}}}
+	if start, end, ok := g.currentWindow(scheduleTime(16, 8, 30)); !ok || !start.Equal(scheduleTime(16, 7, 0)) || !end.Equal(scheduleTime(16, 9, 0)) {
+		t.Errorf("currentWindow = %s, %s, %v", start, end, ok)
+	}
+}
+
+func TestEvaluateGroupsSchedule(t *testing.T) {
+	m := NewManager(nil, "", 0)
+	now := scheduleTime(16, 21, 0)
+	m.now = func() time.Time { return now }
+
+	// 推流地址为空，启动会失败，通过 StartGroup 下发的源流地址判断是否触发了启动
+	target := &PushTarget{ID: "t1", Name: "t1", Protocol: ProtocolRTMP, Status: StatusStopped}
+	m.targets[target.ID] = target
+	g := &PushGroup{
+		ID:        "g1",
+		Name:      "g1",
+		SourceURL: "rtmp://127.0.0.1/live/test",
+		TargetIDs: []string{target.ID},
+		Schedules: []Schedule{{Weekdays: []int{5}, StartTime: "22:00", EndTime: "02:00"}},
+	}
+	m.groups[g.ID] = g
+
+	m.evaluateGroups()
+	if target.SourceURL != "" || !g.LastWindowStart.IsZero() {
+		t.Fatal("时间窗开始前不应启动")
+	}
+
+	now = scheduleTime(16, 22, 30)
+	m.evaluateGroups()
+	if target.SourceURL != g.SourceURL {
+		t.Fatal("进入时间窗后应启动推流组")
+	}
+	if !g.LastWindowStart.Equal(scheduleTime(16, 22, 0)) || !g.ActiveWindowEnd.Equal(scheduleTime(17, 2, 0)) {
+		t.Fatalf("调度状态 = %s, %s", g.LastWindowStart, g.ActiveWindowEnd)
+	}
+
+	// 同一时间窗内只启动一次，手动停止后不会被重新启动
+	target.SourceURL = ""
+	now = scheduleTime(17, 1, 0)
+	m.evaluateGroups()
+	if target.SourceURL != "" {
+		t.Error("同一时间窗内不应重复启动")
+	}
+
+	// 时间窗结束后停止调度器启动的推流
+	target.Status = StatusReconnecting
+	now = scheduleTime(17, 2, 0)
+	m.evaluateGroups()
+	if !g.ActiveWindowEnd.IsZero() || target.Status != StatusStopped {
+		t.Errorf("时间窗结束后 ActiveWindowEnd=%s status=%s", g.ActiveWindowEnd, target.Status)
+	}
+
+	// 下一周同一时间窗再次启动
+	now = scheduleTime(23, 22, 0)
+	m.evaluateGroups()
+	if target.SourceURL != g.SourceURL || !g.LastWindowStart.Equal(scheduleTime(23, 22, 0)) {
+		t.Errorf("下一周期未启动: LastWindowStart=%s", g.LastWindowStart)
+	}
+}
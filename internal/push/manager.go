@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/secrets"
 	"gb28181-onvif-server/internal/zlm"
 )
 
@@ -17,25 +19,39 @@ type PushTarget struct {
 	ID          string    `json:"id"`           // 唯一标识
 	Name        string    `json:"name"`         // 名称（如：抖音直播）
 	Platform    string    `json:"platform"`     // 平台类型: douyin, bilibili, kuaishou, custom
+	Protocol    string    `json:"protocol"`     // 推流协议: rtmp(默认), rtmps, srt, rtsp, whip
 	PushURL     string    `json:"push_url"`     // 推流地址
 	StreamKey   string    `json:"stream_key"`   // 推流密钥
 	ChannelID   string    `json:"channel_id"`   // 关联的通道ID
 	ChannelName string    `json:"channel_name"` // 通道名称
 	SourceURL   string    `json:"source_url"`   // 源流地址
-	Status      string    `json:"status"`       // 状态: stopped, pushing, error
-	FFmpegKey   string    `json:"ffmpeg_key"`   // ZLM FFmpeg 任务 Key
+	Status      string    `json:"status"`       // 状态: stopped, pushing, reconnecting, error
+	FFmpegKey   string    `json:"ffmpeg_key"`   // ZLM 推流任务 Key
+	TaskType    string    `json:"task_type"`    // 推流任务类型: ffmpeg, pusher
 	StartTime   time.Time `json:"start_time"`   // 开始时间
 	ErrorMsg    string    `json:"error_msg"`    // 错误信息
 	CreatedAt   time.Time `json:"created_at"`   // 创建时间
 	UpdatedAt   time.Time `json:"updated_at"`   // 更新时间
+
+	// 自动重连状态
+	RetryCount int         `json:"retry_count"`          // 连续重试次数
+	NextRetry  time.Time   `json:"next_retry,omitempty"` // 下次重试时间
+	History    []PushEvent `json:"history,omitempty"`    // 最近的启停/错误事件
+
+	Options *PushOptions `json:"options,omitempty"` // 协议相关参数
+
+	// 转码与画面叠加
+	Profile  string    `json:"profile,omitempty"`  // 转码模板名称，为空时使用协议默认模板
+	Overlays []Overlay `json:"overlays,omitempty"` // 水印/文字叠加层，需配合转码模板使用
 }
 
 // PlatformInfo 直播平台信息
 type PlatformInfo struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	PushURLTpl  string `json:"push_url_template"` // 推流地址模板
-	Description string `json:"description"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	PushURLTpl  string   `json:"push_url_template"` // 推流地址模板
+	Description string   `json:"description"`
+	Protocols   []string `json:"protocols"` // 支持的推流协议
 }
 
 // 预定义的直播平台
@@ -45,36 +61,42 @@ var SupportedPlatforms = []PlatformInfo{
 		Name:        "抖音直播",
 		PushURLTpl:  "rtmp://live-push.douyin.com/live/{stream_key}",
 		Description: "抖音直播平台",
+		Protocols:   []string{ProtocolRTMP},
 	},
 	{
 		ID:          "bilibili",
 		Name:        "B站直播",
 		PushURLTpl:  "rtmp://live-push.bilivideo.com/live-bvc/{stream_key}",
 		Description: "哔哩哔哩直播",
+		Protocols:   []string{ProtocolRTMP},
 	},
 	{
 		ID:          "kuaishou",
 		Name:        "快手直播",
 		PushURLTpl:  "rtmp://live-push.kuaishou.com/push/{stream_key}",
 		Description: "快手直播平台",
+		Protocols:   []string{ProtocolRTMP},
 	},
 	{
 		ID:          "huya",
 		Name:        "虎牙直播",
 		PushURLTpl:  "rtmp://al.live-push.huya.com/huyalive/{stream_key}",
 		Description: "虎牙直播平台",
+		Protocols:   []string{ProtocolRTMP},
 	},
 	{
 		ID:          "douyu",
 		Name:        "斗鱼直播",
 		PushURLTpl:  "rtmp://send.douyu.com/live/{stream_key}",
 		Description: "斗鱼直播平台",
+		Protocols:   []string{ProtocolRTMP},
 	},
 	{
 		ID:          "custom",
 		Name:        "自定义平台",
 		PushURLTpl:  "{push_url}",
-		Description: "自定义 RTMP 推流地址",
+		Description: "自定义推流地址（RTMP/RTMPS/SRT/RTSP/WHIP）",
+		Protocols:   SupportedProtocols,
 	},
 }
 
@@ -85,17 +107,48 @@ type Manager struct {
 	zlmClient *zlm.ZLMAPIClient
 	dataFile  string
 	httpPort  int // ZLM HTTP 端口
+
+	policy         RetryPolicy
+	supervisorStop chan struct{}
+	now            func() time.Time // 监督器和推流组调度使用的时钟，测试中可替换
+
+	profiles    map[string]*TranscodeProfile // key: 模板名称
+	fontFile    string                       // 文字叠加层默认字体
+	vaapiDevice string                       // VAAPI 硬件编码使用的 DRM 渲染节点
+	overlayDir  string                       // 叠加层文字文件目录
+	cmdSlots    map[string]int               // 转码推流占用的 ZLM 命令模板槽位，key: target ID
+
+	groups       map[string]*PushGroup // key: group ID
+	groupsFile   string
+	sourceOnline map[string]bool // 推流组源流最近一次观测到的在线状态，key: group ID
 }
 
 // NewManager 创建推流管理器
 func NewManager(zlmClient *zlm.ZLMAPIClient, dataFile string, httpPort int) *Manager {
 	m := &Manager{
-		targets:   make(map[string]*PushTarget),
-		zlmClient: zlmClient,
-		dataFile:  dataFile,
-		httpPort:  httpPort,
+		targets:     make(map[string]*PushTarget),
+		zlmClient:   zlmClient,
+		dataFile:    dataFile,
+		httpPort:    httpPort,
+		policy:      DefaultRetryPolicy(),
+		now:         time.Now,
+		profiles:    make(map[string]*TranscodeProfile),
+		overlayDir:  filepath.Join(filepath.Dir(dataFile), "push_overlays"),
+		vaapiDevice: defaultVAAPIDevice,
+		cmdSlots:    make(map[string]int),
+
+		groups:       make(map[string]*PushGroup),
+		sourceOnline: make(map[string]bool),
+	}
+	if dataFile != "" {
+		m.groupsFile = filepath.Join(filepath.Dir(dataFile), "push_groups.json")
+	}
+	for _, p := range DefaultProfiles() {
+		p.BuiltIn = true
+		m.profiles[p.Name] = p
 	}
 	m.loadTargets()
+	m.loadGroups()
 	return m
 }
 
@@ -147,11 +200,18 @@ func (m *Manager) AddTarget(target *PushTarget) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if err := ValidateTarget(target); err != nil {
+		return err
+	}
+	if err := m.validateTranscode(target); err != nil {
+		return err
+	}
+
 	if target.ID == "" {
 		target.ID = fmt.Sprintf("push_%d", time.Now().UnixNano())
 	}
 
-	target.Status = "stopped"
+	target.Status = StatusStopped
 	target.CreatedAt = time.Now()
 	target.UpdatedAt = time.Now()
 
@@ -172,19 +232,68 @@ func (m *Manager) UpdateTarget(id string, updates map[string]interface{}) error
 		return errors.New("push target not found")
 	}
 
+	// 在副本上修改，校验通过后再生效
+	updated := *target
 	if name, ok := updates["name"].(string); ok {
-		target.Name = name
+		updated.Name = name
 	}
 	if platform, ok := updates["platform"].(string); ok {
-		target.Platform = platform
+		updated.Platform = platform
+	}
+	if protocol, ok := updates["protocol"].(string); ok {
+		updated.Protocol = protocol
 	}
 	if pushURL, ok := updates["push_url"].(string); ok {
-		target.PushURL = pushURL
+		updated.PushURL = pushURL
 	}
 	if streamKey, ok := updates["stream_key"].(string); ok {
-		target.StreamKey = streamKey
+		updated.StreamKey = streamKey
+	}
+	if rawOptions, ok := updates["options"]; ok {
+		updated.Options = nil
+		if rawOptions != nil {
+			data, err := json.Marshal(rawOptions)
+			if err != nil {
+				return fmt.Errorf("invalid options: %w", err)
+			}
+			var opts PushOptions
+			if err := json.Unmarshal(data, &opts); err != nil {
+				return fmt.Errorf("invalid options: %w", err)
+			}
+			updated.Options = &opts
+		}
+	}
+	if profile, ok := updates["profile"].(string); ok {
+		updated.Profile = profile
+	}
+	if rawOverlays, ok := updates["overlays"]; ok {
+		updated.Overlays = nil
+		if rawOverlays != nil {
+			data, err := json.Marshal(rawOverlays)
+			if err != nil {
+				return fmt.Errorf("invalid overlays: %w", err)
+			}
+			if err := json.Unmarshal(data, &updated.Overlays); err != nil {
+				return fmt.Errorf("invalid overlays: %w", err)
+			}
+		}
 	}
 
+	if err := ValidateTarget(&updated); err != nil {
+		return err
+	}
+	if err := m.validateTranscode(&updated); err != nil {
+		return err
+	}
+	target.Name = updated.Name
+	target.Platform = updated.Platform
+	target.Protocol = updated.Protocol
+	target.PushURL = updated.PushURL
+	target.StreamKey = updated.StreamKey
+	target.Options = updated.Options
+	target.Profile = updated.Profile
+	target.Overlays = updated.Overlays
+
 	target.UpdatedAt = time.Now()
 	m.saveTargets()
 
@@ -202,11 +311,14 @@ func (m *Manager) DeleteTarget(id string) error {
 	}
 
 	// 如果正在推流，先停止
-	if target.Status == "pushing" && target.FFmpegKey != "" {
-		m.zlmClient.DelFFmpegSource(target.FFmpegKey)
+	if target.Status == StatusPushing && target.FFmpegKey != "" {
+		m.stopTask(target.TaskType, target.FFmpegKey)
 	}
 
 	delete(m.targets, id)
+	m.removeTargetFromGroups(id)
+	m.releaseCmdSlot(id)
+	os.RemoveAll(filepath.Join(m.overlayDir, overlayDirName(id)))
 	m.saveTargets()
 
 	debug.Info("push", "Deleted push target: %s", id)
@@ -222,52 +334,128 @@ func (m *Manager) StartPush(id string) error {
 		return errors.New("push target not found")
 	}
 
-	if target.Status == "pushing" {
+	if target.Status == StatusPushing {
 		m.mutex.Unlock()
 		return errors.New("already pushing")
 	}
 	m.mutex.Unlock()
 
-	// 构建完整的推流地址
-	dstURL := m.buildPushURL(target)
-	if dstURL == "" {
-		return errors.New("invalid push URL")
-	}
-
-	// 源流地址（ZLM 内部流）
-	srcURL := target.SourceURL
-	if srcURL == "" {
-		return errors.New("source URL is empty")
-	}
-
-	debug.Info("push", "Starting push: %s -> %s", srcURL, dstURL)
-
-	// 调用 ZLM 添加 FFmpeg 推流任务
-	result, err := m.zlmClient.AddFFmpegSource(srcURL, dstURL, 10000, true)
+	taskKey, taskType, err := m.launch(target)
 	if err != nil {
 		m.mutex.Lock()
-		target.Status = "error"
+		target.Status = StatusError
 		target.ErrorMsg = err.Error()
+		target.NextRetry = time.Time{}
+		m.releaseCmdSlot(target.ID)
 		target.UpdatedAt = time.Now()
+		target.addEvent(EventError, err.Error(), 0)
 		m.saveTargets()
 		m.mutex.Unlock()
 		return fmt.Errorf("failed to start push: %w", err)
 	}
 
 	m.mutex.Lock()
-	target.FFmpegKey = result.Key
-	target.Status = "pushing"
+	if target.Status == StatusPushing {
+		// 监督器已在此期间完成重连，丢弃本次创建的任务
+		m.mutex.Unlock()
+		m.stopTask(taskType, taskKey)
+		return nil
+	}
+	target.FFmpegKey = taskKey
+	target.TaskType = taskType
+	target.Status = StatusPushing
 	target.StartTime = time.Now()
 	target.ErrorMsg = ""
+	target.RetryCount = 0
+	target.NextRetry = time.Time{}
 	target.UpdatedAt = time.Now()
+	target.addEvent(EventStart, "", 0)
 	m.saveTargets()
 	m.mutex.Unlock()
 
-	debug.Info("push", "Push started successfully: %s (key: %s)", target.Name, result.Key)
+	debug.Info("push", "Push started successfully: %s (%s, key: %s)", target.Name, taskType, taskKey)
 	return nil
 }
 
-// StopPush 停止推流
+// launch 按推流协议创建 ZLM 推流任务，返回任务 Key 和任务类型
+func (m *Manager) launch(target *PushTarget) (string, string, error) {
+	if err := checkDecrypted(target); err != nil {
+		return "", "", err
+	}
+	if err := ValidateTarget(target); err != nil {
+		return "", "", err
+	}
+
+	// 构建完整的推流地址
+	dstURL := m.buildPushURL(target)
+	if dstURL == "" {
+		return "", "", errors.New("invalid push URL")
+	}
+
+	// 源流地址（ZLM 内部流）
+	srcURL := target.SourceURL
+	if srcURL == "" {
+		return "", "", errors.New("source URL is empty")
+	}
+
+	protocol := target.GetProtocol()
+	debug.Info("push", "Starting %s push: %s -> %s", protocol, srcURL, dstURL)
+
+	mechanism := mechanismFor(protocol)
+	cmdKey := ffmpegCmdKey(protocol)
+	if needsTranscode(target) {
+		// 转码和叠加只能由 FFmpeg 完成
+		key, err := m.prepareTranscode(target)
+		if err != nil {
+			return "", "", err
+		}
+		mechanism, cmdKey = MechanismFFmpeg, key
+	}
+
+	switch mechanism {
+	case MechanismPusher:
+		// ZLM 原生转推，直接复用 ZLM 内的源流
+		app, stream, err := parseSourceStream(srcURL)
+		if err != nil {
+			return "", "", err
+		}
+		opts := map[string]interface{}{}
+		if target.Options != nil && target.Options.RTPType == "udp" {
+			opts["rtp_type"] = 1
+		}
+		key, err := m.zlmClient.AddStreamPusherProxy(protocol, app, stream, dstURL, opts)
+		if err != nil {
+			return "", "", err
+		}
+		return key, MechanismPusher, nil
+	default:
+		// 调用 ZLM 添加 FFmpeg 推流任务
+		result, err := m.zlmClient.AddFFmpegSourceWithCmd(srcURL, dstURL, cmdKey, 10000, true)
+		if err != nil {
+			return "", "", err
+		}
+		return result.Key, MechanismFFmpeg, nil
+	}
+}
+
+// stopTask 删除 ZLM 推流任务
+func (m *Manager) stopTask(taskType, key string) {
+	if key == "" {
+		return
+	}
+
+	var err error
+	if taskType == MechanismPusher {
+		err = m.zlmClient.DelStreamPusherProxy(key)
+	} else {
+		err = m.zlmClient.DelFFmpegSource(key)
+	}
+	if err != nil {
+		debug.Warn("push", "Failed to delete %s task %s: %v", taskType, key, err)
+	}
+}
+
+// StopPush 停止推流（同时取消等待中的自动重连）
 func (m *Manager) StopPush(id string) error {
 	m.mutex.Lock()
 	target, exists := m.targets[id]
@@ -276,25 +464,30 @@ func (m *Manager) StopPush(id string) error {
 		return errors.New("push target not found")
 	}
 
-	if target.Status != "pushing" {
+	if target.Status != StatusPushing && target.Status != StatusReconnecting {
 		m.mutex.Unlock()
 		return nil
 	}
 
-	ffmpegKey := target.FFmpegKey
+	wasPushing := target.Status == StatusPushing
+	taskKey, taskType := target.FFmpegKey, target.TaskType
 	m.mutex.Unlock()
 
-	// 停止 FFmpeg 推流
-	if ffmpegKey != "" {
-		if err := m.zlmClient.DelFFmpegSource(ffmpegKey); err != nil {
-			debug.Warn("push", "Failed to delete ffmpeg source: %v", err)
-		}
-	}
+	// 停止推流任务
+	m.stopTask(taskType, taskKey)
 
 	m.mutex.Lock()
-	target.Status = "stopped"
+	var duration time.Duration
+	if wasPushing {
+		duration = time.Since(target.StartTime)
+	}
+	target.Status = StatusStopped
 	target.FFmpegKey = ""
+	m.releaseCmdSlot(target.ID)
+	target.RetryCount = 0
+	target.NextRetry = time.Time{}
 	target.UpdatedAt = time.Now()
+	target.addEvent(EventStop, "", duration)
 	m.saveTargets()
 	m.mutex.Unlock()
 
@@ -302,7 +495,7 @@ func (m *Manager) StopPush(id string) error {
 	return nil
 }
 
-// RefreshStatus 刷新推流状态
+// RefreshStatus 刷新推流状态，FFmpeg 任务消失的推流进入自动重连
 func (m *Manager) RefreshStatus() error {
 	// 获取当前所有 FFmpeg 任务
 	ffmpegList, err := m.zlmClient.ListFFmpegSource()
@@ -310,7 +503,7 @@ func (m *Manager) RefreshStatus() error {
 		return err
 	}
 
-	// 创建 FFmpeg key 集合
+	// 创建任务 key 集合
 	activeKeys := make(map[string]bool)
 	for _, item := range ffmpegList {
 		if key, ok := item["key"].(string); ok {
@@ -318,27 +511,59 @@ func (m *Manager) RefreshStatus() error {
 		}
 	}
 
+	// 转推任务列表获取失败时（如 ZLM 版本不支持）不判断转推任务状态
+	pusherKnown := true
+	pusherList, err := m.zlmClient.ListStreamPusherProxy()
+	if err != nil {
+		pusherKnown = false
+	}
+	for _, item := range pusherList {
+		if key, ok := item["key"].(string); ok {
+			activeKeys[key] = true
+		}
+	}
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// 更新状态
+	changed := false
+	now := m.now()
 	for _, target := range m.targets {
-		if target.Status == "pushing" {
-			if target.FFmpegKey == "" || !activeKeys[target.FFmpegKey] {
-				target.Status = "error"
-				target.ErrorMsg = "Push task not found"
-				target.UpdatedAt = time.Now()
-			}
+		if target.Status != StatusPushing {
+			continue
+		}
+		if target.TaskType == MechanismPusher && !pusherKnown {
+			continue
+		}
+		if target.FFmpegKey == "" || !activeKeys[target.FFmpegKey] {
+			target.FFmpegKey = ""
+			target.addEvent(EventError, "Push task not found", now.Sub(target.StartTime))
+			m.scheduleRetry(target, "Push task not found", now)
+			changed = true
+		} else if target.RetryCount > 0 && now.Sub(target.StartTime) >= m.policy.StableDuration {
+			// 稳定运行一段时间后清零重试计数
+			target.RetryCount = 0
+			changed = true
 		}
 	}
 
-	m.saveTargets()
+	if changed {
+		m.saveTargets()
+	}
 	return nil
 }
 
 // buildPushURL 构建推流地址
 func (m *Manager) buildPushURL(target *PushTarget) string {
 	if target.Platform == "custom" {
+		if target.GetProtocol() == ProtocolSRT && target.PushURL != "" {
+			dstURL, err := buildSRTURL(target.PushURL, target.Options)
+			if err != nil {
+				return ""
+			}
+			return dstURL
+		}
 		return target.PushURL
 	}
 
@@ -375,34 +600,104 @@ func (m *Manager) loadTargets() {
 		return
 	}
 
+	plaintext := 0
 	for _, target := range targets {
-		// 重置运行状态
-		target.Status = "stopped"
+		if target.StreamKey != "" && !secrets.IsEncrypted(target.StreamKey) {
+			plaintext++
+		}
+		// 解密失败时保留密文，回写时原样落盘，避免主密钥错误导致凭据丢失
+		if streamKey, err := secrets.Decrypt(target.StreamKey); err != nil {
+			debug.Error("push", "Failed to decrypt stream key of target %s: %v", target.ID, err)
+		} else {
+			target.StreamKey = streamKey
+		}
+		if target.Options != nil && target.Options.Passphrase != "" {
+			if passphrase, err := secrets.Decrypt(target.Options.Passphrase); err != nil {
+				debug.Error("push", "Failed to decrypt srt passphrase of target %s: %v", target.ID, err)
+			} else {
+				target.Options.Passphrase = passphrase
+			}
+		}
+
+		// 重启前正在推流的目标交由监督器恢复，其余重置为停止；凭据无法解密的目标不恢复
+		if err := checkDecrypted(target); err != nil {
+			target.Status = StatusError
+			target.ErrorMsg = err.Error()
+			target.NextRetry = time.Time{}
+		} else if target.Status == StatusPushing || target.Status == StatusReconnecting {
+			target.Status = StatusReconnecting
+			target.RetryCount = 0
+			target.NextRetry = time.Now()
+			target.addEvent(EventRestore, "restored after restart", 0)
+		} else {
+			target.Status = StatusStopped
+		}
 		target.FFmpegKey = ""
 		m.targets[target.ID] = target
 	}
 
 	debug.Info("push", "Loaded %d push targets", len(targets))
+
+	// 旧版明文推流密钥立即加密回写
+	if plaintext > 0 {
+		debug.Info("push", "Encrypting %d plaintext stream keys", plaintext)
+		m.saveTargets()
+	}
+}
+
+// checkDecrypted 检查推流凭据是否已解密，仍为密文说明主密钥与加密时不一致
+func checkDecrypted(target *PushTarget) error {
+	if secrets.IsEncrypted(target.StreamKey) {
+		return errors.New("stream key cannot be decrypted with the current master key; restore the original secret key or re-enter the stream key")
+	}
+	if target.Options != nil && secrets.IsEncrypted(target.Options.Passphrase) {
+		return errors.New("srt passphrase cannot be decrypted with the current master key; restore the original secret key or re-enter the passphrase")
+	}
+	return nil
+}
+
+// RewriteTargets 使用当前主密钥重写推流目标文件（密钥轮换时使用）
+func (m *Manager) RewriteTargets() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.writeTargets()
 }
 
 // saveTargets 保存推流目标
 func (m *Manager) saveTargets() {
+	if err := m.writeTargets(); err != nil {
+		debug.Warn("push", "Failed to save push targets: %v", err)
+	}
+}
+
+// writeTargets 将推流目标写入文件，推流密钥加密后落盘，内存中保留明文
+func (m *Manager) writeTargets() error {
 	if m.dataFile == "" {
-		return
+		return nil
 	}
 
 	targets := make([]*PushTarget, 0, len(m.targets))
 	for _, target := range m.targets {
-		targets = append(targets, target)
+		copied := *target
+		streamKey, err := secrets.Encrypt(target.StreamKey)
+		if err != nil {
+			return fmt.Errorf("encrypt stream key: %w", err)
+		}
+		copied.StreamKey = streamKey
+		if target.Options != nil {
+			opts := *target.Options
+			if opts.Passphrase, err = secrets.Encrypt(opts.Passphrase); err != nil {
+				return fmt.Errorf("encrypt srt passphrase: %w", err)
+			}
+			copied.Options = &opts
+		}
+		targets = append(targets, &copied)
 	}
 
 	data, err := json.MarshalIndent(targets, "", "  ")
 	if err != nil {
-		debug.Warn("push", "Failed to marshal push targets: %v", err)
-		return
+		return err
 	}
 
-	if err := os.WriteFile(m.dataFile, data, 0644); err != nil {
-		debug.Warn("push", "Failed to save push targets: %v", err)
-	}
+	return os.WriteFile(m.dataFile, data, 0600)
 }
@@ -0,0 +1,59 @@
+package push
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gb28181-onvif-server/internal/secrets"
+)
+
+func TestLoadTargetsKeepsUndecryptableSecrets(t *testing.T) {
+	oldKey, err := secrets.NewKeyring(secrets.GenerateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamKey, _ := oldKey.Encrypt("live-stream-key")
+	passphrase, _ := oldKey.Encrypt("srt-passphrase-123")
+
+	file := filepath.Join(t.TempDir(), "push_targets.json")
+	data, _ := json.Marshal([]*PushTarget{
+		{ID: "rtmp", Name: "rtmp", Protocol: ProtocolRTMP, PushURL: "rtmp://live.example.com/app", StreamKey: streamKey, SourceURL: "rtmp://127.0.0.1/live/a", Status: StatusPushing},
+		{ID: "srt", Name: "srt", Protocol: ProtocolSRT, PushURL: "srt://live.example.com:9000", SourceURL: "rtmp://127.0.0.1/live/b", Options: &PushOptions{Passphrase: passphrase}},
+		{ID: "legacy", Name: "legacy", Protocol: ProtocolRTMP, PushURL: "rtmp://live.example.com/app", StreamKey: "plain-key", SourceURL: "rtmp://127.0.0.1/live/c"},
+	})
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主密钥丢失后重新生成，存量密文无法解密
+	newKey, _ := secrets.NewKeyring(secrets.GenerateKey())
+	secrets.SetDefault(newKey)
+	m := NewManager(nil, file, 0)
+
+	rtmp, _ := m.GetTarget("rtmp")
+	if rtmp.StreamKey != streamKey || rtmp.Status != StatusError || !strings.Contains(rtmp.ErrorMsg, "master key") {
+		t.Errorf("rtmp 目标 = key %q status %s error %q", rtmp.StreamKey, rtmp.Status, rtmp.ErrorMsg)
+	}
+	for _, id := range []string{"rtmp", "srt"} {
+		if err := m.StartPush(id); err == nil || !strings.Contains(err.Error(), "cannot be decrypted") {
+			t.Errorf("StartPush(%s) error = %v", id, err)
+		}
+	}
+
+	// 加载时明文目标触发回写，无法解密的密文必须原样保留
+	saved, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ciphertext := range []string{streamKey, passphrase} {
+		if !strings.Contains(string(saved), ciphertext) {
+			t.Errorf("回写后丢失密文 %s", ciphertext)
+		}
+	}
+	if strings.Contains(string(saved), "plain-key") {
+		t.Error("明文推流密钥未加密回写")
+	}
+}
@@ -0,0 +1,206 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gb28181-onvif-server/internal/secrets"
+)
+
+// 推流协议
+const (
+	ProtocolRTMP  = "rtmp"
+	ProtocolRTMPS = "rtmps"
+	ProtocolSRT   = "srt"
+	ProtocolRTSP  = "rtsp"
+	ProtocolWHIP  = "whip" // WebRTC-HTTP Ingestion Protocol
+)
+
+// SupportedProtocols 支持的推流协议
+var SupportedProtocols = []string{ProtocolRTMP, ProtocolRTMPS, ProtocolSRT, ProtocolRTSP, ProtocolWHIP}
+
+// 推流任务在 ZLM 中的实现方式
+const (
+	MechanismFFmpeg = "ffmpeg" // addFFmpegSource，可转码、封装为任意格式
+	MechanismPusher = "pusher" // addStreamPusherProxy，ZLM 原生转推，不转码
+)
+
+// PushOptions 协议相关的推流参数
+type PushOptions struct {
+	// SRT
+	Latency    int    `json:"latency,omitempty"`    // 接收端缓冲延迟(毫秒)，默认 120
+	Passphrase string `json:"passphrase,omitempty"` // 加密口令，10-79 个字符，落盘加密
+	PBKeyLen   int    `json:"pbkeylen,omitempty"`   // 密钥长度: 16/24/32，默认 16
+	StreamID   string `json:"stream_id,omitempty"`  // SRT streamid（如 #!::r=live/stream,m=publish）
+
+	// RTSP
+	RTPType string `json:"rtp_type,omitempty"` // RTP 传输: tcp(默认)/udp
+}
+
+// GetProtocol 推流协议，旧数据未设置时视为 RTMP
+func (t *PushTarget) GetProtocol() string {
+	if t.Protocol == "" {
+		return ProtocolRTMP
+	}
+	return t.Protocol
+}
+
+// protocolSchemes 各协议允许的推流地址 scheme
+var protocolSchemes = map[string][]string{
+	ProtocolRTMP:  {"rtmp"},
+	ProtocolRTMPS: {"rtmps"},
+	ProtocolSRT:   {"srt"},
+	ProtocolRTSP:  {"rtsp", "rtsps"},
+	ProtocolWHIP:  {"http", "https"},
+}
+
+// ValidateTarget 校验推流协议和协议参数
+func ValidateTarget(t *PushTarget) error {
+	protocol := t.GetProtocol()
+	schemes, ok := protocolSchemes[protocol]
+	if !ok {
+		return fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+	if err := validateOverlays(t.Overlays); err != nil {
+		return err
+	}
+
+	if t.Platform != "custom" {
+		// 预置直播平台均为 RTMP 模板
+		if protocol != ProtocolRTMP {
+			return fmt.Errorf("platform %s only supports rtmp", t.Platform)
+		}
+		return nil
+	}
+
+	if t.PushURL != "" {
+		u, err := url.Parse(t.PushURL)
+		if err != nil || u.Host == "" {
+			return errors.New("invalid push URL")
+		}
+		matched := false
+		for _, scheme := range schemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("push URL scheme must be %s for protocol %s", strings.Join(schemes, "/"), protocol)
+		}
+		if protocol == ProtocolSRT && u.Port() == "" {
+			return errors.New("srt push URL requires a port")
+		}
+	}
+
+	opts := t.Options
+	if opts == nil {
+		return nil
+	}
+
+	if protocol == ProtocolSRT {
+		if opts.Latency < 0 || opts.Latency > 60000 {
+			return errors.New("srt latency must be between 0 and 60000 ms")
+		}
+		// 无法解密而保留的密文不校验长度，以免阻止修改其他字段
+		if opts.Passphrase != "" && !secrets.IsEncrypted(opts.Passphrase) && (len(opts.Passphrase) < 10 || len(opts.Passphrase) > 79) {
+			return errors.New("srt passphrase must be 10-79 characters")
+		}
+		switch opts.PBKeyLen {
+		case 0, 16, 24, 32:
+		default:
+			return errors.New("srt pbkeylen must be 16, 24 or 32")
+		}
+		if opts.PBKeyLen != 0 && opts.Passphrase == "" {
+			return errors.New("srt pbkeylen requires a passphrase")
+		}
+	} else if opts.Latency != 0 || opts.Passphrase != "" || opts.PBKeyLen != 0 || opts.StreamID != "" {
+		return fmt.Errorf("srt options are not valid for protocol %s", protocol)
+	}
+
+	if opts.RTPType != "" {
+		if protocol != ProtocolRTSP {
+			return fmt.Errorf("rtp_type is not valid for protocol %s", protocol)
+		}
+		if opts.RTPType != "tcp" && opts.RTPType != "udp" {
+			return errors.New("rtp_type must be tcp or udp")
+		}
+	}
+
+	return nil
+}
+
+// mechanismFor 协议对应的 ZLM 推流方式
+// RTSP 直接由 ZLM 转推，无需转码；RTMP/RTMPS 经 FFmpeg 转码为直播平台要求的 H.264/AAC，
+// SRT 和 WHIP 需要 FFmpeg 的 mpegts/whip 封装
+func mechanismFor(protocol string) string {
+	if protocol == ProtocolRTSP {
+		return MechanismPusher
+	}
+	return MechanismFFmpeg
+}
+
+// ffmpegCmdKey 协议对应的 ZLM FFmpeg 命令模板（见 config.GenerateConfigINI 的 [ffmpeg] 段）
+func ffmpegCmdKey(protocol string) string {
+	switch protocol {
+	case ProtocolSRT:
+		return "ffmpeg.cmd_srt"
+	case ProtocolWHIP:
+		return "ffmpeg.cmd_whip"
+	default:
+		return ""
+	}
+}
+
+// buildSRTURL 将 SRT 参数附加到推流地址（FFmpeg srt 协议的 latency 单位为微秒）
+func buildSRTURL(base string, opts *PushOptions) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("mode", "caller")
+	latency := 120
+	if opts != nil && opts.Latency > 0 {
+		latency = opts.Latency
+	}
+	query.Set("latency", strconv.Itoa(latency*1000))
+	if opts != nil {
+		if opts.Passphrase != "" {
+			query.Set("passphrase", opts.Passphrase)
+			pbkeylen := opts.PBKeyLen
+			if pbkeylen == 0 {
+				pbkeylen = 16
+			}
+			query.Set("pbkeylen", strconv.Itoa(pbkeylen))
+		}
+		if opts.StreamID != "" {
+			query.Set("streamid", opts.StreamID)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// parseSourceStream 从 ZLM 源流地址解析 app 和 stream（转推任务需要）
+// 支持 rtsp://host/app/stream、rtmp://host/app/stream 和 http://host/app/stream.live.flv
+func parseSourceStream(srcURL string) (string, string, error) {
+	u, err := url.Parse(srcURL)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("cannot parse app/stream from source URL: %s", srcURL)
+	}
+
+	stream := parts[1]
+	for _, suffix := range []string{".live.flv", ".flv", "/hls.m3u8", ".live.ts", ".live.mp4"} {
+		stream = strings.TrimSuffix(stream, suffix)
+	}
+	return parts[0], stream, nil
+}
@@ -0,0 +1,238 @@
+package push
+
+import (
+	"fmt"
+	"time"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+// 推流状态
+const (
+	StatusStopped      = "stopped"
+	StatusPushing      = "pushing"
+	StatusReconnecting = "reconnecting" // 推流中断，等待自动重连
+	StatusError        = "error"        // 启动失败或超过最大重试次数
+)
+
+// 推流事件类型
+const (
+	EventStart   = "start"   // 推流启动（含自动重连成功）
+	EventStop    = "stop"    // 手动停止
+	EventError   = "error"   // 推流中断或启动失败
+	EventRetry   = "retry"   // 计划重试
+	EventGiveUp  = "give_up" // 超过最大重试次数
+	EventRestore = "restore" // 服务重启后恢复推流
+)
+
+// maxHistoryEvents 每个推流目标保留的事件数
+const maxHistoryEvents = 50
+
+// PushEvent 推流事件
+type PushEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration,omitempty"` // 本次推流持续时间（秒），stop/error 事件有效
+	Attempt  int       `json:"attempt,omitempty"`  // 重试次数
+	Message  string    `json:"message,omitempty"`
+}
+
+// RetryPolicy 自动重连策略
+type RetryPolicy struct {
+	MaxRetries     int           // 连续重试上限，0 表示不限
+	InitialBackoff time.Duration // 首次重试等待
+	MaxBackoff     time.Duration // 最大重试等待
+	CheckInterval  time.Duration // 监督器检查间隔
+	StableDuration time.Duration // 稳定运行多久后清零重试计数
+}
+
+// DefaultRetryPolicy 默认重连策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     10,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		CheckInterval:  10 * time.Second,
+		StableDuration: time.Minute,
+	}
+}
+
+// backoff 第 attempt 次重试前的等待时间（指数退避）
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// addEvent 记录推流事件（调用方持有锁）
+func (t *PushTarget) addEvent(eventType, message string, duration time.Duration) {
+	event := PushEvent{
+		Type:    eventType,
+		Time:    time.Now(),
+		Attempt: t.RetryCount,
+		Message: message,
+	}
+	if duration > 0 {
+		event.Duration = duration.Seconds()
+	}
+	t.History = append(t.History, event)
+	if len(t.History) > maxHistoryEvents {
+		t.History = t.History[len(t.History)-maxHistoryEvents:]
+	}
+}
+
+// SetRetryPolicy 设置自动重连策略，需在 StartSupervisor 之前调用
+func (m *Manager) SetRetryPolicy(policy RetryPolicy) {
+	defaults := DefaultRetryPolicy()
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaults.InitialBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = defaults.MaxBackoff
+	}
+	if policy.CheckInterval <= 0 {
+		policy.CheckInterval = defaults.CheckInterval
+	}
+	if policy.StableDuration <= 0 {
+		policy.StableDuration = defaults.StableDuration
+	}
+
+	m.mutex.Lock()
+	m.policy = policy
+	m.mutex.Unlock()
+}
+
+// GetHistory 获取推流目标的事件历史
+func (m *Manager) GetHistory(id string) ([]PushEvent, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	target, exists := m.targets[id]
+	if !exists {
+		return nil, fmt.Errorf("push target not found")
+	}
+	history := make([]PushEvent, len(target.History))
+	copy(history, target.History)
+	return history, nil
+}
+
+// scheduleRetry 推流失败后安排下一次重试，超过上限则放弃（调用方持有锁）
+func (m *Manager) scheduleRetry(target *PushTarget, reason string, now time.Time) {
+	target.RetryCount++
+	target.ErrorMsg = reason
+	target.UpdatedAt = now
+
+	if m.policy.MaxRetries > 0 && target.RetryCount > m.policy.MaxRetries {
+		target.Status = StatusError
+		target.NextRetry = time.Time{}
+		m.releaseCmdSlot(target.ID)
+		target.ErrorMsg = fmt.Sprintf("exceeded max retries (%d): %s", m.policy.MaxRetries, reason)
+		target.addEvent(EventGiveUp, target.ErrorMsg, 0)
+		debug.Error("push", "Push %s gave up after %d retries: %s", target.Name, m.policy.MaxRetries, reason)
+		return
+	}
+
+	wait := m.policy.backoff(target.RetryCount)
+	target.Status = StatusReconnecting
+	target.NextRetry = now.Add(wait)
+	target.addEvent(EventRetry, fmt.Sprintf("retry in %s", wait), 0)
+	debug.Warn("push", "Push %s interrupted (%s), retry #%d in %s", target.Name, reason, target.RetryCount, wait)
+}
+
+// StartSupervisor 启动推流监督器：检测中断的推流并按退避策略自动重连，同时执行推流组的定时和源流上线触发
+func (m *Manager) StartSupervisor() {
+	m.mutex.Lock()
+	if m.supervisorStop != nil {
+		m.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	m.supervisorStop = stop
+	policy := m.policy
+	m.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(policy.CheckInterval)
+		defer ticker.Stop()
+
+		// 启动后立即恢复重启前的推流
+		m.retryDue()
+		m.evaluateGroups()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := m.RefreshStatus(); err != nil {
+					debug.Warn("push", "Failed to refresh push status: %v", err)
+				}
+				m.retryDue()
+				m.evaluateGroups()
+			}
+		}
+	}()
+
+	debug.Info("push", "Push supervisor started (interval=%s, maxRetries=%d)", policy.CheckInterval, policy.MaxRetries)
+}
+
+// StopSupervisor 停止推流监督器（不停止正在进行的推流）
+func (m *Manager) StopSupervisor() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.supervisorStop != nil {
+		close(m.supervisorStop)
+		m.supervisorStop = nil
+	}
+}
+
+// retryDue 重新启动到达重试时间的推流
+func (m *Manager) retryDue() {
+	now := m.now()
+	var due []*PushTarget
+
+	m.mutex.RLock()
+	for _, target := range m.targets {
+		if target.Status == StatusReconnecting && !now.Before(target.NextRetry) {
+			due = append(due, target)
+		}
+	}
+	m.mutex.RUnlock()
+
+	for _, target := range due {
+		taskKey, taskType, err := m.launch(target)
+
+		m.mutex.Lock()
+		// 重试期间目标可能被删除或手动停止
+		if current, exists := m.targets[target.ID]; !exists || current != target || target.Status != StatusReconnecting {
+			m.mutex.Unlock()
+			if err == nil {
+				m.stopTask(taskType, taskKey)
+			}
+			continue
+		}
+
+		now := m.now()
+		if err != nil {
+			m.scheduleRetry(target, err.Error(), now)
+		} else {
+			target.FFmpegKey = taskKey
+			target.TaskType = taskType
+			target.Status = StatusPushing
+			target.StartTime = now
+			target.ErrorMsg = ""
+			target.NextRetry = time.Time{}
+			target.UpdatedAt = now
+			target.addEvent(EventStart, "reconnected", 0)
+			debug.Info("push", "Push %s reconnected (attempt #%d)", target.Name, target.RetryCount)
+		}
+		m.saveTargets()
+		m.mutex.Unlock()
+	}
+}
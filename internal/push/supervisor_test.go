@@ -0,0 +1,68 @@
+package push
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 2 * time.Second, MaxBackoff: 30 * time.Second}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{4, 16 * time.Second},
+		{5, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := policy.backoff(tc.attempt); got != tc.want {
+			t.Errorf("backoff(%d) = %s, want %s", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestRetryDueBackoffAndGiveUp(t *testing.T) {
+	m := NewManager(nil, "", 0)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	m.SetRetryPolicy(RetryPolicy{MaxRetries: 3, InitialBackoff: 2 * time.Second, MaxBackoff: 5 * time.Second})
+
+	// 推流地址为空，每次重连都会失败
+	target := &PushTarget{ID: "t1", Name: "t1", Protocol: ProtocolRTMP, SourceURL: "rtmp://127.0.0.1/live/test",
+		Status: StatusReconnecting, NextRetry: now.Add(time.Second)}
+	m.targets[target.ID] = target
+
+	m.retryDue()
+	if target.RetryCount != 0 {
+		t.Fatal("未到重试时间不应重连")
+	}
+
+	for i, wait := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		now = target.NextRetry
+		m.retryDue()
+		if target.Status != StatusReconnecting || target.RetryCount != i+1 {
+			t.Fatalf("第 %d 次重试后 status=%s retry=%d", i+1, target.Status, target.RetryCount)
+		}
+		if got := target.NextRetry.Sub(now); got != wait {
+			t.Errorf("第 %d 次重试等待 = %s, want %s", i+1, got, wait)
+		}
+	}
+
+	// 超过 MaxRetries 后放弃
+	now = target.NextRetry
+	m.retryDue()
+	if target.Status != StatusError || !target.NextRetry.IsZero() {
+		t.Fatalf("超过重试上限后 status=%s nextRetry=%s", target.Status, target.NextRetry)
+	}
+	if last := target.History[len(target.History)-1]; last.Type != EventGiveUp {
+		t.Errorf("最后一个事件 = %s, want %s", last.Type, EventGiveUp)
+	}
+	now = now.Add(time.Hour)
+	m.retryDue()
+	if target.Status != StatusError || target.RetryCount != 4 {
+		t.Errorf("放弃后不应继续重试: status=%s retry=%d", target.Status, target.RetryCount)
+	}
+}
@@ -0,0 +1,698 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gb28181-onvif-server/internal/config"
+	"gb28181-onvif-server/internal/debug"
+	"gb28181-onvif-server/internal/mediautil"
+)
+
+// 转码视频编码
+const (
+	VideoCodecCopy = "copy" // 不转码
+	VideoCodecH264 = "h264"
+	VideoCodecH265 = "h265"
+)
+
+// 转码音频编码
+const (
+	AudioCodecCopy = "copy"
+	AudioCodecAAC  = "aac"
+	AudioCodecOpus = "opus"
+	AudioCodecNone = "none" // 去除音频
+)
+
+// TranscodeProfile 推流转码模板
+type TranscodeProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	BuiltIn     bool   `json:"builtin"`
+
+	VideoCodec   string `json:"video_codec"`             // copy/h264/h265
+	Width        int    `json:"width,omitempty"`         // 输出宽度，0 表示保持源分辨率
+	Height       int    `json:"height,omitempty"`        // 输出高度，0 表示按宽度等比缩放
+	VideoBitrate int    `json:"video_bitrate,omitempty"` // 视频码率(kbps)
+	FPS          int    `json:"fps,omitempty"`           // 输出帧率，0 表示保持源帧率
+	GOP          int    `json:"gop,omitempty"`           // 关键帧间隔(帧)，0 表示 2 秒
+
+	AudioCodec      string `json:"audio_codec"`                 // copy/aac/opus/none
+	AudioBitrate    int    `json:"audio_bitrate,omitempty"`     // 音频码率(kbps)
+	AudioSampleRate int    `json:"audio_sample_rate,omitempty"` // 音频重采样率(Hz)
+	AudioChannels   int    `json:"audio_channels,omitempty"`    // 音频声道数
+}
+
+// DefaultProfiles 内置转码模板
+func DefaultProfiles() []*TranscodeProfile {
+	return []*TranscodeProfile{
+		{
+			Name:            "passthrough-aac",
+			Description:     "视频直通，音频转 AAC（适用于 G.711 音频的摄像机）",
+			VideoCodec:      VideoCodecCopy,
+			AudioCodec:      AudioCodecAAC,
+			AudioBitrate:    64,
+			AudioSampleRate: 44100,
+			AudioChannels:   1,
+		},
+		{
+			Name:            "h264-1080p",
+			Description:     "H.264 1080p 30fps 4.5Mbps",
+			VideoCodec:      VideoCodecH264,
+			Width:           1920,
+			Height:          1080,
+			VideoBitrate:    4500,
+			FPS:             30,
+			AudioCodec:      AudioCodecAAC,
+			AudioBitrate:    128,
+			AudioSampleRate: 48000,
+			AudioChannels:   2,
+		},
+		{
+			Name:            "h264-720p",
+			Description:     "H.264 720p 25fps 2.5Mbps",
+			VideoCodec:      VideoCodecH264,
+			Width:           1280,
+			Height:          720,
+			VideoBitrate:    2500,
+			FPS:             25,
+			AudioCodec:      AudioCodecAAC,
+			AudioBitrate:    128,
+			AudioSampleRate: 44100,
+			AudioChannels:   2,
+		},
+		{
+			Name:            "h264-480p",
+			Description:     "H.264 480p 25fps 1Mbps（弱网）",
+			VideoCodec:      VideoCodecH264,
+			Width:           854,
+			Height:          480,
+			VideoBitrate:    1000,
+			FPS:             25,
+			AudioCodec:      AudioCodecAAC,
+			AudioBitrate:    64,
+			AudioSampleRate: 44100,
+			AudioChannels:   1,
+		},
+	}
+}
+
+// Validate 校验转码模板参数
+func (p *TranscodeProfile) Validate() error {
+	if p.Name == "" {
+		return errors.New("profile name is required")
+	}
+	switch p.VideoCodec {
+	case VideoCodecCopy, VideoCodecH264, VideoCodecH265:
+	default:
+		return fmt.Errorf("profile %s: unsupported video codec %q", p.Name, p.VideoCodec)
+	}
+	switch p.AudioCodec {
+	case AudioCodecCopy, AudioCodecAAC, AudioCodecOpus, AudioCodecNone:
+	default:
+		return fmt.Errorf("profile %s: unsupported audio codec %q", p.Name, p.AudioCodec)
+	}
+	if p.Width < 0 || p.Height < 0 || p.VideoBitrate < 0 || p.FPS < 0 || p.GOP < 0 ||
+		p.AudioBitrate < 0 || p.AudioSampleRate < 0 || p.AudioChannels < 0 {
+		return fmt.Errorf("profile %s: numeric parameters must not be negative", p.Name)
+	}
+	if p.Width%2 != 0 || p.Height%2 != 0 {
+		return fmt.Errorf("profile %s: width and height must be even", p.Name)
+	}
+	if p.Height > 0 && p.Width == 0 {
+		return fmt.Errorf("profile %s: height requires width", p.Name)
+	}
+	if p.VideoCodec == VideoCodecCopy && (p.Width > 0 || p.VideoBitrate > 0 || p.FPS > 0 || p.GOP > 0) {
+		return fmt.Errorf("profile %s: video parameters require a video codec other than copy", p.Name)
+	}
+	if p.AudioChannels > 2 {
+		return fmt.Errorf("profile %s: audio channels must be 1 or 2", p.Name)
+	}
+	return nil
+}
+
+// 叠加层类型
+const (
+	OverlayLogo        = "logo"         // 图片水印
+	OverlayText        = "text"         // 自定义文字
+	OverlayChannelName = "channel_name" // 通道名称
+	OverlayClock       = "clock"        // 本地时间
+)
+
+// 叠加层位置
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+)
+
+// Overlay 推流画面叠加层
+type Overlay struct {
+	Type      string `json:"type"`                 // logo/text/channel_name/clock
+	Image     string `json:"image,omitempty"`      // 水印图片路径（logo）
+	Text      string `json:"text,omitempty"`       // 文字内容（text；channel_name 为空时使用通道名称）
+	Position  string `json:"position,omitempty"`   // top-left(默认)/top-right/bottom-left/bottom-right
+	FontSize  int    `json:"font_size,omitempty"`  // 字号，默认 24
+	FontColor string `json:"font_color,omitempty"` // 字体颜色，默认 white
+	FontFile  string `json:"font_file,omitempty"`  // 字体文件，为空时使用全局默认字体
+}
+
+// overlayMargin 叠加层距画面边缘的像素
+const overlayMargin = 10
+
+// defaultVAAPIDevice 默认的 VAAPI DRM 渲染节点
+const defaultVAAPIDevice = "/dev/dri/renderD128"
+
+// clockText 时钟叠加层的 drawtext 表达式
+const clockText = `%{localtime:%Y-%m-%d %H\:%M\:%S}`
+
+var (
+	// filterPathPattern 可直接写入 FFmpeg 滤镜参数的路径（ZLM 按空格拆分命令行，滤镜参数不能含分隔符）
+	filterPathPattern = regexp.MustCompile(`^[A-Za-z0-9_./\-]+$`)
+	// colorPattern FFmpeg 颜色（如 white、#FFFFFF、yellow@0.8）
+	colorPattern = regexp.MustCompile(`^[A-Za-z0-9#@.]+$`)
+)
+
+// validateOverlays 校验叠加层参数
+func validateOverlays(overlays []Overlay) error {
+	for i, ov := range overlays {
+		switch ov.Type {
+		case OverlayLogo:
+			if ov.Image == "" {
+				return fmt.Errorf("overlay %d: logo requires an image", i)
+			}
+			if !filterPathPattern.MatchString(ov.Image) {
+				return fmt.Errorf("overlay %d: image path may only contain letters, digits, '_', '-', '.' and '/'", i)
+			}
+		case OverlayText:
+			if ov.Text == "" {
+				return fmt.Errorf("overlay %d: text is required", i)
+			}
+		case OverlayChannelName, OverlayClock:
+		default:
+			return fmt.Errorf("overlay %d: unsupported type %q", i, ov.Type)
+		}
+
+		switch ov.Position {
+		case "", PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight:
+		default:
+			return fmt.Errorf("overlay %d: unsupported position %q", i, ov.Position)
+		}
+		if ov.FontSize < 0 || ov.FontSize > 256 {
+			return fmt.Errorf("overlay %d: font size must be between 1 and 256", i)
+		}
+		if ov.FontColor != "" && !colorPattern.MatchString(ov.FontColor) {
+			return fmt.Errorf("overlay %d: invalid font color", i)
+		}
+		if ov.FontFile != "" && !filterPathPattern.MatchString(ov.FontFile) {
+			return fmt.Errorf("overlay %d: font path may only contain letters, digits, '_', '-', '.' and '/'", i)
+		}
+	}
+	return nil
+}
+
+// needsTranscode 推流目标是否需要按转码模板生成 FFmpeg 命令
+func needsTranscode(t *PushTarget) bool {
+	return t.Profile != "" || len(t.Overlays) > 0
+}
+
+// SetProfiles 加载自定义转码模板，与内置模板同名时覆盖内置模板
+func (m *Manager) SetProfiles(profiles []*TranscodeProfile) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			debug.Warn("push", "Ignoring invalid transcode profile: %v", err)
+			continue
+		}
+		p.BuiltIn = false
+		m.profiles[p.Name] = p
+	}
+}
+
+// SetFontFile 设置文字叠加层的默认字体文件
+func (m *Manager) SetFontFile(path string) {
+	if path != "" && !filterPathPattern.MatchString(path) {
+		debug.Warn("push", "Ignoring overlay font %q: path may only contain letters, digits, '_', '-', '.' and '/'", path)
+		return
+	}
+
+	m.mutex.Lock()
+	m.fontFile = path
+	m.mutex.Unlock()
+}
+
+// SetVAAPIDevice 设置 VAAPI 硬件编码使用的 DRM 渲染节点，为空时使用 /dev/dri/renderD128
+func (m *Manager) SetVAAPIDevice(path string) {
+	if path == "" {
+		path = defaultVAAPIDevice
+	}
+	if !filterPathPattern.MatchString(path) {
+		debug.Warn("push", "Ignoring VAAPI device %q: path may only contain letters, digits, '_', '-', '.' and '/'", path)
+		return
+	}
+
+	m.mutex.Lock()
+	m.vaapiDevice = path
+	m.mutex.Unlock()
+}
+
+// GetProfiles 获取所有转码模板
+func (m *Manager) GetProfiles() []*TranscodeProfile {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	result := make([]*TranscodeProfile, 0, len(m.profiles))
+	for _, p := range m.profiles {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// validateTranscode 校验推流目标引用的转码模板与协议、叠加层是否匹配（调用方持有锁）
+func (m *Manager) validateTranscode(t *PushTarget) error {
+	if !needsTranscode(t) {
+		return nil
+	}
+	if t.Profile == "" {
+		return errors.New("overlays require a transcoding profile")
+	}
+	profile, ok := m.profiles[t.Profile]
+	if !ok {
+		return fmt.Errorf("transcode profile not found: %s", t.Profile)
+	}
+	if len(t.Overlays) > 0 && profile.VideoCodec == VideoCodecCopy {
+		return fmt.Errorf("overlays cannot be used with profile %s (video copy)", profile.Name)
+	}
+
+	protocol := t.GetProtocol()
+	switch protocol {
+	case ProtocolRTMP, ProtocolRTMPS:
+		if profile.VideoCodec == VideoCodecH265 {
+			return fmt.Errorf("protocol %s does not support h265", protocol)
+		}
+		if profile.AudioCodec == AudioCodecOpus {
+			return fmt.Errorf("protocol %s does not support opus", protocol)
+		}
+	case ProtocolWHIP:
+		if profile.VideoCodec == VideoCodecH265 {
+			return fmt.Errorf("protocol %s does not support h265", protocol)
+		}
+	}
+
+	if len(t.Overlays) > 0 && m.fontFile == "" {
+		for _, ov := range t.Overlays {
+			if ov.Type != OverlayLogo && ov.FontFile == "" {
+				debug.Warn("push", "Push target %s: no overlay font configured, relying on ffmpeg fontconfig", t.Name)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// overlayTextFile 文字叠加层的内容文件
+// 文字通过 drawtext 的 textfile 读取，避免通道名称中的空格和特殊字符破坏 ZLM 的命令行拆分
+type overlayTextFile struct {
+	Path    string
+	Content string
+}
+
+// transcodeCommand 生成的转码命令模板
+type transcodeCommand struct {
+	Template  string
+	TextFiles []overlayTextFile
+	Encoder   string
+}
+
+// buildTranscodeCommand 按转码模板和叠加层生成 ZLM FFmpeg 命令模板
+// 模板格式与 ZLM [ffmpeg] cmd 一致：三个 %s 依次为 ffmpeg 路径、源流地址和推流地址
+// vaapiDevice 为 VAAPI 编码使用的 DRM 渲染节点
+func buildTranscodeCommand(t *PushTarget, profile *TranscodeProfile, encoder string, accel mediautil.HWAccelType, vaapiDevice, textDir, defaultFont string) *transcodeCommand {
+	protocol := t.GetProtocol()
+	transcodeVideo := profile.VideoCodec != VideoCodecCopy
+	cmd := &transcodeCommand{Encoder: VideoCodecCopy}
+
+	args := []string{"%s"}
+	if transcodeVideo && accel == mediautil.HWAccelVAAPI {
+		args = append(args, "-vaapi_device", vaapiDevice)
+	}
+	args = append(args, "-re", "-i", "%s")
+
+	// 视频滤镜链：缩放 -> 帧率 -> 文字 -> 图片水印 -> 硬件编码器像素格式
+	var filters []string
+	var logos []Overlay
+	if transcodeVideo {
+		cmd.Encoder = encoder
+
+		var chain []string
+		if profile.Width > 0 {
+			if profile.Height > 0 {
+				chain = append(chain, fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+					profile.Width, profile.Height, profile.Width, profile.Height))
+			} else {
+				chain = append(chain, fmt.Sprintf("scale=%d:-2", profile.Width))
+			}
+		}
+		if profile.FPS > 0 {
+			chain = append(chain, fmt.Sprintf("fps=%d", profile.FPS))
+		}
+
+		for i, ov := range t.Overlays {
+			if ov.Type == OverlayLogo {
+				logos = append(logos, ov)
+				continue
+			}
+			file := overlayTextFile{Path: filepath.Join(textDir, strconv.Itoa(i)+".txt")}
+			switch ov.Type {
+			case OverlayClock:
+				file.Content = clockText
+			case OverlayChannelName:
+				file.Content = escapeDrawText(firstNonEmpty(ov.Text, t.ChannelName, t.ChannelID))
+			default:
+				file.Content = escapeDrawText(ov.Text)
+			}
+			cmd.TextFiles = append(cmd.TextFiles, file)
+			chain = append(chain, drawTextFilter(ov, file.Path, defaultFont))
+		}
+
+		base := "[0:v]"
+		if len(chain) > 0 {
+			filters = append(filters, "[0:v]"+strings.Join(chain, ",")+"[base]")
+			base = "[base]"
+		}
+		for i, logo := range logos {
+			args = append(args, "-i", escapeTemplate(logo.Image))
+			out := fmt.Sprintf("[logo%d]", i)
+			filters = append(filters, fmt.Sprintf("%s[%d:v]overlay=%s%s", base, i+1, overlayPosition(logo.Position, false), out))
+			base = out
+		}
+		if format := hwUploadFilter(accel); format != "" {
+			filters = append(filters, base+format+"[vout]")
+			base = "[vout]"
+		}
+		if len(filters) > 0 {
+			args = append(args, "-filter_complex", escapeTemplate(strings.Join(filters, ";")), "-map", base)
+			if profile.AudioCodec != AudioCodecNone {
+				args = append(args, "-map", "0:a?")
+			}
+		}
+	}
+
+	// 视频编码
+	if !transcodeVideo {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", encoder)
+		if accel == mediautil.HWAccelNone {
+			args = append(args, "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p")
+		}
+		if protocol == ProtocolWHIP {
+			// WebRTC 不支持 B 帧
+			args = append(args, "-profile:v", "baseline", "-bf", "0")
+		}
+		if profile.VideoBitrate > 0 {
+			args = append(args,
+				"-b:v", fmt.Sprintf("%dk", profile.VideoBitrate),
+				"-maxrate", fmt.Sprintf("%dk", profile.VideoBitrate),
+				"-bufsize", fmt.Sprintf("%dk", profile.VideoBitrate*2))
+		}
+		gop := profile.GOP
+		if gop == 0 {
+			gop = 50
+			if profile.FPS > 0 {
+				gop = profile.FPS * 2
+			}
+		}
+		args = append(args, "-g", strconv.Itoa(gop), "-keyint_min", strconv.Itoa(gop))
+	}
+
+	// 音频编码，WHIP 只能使用 Opus
+	audioCodec := profile.AudioCodec
+	if protocol == ProtocolWHIP && audioCodec != AudioCodecNone {
+		audioCodec = AudioCodecOpus
+	}
+	switch audioCodec {
+	case AudioCodecNone:
+		args = append(args, "-an")
+	case AudioCodecCopy:
+		args = append(args, "-c:a", "copy")
+	default:
+		sampleRate := profile.AudioSampleRate
+		if audioCodec == AudioCodecOpus {
+			args = append(args, "-c:a", "libopus")
+			sampleRate = 48000
+		} else {
+			args = append(args, "-c:a", "aac")
+		}
+		if sampleRate > 0 {
+			args = append(args, "-ar", strconv.Itoa(sampleRate))
+		}
+		if profile.AudioChannels > 0 {
+			args = append(args, "-ac", strconv.Itoa(profile.AudioChannels))
+		}
+		if profile.AudioBitrate > 0 {
+			args = append(args, "-b:a", fmt.Sprintf("%dk", profile.AudioBitrate))
+		}
+	}
+
+	// 输出封装
+	switch protocol {
+	case ProtocolSRT:
+		args = append(args, "-f", "mpegts")
+	case ProtocolWHIP:
+		args = append(args, "-f", "whip")
+	case ProtocolRTSP:
+		transport := "tcp"
+		if t.Options != nil && t.Options.RTPType == "udp" {
+			transport = "udp"
+		}
+		args = append(args, "-f", "rtsp", "-rtsp_transport", transport)
+	default:
+		args = append(args, "-f", "flv")
+	}
+	args = append(args, "%s")
+
+	cmd.Template = strings.Join(args, " ")
+	return cmd
+}
+
+// drawTextFilter 生成文字叠加层的 drawtext 滤镜
+func drawTextFilter(ov Overlay, textFile, defaultFont string) string {
+	fontSize := ov.FontSize
+	if fontSize == 0 {
+		fontSize = 24
+	}
+	fontColor := ov.FontColor
+	if fontColor == "" {
+		fontColor = "white"
+	}
+
+	parts := []string{
+		"drawtext=textfile=" + textFile,
+		"expansion=normal",
+		"fontsize=" + strconv.Itoa(fontSize),
+		"fontcolor=" + fontColor,
+		"box=1",
+		"boxcolor=black@0.4",
+		"boxborderw=6",
+		overlayPosition(ov.Position, true),
+	}
+	if font := firstNonEmpty(ov.FontFile, defaultFont); font != "" {
+		parts = append(parts, "fontfile="+font)
+	}
+	return strings.Join(parts, ":")
+}
+
+// overlayPosition 叠加层坐标表达式（drawtext 使用 w/h/tw/th，overlay 使用 W/H/w/h）
+func overlayPosition(position string, text bool) string {
+	right, bottom := "W-w", "H-h"
+	if text {
+		right, bottom = "w-tw", "h-th"
+	}
+	m := strconv.Itoa(overlayMargin)
+
+	var x, y string
+	switch position {
+	case PositionTopRight:
+		x, y = right+"-"+m, m
+	case PositionBottomLeft:
+		x, y = m, bottom+"-"+m
+	case PositionBottomRight:
+		x, y = right+"-"+m, bottom+"-"+m
+	default:
+		x, y = m, m
+	}
+	if text {
+		return "x=" + x + ":y=" + y
+	}
+	return x + ":" + y
+}
+
+// hwUploadFilter 硬件编码器要求的像素格式转换
+func hwUploadFilter(accel mediautil.HWAccelType) string {
+	switch accel {
+	case mediautil.HWAccelVAAPI:
+		return "format=nv12,hwupload"
+	case mediautil.HWAccelQSV:
+		return "format=nv12"
+	default:
+		return ""
+	}
+}
+
+// escapeDrawText 转义 drawtext 文字展开中的特殊字符
+func escapeDrawText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`).Replace(s)
+}
+
+// escapeTemplate 转义 ZLM 命令模板中的 %（模板经 snprintf 格式化）
+func escapeTemplate(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// overlayDirName 推流目标叠加层文件目录名
+func overlayDirName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// transcodeCommandFor 按推流目标当前的转码配置生成命令模板
+func (m *Manager) transcodeCommandFor(target *PushTarget) (*transcodeCommand, error) {
+	m.mutex.RLock()
+	profile, ok := m.profiles[target.Profile]
+	fontFile := m.fontFile
+	vaapiDevice := m.vaapiDevice
+	m.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("transcode profile not found: %s", target.Profile)
+	}
+
+	encoder, accel := "", mediautil.HWAccelNone
+	if profile.VideoCodec != VideoCodecCopy {
+		encoder, accel = mediautil.GetGlobalDetector().GetEncoderName(profile.VideoCodec)
+	}
+
+	textDir, err := filepath.Abs(filepath.Join(m.overlayDir, overlayDirName(target.ID)))
+	if err != nil {
+		return nil, err
+	}
+	if len(target.Overlays) > 0 && !filterPathPattern.MatchString(textDir) {
+		return nil, fmt.Errorf("overlay directory %s contains unsupported characters", textDir)
+	}
+
+	return buildTranscodeCommand(target, profile, encoder, accel, vaapiDevice, textDir, fontFile), nil
+}
+
+// GetCommandTemplate 预览推流目标的 FFmpeg 命令模板（未配置转码时返回协议默认模板名称）
+func (m *Manager) GetCommandTemplate(id string) (map[string]interface{}, error) {
+	target, err := m.GetTarget(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !needsTranscode(target) {
+		protocol := target.GetProtocol()
+		cmdKey := ffmpegCmdKey(protocol)
+		if cmdKey == "" && mechanismFor(protocol) == MechanismFFmpeg {
+			cmdKey = "ffmpeg.cmd"
+		}
+		return map[string]interface{}{
+			"mechanism": mechanismFor(protocol),
+			"cmd_key":   cmdKey,
+		}, nil
+	}
+
+	cmd, err := m.transcodeCommandFor(target)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"mechanism": MechanismFFmpeg,
+		"profile":   target.Profile,
+		"encoder":   cmd.Encoder,
+		"template":  cmd.Template,
+	}, nil
+}
+
+// prepareTranscode 写入叠加层文字文件，并将命令模板写入分配给该目标的 ZLM 模板槽位，返回 ffmpeg_cmd_key
+func (m *Manager) prepareTranscode(target *PushTarget) (string, error) {
+	cmd, err := m.transcodeCommandFor(target)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range cmd.TextFiles {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return "", fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+		if err := os.WriteFile(file.Path, []byte(file.Content), 0644); err != nil {
+			return "", fmt.Errorf("failed to write overlay text: %w", err)
+		}
+	}
+
+	slot, err := m.acquireCmdSlot(target.ID)
+	if err != nil {
+		return "", err
+	}
+	cmdKey := config.FFmpegPushCmdKey(slot)
+	if err := m.zlmClient.SetServerConfig(map[string]interface{}{cmdKey: cmd.Template}); err != nil {
+		// 模板未写入，槽位归还给其他转码推流
+		m.mutex.Lock()
+		m.releaseCmdSlot(target.ID)
+		m.mutex.Unlock()
+		return "", fmt.Errorf("failed to set ffmpeg command template: %w", err)
+	}
+
+	debug.Info("push", "Push %s uses profile %s (encoder: %s, %s): %s", target.Name, target.Profile, cmd.Encoder, cmdKey, cmd.Template)
+	return cmdKey, nil
+}
+
+// acquireCmdSlot 为推流目标分配命令模板槽位，已分配时复用
+func (m *Manager) acquireCmdSlot(id string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if slot, ok := m.cmdSlots[id]; ok {
+		return slot, nil
+	}
+
+	used := make(map[int]bool, len(m.cmdSlots))
+	for _, slot := range m.cmdSlots {
+		used[slot] = true
+	}
+	for slot := 0; slot < config.FFmpegPushCmdSlots; slot++ {
+		if !used[slot] {
+			m.cmdSlots[id] = slot
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("too many transcoding pushes (max %d)", config.FFmpegPushCmdSlots)
+}
+
+// releaseCmdSlot 释放推流目标占用的命令模板槽位（调用方持有锁）
+func (m *Manager) releaseCmdSlot(id string) {
+	delete(m.cmdSlots, id)
+}
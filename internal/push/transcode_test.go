@@ -0,0 +1,93 @@
+package push
+
+import (
+	"strings"
+	"testing"
+
+	"gb28181-onvif-server/internal/mediautil"
+)
+
+func TestBuildTranscodeCommand(t *testing.T) {
+	cases := []struct {
+		name     string
+		protocol string
+		profile  TranscodeProfile
+		encoder  string
+		accel    mediautil.HWAccelType
+		overlays []Overlay
+		want     string
+	}{
+		{
+			name:     "CopyVideoAAC",
+			protocol: ProtocolRTMP,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecCopy, AudioCodec: AudioCodecAAC, AudioBitrate: 128},
+			accel:    mediautil.HWAccelVAAPI,
+			want:     "%s -re -i %s -c:v copy -c:a aac -b:a 128k -f flv %s",
+		},
+		{
+			name:     "H264Software",
+			protocol: ProtocolRTMP,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecH264, Width: 1280, Height: 720, FPS: 25, VideoBitrate: 2000, AudioCodec: AudioCodecCopy},
+			encoder:  "libx264",
+			accel:    mediautil.HWAccelNone,
+			want: "%s -re -i %s -filter_complex [0:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,fps=25[base] -map [base] -map 0:a? " +
+				"-c:v libx264 -preset veryfast -tune zerolatency -pix_fmt yuv420p -b:v 2000k -maxrate 2000k -bufsize 4000k -g 50 -keyint_min 50 -c:a copy -f flv %s",
+		},
+		{
+			name:     "H264VAAPI",
+			protocol: ProtocolRTMP,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecH264, AudioCodec: AudioCodecNone},
+			encoder:  "h264_vaapi",
+			accel:    mediautil.HWAccelVAAPI,
+			want:     "%s -vaapi_device /dev/dri/renderD129 -re -i %s -filter_complex [0:v]format=nv12,hwupload[vout] -map [vout] -c:v h264_vaapi -g 50 -keyint_min 50 -an -f flv %s",
+		},
+		{
+			name:     "H265QSVOverSRT",
+			protocol: ProtocolSRT,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecH265, Width: 960, GOP: 30, AudioCodec: AudioCodecAAC, AudioSampleRate: 44100, AudioChannels: 2},
+			encoder:  "hevc_qsv",
+			accel:    mediautil.HWAccelQSV,
+			want:     "%s -re -i %s -filter_complex [0:v]scale=960:-2[base];[base]format=nv12[vout] -map [vout] -map 0:a? -c:v hevc_qsv -g 30 -keyint_min 30 -c:a aac -ar 44100 -ac 2 -f mpegts %s",
+		},
+		{
+			name:     "H264NVENCNoFilter",
+			protocol: ProtocolRTSP,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecH264, FPS: 15, AudioCodec: AudioCodecCopy},
+			encoder:  "h264_nvenc",
+			accel:    mediautil.HWAccelCUDA,
+			want:     "%s -re -i %s -filter_complex [0:v]fps=15[base] -map [base] -map 0:a? -c:v h264_nvenc -g 30 -keyint_min 30 -c:a copy -f rtsp -rtsp_transport tcp %s",
+		},
+		{
+			name:     "WHIPForcesOpusAndBaseline",
+			protocol: ProtocolWHIP,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecH264, AudioCodec: AudioCodecAAC},
+			encoder:  "libx264",
+			accel:    mediautil.HWAccelNone,
+			want:     "%s -re -i %s -c:v libx264 -preset veryfast -tune zerolatency -pix_fmt yuv420p -profile:v baseline -bf 0 -g 50 -keyint_min 50 -c:a libopus -ar 48000 -f whip %s",
+		},
+		{
+			name:     "OverlaysWithVAAPI",
+			protocol: ProtocolRTMP,
+			profile:  TranscodeProfile{VideoCodec: VideoCodecH264, AudioCodec: AudioCodecAAC},
+			encoder:  "h264_vaapi",
+			accel:    mediautil.HWAccelVAAPI,
+			overlays: []Overlay{{Type: OverlayClock, Position: PositionBottomRight}, {Type: OverlayLogo, Image: "/data/logo.png", Position: PositionTopRight}},
+			want: "%s -vaapi_device /dev/dri/renderD129 -re -i %s -i /data/logo.png -filter_complex " +
+				"[0:v]drawtext=textfile=/tmp/overlay/0.txt:expansion=normal:fontsize=24:fontcolor=white:box=1:boxcolor=black@0.4:boxborderw=6:x=w-tw-10:y=h-th-10[base];" +
+				"[base][1:v]overlay=W-w-10:10[logo0];[logo0]format=nv12,hwupload[vout] -map [vout] -map 0:a? -c:v h264_vaapi -g 50 -keyint_min 50 -c:a aac -f flv %s",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := &PushTarget{ID: "t1", Protocol: tc.protocol, Overlays: tc.overlays}
+			cmd := buildTranscodeCommand(target, &tc.profile, tc.encoder, tc.accel, "/dev/dri/renderD129", "/tmp/overlay", "")
+			if cmd.Template != tc.want {
+				t.Errorf("template =\n%s\nwant\n%s", cmd.Template, tc.want)
+			}
+			if strings.Count(cmd.Template, "%s") != 3 {
+				t.Errorf("模板应包含 3 个 %%s 占位符: %s", cmd.Template)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gb28181-onvif-server/internal/debug"
+)
+
+const (
+	// EnvKey 主密钥环境变量（32 字节，Base64 或 Hex 编码）
+	EnvKey = "GB28181_SECRET_KEY"
+	// EnvKeyFile 主密钥文件路径环境变量
+	EnvKeyFile = "GB28181_SECRET_KEY_FILE"
+	// DefaultKeyFile 默认主密钥文件，不存在时自动生成
+	DefaultKeyFile = "configs/secret.key"
+
+	// encryptedPrefix 密文前缀，未带前缀的值视为旧版明文
+	encryptedPrefix = "enc:v1:"
+	keySize         = 32
+)
+
+var (
+	ErrInvalidKey    = errors.New("invalid master key: expect 32 bytes in base64 or hex")
+	ErrDecryptFailed = errors.New("failed to decrypt secret: wrong master key or corrupted data")
+)
+
+// Keyring 使用 AES-256-GCM 加解密敏感字段
+type Keyring struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// NewKeyring 使用主密钥创建 Keyring
+func NewKeyring(key []byte) (*Keyring, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Keyring{aead: aead, id: hex.EncodeToString(sum[:4])}, nil
+}
+
+// ID 主密钥指纹（用于日志和排查，不泄露密钥）
+func (k *Keyring) ID() string {
+	return k.id
+}
+
+// Encrypt 加密明文，空字符串和已加密的值原样返回
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+	debug.RegisterSecret(plaintext)
+
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密密文，未加密的旧版明文原样返回
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", ErrDecryptFailed
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrDecryptFailed
+	}
+
+	debug.RegisterSecret(string(plaintext))
+	return string(plaintext), nil
+}
+
+// IsEncrypted 检查值是否为密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// GenerateKey 生成随机主密钥
+func GenerateKey() []byte {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	return key
+}
+
+// EncodeKey 将主密钥编码为 Base64 文本
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParseKey 解析 Base64 或 Hex 编码的主密钥
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// ReadKeyFile 读取主密钥文件
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// WriteKeyFile 写入主密钥文件（仅属主可读写）
+func WriteKeyFile(path string, key []byte) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(EncodeKey(key)+"\n"), 0600)
+}
+
+// KeyFilePath 当前生效的主密钥文件路径
+func KeyFilePath() string {
+	if path := os.Getenv(EnvKeyFile); path != "" {
+		return path
+	}
+	return DefaultKeyFile
+}
+
+// LoadKey 按优先级加载主密钥：环境变量 > 密钥文件；默认密钥文件不存在时自动生成
+func LoadKey() ([]byte, error) {
+	if value := os.Getenv(EnvKey); value != "" {
+		key, err := ParseKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvKey, err)
+		}
+		return key, nil
+	}
+
+	path := KeyFilePath()
+	key, err := ReadKeyFile(path)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取主密钥文件 %s 失败: %w", path, err)
+	}
+	// 显式指定的密钥文件不存在时不自动生成，避免用新密钥覆盖丢失的旧密钥
+	if os.Getenv(EnvKeyFile) != "" {
+		return nil, fmt.Errorf("主密钥文件 %s 不存在", path)
+	}
+
+	key = GenerateKey()
+	if err := WriteKeyFile(path, key); err != nil {
+		return nil, fmt.Errorf("生成主密钥文件 %s 失败: %w", path, err)
+	}
+	debug.Warn("secrets", "已生成新的主密钥文件 %s，请妥善备份，丢失后无法解密已保存的凭据", path)
+	return key, nil
+}
+
+var (
+	defaultMutex   sync.Mutex
+	defaultKeyring *Keyring
+)
+
+// Default 获取全局 Keyring，首次调用时加载主密钥
+func Default() (*Keyring, error) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	if defaultKeyring != nil {
+		return defaultKeyring, nil
+	}
+
+	key, err := LoadKey()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := NewKeyring(key)
+	if err != nil {
+		return nil, err
+	}
+	defaultKeyring = keyring
+	return defaultKeyring, nil
+}
+
+// SetDefault 替换全局 Keyring（密钥轮换时使用）
+func SetDefault(k *Keyring) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultKeyring = k
+}
+
+// Encrypt 使用全局 Keyring 加密
+func Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return k.Encrypt(plaintext)
+}
+
+// Decrypt 使用全局 Keyring 解密
+// 仅登记实际解密得到的明文；旧版明文原样返回，由调用方按字段决定是否登记
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return k.Decrypt(value)
+}
@@ -36,16 +36,17 @@ type VersionInfo struct {
 
 // StreamInfo 流信息
 type StreamInfo struct {
-	App         string `json:"app"`
-	Stream      string `json:"stream"`
-	Schema      string `json:"schema"`      // rtsp, rtmp, hls, flv
-	Online      int    `json:"online"`      // 0=offline, 1=online
-	ReaderCount int    `json:"readerCount"` // 观众数
-	BytesSpeed  int64  `json:"bytesSpeed"`  // 实时码率 (字节/秒)
-	CreateTime  int64  `json:"createTime"`  // 创建时间
-	AliveSecond int    `json:"aliveSecond"` // 存活时间 (秒)
-	OriginURL   string `json:"originUrl"`   // 源流地址
-	OriginSock  *struct {
+	App              string `json:"app"`
+	Stream           string `json:"stream"`
+	Schema           string `json:"schema"`           // rtsp, rtmp, hls, flv
+	Online           int    `json:"online"`           // 0=offline, 1=online
+	ReaderCount      int    `json:"readerCount"`      // 观众数（当前协议）
+	TotalReaderCount int    `json:"totalReaderCount"` // 同一路流各协议的观众数之和
+	BytesSpeed       int64  `json:"bytesSpeed"`       // 实时码率 (字节/秒)
+	CreateTime       int64  `json:"createTime"`       // 创建时间
+	AliveSecond      int    `json:"aliveSecond"`      // 存活时间 (秒)
+	OriginURL        string `json:"originUrl"`        // 源流地址
+	OriginSock       *struct {
 		Identifier string `json:"identifier"`
 		LocalIP    string `json:"local_ip"`
 		LocalPort  int    `json:"local_port"`
@@ -193,6 +194,10 @@ func (c *ZLMAPIClient) GetMediaList() ([]*StreamInfo, error) {
 		if readerCount, ok := item["readerCount"].(float64); ok {
 			streamInfo.ReaderCount = int(readerCount)
 		}
+		// 同一路流各协议的观看数之和（上面按 app_stream 去重只保留了一个协议）
+		if totalReaderCount, ok := item["totalReaderCount"].(float64); ok {
+			streamInfo.TotalReaderCount = int(totalReaderCount)
+		}
 		if bytesSpeed, ok := item["bytesSpeed"].(float64); ok {
 			streamInfo.BytesSpeed = int64(bytesSpeed)
 		}
@@ -538,7 +543,7 @@ type GB28181RtpInfo struct {
 func (c *ZLMAPIClient) OpenRtpServer(streamID string, tcpMode int, port int) (*GB28181RtpInfo, error) {
 	params := map[string]interface{}{
 		"port":      port,     // 0 表示随机端口
-		"tcp_mode":  tcpMode,  // 0: 不启用tcp, 1: tcp主动, 2: tcp被动
+		"tcp_mode":  tcpMode,  // 0: UDP, 1: TCP被动（ZLM监听）, 2: TCP主动（需调用 connectRtpServer）
 		"stream_id": streamID, // 流ID
 	}
 
@@ -563,6 +568,33 @@ func (c *ZLMAPIClient) OpenRtpServer(streamID string, tcpMode int, port int) (*G
 	}, nil
 }
 
+// ConnectRtpServer TCP 主动模式下连接设备的媒体端口
+// API: GET /index/api/connectRtpServer
+// 需先以 tcp_mode=2 调用 openRtpServer
+func (c *ZLMAPIClient) ConnectRtpServer(streamID, dstURL string, dstPort int) error {
+	params := map[string]interface{}{
+		"stream_id": streamID,
+		"dst_url":   dstURL,
+		"dst_port":  dstPort,
+	}
+
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+
+	err := c.doRequest("GET", "/index/api/connectRtpServer", params, &resp)
+	if err != nil {
+		return fmt.Errorf("connect rtp server failed: %w", err)
+	}
+
+	if resp.Code != 0 {
+		return fmt.Errorf("connect rtp server failed: %s (code: %d)", resp.Msg, resp.Code)
+	}
+
+	return nil
+}
+
 // CloseRtpServer 关闭 GB28181 RTP 接收端口
 // API: POST /index/api/closeRtpServer
 func (c *ZLMAPIClient) CloseRtpServer(streamID string) error {
@@ -857,10 +889,19 @@ type PushStreamResult struct {
 // AddFFmpegSource 添加 FFmpeg 拉流代理（用于推流）
 // 使用 FFmpeg 拉取源流并推送到目标地址
 func (c *ZLMAPIClient) AddFFmpegSource(srcURL, dstURL string, timeoutMS int, enableAudio bool) (*PushStreamResult, error) {
+	return c.AddFFmpegSourceWithCmd(srcURL, dstURL, "", timeoutMS, enableAudio)
+}
+
+// AddFFmpegSourceWithCmd 使用指定的 FFmpeg 命令模板添加推流
+// cmdKey 为 ZLM 配置中的命令模板键（如 ffmpeg.cmd_srt），为空时使用默认的 ffmpeg.cmd
+func (c *ZLMAPIClient) AddFFmpegSourceWithCmd(srcURL, dstURL, cmdKey string, timeoutMS int, enableAudio bool) (*PushStreamResult, error) {
 	var resp struct {
 		Code int    `json:"code"`
 		Msg  string `json:"msg"`
 		Key  string `json:"key"`
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
 	}
 
 	params := map[string]interface{}{
@@ -871,6 +912,9 @@ func (c *ZLMAPIClient) AddFFmpegSource(srcURL, dstURL string, timeoutMS int, ena
 		"enable_mp4":     false,
 		"add_mute_audio": enableAudio,
 	}
+	if cmdKey != "" {
+		params["ffmpeg_cmd_key"] = cmdKey
+	}
 
 	err := c.doRequest("GET", "/index/api/addFFmpegSource", params, &resp)
 	if err != nil {
@@ -881,8 +925,14 @@ func (c *ZLMAPIClient) AddFFmpegSource(srcURL, dstURL string, timeoutMS int, ena
 		return nil, fmt.Errorf("add ffmpeg source failed: %s", resp.Msg)
 	}
 
+	// ZLM 在 data.key 中返回任务 Key，兼容旧版本的顶层 key
+	key := resp.Data.Key
+	if key == "" {
+		key = resp.Key
+	}
+
 	return &PushStreamResult{
-		Key:  resp.Key,
+		Key:  key,
 		Code: resp.Code,
 		Msg:  resp.Msg,
 	}, nil
@@ -931,6 +981,77 @@ func (c *ZLMAPIClient) ListFFmpegSource() ([]map[string]interface{}, error) {
 	return resp.Data, nil
 }
 
+// AddStreamPusherProxy 将 ZLM 内已有的流直接转推到外部服务器（不经过 FFmpeg）
+// schema 为转推使用的协议族（rtsp 或 rtmp），opts 可覆盖 rtp_type/timeout_sec/retry_count
+func (c *ZLMAPIClient) AddStreamPusherProxy(schema, app, stream, dstURL string, opts map[string]interface{}) (string, error) {
+	params := map[string]interface{}{
+		"vhost":       "__defaultVhost__",
+		"schema":      schema,
+		"app":         app,
+		"stream":      stream,
+		"dst_url":     dstURL,
+		"rtp_type":    0, // 0: tcp, 1: udp
+		"timeout_sec": 10,
+		"retry_count": 0, // 重试由推流监督器负责
+	}
+	for k, v := range opts {
+		params[k] = v
+	}
+
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+
+	if err := c.doRequest("GET", "/index/api/addStreamPusherProxy", params, &resp); err != nil {
+		return "", err
+	}
+	if resp.Code != 0 {
+		return "", fmt.Errorf("add stream pusher proxy failed: %s (code: %d)", resp.Msg, resp.Code)
+	}
+	return resp.Data.Key, nil
+}
+
+// DelStreamPusherProxy 删除转推任务
+func (c *ZLMAPIClient) DelStreamPusherProxy(key string) error {
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+
+	params := map[string]interface{}{
+		"key": key,
+	}
+
+	if err := c.doRequest("GET", "/index/api/delStreamPusherProxy", params, &resp); err != nil {
+		return err
+	}
+	if resp.Code != 0 {
+		return fmt.Errorf("delete stream pusher proxy failed: %s", resp.Msg)
+	}
+	return nil
+}
+
+// ListStreamPusherProxy 列出所有转推任务
+func (c *ZLMAPIClient) ListStreamPusherProxy() ([]map[string]interface{}, error) {
+	var resp struct {
+		Code int                      `json:"code"`
+		Msg  string                   `json:"msg"`
+		Data []map[string]interface{} `json:"data"`
+	}
+
+	if err := c.doRequest("GET", "/index/api/listStreamPusherProxy", nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != 0 {
+		return nil, fmt.Errorf("list stream pusher proxy failed: %s", resp.Msg)
+	}
+	return resp.Data, nil
+}
+
 // StartSendRtp 开始 RTP 推流（GB28181 方式）
 // app: 应用名, stream: 流名, ssrc: RTP的SSRC, dstURL: 目标地址, dstPort: 目标端口
 // isUDP: 是否使用UDP, srcPort: 本地端口(可选)